@@ -0,0 +1,448 @@
+// Package alertmanager is an Alertmanager webhook receiver delivering the notifications by SMS.
+// Notifications are rendered with Go templates, routed to phone numbers by receiver and label
+// matchers, deduplicated, rate limited per phone number and sent by a single worker.
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// DEFAULT_TEMPLATE render a notification when neither the route nor the config have a template
+const DEFAULT_TEMPLATE = `[{{ .Status | toUpper }}{{ if eq .Status "firing" }}:{{ len .Alerts.Firing }}{{ end }}] {{ .CommonLabels.alertname }}{{ range .Alerts }} {{ .Annotations.summary }}{{ end }}`
+
+// QUEUE_SIZE is the number of messages waiting to be sent, notifications are refused beyond
+const QUEUE_SIZE = 100
+
+// SEND_TIMEOUT is the time a message has to be sent
+const SEND_TIMEOUT = 2 * time.Minute
+
+// Dropped message reasons
+const (
+	DROPPED_DUPLICATE  = "duplicate"
+	DROPPED_RATE_LIMIT = "rate_limit"
+	DROPPED_QUEUE_FULL = "queue_full"
+)
+
+// Message is the Alertmanager webhook payload, version 4
+type Message struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            Alerts            `json:"alerts"`
+}
+
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type Alerts []Alert
+
+// Firing return the firing alerts
+func (alerts Alerts) Firing() Alerts {
+	return alerts.withStatus("firing")
+}
+
+// Resolved return the resolved alerts
+func (alerts Alerts) Resolved() Alerts {
+	return alerts.withStatus("resolved")
+}
+
+func (alerts Alerts) withStatus(status string) Alerts {
+	var selected Alerts
+	for _, alert := range alerts {
+		if alert.Status == status {
+			selected = append(selected, alert)
+		}
+	}
+	return selected
+}
+
+// Config of the receiver, read from a JSON file
+type Config struct {
+	// Template is the default template of the routes
+	Template string  `json:"template,omitempty"`
+	Routes   []Route `json:"routes"`
+	// DedupWindow is the time an identical message to the same number is not sent again, like "1h"
+	DedupWindow string `json:"dedup_window,omitempty"`
+	// RateLimit is the number of messages sent to a number per RateLimitPeriod, 0 for no limit
+	RateLimit       int    `json:"rate_limit,omitempty"`
+	RateLimitPeriod string `json:"rate_limit_period,omitempty"`
+
+	dedupWindow     time.Duration
+	rateLimitPeriod time.Duration
+}
+
+// Route send the notifications of Receiver (any receiver if empty) whose common labels match
+// all the Matchers to the To phone numbers. Matchers are like name="value", name!=value,
+// name=~regexp or name!~regexp
+type Route struct {
+	Receiver string   `json:"receiver,omitempty"`
+	Matchers []string `json:"matchers,omitempty"`
+	To       []string `json:"to"`
+	Template string   `json:"template,omitempty"`
+
+	matchers []matcher
+	template *template.Template
+}
+
+type matcher struct {
+	name   string
+	negate bool
+	regexp *regexp.Regexp
+}
+
+var matcherRegexp = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"?(.*?)"?\s*$`)
+
+var templateFuncs = template.FuncMap{
+	"toUpper": strings.ToUpper,
+	"toLower": strings.ToLower,
+	"join":    func(separator string, values []string) string { return strings.Join(values, separator) },
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, config.Validate()
+}
+
+// Validate check the config and compile its templates and matchers
+func (config *Config) Validate() error {
+	var err error
+	if config.DedupWindow != "" {
+		config.dedupWindow, err = time.ParseDuration(config.DedupWindow)
+		if err != nil {
+			return fmt.Errorf("dedup_window: %s", err)
+		}
+	}
+	config.rateLimitPeriod = time.Hour
+	if config.RateLimitPeriod != "" {
+		config.rateLimitPeriod, err = time.ParseDuration(config.RateLimitPeriod)
+		if err != nil {
+			return fmt.Errorf("rate_limit_period: %s", err)
+		}
+	}
+	if len(config.Routes) == 0 {
+		return fmt.Errorf("no route")
+	}
+
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		if len(route.To) == 0 {
+			return fmt.Errorf("route %d: no phone number", i)
+		}
+
+		text := route.Template
+		if text == "" {
+			text = config.Template
+		}
+		if text == "" {
+			text = DEFAULT_TEMPLATE
+		}
+		route.template, err = template.New(fmt.Sprintf("route %d", i)).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return err
+		}
+
+		route.matchers = nil
+		for _, expression := range route.Matchers {
+			parts := matcherRegexp.FindStringSubmatch(expression)
+			if parts == nil {
+				return fmt.Errorf("route %d: invalid matcher: %s", i, expression)
+			}
+			value := regexp.QuoteMeta(parts[3])
+			if strings.HasSuffix(parts[2], "~") {
+				value = parts[3]
+			}
+			compiled, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return fmt.Errorf("route %d: %s", i, err)
+			}
+			route.matchers = append(route.matchers, matcher{name: parts[1], negate: strings.HasPrefix(parts[2], "!"), regexp: compiled})
+		}
+	}
+	return nil
+}
+
+// match return true if the route receive the message
+func (route *Route) match(message *Message) bool {
+	if route.Receiver != "" && route.Receiver != message.Receiver {
+		return false
+	}
+	for _, matcher := range route.matchers {
+		if matcher.regexp.MatchString(message.CommonLabels[matcher.name]) == matcher.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// SendFunc send a SMS
+type SendFunc func(ctx context.Context, to []string, text string) error
+
+// sms is a queued message, admitted at admittedAt
+type sms struct {
+	to         string
+	text       string
+	admittedAt time.Time
+}
+
+// Receiver is the webhook handler, it is also the collector of its metrics
+type Receiver struct {
+	Config *Config
+	Send   SendFunc
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	queue   chan sms
+	mutex   sync.Mutex
+	sentAt  map[string]time.Time
+	history map[string][]time.Time
+
+	queued   prometheus.Counter
+	sent     prometheus.Counter
+	failed   prometheus.Counter
+	dropped  *prometheus.CounterVec
+	queueLen prometheus.GaugeFunc
+}
+
+// New return a receiver, Run must be called to send the messages
+func New(config *Config, send SendFunc) *Receiver {
+	receiver := &Receiver{
+		Config:  config,
+		Send:    send,
+		Now:     time.Now,
+		queue:   make(chan sms, QUEUE_SIZE),
+		sentAt:  map[string]time.Time{},
+		history: map[string][]time.Time{},
+		queued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modem_alert_sms_queued_total",
+			Help: "Alert SMS queued, one per phone number",
+		}),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modem_alert_sms_sent_total",
+			Help: "Alert SMS sent",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "modem_alert_sms_failed_total",
+			Help: "Alert SMS the modem failed to send, or whose send is unconfirmed",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_alert_sms_dropped_total",
+			Help: "Alert SMS not sent, by reason: duplicate, rate_limit or queue_full",
+		}, []string{"reason"}),
+	}
+	receiver.queueLen = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "modem_alert_sms_queue_length",
+		Help: "Alert SMS waiting to be sent",
+	}, func() float64 { return float64(len(receiver.queue)) })
+	return receiver
+}
+
+// Describe implement prometheus.Collector
+func (receiver *Receiver) Describe(ch chan<- *prometheus.Desc) {
+	receiver.queued.Describe(ch)
+	receiver.sent.Describe(ch)
+	receiver.failed.Describe(ch)
+	receiver.dropped.Describe(ch)
+	receiver.queueLen.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (receiver *Receiver) Collect(ch chan<- prometheus.Metric) {
+	receiver.queued.Collect(ch)
+	receiver.sent.Collect(ch)
+	receiver.failed.Collect(ch)
+	receiver.dropped.Collect(ch)
+	receiver.queueLen.Collect(ch)
+}
+
+// Run send the queued messages one at a time, until ctx is done
+func (receiver *Receiver) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-receiver.queue:
+			receiver.send(ctx, message)
+		}
+	}
+}
+
+// send send a queued message. The next notification isn't a duplicate of a message not sent, but a
+// message whose send is unconfirmed was probably sent, it is still a duplicate and rate limited
+func (receiver *Receiver) send(ctx context.Context, message sms) {
+	sendCtx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+	err := receiver.Send(sendCtx, []string{message.to}, message.text)
+	cancel()
+	switch {
+	case err == device.ErrSendUnconfirmed:
+		log.Warnf("[Alertmanager] SMS to %s: %s", message.to, err)
+		receiver.failed.Inc()
+	case err != nil:
+		log.Errorf("[Alertmanager] SMS to %s: %s", message.to, err)
+		receiver.failed.Inc()
+		receiver.release(message.to, message.text, message.admittedAt)
+	default:
+		receiver.sent.Inc()
+	}
+}
+
+// ServeHTTP receive an Alertmanager notification
+func (receiver *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var message Message
+	err := json.NewDecoder(r.Body).Decode(&message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = receiver.Notify(&message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Notify render a notification for the matching routes and queue it, once per phone number
+func (receiver *Receiver) Notify(message *Message) error {
+	texts := map[string]string{}
+	var numbers []string
+	for i := range receiver.Config.Routes {
+		route := &receiver.Config.Routes[i]
+		if route.match(message) == false {
+			continue
+		}
+		var text bytes.Buffer
+		err := route.template.Execute(&text, message)
+		if err != nil {
+			return err
+		}
+		for _, number := range route.To {
+			if _, ok := texts[number]; ok == false {
+				numbers = append(numbers, number)
+				texts[number] = strings.TrimSpace(text.String())
+			}
+		}
+	}
+
+	queueFull := false
+	for _, number := range numbers {
+		admittedAt, reason := receiver.admit(number, texts[number])
+		if reason == "" {
+			select {
+			case receiver.queue <- sms{to: number, text: texts[number], admittedAt: admittedAt}:
+				receiver.queued.Inc()
+				continue
+			default:
+				// Alertmanager retry, the retry isn't a duplicate
+				receiver.release(number, texts[number], admittedAt)
+				reason = DROPPED_QUEUE_FULL
+				queueFull = true
+			}
+		}
+		log.Infof("[Alertmanager] SMS to %s dropped: %s", number, reason)
+		receiver.dropped.WithLabelValues(reason).Inc()
+	}
+	if queueFull {
+		// Alertmanager retry the notification
+		return fmt.Errorf("SMS queue full")
+	}
+	return nil
+}
+
+// admit return why a message can't be queued, or an empty string and record it for the
+// deduplication and the rate limit at the returned time, until it is released
+func (receiver *Receiver) admit(number string, text string) (time.Time, string) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	now := receiver.Now()
+
+	key := number + "\n" + text
+	if sentAt, ok := receiver.sentAt[key]; ok && now.Sub(sentAt) < receiver.Config.dedupWindow {
+		return now, DROPPED_DUPLICATE
+	}
+
+	if receiver.Config.RateLimit > 0 {
+		var recent []time.Time
+		for _, sentAt := range receiver.history[number] {
+			if now.Sub(sentAt) < receiver.Config.rateLimitPeriod {
+				recent = append(recent, sentAt)
+			}
+		}
+		receiver.history[number] = recent
+		if len(recent) >= receiver.Config.RateLimit {
+			return now, DROPPED_RATE_LIMIT
+		}
+		receiver.history[number] = append(recent, now)
+	}
+
+	// forget the messages out of the window, so the map doesn't grow forever
+	for key, sentAt := range receiver.sentAt {
+		if now.Sub(sentAt) >= receiver.Config.dedupWindow {
+			delete(receiver.sentAt, key)
+		}
+	}
+	if receiver.Config.dedupWindow > 0 {
+		receiver.sentAt[key] = now
+	}
+	return now, ""
+}
+
+// release forget a message admitted at admittedAt but not sent, for the deduplication and the
+// rate limit
+func (receiver *Receiver) release(number string, text string, admittedAt time.Time) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	key := number + "\n" + text
+	if sentAt, ok := receiver.sentAt[key]; ok && sentAt.Equal(admittedAt) {
+		delete(receiver.sentAt, key)
+	}
+	history := receiver.history[number]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Equal(admittedAt) {
+			receiver.history[number] = append(history[:i:i], history[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package alertmanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+const testPayload = `{
+  "version": "4",
+  "status": "firing",
+  "receiver": "oncall",
+  "groupLabels": {"alertname": "UplinkDown"},
+  "commonLabels": {"alertname": "UplinkDown", "severity": "critical", "site": "lisbon"},
+  "commonAnnotations": {},
+  "alerts": [
+    {"status": "firing", "labels": {"alertname": "UplinkDown"}, "annotations": {"summary": "WAN down for 5m"}}
+  ]
+}`
+
+type testSender struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (sender *testSender) send(ctx context.Context, to []string, text string) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	sender.messages = append(sender.messages, to[0]+": "+text)
+	return nil
+}
+
+func testConfig(t *testing.T) *Config {
+	config := &Config{
+		DedupWindow: "1h",
+		RateLimit:   2,
+		Routes: []Route{
+			{Receiver: "oncall", Matchers: []string{`severity="critical"`, "site=~lisbon|porto"}, To: []string{"+351910000000", "+351920000000"}},
+			{Matchers: []string{"severity!=critical"}, To: []string{"+351930000000"}},
+			{Receiver: "oncall", To: []string{"+351910000000"}, Template: "ignored, the number already has a message"},
+		},
+	}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[testConfig] Error: %s", err)
+	}
+	return config
+}
+
+func post(t *testing.T, receiver *Receiver, payload string) int {
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, httptest.NewRequest("POST", "/alertmanager", strings.NewReader(payload)))
+	return w.Code
+}
+
+func TestNotify(t *testing.T) {
+	sender := &testSender{}
+	receiver := New(testConfig(t), sender.send)
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	receiver.Now = func() time.Time { return now }
+
+	if code := post(t, receiver, testPayload); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got: %d", code)
+	}
+	if len(receiver.queue) != 2 {
+		t.Fatalf("Expected 2 queued messages, got: %d", len(receiver.queue))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		receiver.Run(ctx)
+		done <- true
+	}()
+	for len(receiver.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if len(sender.messages) != 2 || sender.messages[0] != "+351910000000: [FIRING:1] UplinkDown WAN down for 5m" {
+		t.Logf("Unexpected messages: %q", sender.messages)
+		t.Fail()
+	}
+}
+
+func TestDedupAndRateLimit(t *testing.T) {
+	receiver := New(testConfig(t), (&testSender{}).send)
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	receiver.Now = func() time.Time { return now }
+
+	post(t, receiver, testPayload)
+	post(t, receiver, testPayload)
+	if len(receiver.queue) != 2 {
+		t.Fatalf("Expected the duplicates dropped, got %d queued", len(receiver.queue))
+	}
+
+	// a different message is not a duplicate, but the 2 messages per hour are reached
+	post(t, receiver, strings.Replace(testPayload, `"status": "firing",`, `"status": "resolved",`, 1))
+	if len(receiver.queue) != 4 {
+		t.Fatalf("Expected 4 queued messages, got: %d", len(receiver.queue))
+	}
+	now = now.Add(30 * time.Minute)
+	post(t, receiver, strings.Replace(testPayload, "5m", "35m", 1))
+	if len(receiver.queue) != 4 {
+		t.Fatalf("Expected the rate limited messages dropped, got %d queued", len(receiver.queue))
+	}
+
+	now = now.Add(time.Hour)
+	post(t, receiver, testPayload)
+	if len(receiver.queue) != 6 {
+		t.Fatalf("Expected 6 queued messages after the windows, got: %d", len(receiver.queue))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	config := &Config{Routes: []Route{{Matchers: []string{"severity"}, To: []string{"+351910000000"}}}}
+	if config.Validate() == nil {
+		t.Logf("Expected an invalid matcher error")
+		t.Fail()
+	}
+	config = &Config{Routes: []Route{{To: []string{"+351910000000"}, Template: "{{ .Status"}}}
+	if config.Validate() == nil {
+		t.Logf("Expected an invalid template error")
+		t.Fail()
+	}
+}
+
+func TestRetryAfterFailure(t *testing.T) {
+	failures := 1
+	receiver := New(testConfig(t), func(ctx context.Context, to []string, text string) error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("modem busy")
+		}
+		return nil
+	})
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	receiver.Now = func() time.Time { return now }
+
+	// a notification dropped because the queue is full is accepted on retry
+	for i := 0; i < QUEUE_SIZE; i++ {
+		receiver.queue <- sms{to: "+351990000000", text: "filler"}
+	}
+	if code := post(t, receiver, testPayload); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503, got: %d", code)
+	}
+	for len(receiver.queue) > 0 {
+		<-receiver.queue
+	}
+	if code := post(t, receiver, testPayload); code != http.StatusOK || len(receiver.queue) != 2 {
+		t.Fatalf("Expected the retry queued, got: %d with %d queued", code, len(receiver.queue))
+	}
+
+	// a message the modem failed to send isn't a duplicate
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		receiver.Run(ctx)
+		done <- true
+	}()
+	for len(receiver.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	post(t, receiver, testPayload)
+	if len(receiver.queue) != 1 {
+		t.Logf("Expected the failed message queued again, got %d queued", len(receiver.queue))
+		t.Fail()
+	}
+}
+
+func TestUnconfirmedSend(t *testing.T) {
+	sends := 0
+	receiver := New(testConfig(t), func(ctx context.Context, to []string, text string) error {
+		sends++
+		return device.ErrSendUnconfirmed
+	})
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	receiver.Now = func() time.Time { return now }
+
+	// a message probably sent is still a duplicate of the next notification
+	if code := post(t, receiver, testPayload); code != http.StatusOK || len(receiver.queue) != 2 {
+		t.Fatalf("Expected 2 messages queued, got: %d with %d queued", code, len(receiver.queue))
+	}
+	for len(receiver.queue) > 0 {
+		receiver.send(context.Background(), <-receiver.queue)
+	}
+	post(t, receiver, testPayload)
+	if len(receiver.queue) != 0 || sends != 2 {
+		t.Logf("Expected the unconfirmed messages not queued again, got %d queued and %d sends", len(receiver.queue), sends)
+		t.Fail()
+	}
+}
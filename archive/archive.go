@@ -0,0 +1,400 @@
+// Package archive keep every SMS received and sent by the modems, which only store about 100
+// messages. Records are appended to a JSON lines file and held in memory for the searches, old
+// records are removed by the retention.
+package archive
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Directions of a message
+const (
+	RECEIVED = "received"
+	SENT     = "sent"
+)
+
+// DEFAULT_INTERVAL is the time between two polls of the inboxes
+const DEFAULT_INTERVAL = time.Minute
+
+// SENT_TIME_TOLERANCE is the gap between the times of a sent message archived twice, when sent by
+// the exporter and once read from the modem storage, the exporter and the modem clocks may differ
+const SENT_TIME_TOLERANCE = 5 * time.Minute
+
+// Record is an archived message
+type Record struct {
+	// Key identify the message, it is archived once
+	Key        string    `json:"key"`
+	Modem      string    `json:"modem"`
+	Direction  string    `json:"direction"`
+	Number     string    `json:"number"`
+	Time       time.Time `json:"time"`
+	Text       string    `json:"text"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// NewRecord return the record of a message, with its key
+func NewRecord(modem string, direction string, number string, messageTime time.Time, text string) Record {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%d\n%s", modem, direction, number, messageTime.Unix(), text)))
+	return Record{
+		Key:       hex.EncodeToString(hash[:16]),
+		Modem:     modem,
+		Direction: direction,
+		Number:    number,
+		Time:      messageTime,
+		Text:      text,
+	}
+}
+
+// Archive is the store, it is also the collector of its metrics
+type Archive struct {
+	File string
+	// Retention is the age of the messages removed, 0 to keep them forever
+	Retention time.Duration
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	records []Record
+	keys    map[string]bool
+	// sent are the times of the sent messages, by sentKey
+	sent map[string][]time.Time
+
+	archived *prometheus.CounterVec
+	size     prometheus.GaugeFunc
+}
+
+// Open load an archive file, created on the first record
+func Open(file string) (*Archive, error) {
+	archive := &Archive{
+		File: file,
+		Now:  time.Now,
+		keys: map[string]bool{},
+		sent: map[string][]time.Time{},
+		archived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_sms_archived_total",
+			Help: "SMS archived, by direction: received or sent",
+		}, []string{"direction"}),
+	}
+	archive.size = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "modem_sms_archive_records",
+		Help: "SMS in the archive",
+	}, func() float64 {
+		archive.mutex.Lock()
+		defer archive.mutex.Unlock()
+		return float64(len(archive.records))
+	})
+
+	input, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return archive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// a line cut by a crash
+			log.Warnf("[Archive] %s:%d ignored: %s", file, line, err)
+			continue
+		}
+		if archive.keys[record.Key] == false {
+			archive.index(record)
+		}
+	}
+	return archive, scanner.Err()
+}
+
+// Describe implement prometheus.Collector
+func (archive *Archive) Describe(ch chan<- *prometheus.Desc) {
+	archive.archived.Describe(ch)
+	archive.size.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (archive *Archive) Collect(ch chan<- prometheus.Metric) {
+	archive.archived.Collect(ch)
+	archive.size.Collect(ch)
+}
+
+// index add a record to the records and its keys, called with the mutex locked or while opening
+func (archive *Archive) index(record Record) {
+	archive.keys[record.Key] = true
+	archive.records = append(archive.records, record)
+	if record.Direction == SENT {
+		key := sentKey(record)
+		archive.sent[key] = append(archive.sent[key], record.Time)
+	}
+}
+
+// known return true if the record, or the same sent message stamped by another clock, is
+// already archived. The same text sent again to a number within SENT_TIME_TOLERANCE of an archived
+// one is taken for it
+func (archive *Archive) known(record Record) bool {
+	if archive.keys[record.Key] {
+		return true
+	}
+	if record.Direction != SENT {
+		return false
+	}
+	for _, sentAt := range archive.sent[sentKey(record)] {
+		if gap := record.Time.Sub(sentAt); gap < SENT_TIME_TOLERANCE && gap > -SENT_TIME_TOLERANCE {
+			return true
+		}
+	}
+	return false
+}
+
+// sentKey identify a sent message without its time
+func sentKey(record Record) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s", record.Modem, record.Number, record.Text)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// Add append the records not already archived, and return how many were
+func (archive *Archive) Add(records ...Record) (int, error) {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+	now := archive.Now()
+
+	var content []byte
+	var added []Record
+	for _, record := range records {
+		if archive.known(record) || archive.expired(record, now) {
+			continue
+		}
+		record.ArchivedAt = now
+		line, err := json.Marshal(record)
+		if err != nil {
+			return 0, err
+		}
+		content = append(append(content, line...), '\n')
+		added = append(added, record)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	output, err := os.OpenFile(archive.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	_, err = output.Write(content)
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range added {
+		archive.index(record)
+		archive.archived.WithLabelValues(record.Direction).Inc()
+	}
+	return len(added), nil
+}
+
+// Poll archive the received messages of a source, and the sent ones if the modem keep them
+func (archive *Archive) Poll(source poll.Source) error {
+	reader, err := source.SMSReader()
+	if err != nil {
+		return err
+	}
+	messages, err := reader.ReceivedSMS()
+	if err != nil {
+		return err
+	}
+
+	var records []Record
+	for _, message := range messages {
+		records = append(records, NewRecord(source.Name, RECEIVED, message.Number, message.Time, message.Text))
+	}
+	if sentReader, ok := reader.(device.SentSMSReader); ok {
+		messages, err = sentReader.StoredSentSMS()
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			records = append(records, NewRecord(source.Name, SENT, message.Number, message.Time, message.Text))
+		}
+	}
+	added, err := archive.Add(records...)
+	if added > 0 {
+		log.Infof("[Archive] %s: %d message(s) archived", source.Name, added)
+	}
+	return err
+}
+
+// Run poll the sources and apply the retention every interval, until ctx is done
+func (archive *Archive) Run(ctx context.Context, sources []poll.Source, interval time.Duration) {
+	for {
+		poll.Each(ctx, "Archive", sources, func(ctx context.Context, source poll.Source) error {
+			return archive.Poll(source)
+		})
+		err := archive.Expire()
+		if err != nil {
+			log.Errorf("[Archive] %s", err)
+		}
+		if poll.Wait(ctx, interval) == false {
+			return
+		}
+	}
+}
+
+// Expire remove the records older than Retention, rewriting the file
+func (archive *Archive) Expire() error {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+	now := archive.Now()
+
+	var kept []Record
+	for _, record := range archive.records {
+		if archive.expired(record, now) == false {
+			kept = append(kept, record)
+		}
+	}
+	if len(kept) == len(archive.records) {
+		return nil
+	}
+
+	temporary := archive.File + ".tmp"
+	output, err := os.OpenFile(temporary, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(output)
+	encoder := json.NewEncoder(writer)
+	for _, record := range kept {
+		err = encoder.Encode(record)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary, archive.File)
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	log.Infof("[Archive] %d message(s) older than %s removed", len(archive.records)-len(kept), archive.Retention)
+	// the keys are kept, the expired messages still on a modem are not archived again
+	archive.records = kept
+	return nil
+}
+
+// expired return true if the record is older than Retention
+func (archive *Archive) expired(record Record, now time.Time) bool {
+	return archive.Retention > 0 && now.Sub(record.Time) > archive.Retention
+}
+
+// Query select records, the empty fields match any record
+type Query struct {
+	// Text are words the message must all contain, case insensitive
+	Text      string
+	Number    string
+	Modem     string
+	Direction string
+	Since     time.Time
+	Until     time.Time
+	// Limit is the maximum number of records, the most recent, 0 for all
+	Limit int
+}
+
+// Search return the records matching a query, oldest first
+func (archive *Archive) Search(query Query) []Record {
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	words := strings.Fields(strings.ToLower(query.Text))
+	var found []Record
+	for _, record := range archive.records {
+		if query.match(record, words) {
+			found = append(found, record)
+		}
+	}
+	sortRecords(found)
+	if query.Limit > 0 && len(found) > query.Limit {
+		found = found[len(found)-query.Limit:]
+	}
+	return found
+}
+
+func (query *Query) match(record Record, words []string) bool {
+	switch {
+	case query.Number != "" && strings.Contains(record.Number, query.Number) == false:
+		return false
+	case query.Modem != "" && record.Modem != query.Modem:
+		return false
+	case query.Direction != "" && record.Direction != query.Direction:
+		return false
+	case query.Since.IsZero() == false && record.Time.Before(query.Since):
+		return false
+	case query.Until.IsZero() == false && record.Time.Before(query.Until) == false:
+		return false
+	}
+	text := strings.ToLower(record.Text)
+	for _, word := range words {
+		if strings.Contains(text, word) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func sortRecords(records []Record) {
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+}
+
+// WriteCSV write records as CSV with a header line
+func WriteCSV(w io.Writer, records []Record) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"time", "modem", "direction", "number", "text"})
+	for _, record := range records {
+		writer.Write([]string{record.Time.Format(time.RFC3339), record.Modem, record.Direction, record.Number, record.Text})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON write records as a JSON array
+func WriteJSON(w io.Writer, records []Record) error {
+	if records == nil {
+		records = []Record{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
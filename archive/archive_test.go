@@ -0,0 +1,150 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func testArchive(t *testing.T) (*Archive, func()) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := Open(filepath.Join(dir, "sms.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archive, func() { os.RemoveAll(dir) }
+}
+
+func TestArchive(t *testing.T) {
+	archive, cleanup := testArchive(t)
+	defer cleanup()
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	archive.Now = func() time.Time { return now }
+
+	inbox := &modemtest.Inbox{Messages: []device.ReceivedSMS{
+		{Id: 1, Number: "NOS", Time: now.Add(-48 * time.Hour), Text: "Carregamento de 10,00EUR efetuado"},
+		{Id: 2, Number: "NOS", Time: now.Add(-time.Hour), Text: "Atingiu 80% do seu plafond de dados"},
+	}}
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return inbox, nil }}
+	for i := 0; i < 2; i++ {
+		err := archive.Poll(source)
+		if err != nil {
+			t.Fatalf("[TestArchive] Error: %s", err)
+		}
+	}
+	archive.Add(NewRecord("home", SENT, "+351910000000", now, "Backup done"))
+
+	// reopen from the file
+	archive, err := Open(archive.File)
+	if err != nil {
+		t.Fatalf("[TestArchive] Error: %s", err)
+	}
+	archive.Now = func() time.Time { return now }
+	if records := archive.Search(Query{}); len(records) != 3 || records[2].Direction != SENT {
+		t.Fatalf("Expected 3 records, got: %+v", records)
+	}
+
+	tests := []struct {
+		query    Query
+		expected int
+	}{
+		{Query{Text: "carregamento EUR"}, 1},
+		{Query{Text: "carregamento dados"}, 0},
+		{Query{Number: "NOS", Direction: RECEIVED}, 2},
+		{Query{Since: now.Add(-24 * time.Hour)}, 2},
+		{Query{Until: now}, 2},
+		{Query{Limit: 1}, 1},
+	}
+	for _, test := range tests {
+		if records := archive.Search(test.query); len(records) != test.expected {
+			t.Logf("Search(%+v): expected %d records, got: %d", test.query, test.expected, len(records))
+			t.Fail()
+		}
+	}
+
+	archive.Retention = 24 * time.Hour
+	err = archive.Expire()
+	if err != nil {
+		t.Fatalf("[TestArchive] Error: %s", err)
+	}
+	archive.Poll(source)
+	archive, _ = Open(archive.File)
+	if records := archive.Search(Query{}); len(records) != 2 || strings.Contains(records[0].Text, "plafond") == false {
+		t.Logf("Expected the old message removed, got: %+v", records)
+		t.Fail()
+	}
+}
+
+func TestSentOnce(t *testing.T) {
+	archive, cleanup := testArchive(t)
+	defer cleanup()
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	archive.Now = func() time.Time { return now }
+
+	// archived when sent, then read from the modem storage stamped by its clock
+	archive.Add(NewRecord("home", SENT, "+351910000000", now, "Backup done"))
+	inbox := &modemtest.Inbox{Sent: []device.ReceivedSMS{
+		{Id: 7, Number: "+351910000000", Time: now.Add(90 * time.Second), Text: "Backup done"},
+		{Id: 8, Number: "+351910000000", Time: now.Add(time.Hour), Text: "Backup done"},
+	}}
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return inbox, nil }}
+	err := archive.Poll(source)
+	if err != nil {
+		t.Fatalf("[TestSentOnce] Error: %s", err)
+	}
+
+	archive, err = Open(archive.File)
+	if err != nil {
+		t.Fatalf("[TestSentOnce] Error: %s", err)
+	}
+	records := archive.Search(Query{Direction: SENT})
+	if len(records) != 2 || records[0].Time.Equal(now) == false || records[1].Time.Equal(now.Add(time.Hour)) == false {
+		t.Logf("Expected the message sent archived once and the one sent an hour later, got: %+v", records)
+		t.Fail()
+	}
+}
+
+func TestExport(t *testing.T) {
+	archive, cleanup := testArchive(t)
+	defer cleanup()
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	archive.Add(NewRecord("home", RECEIVED, "NOS", now, "Saldo: 2,00 GB, \"obrigado\""))
+
+	var csv bytes.Buffer
+	WriteCSV(&csv, archive.Search(Query{}))
+	expected := "time,modem,direction,number,text\n2019-03-14T10:00:00Z,home,received,NOS,\"Saldo: 2,00 GB, \"\"obrigado\"\"\"\n"
+	if csv.String() != expected {
+		t.Logf("Expected CSV: %q, got: %q", expected, csv.String())
+		t.Fail()
+	}
+
+	w := httptest.NewRecorder()
+	archive.ServeHTTP(w, httptest.NewRequest("GET", API_PATH+"?q=saldo&since=2019-03-14", nil))
+	var records []Record
+	json.NewDecoder(w.Body).Decode(&records)
+	if w.Code != http.StatusOK || len(records) != 1 || records[0].Number != "NOS" {
+		t.Logf("Unexpected response: %d %+v", w.Code, records)
+		t.Fail()
+	}
+
+	w = httptest.NewRecorder()
+	archive.ServeHTTP(w, httptest.NewRequest("GET", API_PATH+"?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Logf("Expected 400 on an invalid date, got: %d", w.Code)
+		t.Fail()
+	}
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// API_PATH is the path of the search API
+const API_PATH = "/api/v1/archive"
+
+// DATE_LAYOUT is the layout of the dates accepted by ParseTime, besides RFC 3339
+const DATE_LAYOUT = "2006-01-02"
+
+// ParseTime parse a date or a RFC 3339 time, a date is midnight local time
+func ParseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation(DATE_LAYOUT, value, time.Local)
+}
+
+// ParseQuery read a query from the parameters q, number, modem, direction, since, until and limit
+func ParseQuery(values url.Values) (Query, error) {
+	query := Query{
+		Text:      values.Get("q"),
+		Number:    values.Get("number"),
+		Modem:     values.Get("modem"),
+		Direction: values.Get("direction"),
+	}
+	var err error
+	if since := values.Get("since"); since != "" {
+		query.Since, err = ParseTime(since)
+		if err != nil {
+			return query, fmt.Errorf("since: %s", err)
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		query.Until, err = ParseTime(until)
+		if err != nil {
+			return query, fmt.Errorf("until: %s", err)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return query, fmt.Errorf("limit: %s", err)
+		}
+	}
+	return query, nil
+}
+
+// ServeHTTP return the records matching the query parameters, as JSON or as CSV with format=csv
+func (archive *Archive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records := archive.Search(query)
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		WriteJSON(w, records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="sms.csv"`)
+		WriteCSV(w, records)
+	default:
+		http.Error(w, "format: expected json or csv", http.StatusBadRequest)
+	}
+}
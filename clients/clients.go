@@ -0,0 +1,131 @@
+// Package clients expose the Wi-Fi and USB clients of the modems as metrics. The number of clients
+// exported per modem is limited, since every client is a new series, and the MAC addresses can be
+// replaced by a keyed hash so the metrics don't store them.
+package clients
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// DEFAULT_LIMIT is the default maximum number of clients exported per modem
+const DEFAULT_LIMIT = 32
+
+// HASH_LENGTH is the number of hexadecimal digits of a hashed MAC address
+const HASH_LENGTH = 12
+
+// HashMAC return a keyed hash of a MAC address, the same for any case and separator
+func HashMAC(key string, mac string) string {
+	normalized := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(mac))
+	hash := hmac.New(sha256.New, []byte(key))
+	hash.Write([]byte(normalized))
+	return hex.EncodeToString(hash.Sum(nil))[:HASH_LENGTH]
+}
+
+// modem is the last client list of a modem
+type modem struct {
+	labels  []string
+	clients []device.ConnectedClient
+	dropped int
+}
+
+// Collector keep the last client list of each modem, set by Update
+type Collector struct {
+	// Limit is the maximum number of clients exported per modem, the longest connected first
+	Limit int
+	// HashKey replace the MAC addresses by HashMAC when not empty
+	HashKey string
+
+	mutex  sync.Mutex
+	modems map[string]*modem
+
+	connected *prometheus.Desc
+	duration  *prometheus.Desc
+	download  *prometheus.Desc
+	upload    *prometheus.Desc
+	dropped   *prometheus.Desc
+}
+
+// New return a collector for modems identified by the labels modemLabels
+func New(modemLabels []string, limit int, hashKey string) *Collector {
+	clientLabels := append(append([]string{}, modemLabels...), "mac", "hostname")
+	return &Collector{
+		Limit:     limit,
+		HashKey:   hashKey,
+		modems:    map[string]*modem{},
+		connected: prometheus.NewDesc("modem_client_connected", "Client connected to the modem, by MAC address, hostname and type: wifi, usb or ethernet", append(append([]string{}, clientLabels...), "type"), nil),
+		duration:  prometheus.NewDesc("modem_client_connected_seconds", "Time since the client connected", clientLabels, nil),
+		download:  prometheus.NewDesc("modem_client_download_bytes", "Bytes downloaded by the client since it connected, on models counting them", clientLabels, nil),
+		upload:    prometheus.NewDesc("modem_client_upload_bytes", "Bytes uploaded by the client since it connected, on models counting them", clientLabels, nil),
+		dropped:   prometheus.NewDesc("modem_clients_over_limit", "Connected clients not exported because of the limit", modemLabels, nil),
+	}
+}
+
+// Update set the clients of a modem, identified by the values of the modem labels
+func (collector *Collector) Update(labels []string, clients []device.ConnectedClient) {
+	clients = append([]device.ConnectedClient{}, clients...)
+	for i := range clients {
+		if collector.HashKey != "" {
+			clients[i].MAC = HashMAC(collector.HashKey, clients[i].MAC)
+		} else {
+			clients[i].MAC = strings.ToLower(clients[i].MAC)
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		if clients[i].Connected != clients[j].Connected {
+			return clients[i].Connected > clients[j].Connected
+		}
+		return clients[i].MAC < clients[j].MAC
+	})
+	dropped := 0
+	if collector.Limit >= 0 && len(clients) > collector.Limit {
+		dropped = len(clients) - collector.Limit
+		clients = clients[:collector.Limit]
+	}
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.modems[strings.Join(labels, "\x00")] = &modem{labels: labels, clients: clients, dropped: dropped}
+}
+
+// Describe implement prometheus.Collector
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.connected
+	ch <- collector.duration
+	ch <- collector.download
+	ch <- collector.upload
+	ch <- collector.dropped
+}
+
+// Collect implement prometheus.Collector
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	for _, modem := range collector.modems {
+		ch <- prometheus.MustNewConstMetric(collector.dropped, prometheus.GaugeValue, float64(modem.dropped), modem.labels...)
+		// a MAC address is exported once, some models list a client on several interfaces
+		seen := map[string]bool{}
+		for _, client := range modem.clients {
+			if seen[client.MAC] {
+				continue
+			}
+			seen[client.MAC] = true
+			labels := append(append([]string{}, modem.labels...), client.MAC, client.Hostname)
+			ch <- prometheus.MustNewConstMetric(collector.connected, prometheus.GaugeValue, 1, append(labels, client.Type)...)
+			ch <- prometheus.MustNewConstMetric(collector.duration, prometheus.GaugeValue, client.Connected.Seconds(), labels...)
+			if client.Traffic != nil {
+				ch <- prometheus.MustNewConstMetric(collector.download, prometheus.GaugeValue, client.Traffic.DownloadBytes, labels...)
+				ch <- prometheus.MustNewConstMetric(collector.upload, prometheus.GaugeValue, client.Traffic.UploadBytes, labels...)
+			}
+		}
+	}
+}
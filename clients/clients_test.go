@@ -0,0 +1,76 @@
+package clients
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+func gather(t *testing.T, collector *Collector) string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	for _, family := range families {
+		expfmt.MetricFamilyToText(&output, family)
+	}
+	return output.String()
+}
+
+func TestCollector(t *testing.T) {
+	clients := []device.ConnectedClient{
+		{MAC: "A0:B1:C2:D3:E4:F5", Hostname: "laptop", Type: device.CLIENT_WIFI, Connected: time.Hour},
+		{MAC: "a0:b1:c2:d3:e4:f6", Hostname: "phone", Type: device.CLIENT_WIFI, Connected: time.Minute, Traffic: &device.ClientTraffic{DownloadBytes: 2000, UploadBytes: 100}},
+		{MAC: "a0:b1:c2:d3:e4:f7", Hostname: "camera", Type: device.CLIENT_USB, Connected: time.Second},
+	}
+	collector := New([]string{"modem"}, 2, "")
+	collector.Update([]string{"home"}, clients)
+
+	output := gather(t, collector)
+	for _, expected := range []string{
+		`modem_client_connected{hostname="laptop",mac="a0:b1:c2:d3:e4:f5",modem="home",type="wifi"} 1`,
+		`modem_client_connected_seconds{hostname="laptop",mac="a0:b1:c2:d3:e4:f5",modem="home"} 3600`,
+		`modem_client_download_bytes{hostname="phone",mac="a0:b1:c2:d3:e4:f6",modem="home"} 2000`,
+		`modem_clients_over_limit{modem="home"} 1`,
+	} {
+		if strings.Contains(output, expected) == false {
+			t.Logf("Expected %s in:\n%s", expected, output)
+			t.Fail()
+		}
+	}
+	if strings.Contains(output, "camera") || strings.Contains(output, `modem_client_download_bytes{hostname="laptop"`) {
+		t.Logf("Expected the last connected client dropped, and no traffic without counters:\n%s", output)
+		t.Fail()
+	}
+
+	// a client disconnected is no longer exported
+	collector.Update([]string{"home"}, clients[:1])
+	if output = gather(t, collector); strings.Contains(output, "phone") {
+		t.Logf("Expected the disconnected client removed:\n%s", output)
+		t.Fail()
+	}
+}
+
+func TestHashMAC(t *testing.T) {
+	collector := New([]string{"modem"}, DEFAULT_LIMIT, "secret")
+	collector.Update([]string{"home"}, []device.ConnectedClient{{MAC: "A0:B1:C2:D3:E4:F5", Hostname: "laptop", Type: device.CLIENT_WIFI}})
+
+	hash := HashMAC("secret", "a0-b1-c2-d3-e4-f5")
+	if len(hash) != HASH_LENGTH || hash == HashMAC("other", "a0:b1:c2:d3:e4:f5") {
+		t.Fatalf("Unexpected hash: %s", hash)
+	}
+	output := gather(t, collector)
+	if strings.Contains(output, `mac="`+hash+`"`) == false || strings.Contains(strings.ToLower(output), "a0:b1") {
+		t.Logf("Expected the MAC address hashed as %s:\n%s", hash, output)
+		t.Fail()
+	}
+}
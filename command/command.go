@@ -0,0 +1,440 @@
+// Package command is an SMS command channel, to manage a modem when its data path is down. The
+// inboxes are polled for commands from allow-listed numbers, optionally prefixed by a PIN, like
+// "1234 REBOOT". Each command is run against the modem, answered by SMS and written to an audit
+// log. A command is run once: the processed messages are recorded in a state file, deleted from
+// the inbox, and messages older than MaxAge are refused.
+package command
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Commands
+const (
+	STATUS    = "STATUS"
+	REBOOT    = "REBOOT"
+	RECONNECT = "RECONNECT"
+	WIFI_ON   = "WIFI ON"
+	WIFI_OFF  = "WIFI OFF"
+	HELP      = "HELP"
+)
+
+// COMMANDS are the known commands
+var COMMANDS = []string{STATUS, REBOOT, RECONNECT, WIFI_ON, WIFI_OFF, HELP}
+
+// Audit results
+const (
+	RESULT_DONE    = "done"
+	RESULT_FAILED  = "failed"
+	RESULT_DENIED  = "denied"
+	RESULT_EXPIRED = "expired"
+	RESULT_UNKNOWN = "unknown"
+)
+
+// Defaults of the config
+const (
+	DEFAULT_INTERVAL = 30 * time.Second
+	DEFAULT_MAX_AGE  = 15 * time.Minute
+)
+
+// SEND_TIMEOUT is the time a reply has to be sent
+const SEND_TIMEOUT = 2 * time.Minute
+
+var (
+	ErrBadPIN         = errors.New("bad PIN")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// Config of the command channel, read from a JSON file
+type Config struct {
+	// Allow are the phone numbers allowed to send commands, in international format
+	Allow []string `json:"allow"`
+	// PIN is the first word of the commands when set
+	PIN string `json:"pin,omitempty"`
+	// Interval between two polls, like "30s"
+	Interval string `json:"interval,omitempty"`
+	// MaxAge is the age beyond which a command is refused, like "15m"
+	MaxAge    string `json:"max_age,omitempty"`
+	AuditLog  string `json:"audit_log"`
+	StateFile string `json:"state_file"`
+
+	interval time.Duration
+	maxAge   time.Duration
+	allow    map[string]bool
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config
+func (config *Config) Validate() error {
+	var err error
+	config.interval, err = poll.Duration("interval", config.Interval, DEFAULT_INTERVAL)
+	if err != nil {
+		return err
+	}
+	config.maxAge, err = poll.Duration("max_age", config.MaxAge, DEFAULT_MAX_AGE)
+	if err != nil {
+		return err
+	}
+	if config.AuditLog == "" || config.StateFile == "" {
+		return fmt.Errorf("audit_log and state_file are required")
+	}
+	if strings.ContainsAny(config.PIN, " \t\n") {
+		return fmt.Errorf("pin: no space allowed")
+	}
+
+	config.allow = map[string]bool{}
+	for _, number := range config.Allow {
+		number = normalize(number)
+		if strings.HasPrefix(number, "+") == false {
+			return fmt.Errorf("allow: %s is not in international format", number)
+		}
+		config.allow[number] = true
+	}
+	if len(config.allow) == 0 {
+		return fmt.Errorf("allow: no phone number")
+	}
+	return nil
+}
+
+// Allowed return true if number is allow-listed
+func (config *Config) Allowed(number string) bool {
+	return config.allow[normalize(number)]
+}
+
+// Parse return the command of a text, checking its PIN if pin is set
+func Parse(text string, pin string) (string, error) {
+	words := strings.Fields(text)
+	if pin != "" {
+		if len(words) == 0 || subtle.ConstantTimeCompare([]byte(words[0]), []byte(pin)) != 1 {
+			return "", ErrBadPIN
+		}
+		words = words[1:]
+	}
+	command := strings.ToUpper(strings.Join(words, " "))
+	for _, known := range COMMANDS {
+		if command == known {
+			return command, nil
+		}
+	}
+	return "", ErrUnknownCommand
+}
+
+// normalize remove the separators of a phone number, and replace the 00 prefix by +
+func normalize(number string) string {
+	number = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(number))
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+	return number
+}
+
+// SendFunc send a SMS through a modem, by name
+type SendFunc func(ctx context.Context, modem string, to []string, text string) error
+
+// Entry is a line of the audit log
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Modem   string    `json:"modem"`
+	Number  string    `json:"number"`
+	SMSId   int       `json:"sms_id"`
+	SMSTime time.Time `json:"sms_time"`
+	Command string    `json:"command,omitempty"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Reply   string    `json:"reply,omitempty"`
+}
+
+// Channel poll the sources for commands, it is also the collector of its metrics
+type Channel struct {
+	Config  *Config
+	Sources []poll.Source
+	// Send send the replies through the modem receiving the command
+	Send SendFunc
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex sync.Mutex
+	// seen are the processed messages, by key, with the time they were processed
+	seen     map[string]time.Time
+	commands *prometheus.CounterVec
+}
+
+// New return a channel with the state of the config, Run must be called to process the commands
+func New(config *Config, sources []poll.Source, send SendFunc) (*Channel, error) {
+	channel := &Channel{
+		Config:  config,
+		Sources: sources,
+		Send:    send,
+		Now:     time.Now,
+		seen:    map[string]time.Time{},
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_sms_commands_total",
+			Help: "SMS commands received, by modem, command and result: done, failed, denied, expired or unknown",
+		}, []string{"modem", "command", "result"}),
+	}
+
+	content, err := ioutil.ReadFile(config.StateFile)
+	if err != nil && os.IsNotExist(err) == false {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &channel.seen)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", config.StateFile, err)
+		}
+	}
+	return channel, nil
+}
+
+// Describe implement prometheus.Collector
+func (channel *Channel) Describe(ch chan<- *prometheus.Desc) {
+	channel.commands.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (channel *Channel) Collect(ch chan<- prometheus.Metric) {
+	channel.commands.Collect(ch)
+}
+
+// Run poll the sources every interval, until ctx is done
+func (channel *Channel) Run(ctx context.Context) {
+	poll.Run(ctx, "Command", channel.Sources, channel.Config.interval, channel.Poll)
+}
+
+// Poll process the commands received by a source
+func (channel *Channel) Poll(ctx context.Context, source poll.Source) error {
+	modem, err := source.Open()
+	if err != nil {
+		return err
+	}
+	reader, ok := modem.(device.SMSReader)
+	if ok == false {
+		return device.ErrNotSupported
+	}
+	messages, err := reader.ReceivedSMS()
+	if err != nil {
+		return err
+	}
+
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	now := channel.Now()
+	current := map[string]bool{}
+	for _, message := range messages {
+		current[messageKey(source.Name, message)] = true
+	}
+	for _, message := range messages {
+		key := messageKey(source.Name, message)
+		if _, ok := channel.seen[key]; ok {
+			continue
+		}
+
+		entry, process := channel.check(source.Name, message, now)
+		if process == false {
+			continue
+		}
+		// recorded before running, a command which restart the modem must not run again
+		channel.seen[key] = now
+		err = channel.save(current, now)
+		if err != nil {
+			return err
+		}
+
+		if entry.Result == "" {
+			channel.run(ctx, source, modem, entry)
+		}
+		channel.audit(entry)
+
+		err = reader.DeleteReceivedSMS(message)
+		if err != nil {
+			log.Errorf("[Command] %s: delete message %d: %s", source.Name, message.Id, err)
+		}
+		if entry.Command == REBOOT && entry.Result == RESULT_DONE {
+			// the modem is gone until it restarts
+			break
+		}
+	}
+	return channel.save(current, now)
+}
+
+// check return the audit entry of a message, and false if it isn't a command. The entry has a
+// result if the command must not run
+func (channel *Channel) check(name string, message device.ReceivedSMS, now time.Time) (*Entry, bool) {
+	// only the messages looking like commands are audited, not the operator messages
+	guess := guessCommand(message.Text)
+	if guess == "" {
+		return nil, false
+	}
+	entry := &Entry{Time: now, Modem: name, Number: message.Number, SMSId: message.Id, SMSTime: message.Time, Command: guess}
+	_, err := Parse(message.Text, channel.Config.PIN)
+
+	if channel.Config.Allowed(message.Number) == false {
+		entry.Result = RESULT_DENIED
+		entry.Error = "number not allowed"
+		return entry, true
+	}
+	switch {
+	case err == ErrBadPIN:
+		entry.Result = RESULT_DENIED
+		entry.Error = err.Error()
+	case err != nil:
+		entry.Result = RESULT_UNKNOWN
+		entry.Error = err.Error()
+	case now.Sub(message.Time) > channel.Config.maxAge || message.Time.Sub(now) > channel.Config.maxAge:
+		entry.Result = RESULT_EXPIRED
+		entry.Error = fmt.Sprintf("sent at %s", message.Time.Format(time.RFC3339))
+	}
+	return entry, true
+}
+
+// guessCommand return the command of a text, with or without a PIN, or an empty string
+func guessCommand(text string) string {
+	command, err := Parse(text, "")
+	if err == nil {
+		return command
+	}
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return ""
+	}
+	command, _ = Parse(strings.Join(words[1:], " "), "")
+	return command
+}
+
+// run execute the command of entry and send the reply
+func (channel *Channel) run(ctx context.Context, source poll.Source, modem device.Device, entry *Entry) {
+	reply := func(text string) {
+		entry.Reply = text
+		sendCtx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+		defer cancel()
+		err := channel.Send(sendCtx, source.Name, []string{entry.Number}, text)
+		if err != nil {
+			log.Errorf("[Command] %s: reply to %s: %s", source.Name, entry.Number, err)
+		}
+	}
+
+	controller, ok := modem.(device.Controller)
+	var err error
+	switch {
+	case entry.Command == STATUS:
+		reply(Status(source.Name, modem))
+	case entry.Command == HELP:
+		reply(fmt.Sprintf("%s: %s", source.Name, strings.Join(COMMANDS, ", ")))
+	case ok == false:
+		err = device.ErrNotSupported
+	case entry.Command == REBOOT:
+		// answered first, the modem can't send anything once rebooting
+		reply(fmt.Sprintf("%s: rebooting", source.Name))
+		err = controller.Reboot()
+	case entry.Command == RECONNECT:
+		err = controller.Reconnect()
+		if err == nil {
+			reply(Status(source.Name, modem))
+		}
+	case entry.Command == WIFI_ON, entry.Command == WIFI_OFF:
+		err = controller.SetWiFi(entry.Command == WIFI_ON)
+		if err == nil {
+			reply(fmt.Sprintf("%s: Wi-Fi %s", source.Name, strings.ToLower(strings.TrimPrefix(entry.Command, "WIFI "))))
+		}
+	}
+
+	entry.Result = RESULT_DONE
+	if err != nil {
+		entry.Result = RESULT_FAILED
+		entry.Error = err.Error()
+		if entry.Command != REBOOT {
+			reply(fmt.Sprintf("%s: %s failed: %s", source.Name, entry.Command, err))
+		}
+	}
+}
+
+// Status return a one line summary of the modem state
+func Status(name string, modem device.Device) string {
+	parts := []string{name + ":"}
+	connectionState, err := modem.ConnectionState()
+	if err == nil {
+		switch connectionState.ConnectionStatus {
+		case device.CONNECTED:
+			parts = append(parts, "connected "+connectionState.IPv4Address)
+			parts = append(parts, "up "+(time.Duration(connectionState.ConnectionTime)*time.Second).String())
+		case device.CONNECTING:
+			parts = append(parts, "connecting")
+		default:
+			parts = append(parts, "disconnected")
+		}
+	}
+	signal, err := modem.Signal()
+	if err == nil {
+		parts = append(parts, fmt.Sprintf("%s %s RSRP %.0fdBm", signal.NetworkName, signal.NetworkType, signal.RSRP))
+	}
+	systemStatus, err := modem.SystemStatus()
+	if err == nil {
+		parts = append(parts, fmt.Sprintf("%.0f clients", systemStatus.CurrentConnection))
+		if systemStatus.Battery != nil {
+			parts = append(parts, fmt.Sprintf("battery %.0f%%", systemStatus.Battery.Capacity))
+		}
+	}
+	if len(parts) == 1 {
+		parts = append(parts, "no answer")
+	}
+	return strings.Join(parts, " ")
+}
+
+// audit write an entry to the audit log and count it, called with the mutex locked
+func (channel *Channel) audit(entry *Entry) {
+	channel.commands.WithLabelValues(entry.Modem, entry.Command, entry.Result).Inc()
+	log.Infof("[Command] %s: %s from %s: %s %s", entry.Modem, entry.Command, entry.Number, entry.Result, entry.Error)
+
+	content, err := json.Marshal(entry)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(channel.Config.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err == nil {
+			_, err = file.Write(append(content, '\n'))
+			file.Close()
+		}
+	}
+	if err != nil {
+		log.Errorf("[Command] audit log: %s", err)
+	}
+}
+
+// save write the processed messages still in an inbox or processed less than MaxAge ago, called
+// with the mutex locked
+func (channel *Channel) save(current map[string]bool, now time.Time) error {
+	for key, processedAt := range channel.seen {
+		if current[key] == false && now.Sub(processedAt) > channel.Config.maxAge {
+			delete(channel.seen, key)
+		}
+	}
+	return poll.SaveJSON(channel.Config.StateFile, channel.seen)
+}
+
+// messageKey identify a message across polls, see device.ReceivedSMS
+func messageKey(name string, message device.ReceivedSMS) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%d\n%s", name, message.Number, message.Time.Unix(), message.Text)))
+	return hex.EncodeToString(hash[:16])
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text    string
+		pin     string
+		command string
+		err     error
+	}{
+		{"status", "", STATUS, nil},
+		{" Wifi  off ", "", WIFI_OFF, nil},
+		{"1234 REBOOT", "1234", REBOOT, nil},
+		{"REBOOT", "1234", "", ErrBadPIN},
+		{"4321 REBOOT", "1234", "", ErrBadPIN},
+		{"1234 SHUTDOWN", "1234", "", ErrUnknownCommand},
+	}
+	for _, test := range tests {
+		command, err := Parse(test.text, test.pin)
+		if command != test.command || err != test.err {
+			t.Logf("Parse(%q, %q): expected %q %v, got %q %v", test.text, test.pin, test.command, test.err, command, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	modem_alcatel_mw40v.ReconnectDelay = 0
+
+	server := modemtest.NewServer()
+	defer server.Close()
+	now := time.Now().Truncate(time.Second)
+	server.Modem.Now = func() time.Time { return now.Add(-time.Hour) }
+	server.Modem.Deliver("+351910000000", "1234 REBOOT")
+	// messages of a thread received the same second are joined as a concatenated message
+	sentAt := now.Add(-time.Minute)
+	server.Modem.Now = func() time.Time { return sentAt }
+	for _, message := range [][2]string{
+		{"+351910000000", "1234 wifi off"},
+		{"+351920000000", "1234 REBOOT"},
+		{"+351910000000", "4321 RECONNECT"},
+		{"NOS", "Saldo: 2,00 GB"},
+	} {
+		server.Modem.Deliver(message[0], message[1])
+		sentAt = sentAt.Add(time.Second)
+	}
+
+	config := &Config{
+		Allow:     []string{"00351 910 000 000"},
+		PIN:       "1234",
+		AuditLog:  filepath.Join(dir, "audit.log"),
+		StateFile: filepath.Join(dir, "state.json"),
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	var replies []string
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return modem, nil }}
+	send := func(ctx context.Context, name string, to []string, text string) error {
+		replies = append(replies, to[0]+": "+text)
+		return nil
+	}
+	channel, err := New(config, []poll.Source{source}, send)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+
+	err = channel.Poll(context.Background(), source)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	if server.Modem.WiFi() || server.Modem.Reboots() != 0 || server.Modem.Connected() == false {
+		t.Logf("Expected only the Wi-Fi off command run")
+		t.Fail()
+	}
+	if len(replies) != 1 || replies[0] != "+351910000000: home: Wi-Fi off" {
+		t.Logf("Unexpected replies: %q", replies)
+		t.Fail()
+	}
+	if inbox := server.Modem.Inbox(); len(inbox) != 1 || inbox[0].Number != "NOS" {
+		t.Logf("Expected the commands deleted, got: %+v", inbox)
+		t.Fail()
+	}
+
+	file, err := os.Open(config.AuditLog)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	defer file.Close()
+	var results []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		json.Unmarshal(scanner.Bytes(), &entry)
+		results = append(results, entry.Command+" "+entry.Result)
+	}
+	expected := "REBOOT expired, WIFI OFF done, REBOOT denied, RECONNECT denied"
+	if strings.Join(results, ", ") != expected {
+		t.Logf("Expected audit: %s, got: %s", expected, strings.Join(results, ", "))
+		t.Fail()
+	}
+
+	// a command still in the inbox after a restart is not run again
+	server.Modem.Deliver("+351910000000", "1234 STATUS")
+	server.Modem.SetError("DeleteSMS", &modem_alcatel_mw40v.RPCError{Code: "-1", Message: "busy"})
+	channel.Poll(context.Background(), source)
+	channel, err = New(config, []poll.Source{source}, send)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	channel.Poll(context.Background(), source)
+	if len(replies) != 2 || strings.HasPrefix(replies[1], "+351910000000: home: connected") == false {
+		t.Logf("Expected one status reply, got: %q", replies)
+		t.Fail()
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// startControl serve the control API of the targets, protected by token
+func startControl(token string, targets []*target) error {
+	var sources []control.Source
+	for _, t := range targets {
+		t := t
+		sources = append(sources, control.Source{Name: t.Alias, URL: t.Url, Open: func() (device.Device, error) {
+			modem, _, err := t.open()
+			return modem, err
+		}})
+	}
+	api, err := control.New(token, sources)
+	if err != nil {
+		return err
+	}
+	err = prometheus.Register(api)
+	if err != nil {
+		return err
+	}
+	http.Handle(control.API_PATH, api)
+	log.Infof("Control API on %s", control.API_PATH)
+	return nil
+}
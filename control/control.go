@@ -0,0 +1,203 @@
+// Package control is the REST API changing the modem settings, for the staff managing the
+// hotspots. Its resources are per target, at API_PATH<target>/<resource>, and every request need
+// the API token as a bearer token:
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://exporter:8080/api/v1/control/home/wifi
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// API_PATH is the path of the control API
+const API_PATH = "/api/v1/control/"
+
+// MIN_TOKEN_LENGTH is the minimum length of the API token
+const MIN_TOKEN_LENGTH = 16
+
+// Request results
+const (
+	RESULT_OK            = "ok"
+	RESULT_INVALID       = "invalid"
+	RESULT_UNAUTHORIZED  = "unauthorized"
+	RESULT_NOT_SUPPORTED = "not_supported"
+	RESULT_FAILED        = "failed"
+)
+
+// Source is a modem managed by the API, URL is the address the exporter reach it at
+type Source struct {
+	Name string
+	URL  string
+	Open func() (device.Device, error)
+}
+
+// API serve the control API, it is also the collector of its metrics
+type API struct {
+	Token   string
+	Sources []Source
+
+	requests *prometheus.CounterVec
+}
+
+// New return the API of the sources, protected by token
+func New(token string, sources []Source) (*API, error) {
+	if len(token) < MIN_TOKEN_LENGTH {
+		return nil, fmt.Errorf("control API token: at least %d characters", MIN_TOKEN_LENGTH)
+	}
+	return &API{
+		Token:   token,
+		Sources: sources,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_control_requests_total",
+			Help: "Requests to the control API, by modem, resource and result: ok, invalid, unauthorized, not_supported or failed",
+		}, []string{"modem", "resource", "result"}),
+	}, nil
+}
+
+// ServeHTTP check the token and serve a resource of a source
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, API_PATH), "/"), "/")
+	name, resource := path[0], ""
+	if len(path) > 1 {
+		resource = path[1]
+	}
+	// the labels are only set for the known targets and resources, so requests can't add series
+	var labels [2]string
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		api.requests.WithLabelValues(labels[0], labels[1], result(recorder.status)).Inc()
+	}()
+
+	if authorized(r, api.Token) == false {
+		unauthorized(recorder)
+		return
+	}
+	var source *Source
+	for i := range api.Sources {
+		if api.Sources[i].Name == name {
+			source = &api.Sources[i]
+		}
+	}
+	if source == nil {
+		http.Error(recorder, "unknown target: "+name, http.StatusNotFound)
+		return
+	}
+	labels[0] = name
+	modem, err := source.Open()
+	if err != nil {
+		writeError(recorder, err)
+		return
+	}
+
+	switch resource {
+	case "wifi":
+		labels[1] = resource
+		api.serveWiFi(recorder, r, modem, path[2:])
+	case "macfilter":
+		labels[1] = resource
+		api.serveMACFilter(recorder, r, modem, source, path[2:])
+	case "firewall":
+		labels[1] = resource
+		api.serveFirewall(recorder, r, modem, path[2:])
+	case "portforwarding":
+		labels[1] = resource
+		api.servePortForwarding(recorder, r, modem, path[2:])
+	case "lan":
+		labels[1] = resource
+		api.serveLAN(recorder, r, modem, source, path[2:])
+	default:
+		http.NotFound(recorder, r)
+	}
+	if recorder.status < 300 && r.Method != "GET" {
+		log.Infof("[Control] %s: %s %s", name, r.Method, r.URL.Path)
+	}
+}
+
+// Protect return handler serving only the requests with the bearer token, for the other APIs
+// exposing the modems or their users
+func Protect(token string, handler http.Handler) (http.Handler, error) {
+	if len(token) < MIN_TOKEN_LENGTH {
+		return nil, fmt.Errorf("API token: at least %d characters", MIN_TOKEN_LENGTH)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(r, token) == false {
+			unauthorized(w)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+// authorized return true if the request has the bearer token
+func authorized(r *http.Request, token string) bool {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") == false {
+		return false
+	}
+	bearer := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="control"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// Describe implement prometheus.Collector
+func (api *API) Describe(ch chan<- *prometheus.Desc) {
+	api.requests.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (api *API) Collect(ch chan<- prometheus.Metric) {
+	api.requests.Collect(ch)
+}
+
+// statusRecorder keep the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func result(status int) string {
+	switch {
+	case status < 300:
+		return RESULT_OK
+	case status == http.StatusUnauthorized:
+		return RESULT_UNAUTHORIZED
+	case status == http.StatusNotImplemented:
+		return RESULT_NOT_SUPPORTED
+	case status < 500:
+		return RESULT_INVALID
+	}
+	return RESULT_FAILED
+}
+
+// writeError answer 501 for the features the model doesn't have, 502 for the modem errors
+func writeError(w http.ResponseWriter, err error) {
+	if err == device.ErrNotSupported {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
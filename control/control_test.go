@@ -0,0 +1,425 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+const TOKEN = "0123456789abcdef"
+
+// newAPI return an API managing a fake modem as "home"
+func newAPI(t *testing.T) (*API, *modemtest.Server) {
+	server := modemtest.NewServer()
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error: %s", err)
+	}
+	api, err := New(TOKEN, []Source{{Name: "home", URL: server.URL, Open: func() (device.Device, error) { return modem, nil }}})
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error: %s", err)
+	}
+	return api, server
+}
+
+func request(api *API, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, API_PATH+path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+TOKEN)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthorization(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	for _, authorization := range []string{"", "Bearer wrong", TOKEN} {
+		r := httptest.NewRequest("GET", API_PATH+"home/wifi", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Logf("Authorization %q: expected 401, got %d", authorization, w.Code)
+			t.Fail()
+		}
+	}
+	if w := request(api, "GET", "lisbon/wifi", ""); w.Code != http.StatusNotFound {
+		t.Logf("Expected an unknown target, got %d", w.Code)
+		t.Fail()
+	}
+
+	_, err := New("short", nil)
+	if err == nil {
+		t.Logf("Expected a short token refused")
+		t.Fail()
+	}
+}
+
+func TestWiFi(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	w := request(api, "GET", "home/wifi", "")
+	var settings device.WiFiSettings
+	json.NewDecoder(w.Body).Decode(&settings)
+	if w.Code != http.StatusOK || settings.SSID != "MW40V_1234" || settings.Security != device.WIFI_SECURITY_WPA2 || settings.CurrentChannels[device.WIFI_BAND_2_4GHZ] != 6 || settings.Enabled == false {
+		t.Logf("Unexpected settings: %d %+v", w.Code, settings)
+		t.Fail()
+	}
+
+	invalid := []string{
+		`{"passphrase": "short"}`,
+		`{"ssid": ""}`,
+		`{"channel": 36}`,
+		`{"security": "wpa3"}`,
+		`{"band": "6GHz"}`,
+		`{"max_clients": 0}`,
+		`not json`,
+	}
+	for _, body := range invalid {
+		w = request(api, "PUT", "home/wifi", body)
+		if w.Code != http.StatusBadRequest {
+			t.Logf("%s: expected 400, got %d", body, w.Code)
+			t.Fail()
+		}
+	}
+	// the 2.4GHz only fake modem refuse 5GHz
+	if w = request(api, "PUT", "home/wifi", `{"band": "5GHz", "channel": 36}`); w.Code != http.StatusBadGateway {
+		t.Logf("Expected 5GHz refused, got %d", w.Code)
+		t.Fail()
+	}
+
+	w = request(api, "PUT", "home/wifi", `{"ssid": "Beach bar", "passphrase": "sunset;2019", "channel": 11, "hidden": true, "enabled": false}`)
+	json.NewDecoder(w.Body).Decode(&settings)
+	ap := server.Modem.WlanSettings().AP2G
+	if w.Code != http.StatusOK || ap.Ssid != "Beach bar" || ap.WpaKey != "sunset;2019" || ap.Channel != 11 || ap.SsidHidden != 1 || ap.MaxNumsta != 15 || server.Modem.WiFi() {
+		t.Logf("Unexpected modem settings: %d %+v, Wi-Fi on: %v", w.Code, ap, server.Modem.WiFi())
+		t.Fail()
+	}
+	if settings.CurrentChannels[device.WIFI_BAND_2_4GHZ] != 11 {
+		t.Logf("Expected the new settings, got %+v", settings)
+		t.Fail()
+	}
+
+	// in dual mode the channel is the 2.4GHz one, the 5GHz one is kept
+	wlanSettings := server.Modem.WlanSettings()
+	wlanSettings.WlanAPMode = modem_alcatel_mw40v.WLAN_AP_MODE_DUAL
+	wlanSettings.AP5G = &modem_alcatel_mw40v.WlanAP{Ssid: "Beach bar", Channel: 36, CurChannel: 36, MaxNumsta: 15}
+	server.Modem.SetWlanSettings(wlanSettings)
+	if w = request(api, "PUT", "home/wifi", `{"ssid": "Dual bar"}`); w.Code != http.StatusOK {
+		t.Logf("Expected a partial update in dual mode, got %d %s", w.Code, w.Body.String())
+		t.Fail()
+	}
+	w = request(api, "PUT", "home/wifi", `{"channel": 1}`)
+	wlanSettings = server.Modem.WlanSettings()
+	if w.Code != http.StatusOK || wlanSettings.AP2G.Channel != 1 || wlanSettings.AP5G.Channel != 36 || wlanSettings.AP5G.Ssid != "Dual bar" {
+		t.Logf("Unexpected dual mode settings: %d %+v %+v", w.Code, wlanSettings.AP2G, wlanSettings.AP5G)
+		t.Fail()
+	}
+
+	if w = request(api, "POST", "home/wifi/wps", ""); w.Code != http.StatusNoContent || server.Modem.Calls("SetWPSPbc") != 1 {
+		t.Logf("Expected WPS started, got %d", w.Code)
+		t.Fail()
+	}
+	if w = request(api, "DELETE", "home/wifi", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Logf("Expected 405, got %d", w.Code)
+		t.Fail()
+	}
+}
+
+func TestWiFiQRCode(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	w := request(api, "GET", "home/wifi/qr.svg", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || strings.Contains(w.Body.String(), "<path") == false {
+		t.Logf("Unexpected SVG: %d %s", w.Code, w.Body.String())
+		t.Fail()
+	}
+	w = request(api, "GET", "home/wifi/qr.png?scale=2", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || strings.HasPrefix(w.Body.String(), "\x89PNG") == false {
+		t.Logf("Unexpected PNG: %d", w.Code)
+		t.Fail()
+	}
+	if w = request(api, "GET", "home/wifi/qr.png?scale=0", ""); w.Code != http.StatusBadRequest {
+		t.Logf("Expected an invalid scale, got %d", w.Code)
+		t.Fail()
+	}
+
+	tests := []struct {
+		settings device.WiFiSettings
+		expected string
+	}{
+		{device.WiFiSettings{SSID: "home", Security: device.WIFI_SECURITY_WPA2, Passphrase: "12345678"}, `WIFI:T:WPA;S:home;P:12345678;;`},
+		{device.WiFiSettings{SSID: `bar;"1"`, Security: device.WIFI_SECURITY_WEP, Passphrase: `a:b\c,d`, Hidden: true}, `WIFI:T:WEP;S:bar\;\"1\";P:a\:b\\c\,d;H:true;;`},
+		{device.WiFiSettings{SSID: "guest", Security: device.WIFI_SECURITY_OPEN}, `WIFI:T:nopass;S:guest;;`},
+	}
+	for _, test := range tests {
+		if text := WiFiQRText(&test.settings); text != test.expected {
+			t.Logf("Expected %s, got %s", test.expected, text)
+			t.Fail()
+		}
+	}
+}
+
+func TestMACFilter(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	var filter device.MACFilter
+	w := request(api, "GET", "home/macfilter", "")
+	json.NewDecoder(w.Body).Decode(&filter)
+	if w.Code != http.StatusOK || filter.Mode != device.MAC_FILTER_DISABLED || filter.MACs == nil || len(filter.MACs) != 0 {
+		t.Logf("Unexpected filter: %d %+v", w.Code, filter)
+		t.Fail()
+	}
+
+	for _, body := range []string{`{"mode": "block"}`, `{"macs": ["a0:b1:c2"]}`} {
+		if w = request(api, "PUT", "home/macfilter", body); w.Code != http.StatusBadRequest {
+			t.Logf("%s: expected 400, got %d", body, w.Code)
+			t.Fail()
+		}
+	}
+	if w = request(api, "PUT", "home/macfilter/not-a-mac", ""); w.Code != http.StatusBadRequest {
+		t.Logf("Expected an invalid MAC address, got %d", w.Code)
+		t.Fail()
+	}
+
+	steps := []struct {
+		method   string
+		path     string
+		body     string
+		mode     int
+		expected string
+	}{
+		{"PUT", "home/macfilter", `{"mode": "allow", "macs": ["A0-B1-C2-D3-E4-F5", "a0:b1:c2:d3:e4:f6"]}`, modem_alcatel_mw40v.MAC_FILTER_ALLOW, "a0:b1:c2:d3:e4:f5 a0:b1:c2:d3:e4:f6"},
+		{"PUT", "home/macfilter/B8:27:EB:12:34:56", "", modem_alcatel_mw40v.MAC_FILTER_ALLOW, "a0:b1:c2:d3:e4:f5 a0:b1:c2:d3:e4:f6 b8:27:eb:12:34:56"},
+		{"DELETE", "home/macfilter/a0:b1:c2:d3:e4:f6", "", modem_alcatel_mw40v.MAC_FILTER_ALLOW, "a0:b1:c2:d3:e4:f5 b8:27:eb:12:34:56"},
+		// blocking remove the device from the allow list
+		{"POST", "home/macfilter/b8:27:eb:12:34:56/block", "", modem_alcatel_mw40v.MAC_FILTER_ALLOW, "a0:b1:c2:d3:e4:f5"},
+		{"PUT", "home/macfilter", `{"mode": "deny", "macs": []}`, modem_alcatel_mw40v.MAC_FILTER_DENY, ""},
+		{"POST", "home/macfilter/b8:27:eb:12:34:56/block", "", modem_alcatel_mw40v.MAC_FILTER_DENY, "b8:27:eb:12:34:56"},
+		{"POST", "home/macfilter/b8:27:eb:12:34:56/unblock", "", modem_alcatel_mw40v.MAC_FILTER_DENY, ""},
+	}
+	for _, step := range steps {
+		w = request(api, step.method, step.path, step.body)
+		settings := server.Modem.MacFilter()
+		var macs []string
+		for _, entry := range settings.MacList {
+			macs = append(macs, entry.MacAddress)
+		}
+		if w.Code != http.StatusOK || settings.MacFilterMode != step.mode || strings.Join(macs, " ") != step.expected {
+			t.Logf("%s %s: expected mode %d with %q, got %d: mode %d with %q", step.method, step.path, step.mode, step.expected, w.Code, settings.MacFilterMode, strings.Join(macs, " "))
+			t.Fail()
+		}
+	}
+
+	// an allow list without the connected laptop is refused unless forced
+	body := `{"mode": "allow", "macs": ["b8:27:eb:12:34:56"]}`
+	if w = request(api, "PUT", "home/macfilter", body); w.Code != http.StatusConflict || strings.Contains(w.Body.String(), "laptop a0:b1:c2:d3:e4:f5") == false {
+		t.Logf("Expected the laptop lockout refused, got %d %s", w.Code, w.Body.String())
+		t.Fail()
+	}
+	if server.Modem.MacFilter().MacFilterMode != modem_alcatel_mw40v.MAC_FILTER_DENY {
+		t.Logf("Expected the filter unchanged, got: %+v", server.Modem.MacFilter())
+		t.Fail()
+	}
+	if w = request(api, "PUT", "home/macfilter?force=true", body); w.Code != http.StatusOK || server.Modem.MacFilter().MacFilterMode != modem_alcatel_mw40v.MAC_FILTER_ALLOW {
+		t.Logf("Expected the forced filter applied, got %d %s", w.Code, w.Body.String())
+		t.Fail()
+	}
+
+	if w = request(api, "DELETE", "home/macfilter", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Logf("Expected 405, got %d", w.Code)
+		t.Fail()
+	}
+}
+
+func TestFirewall(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	var settings device.FirewallSettings
+	w := request(api, "GET", "home/firewall", "")
+	json.NewDecoder(w.Body).Decode(&settings)
+	if w.Code != http.StatusOK || settings != (device.FirewallSettings{Firewall: true, UPnP: true}) {
+		t.Logf("Unexpected settings: %d %+v", w.Code, settings)
+		t.Fail()
+	}
+
+	for _, body := range []string{`{"dmz": "camera"}`, `{"dmz": "fe80::1"}`, `not json`} {
+		if w = request(api, "PUT", "home/firewall", body); w.Code != http.StatusBadRequest {
+			t.Logf("%s: expected 400, got %d", body, w.Code)
+			t.Fail()
+		}
+	}
+	w = request(api, "PUT", "home/firewall", `{"upnp": false, "dmz": "192.168.1.50"}`)
+	json.NewDecoder(w.Body).Decode(&settings)
+	firewall, upnp, dmz := server.Modem.Firewall()
+	if w.Code != http.StatusOK || firewall.FirewallStatus != modem_alcatel_mw40v.STATUS_ENABLED || upnp.UpnpStatus != modem_alcatel_mw40v.STATUS_DISABLED || dmz.DMZIp != "192.168.1.50" || settings.DMZ != "192.168.1.50" {
+		t.Logf("Unexpected modem settings: %d %+v %+v %+v", w.Code, firewall, upnp, dmz)
+		t.Fail()
+	}
+	if w = request(api, "DELETE", "home/firewall", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Logf("Expected 405, got %d", w.Code)
+		t.Fail()
+	}
+}
+
+func TestPortForwarding(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	w := request(api, "GET", "home/portforwarding", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Logf("Expected no rules, got %d %s", w.Code, w.Body.String())
+		t.Fail()
+	}
+
+	var rule device.PortForwarding
+	w = request(api, "POST", "home/portforwarding", `{"name": "camera", "enabled": true, "protocol": "tcp", "wan_port": 8080, "lan_ip": "192.168.1.50", "lan_port": 80}`)
+	json.NewDecoder(w.Body).Decode(&rule)
+	if w.Code != http.StatusCreated || rule.ID != "1" || len(server.Modem.PortForwarding()) != 1 {
+		t.Fatalf("Unexpected rule: %d %+v", w.Code, rule)
+	}
+	if w = request(api, "POST", "home/portforwarding", `{"name": "web", "protocol": "tcp/udp", "wan_port": 8080, "lan_ip": "192.168.1.51", "lan_port": 80}`); w.Code != http.StatusConflict {
+		t.Logf("Expected a conflict, got %d", w.Code)
+		t.Fail()
+	}
+	invalid := []string{
+		`{"name": "web", "protocol": "icmp", "wan_port": 80, "lan_ip": "192.168.1.51", "lan_port": 80}`,
+		`{"name": "web", "protocol": "tcp", "wan_port": 70000, "lan_ip": "192.168.1.51", "lan_port": 80}`,
+		`{"name": "web", "protocol": "tcp", "wan_port": 80, "lan_ip": "", "lan_port": 80}`,
+		`{"protocol": "tcp", "wan_port": 80, "lan_ip": "192.168.1.51", "lan_port": 80}`,
+	}
+	for _, body := range invalid {
+		if w = request(api, "POST", "home/portforwarding", body); w.Code != http.StatusBadRequest {
+			t.Logf("%s: expected 400, got %d", body, w.Code)
+			t.Fail()
+		}
+	}
+
+	if w = request(api, "DELETE", "home/portforwarding/2", ""); w.Code != http.StatusNotFound {
+		t.Logf("Expected an unknown rule, got %d", w.Code)
+		t.Fail()
+	}
+	if w = request(api, "DELETE", "home/portforwarding/1", ""); w.Code != http.StatusNoContent || len(server.Modem.PortForwarding()) != 0 {
+		t.Logf("Expected the rule removed, got %d", w.Code)
+		t.Fail()
+	}
+	if w = request(api, "PUT", "home/portforwarding", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Logf("Expected 405, got %d", w.Code)
+		t.Fail()
+	}
+}
+
+func TestLAN(t *testing.T) {
+	api, server := newAPI(t)
+	defer server.Close()
+
+	var settings device.LANSettings
+	w := request(api, "GET", "home/lan", "")
+	json.NewDecoder(w.Body).Decode(&settings)
+	if w.Code != http.StatusOK || settings.Gateway != "192.168.1.1" || settings.DHCP == false || settings.LeaseHours != 12 || settings.DNSMode != device.DNS_MODE_AUTO {
+		t.Logf("Unexpected settings: %d %+v", w.Code, settings)
+		t.Fail()
+	}
+
+	invalid := []string{
+		`{"gateway": "192.168.1.0"}`,
+		`{"netmask": "255.0.255.0"}`,
+		`{"dhcp_start": "192.168.2.100"}`,
+		`{"dhcp_start": "192.168.1.200", "dhcp_end": "192.168.1.100"}`,
+		`{"gateway": "192.168.1.150"}`,
+		`{"lease_hours": 0}`,
+		`{"dns_mode": "manual"}`,
+		`{"dns_mode": "manual", "dns": ["dns.google"]}`,
+		`not json`,
+	}
+	for _, body := range invalid {
+		if w = request(api, "PUT", "home/lan", body); w.Code != http.StatusBadRequest {
+			t.Logf("%s: expected 400, got %d", body, w.Code)
+			t.Fail()
+		}
+	}
+
+	// the exporter reach the fake modem at 127.0.0.1
+	body := `{"gateway": "10.23.0.1", "netmask": "255.255.255.0", "dhcp_start": "10.23.0.10", "dhcp_end": "10.23.0.99", "dns_mode": "manual", "dns": ["1.1.1.1", "9.9.9.9"]}`
+	if w = request(api, "PUT", "home/lan", body); w.Code != http.StatusConflict || server.Modem.LanSettings().IPv4IPAddress != "192.168.1.1" {
+		t.Logf("Expected an unreachable gateway refused, got %d", w.Code)
+		t.Fail()
+	}
+	w = request(api, "PUT", "home/lan?force=true", body)
+	lan := server.Modem.LanSettings()
+	expected := modem_alcatel_mw40v.LanSettings{
+		IPv4IPAddress:    "10.23.0.1",
+		SubnetMask:       "255.255.255.0",
+		DHCPServerStatus: modem_alcatel_mw40v.STATUS_ENABLED,
+		StartIPAddress:   "10.23.0.10",
+		EndIPAddress:     "10.23.0.99",
+		DHCPLeaseTime:    12,
+		DNSMode:          modem_alcatel_mw40v.DNS_MODE_MANUAL,
+		DNSAddress1:      "1.1.1.1",
+		DNSAddress2:      "9.9.9.9",
+	}
+	if w.Code != http.StatusOK || lan != expected {
+		t.Logf("Unexpected modem settings: %d %+v", w.Code, lan)
+		t.Fail()
+	}
+
+	reaches := []struct {
+		url      string
+		expected bool
+	}{
+		{"http://10.23.0.1", true},
+		{"http://10.23.0.1:8080/", true},
+		{"http://192.168.1.1", false},
+		{"http://localhost:8080/", false},
+		{"http://modem.invalid", false},
+	}
+	settings = device.LANSettings{Gateway: "10.23.0.1"}
+	for _, test := range reaches {
+		if settings.Reaches(test.url) != test.expected {
+			t.Logf("%s: expected %v", test.url, test.expected)
+			t.Fail()
+		}
+	}
+	// host names are resolved
+	settings = device.LANSettings{Gateway: "127.0.0.1"}
+	if settings.Reaches("http://localhost:8080/") == false {
+		t.Logf("Expected localhost to reach 127.0.0.1")
+		t.Fail()
+	}
+}
+
+func TestProtect(t *testing.T) {
+	handler, err := Protect(TOKEN, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	if err != nil {
+		t.Fatalf("[TestProtect] Error: %s", err)
+	}
+	for authorization, expected := range map[string]int{"": http.StatusUnauthorized, TOKEN: http.StatusUnauthorized, "Bearer " + TOKEN: http.StatusNoContent} {
+		r := httptest.NewRequest("POST", "/api/v1/sms", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Logf("Authorization %q: expected %d, got %d", authorization, expected, w.Code)
+			t.Fail()
+		}
+	}
+	if _, err = Protect("", nil); err == nil {
+		t.Logf("Expected an empty token refused")
+		t.Fail()
+	}
+}
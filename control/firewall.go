@@ -0,0 +1,128 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// serveFirewall serve the settings exposing the LAN of a modem: GET <target>/firewall return the
+// firewall, ping from WAN, UPnP and DMZ settings, PUT change the fields set in the body
+func (api *API) serveFirewall(w http.ResponseWriter, r *http.Request, modem device.Device, path []string) {
+	configurer, ok := modem.(device.FirewallConfigurer)
+	if ok == false {
+		writeError(w, device.ErrNotSupported)
+		return
+	}
+	if len(path) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := configurer.FirewallSettings()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Method == "PUT" {
+		// the fields missing from the body are kept
+		err = json.NewDecoder(r.Body).Decode(settings)
+		if err == nil {
+			err = settings.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = configurer.SetFirewallSettings(settings)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// servePortForwarding serve the port forwarding rules of a modem:
+//   - GET <target>/portforwarding return the rules, POST add the rule of the body
+//   - DELETE <target>/portforwarding/<id> remove a rule
+func (api *API) servePortForwarding(w http.ResponseWriter, r *http.Request, modem device.Device, path []string) {
+	configurer, ok := modem.(device.FirewallConfigurer)
+	if ok == false {
+		writeError(w, device.ErrNotSupported)
+		return
+	}
+	if len(path) > 1 {
+		http.NotFound(w, r)
+		return
+	}
+	allowed := map[string]bool{"GET": len(path) == 0, "POST": len(path) == 0, "DELETE": len(path) == 1}
+	if allowed[r.Method] == false {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rule device.PortForwarding
+	if r.Method == "POST" {
+		err := json.NewDecoder(r.Body).Decode(&rule)
+		if err == nil {
+			err = rule.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	rules, err := configurer.PortForwardings()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if rules == nil {
+		rules = []device.PortForwarding{}
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, rules)
+	case "POST":
+		for _, existing := range rules {
+			if existing.WANPort == rule.WANPort && overlap(existing.Protocol, rule.Protocol) {
+				http.Error(w, fmt.Sprintf("WAN port %d is already forwarded by %s", rule.WANPort, existing.Name), http.StatusConflict)
+				return
+			}
+		}
+		added, err := configurer.AddPortForwarding(&rule)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, added)
+	case "DELETE":
+		found := false
+		for _, existing := range rules {
+			found = found || existing.ID == path[0]
+		}
+		if found == false {
+			http.Error(w, "unknown port forwarding rule: "+path[0], http.StatusNotFound)
+			return
+		}
+		err = configurer.RemovePortForwarding(path[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// overlap return true if the protocols share TCP or UDP
+func overlap(protocol string, other string) bool {
+	return protocol == other || protocol == device.PROTOCOL_BOTH || other == device.PROTOCOL_BOTH
+}
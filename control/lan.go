@@ -0,0 +1,63 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// serveLAN serve the LAN and DHCP settings of a modem: GET <target>/lan return them, PUT change the
+// fields set in the body. A change moving the modem away from the address of the source is refused
+// with 409, unless the request has ?force=true
+func (api *API) serveLAN(w http.ResponseWriter, r *http.Request, modem device.Device, source *Source, path []string) {
+	configurer, ok := modem.(device.LANConfigurer)
+	if ok == false {
+		writeError(w, device.ErrNotSupported)
+		return
+	}
+	if len(path) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := configurer.LANSettings()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, settings)
+		return
+	}
+
+	// the fields missing from the body are kept
+	err = json.NewDecoder(r.Body).Decode(settings)
+	if err == nil {
+		err = settings.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if settings.Reaches(source.URL) == false {
+		if r.URL.Query().Get("force") != "true" {
+			http.Error(w, fmt.Sprintf("the exporter reach %s at %s, it would be unreachable at gateway %s: repeat with ?force=true to apply", source.Name, source.URL, settings.Gateway), http.StatusConflict)
+			return
+		}
+		log.Warnf("[Control] %s: gateway moved to %s, %s is unreachable until the exporter configuration is changed", source.Name, settings.Gateway, source.URL)
+	}
+	err = configurer.SetLANSettings(settings)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
@@ -0,0 +1,185 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// serveMACFilter serve the Wi-Fi MAC filter of a modem:
+//   - GET <target>/macfilter return the filter, PUT change the fields set in the body
+//   - PUT <target>/macfilter/<mac> add a device to the list, DELETE remove it
+//   - POST <target>/macfilter/<mac>/block refuse a device whatever the mode, /unblock accept it again
+//
+// A change disconnecting connected clients other than the device of the path is refused with 409,
+// unless the request has ?force=true
+func (api *API) serveMACFilter(w http.ResponseWriter, r *http.Request, modem device.Device, source *Source, path []string) {
+	filtering, ok := modem.(device.MACFiltering)
+	if ok == false {
+		writeError(w, device.ErrNotSupported)
+		return
+	}
+
+	mac, action := "", ""
+	if len(path) > 0 {
+		hardware, err := net.ParseMAC(path[0])
+		if err != nil || len(hardware) != 6 {
+			http.Error(w, "invalid MAC address: "+path[0], http.StatusBadRequest)
+			return
+		}
+		mac = hardware.String()
+	}
+	if len(path) > 1 {
+		action = path[1]
+	}
+	if len(path) > 2 || (action != "" && action != "block" && action != "unblock") {
+		http.NotFound(w, r)
+		return
+	}
+	allowed := map[string]bool{
+		"GET": mac == "", "PUT": action == "",
+		"DELETE": mac != "" && action == "", "POST": action != "",
+	}
+	if allowed[r.Method] == false {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := filtering.MACFilter()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if filter.MACs == nil {
+		filter.MACs = []string{}
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, filter)
+		return
+	}
+
+	previous := device.MACFilter{Mode: filter.Mode, MACs: append([]string{}, filter.MACs...)}
+	changed := true
+	switch {
+	case mac == "":
+		// the fields missing from the body are kept
+		err = json.NewDecoder(r.Body).Decode(filter)
+		if err == nil {
+			err = filter.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case r.Method == "PUT":
+		changed = contains(filter.MACs, mac) == false
+		filter.MACs = append(filter.MACs, mac)
+	case r.Method == "DELETE":
+		changed = contains(filter.MACs, mac)
+		filter.MACs = remove(filter.MACs, mac)
+	case action == "block":
+		changed = filter.Block(mac)
+	case action == "unblock":
+		changed = filter.Unblock(mac)
+	}
+	if changed {
+		lockout, err := Lockout(modem, source.URL, &previous, filter, mac)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if lockout != "" {
+			if r.URL.Query().Get("force") != "true" {
+				http.Error(w, fmt.Sprintf("the filter would disconnect %s: repeat with ?force=true to apply", lockout), http.StatusConflict)
+				return
+			}
+			log.Warnf("[Control] %s: the MAC filter disconnect %s", source.Name, lockout)
+		}
+		err = filtering.SetMACFilter(filter)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if filter.MACs == nil {
+		filter.MACs = []string{}
+	}
+	writeJSON(w, http.StatusOK, filter)
+}
+
+// Lockout describe the connected Wi-Fi clients a filter disconnect, empty if none. target is the
+// device the change is about, it isn't reported unless it is the exporter host. When the clients
+// can't be listed, a switch to allow mode is reported as disconnecting them all
+func Lockout(modem device.Device, modemURL string, previous *device.MACFilter, filter *device.MACFilter, target string) (string, error) {
+	lister, ok := modem.(device.ClientLister)
+	if ok == false {
+		if filter.Mode == device.MAC_FILTER_ALLOW && previous.Mode != device.MAC_FILTER_ALLOW {
+			return "every client not listed", nil
+		}
+		return "", nil
+	}
+	clients, err := lister.ConnectedClients()
+	if err != nil {
+		return "", err
+	}
+
+	host := localIP(modemURL)
+	var names []string
+	for _, client := range filter.Disconnects(previous, clients) {
+		exporter := host != "" && client.IP == host
+		if strings.ToLower(client.MAC) == target && exporter == false {
+			continue
+		}
+		name := strings.ToLower(client.MAC)
+		if client.Hostname != "" {
+			name = client.Hostname + " " + name
+		}
+		if exporter {
+			name += " (the exporter host)"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", "), nil
+}
+
+// localIP return the address this host use to reach a modem, empty if unknown
+func localIP(modemURL string) string {
+	parsed, err := url.Parse(modemURL)
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	// no packet is sent, the route is only looked up
+	conn, err := net.Dial("udp", net.JoinHostPort(parsed.Hostname(), "80"))
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return host
+}
+
+func contains(macs []string, mac string) bool {
+	for _, address := range macs {
+		if address == mac {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(macs []string, mac string) []string {
+	result := []string{}
+	for _, address := range macs {
+		if address != mac {
+			result = append(result, address)
+		}
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/qrcode"
+)
+
+// DEFAULT_QR_SCALE is the size of a QR code module, in pixels
+const DEFAULT_QR_SCALE = 8
+
+// serveWiFi serve the Wi-Fi settings of a modem:
+//   - GET <target>/wifi return the settings, PUT change the fields set in the body
+//   - POST <target>/wifi/wps start the WPS push button pairing
+//   - GET <target>/wifi/qr.png or qr.svg return the join QR code, ?scale= is the pixels per module
+func (api *API) serveWiFi(w http.ResponseWriter, r *http.Request, modem device.Device, path []string) {
+	configurer, ok := modem.(device.WiFiConfigurer)
+	if ok == false {
+		writeError(w, device.ErrNotSupported)
+		return
+	}
+	action := strings.Join(path, "/")
+
+	switch {
+	case action == "" && (r.Method == "GET" || r.Method == "PUT"):
+		settings, err := configurer.WiFiSettings()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if r.Method == "PUT" {
+			// the fields missing from the body are kept
+			err = json.NewDecoder(r.Body).Decode(settings)
+			if err == nil {
+				err = settings.Validate()
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = configurer.SetWiFiSettings(settings)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			settings, err = configurer.WiFiSettings()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, settings)
+
+	case action == "wps" && r.Method == "POST":
+		err := configurer.StartWPS()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case (action == "qr.png" || action == "qr.svg") && r.Method == "GET":
+		scale := DEFAULT_QR_SCALE
+		if value := r.URL.Query().Get("scale"); value != "" {
+			var err error
+			scale, err = strconv.Atoi(value)
+			if err != nil || scale < 1 || scale > 100 {
+				http.Error(w, "scale: expected 1 to 100 pixels per module", http.StatusBadRequest)
+				return
+			}
+		}
+		settings, err := configurer.WiFiSettings()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		code, err := qrcode.Encode([]byte(WiFiQRText(settings)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the code hold the passphrase
+		w.Header().Set("Cache-Control", "no-store")
+		if action == "qr.svg" {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write(code.SVG(scale))
+			return
+		}
+		content, err := code.PNG(scale)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(content)
+
+	case action == "" || action == "wps" || action == "qr.png" || action == "qr.svg":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// WiFiQRText return the join text of a network read by the phone cameras, like
+// WIFI:T:WPA;S:home;P:secret;;
+func WiFiQRText(settings *device.WiFiSettings) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`).Replace
+
+	text := "WIFI:"
+	switch settings.Security {
+	case device.WIFI_SECURITY_OPEN:
+		text += "T:nopass;"
+	case device.WIFI_SECURITY_WEP:
+		text += "T:WEP;"
+	default:
+		text += "T:WPA;"
+	}
+	text += "S:" + escape(settings.SSID) + ";"
+	if settings.Security != device.WIFI_SECURITY_OPEN {
+		text += "P:" + escape(settings.Passphrase) + ";"
+	}
+	if settings.Hidden {
+		text += "H:true;"
+	}
+	return text + ";"
+}
@@ -0,0 +1,675 @@
+// Package device is the vendor neutral model of a modem used by the exporter. Drivers register
+// themselves with Register, usually from an init function, and are opened by name:
+//
+//	import _ "nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+//
+//	modem, err := device.Open("tcl", "http://192.168.1.1", device.Options{})
+package device
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotSupported is returned by the features a model doesn't have
+var ErrNotSupported = errors.New("not supported by this model")
+
+// ErrSendUnconfirmed is returned when a SMS was accepted by the modem but its send result is
+// unknown. It may have been sent, so sending it again can deliver it twice
+var ErrSendUnconfirmed = errors.New("SMS accepted by the modem, send result unknown")
+
+// Device is a modem, whatever its vendor
+type Device interface {
+	// Driver return the driver name
+	Driver() string
+	SystemInfo() (*SystemInfo, error)
+	SystemStatus() (*SystemStatus, error)
+	ConnectionState() (*ConnectionState, error)
+	Signal() (*Signal, error)
+	SMSStorageState() (*SMSStorageState, error)
+}
+
+// SMSSender is implemented by the devices able to send SMS
+type SMSSender interface {
+	// SendSMS send text to numbers and wait until the modem sent it, failed or ctx is done
+	SendSMS(ctx context.Context, to []string, text string) ([]SentSMS, error)
+}
+
+// SMSReader is implemented by the devices able to read their received SMS
+type SMSReader interface {
+	// ReceivedSMS return the received messages, oldest first
+	ReceivedSMS() ([]ReceivedSMS, error)
+	MarkReceivedSMSRead(message ReceivedSMS) error
+	DeleteReceivedSMS(message ReceivedSMS) error
+}
+
+// SentSMSReader is implemented by the devices keeping the messages they sent in their storage. The
+// messages are returned like received ones, Number is the recipient and they are never unread
+type SentSMSReader interface {
+	// StoredSentSMS return the sent messages, drafts and delivery reports, oldest first
+	StoredSentSMS() ([]ReceivedSMS, error)
+	DeleteStoredSentSMS(message ReceivedSMS) error
+}
+
+// SystemInfo identify the modem
+type SystemInfo struct {
+	Vendor          string
+	Model           string
+	DeviceName      string
+	SoftwareVersion string
+	HardwareVersion string
+	MacAddress      string
+	IMEI            string
+	IMSI            string
+	ICCID           string
+}
+
+// SystemStatus is the modem state, Battery is nil on models without battery
+type SystemStatus struct {
+	Battery           *Battery
+	Roaming           bool
+	SignalStrength    float64
+	CurrentConnection float64
+	TotalConnection   float64
+}
+
+// Battery capacity in percent, level in bars
+type Battery struct {
+	Capacity float64
+	Level    float64
+}
+
+// Connection status values
+const (
+	DISCONNECTED  = 0
+	CONNECTING    = 1
+	CONNECTED     = 2
+	DISCONNECTING = 3
+)
+
+// ConnectionState is the mobile data connection state and traffic, speeds and rates in bytes per second
+type ConnectionState struct {
+	ConnectionStatus float64
+	IPv4Address      string
+	IPv6Address      string
+	SpeedDownload    float64
+	SpeedUpload      float64
+	DownloadRate     float64
+	UploadRate       float64
+	ConnectionTime   float64
+	DownloadBytes    float64
+	UploadBytes      float64
+}
+
+// Signal is the radio signal quality, in dBm for RSSI and RSRP and in dB for RSRQ and SINR
+type Signal struct {
+	NetworkType string
+	NetworkName string
+	Strength    float64
+	RSSI        float64
+	RSRP        float64
+	RSRQ        float64
+	SINR        float64
+	CellId      string
+}
+
+// SMSStorageState is the SMS storage usage
+type SMSStorageState struct {
+	UnreadSMSCount float64
+	UsedCount      float64
+	MaxCount       float64
+	LeftCount      float64
+}
+
+// SentSMS is a message sent to a number, Reference identify it in delivery reports
+type SentSMS struct {
+	Number    string
+	Reference string
+	Encoding  string
+	Parts     int
+	Time      time.Time
+}
+
+// Controller is implemented by the devices that can be managed remotely
+type Controller interface {
+	Reboot() error
+	// Reconnect restart the mobile data connection
+	Reconnect() error
+	SetWiFi(enabled bool) error
+}
+
+// Wi-Fi security modes
+const (
+	WIFI_SECURITY_OPEN     = "open"
+	WIFI_SECURITY_WEP      = "wep"
+	WIFI_SECURITY_WPA      = "wpa"
+	WIFI_SECURITY_WPA2     = "wpa2"
+	WIFI_SECURITY_WPA_WPA2 = "wpa/wpa2"
+)
+
+// Wi-Fi bands, WIFI_BAND_DUAL run an access point on each band
+const (
+	WIFI_BAND_2_4GHZ = "2.4GHz"
+	WIFI_BAND_5GHZ   = "5GHz"
+	WIFI_BAND_DUAL   = "dual"
+)
+
+// WiFiSettings is the Wi-Fi access point. Channel is 0 for the automatic selection, in dual mode it
+// is the channel of the 2.4GHz access point. CurrentChannels are the channels in use by band, they
+// are read only
+type WiFiSettings struct {
+	Enabled         bool           `json:"enabled"`
+	SSID            string         `json:"ssid"`
+	Security        string         `json:"security"`
+	Passphrase      string         `json:"passphrase"`
+	Band            string         `json:"band"`
+	Channel         int            `json:"channel"`
+	Hidden          bool           `json:"hidden"`
+	MaxClients      int            `json:"max_clients"`
+	CurrentChannels map[string]int `json:"current_channels,omitempty"`
+}
+
+// WiFiConfigurer is implemented by the devices whose Wi-Fi settings can be changed
+type WiFiConfigurer interface {
+	WiFiSettings() (*WiFiSettings, error)
+	// SetWiFiSettings validate and apply the settings, turning the Wi-Fi on or off
+	SetWiFiSettings(settings *WiFiSettings) error
+	// StartWPS start the WPS push button pairing
+	StartWPS() error
+}
+
+// wifiChannels are the valid channels by band, 0 is the automatic selection. The channel of the dual
+// mode is the one of the 2.4GHz access point
+var wifiChannels = map[string][]int{
+	WIFI_BAND_2_4GHZ: {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
+	WIFI_BAND_5GHZ:   {0, 36, 40, 44, 48, 52, 56, 60, 64, 100, 104, 108, 112, 116, 120, 124, 128, 132, 136, 140, 149, 153, 157, 161, 165},
+	WIFI_BAND_DUAL:   {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13},
+}
+
+// Validate check the settings before they are sent to a modem
+func (settings *WiFiSettings) Validate() error {
+	if len(settings.SSID) == 0 || len(settings.SSID) > 32 {
+		return fmt.Errorf("ssid: expected 1 to 32 bytes, got %d", len(settings.SSID))
+	}
+
+	hexadecimal := func(value string) bool {
+		_, err := hex.DecodeString(value)
+		return err == nil
+	}
+	printable := func(value string) bool {
+		for _, c := range value {
+			if c < 0x20 || c > 0x7e {
+				return false
+			}
+		}
+		return true
+	}
+	switch settings.Security {
+	case WIFI_SECURITY_OPEN:
+		if settings.Passphrase != "" {
+			return fmt.Errorf("passphrase: not used by an open network")
+		}
+	case WIFI_SECURITY_WEP:
+		length := len(settings.Passphrase)
+		key := (length == 5 || length == 13) && printable(settings.Passphrase)
+		hexKey := (length == 10 || length == 26) && hexadecimal(settings.Passphrase)
+		if key == false && hexKey == false {
+			return fmt.Errorf("passphrase: a WEP key is 5 or 13 characters, or 10 or 26 hexadecimal digits")
+		}
+	case WIFI_SECURITY_WPA, WIFI_SECURITY_WPA2, WIFI_SECURITY_WPA_WPA2:
+		length := len(settings.Passphrase)
+		passphrase := length >= 8 && length <= 63 && printable(settings.Passphrase)
+		hexKey := length == 64 && hexadecimal(settings.Passphrase)
+		if passphrase == false && hexKey == false {
+			return fmt.Errorf("passphrase: a WPA passphrase is 8 to 63 printable ASCII characters, or 64 hexadecimal digits")
+		}
+	default:
+		return fmt.Errorf("security: unknown mode %s, expected %s, %s, %s, %s or %s", settings.Security, WIFI_SECURITY_OPEN, WIFI_SECURITY_WEP, WIFI_SECURITY_WPA, WIFI_SECURITY_WPA2, WIFI_SECURITY_WPA_WPA2)
+	}
+
+	channels, ok := wifiChannels[settings.Band]
+	if ok == false {
+		return fmt.Errorf("band: unknown band %s, expected %s, %s or %s", settings.Band, WIFI_BAND_2_4GHZ, WIFI_BAND_5GHZ, WIFI_BAND_DUAL)
+	}
+	valid := false
+	for _, channel := range channels {
+		valid = valid || channel == settings.Channel
+	}
+	if valid == false {
+		return fmt.Errorf("channel: %d isn't a %s channel", settings.Channel, settings.Band)
+	}
+	if settings.MaxClients < 1 {
+		return fmt.Errorf("max_clients: at least 1 client")
+	}
+	return nil
+}
+
+// ClientLister is implemented by the devices able to list their Wi-Fi and USB clients
+type ClientLister interface {
+	ConnectedClients() ([]ConnectedClient, error)
+}
+
+// Client connection types
+const (
+	CLIENT_WIFI     = "wifi"
+	CLIENT_USB      = "usb"
+	CLIENT_ETHERNET = "ethernet"
+)
+
+// ConnectedClient is a device connected to the modem, Traffic is nil when the model doesn't count it
+type ConnectedClient struct {
+	MAC       string
+	IP        string
+	Hostname  string
+	Type      string
+	Connected time.Duration
+	Traffic   *ClientTraffic
+}
+
+// ClientTraffic is the traffic of a client since it connected
+type ClientTraffic struct {
+	DownloadBytes float64
+	UploadBytes   float64
+}
+
+// MAC filter modes
+const (
+	MAC_FILTER_DISABLED = "disabled"
+	MAC_FILTER_ALLOW    = "allow"
+	MAC_FILTER_DENY     = "deny"
+)
+
+// MACFilter is the Wi-Fi MAC filter, MACs are the allowed or denied clients depending on Mode
+type MACFilter struct {
+	Mode string   `json:"mode"`
+	MACs []string `json:"macs"`
+}
+
+// MACFiltering is implemented by the devices with a Wi-Fi MAC filter
+type MACFiltering interface {
+	MACFilter() (*MACFilter, error)
+	SetMACFilter(filter *MACFilter) error
+}
+
+// Block change the filter so mac is refused, and return false if it already was. A disabled
+// filter become a deny list, of mac and the addresses it kept
+func (filter *MACFilter) Block(mac string) bool {
+	mac = strings.ToLower(mac)
+	if filter.Mode == MAC_FILTER_ALLOW {
+		var allowed []string
+		for _, address := range filter.MACs {
+			if strings.ToLower(address) != mac {
+				allowed = append(allowed, address)
+			}
+		}
+		if len(allowed) == len(filter.MACs) {
+			return false
+		}
+		filter.MACs = allowed
+		return true
+	}
+
+	changed := filter.Mode != MAC_FILTER_DENY
+	filter.Mode = MAC_FILTER_DENY
+	for _, denied := range filter.MACs {
+		if strings.ToLower(denied) == mac {
+			return changed
+		}
+	}
+	filter.MACs = append(filter.MACs, mac)
+	return true
+}
+
+// Disconnects return the connected Wi-Fi clients the filter refuse while previous accepted them
+func (filter *MACFilter) Disconnects(previous *MACFilter, clients []ConnectedClient) []ConnectedClient {
+	var disconnected []ConnectedClient
+	for _, client := range clients {
+		if client.Type == CLIENT_WIFI && filter.refuses(client.MAC) && previous.refuses(client.MAC) == false {
+			disconnected = append(disconnected, client)
+		}
+	}
+	return disconnected
+}
+
+// refuses return true if mac is missing from an allow list, or in a deny list
+func (filter *MACFilter) refuses(mac string) bool {
+	listed := false
+	for _, address := range filter.MACs {
+		listed = listed || strings.ToLower(address) == strings.ToLower(mac)
+	}
+	return (filter.Mode == MAC_FILTER_ALLOW && listed == false) || (filter.Mode == MAC_FILTER_DENY && listed)
+}
+
+// Unblock change the filter so mac is accepted again, and return false if it already was
+func (filter *MACFilter) Unblock(mac string) bool {
+	mac = strings.ToLower(mac)
+	switch filter.Mode {
+	case MAC_FILTER_DENY:
+		var denied []string
+		for _, address := range filter.MACs {
+			if strings.ToLower(address) != mac {
+				denied = append(denied, address)
+			}
+		}
+		if len(denied) == len(filter.MACs) {
+			return false
+		}
+		filter.MACs = denied
+	case MAC_FILTER_ALLOW:
+		for _, allowed := range filter.MACs {
+			if strings.ToLower(allowed) == mac {
+				return false
+			}
+		}
+		filter.MACs = append(filter.MACs, mac)
+	default:
+		return false
+	}
+	return true
+}
+
+// Validate check the mode and normalize the MAC addresses to the lower case colon form
+func (filter *MACFilter) Validate() error {
+	switch filter.Mode {
+	case MAC_FILTER_DISABLED, MAC_FILTER_ALLOW, MAC_FILTER_DENY:
+	default:
+		return fmt.Errorf("mode: unknown mode %s, expected %s, %s or %s", filter.Mode, MAC_FILTER_DISABLED, MAC_FILTER_ALLOW, MAC_FILTER_DENY)
+	}
+	seen := map[string]bool{}
+	var macs []string
+	for _, address := range filter.MACs {
+		hardware, err := net.ParseMAC(strings.TrimSpace(address))
+		if err != nil || len(hardware) != 6 {
+			return fmt.Errorf("macs: invalid MAC address %s", address)
+		}
+		if seen[hardware.String()] == false {
+			seen[hardware.String()] = true
+			macs = append(macs, hardware.String())
+		}
+	}
+	filter.MACs = macs
+	return nil
+}
+
+// Port forwarding protocols
+const (
+	PROTOCOL_TCP  = "tcp"
+	PROTOCOL_UDP  = "udp"
+	PROTOCOL_BOTH = "tcp/udp"
+)
+
+// PortForwarding is a rule forwarding WANPort of the modem to LANPort of LANIP. ID is set by
+// the device
+type PortForwarding struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Protocol string `json:"protocol"`
+	WANPort  int    `json:"wan_port"`
+	LANIP    string `json:"lan_ip"`
+	LANPort  int    `json:"lan_port"`
+}
+
+// FirewallSettings are the settings exposing the LAN to the WAN. DMZ is the host receiving the
+// ports not forwarded, empty when the DMZ is disabled
+type FirewallSettings struct {
+	Firewall bool   `json:"firewall"`
+	WANPing  bool   `json:"wan_ping"`
+	UPnP     bool   `json:"upnp"`
+	DMZ      string `json:"dmz"`
+}
+
+// FirewallConfigurer is implemented by the devices whose port forwarding and firewall can be changed
+type FirewallConfigurer interface {
+	PortForwardings() ([]PortForwarding, error)
+	// AddPortForwarding validate and add a rule, and return it with its ID
+	AddPortForwarding(rule *PortForwarding) (*PortForwarding, error)
+	RemovePortForwarding(id string) error
+	FirewallSettings() (*FirewallSettings, error)
+	SetFirewallSettings(settings *FirewallSettings) error
+}
+
+// Validate check the rule before it is sent to a modem
+func (rule *PortForwarding) Validate() error {
+	if len(rule.Name) == 0 || len(rule.Name) > 32 {
+		return fmt.Errorf("name: expected 1 to 32 bytes, got %d", len(rule.Name))
+	}
+	switch rule.Protocol {
+	case PROTOCOL_TCP, PROTOCOL_UDP, PROTOCOL_BOTH:
+	default:
+		return fmt.Errorf("protocol: unknown protocol %s, expected %s, %s or %s", rule.Protocol, PROTOCOL_TCP, PROTOCOL_UDP, PROTOCOL_BOTH)
+	}
+	if rule.WANPort < 1 || rule.WANPort > 65535 {
+		return fmt.Errorf("wan_port: expected 1 to 65535, got %d", rule.WANPort)
+	}
+	if rule.LANPort < 1 || rule.LANPort > 65535 {
+		return fmt.Errorf("lan_port: expected 1 to 65535, got %d", rule.LANPort)
+	}
+	if ip := net.ParseIP(rule.LANIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("lan_ip: invalid IPv4 address %s", rule.LANIP)
+	}
+	return nil
+}
+
+// Validate check the DMZ host
+func (settings *FirewallSettings) Validate() error {
+	if settings.DMZ == "" {
+		return nil
+	}
+	if ip := net.ParseIP(settings.DMZ); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("dmz: invalid IPv4 address %s", settings.DMZ)
+	}
+	return nil
+}
+
+// DNS modes
+const (
+	DNS_MODE_AUTO   = "auto"
+	DNS_MODE_MANUAL = "manual"
+)
+
+// LANSettings are the LAN and DHCP server settings, the modem is the LAN gateway. The DHCP range
+// is DHCPStart to DHCPEnd, and DNS are the servers given to the clients in DNS_MODE_MANUAL
+type LANSettings struct {
+	Gateway    string   `json:"gateway"`
+	Netmask    string   `json:"netmask"`
+	DHCP       bool     `json:"dhcp"`
+	DHCPStart  string   `json:"dhcp_start"`
+	DHCPEnd    string   `json:"dhcp_end"`
+	LeaseHours int      `json:"lease_hours"`
+	DNSMode    string   `json:"dns_mode"`
+	DNS        []string `json:"dns"`
+}
+
+// LANConfigurer is implemented by the devices whose LAN settings can be changed
+type LANConfigurer interface {
+	LANSettings() (*LANSettings, error)
+	// SetLANSettings validate and apply the settings, the device is only reachable at the new
+	// gateway address afterwards
+	SetLANSettings(settings *LANSettings) error
+}
+
+// Validate check the subnet, the DHCP range is in the subnet without the gateway
+func (settings *LANSettings) Validate() error {
+	gateway, ok := ipv4(settings.Gateway)
+	if ok == false {
+		return fmt.Errorf("gateway: invalid IPv4 address %s", settings.Gateway)
+	}
+	mask, ok := ipv4(settings.Netmask)
+	ones, bits := net.IPMask(net.ParseIP(settings.Netmask).To4()).Size()
+	if ok == false || bits == 0 || ones < 8 || ones > 30 {
+		return fmt.Errorf("netmask: expected a netmask from 255.0.0.0 to 255.255.255.252, got %s", settings.Netmask)
+	}
+	network, broadcast := gateway&mask, gateway|^mask
+	if gateway == network || gateway == broadcast {
+		return fmt.Errorf("gateway: %s is the network or broadcast address", settings.Gateway)
+	}
+
+	if settings.DHCP {
+		start, ok := ipv4(settings.DHCPStart)
+		if ok == false || start&mask != network || start == network || start == broadcast {
+			return fmt.Errorf("dhcp_start: %s isn't a host of the subnet", settings.DHCPStart)
+		}
+		end, ok := ipv4(settings.DHCPEnd)
+		if ok == false || end&mask != network || end == network || end == broadcast {
+			return fmt.Errorf("dhcp_end: %s isn't a host of the subnet", settings.DHCPEnd)
+		}
+		if start > end {
+			return fmt.Errorf("dhcp_end: %s is before dhcp_start %s", settings.DHCPEnd, settings.DHCPStart)
+		}
+		if gateway >= start && gateway <= end {
+			return fmt.Errorf("dhcp_start: the range %s-%s contains the gateway", settings.DHCPStart, settings.DHCPEnd)
+		}
+		if settings.LeaseHours < 1 {
+			return fmt.Errorf("lease_hours: at least 1 hour")
+		}
+	}
+
+	switch settings.DNSMode {
+	case DNS_MODE_AUTO:
+	case DNS_MODE_MANUAL:
+		if len(settings.DNS) == 0 || len(settings.DNS) > 2 {
+			return fmt.Errorf("dns: expected 1 or 2 servers, got %d", len(settings.DNS))
+		}
+		for _, server := range settings.DNS {
+			if _, ok := ipv4(server); ok == false {
+				return fmt.Errorf("dns: invalid IPv4 address %s", server)
+			}
+		}
+	default:
+		return fmt.Errorf("dns_mode: unknown mode %s, expected %s or %s", settings.DNSMode, DNS_MODE_AUTO, DNS_MODE_MANUAL)
+	}
+	return nil
+}
+
+// Reaches return false when modemURL addresses the device by an IP address the settings don't give
+// it. A host name is resolved, it is taken as unreachable when it doesn't resolve to the gateway
+// or can't be resolved
+func (settings *LANSettings) Reaches(modemURL string) bool {
+	parsed, err := url.Parse(modemURL)
+	if err != nil || parsed.Hostname() == "" {
+		return true
+	}
+	gateway := net.ParseIP(settings.Gateway)
+	hosts := []net.IP{net.ParseIP(parsed.Hostname())}
+	if hosts[0] == nil {
+		hosts, err = net.LookupIP(parsed.Hostname())
+		if err != nil {
+			return false
+		}
+	}
+	for _, host := range hosts {
+		if host.Equal(gateway) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipv4 return the IPv4 address of value as a number
+func ipv4(value string) (uint32, bool) {
+	ip := net.ParseIP(strings.TrimSpace(value)).To4()
+	if ip == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(ip), true
+}
+
+// USSDSender is implemented by the devices able to run USSD codes. A device has a single USSD
+// session: when a reply leave it open, ReplyUSSD or CancelUSSD must be called before the next code
+type USSDSender interface {
+	// SendUSSD send a code like *#123# and wait for the network answer until ctx is done
+	SendUSSD(ctx context.Context, code string) (*USSDReply, error)
+	ReplyUSSD(ctx context.Context, text string) (*USSDReply, error)
+	CancelUSSD() error
+}
+
+// USSDReply is the network answer to a USSD code, SessionOpen is true when it wait for a reply
+type USSDReply struct {
+	Text        string
+	SessionOpen bool
+}
+
+// ReceivedSMS is a received message. Id is the modem id of its first part, it identify the message
+// while it is stored only: the modems may give the id of a deleted message to a new one, so the
+// messages are ordered by time, and told apart across polls by their number, time and text. Unread is the state before the message was fetched, some models mark the messages
+// read when they are fetched
+type ReceivedSMS struct {
+	Id      int
+	PartIds []int
+	// Thread is the modem conversation of the message, 0 if the model has none
+	Thread int
+	Number string
+	Time   time.Time
+	Text   string
+	Unread bool
+}
+
+// Options passed to a driver
+type Options struct {
+	Username string
+	Password string
+}
+
+// OpenFunc open a device at url
+type OpenFunc func(url string, options Options) (Device, error)
+
+var (
+	driversMutex sync.Mutex
+	drivers      = map[string]OpenFunc{}
+)
+
+// Register make a driver available by name, it panics if the name is already registered
+func Register(name string, open OpenFunc) {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+	if _, ok := drivers[name]; ok {
+		panic("device: driver registered twice: " + name)
+	}
+	drivers[name] = open
+}
+
+// Drivers return the registered driver names, sorted
+func Drivers() []string {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open a device with a registered driver
+func Open(driver string, url string, options Options) (Device, error) {
+	driversMutex.Lock()
+	open, ok := drivers[driver]
+	driversMutex.Unlock()
+	if ok == false {
+		return nil, fmt.Errorf("unknown driver %s, available: %v", driver, Drivers())
+	}
+	return open(url, options)
+}
+
+// ParseSignal parse a signal value like "-95", "-95dBm" or "-11.0dB", NaN if unknown
+func ParseSignal(value string) float64 {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(value, "dBm"), "dB"))
+	signal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return signal
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/discovery"
+)
+
+// runDiscover scan the LAN and print the compatible modems found
+func runDiscover(args []string) {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	ranges := flags.String("range", discovery.GATEWAY, "Comma separated list of gateway, CIDR ranges, addresses or urls to scan")
+	format := flags.String("format", "list", "Output format: list or http_sd")
+	timeout := flags.Duration("timeout", time.Second, "Connection timeout per host")
+	flags.Parse(args)
+
+	urls, err := discovery.Urls(strings.Split(*ranges, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	modems := (&discovery.Scanner{Timeout: *timeout}).Scan(urls)
+
+	switch *format {
+	case "list":
+		for _, modem := range modems {
+			fmt.Printf("%s\t%s\t%s %s\t%s\n", modem.Url, modem.Driver, modem.SystemInfo.Vendor, modem.SystemInfo.Model, modem.SystemInfo.SoftwareVersion)
+		}
+	case "http_sd":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(discovery.HTTPSD(modems))
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown format: %s", *format)
+	}
+}
+
+// discoverTargets scan the ranges and return the modems found as targets, aliased by host
+func discoverTargets(ranges string, options device.Options) ([]*target, error) {
+	urls, err := discovery.Urls(strings.Split(ranges, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*target
+	for _, modem := range (&discovery.Scanner{}).Scan(urls) {
+		alias := modem.Url
+		if parsed, err := url.Parse(modem.Url); err == nil {
+			alias = parsed.Host
+		}
+		targets = append(targets, &target{Alias: alias, Driver: modem.Driver, Url: modem.Url, Options: options})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no modem found in %s", ranges)
+	}
+	return targets, nil
+}
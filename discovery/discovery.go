@@ -0,0 +1,267 @@
+// Package discovery find compatible modems on the LAN. Candidate hosts (default gateways and
+// CIDR ranges) with an open HTTP port are identified with the unauthenticated system information
+// call of each registered driver, the first driver answering wins.
+package discovery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// GATEWAY is the range name expanded to the host default gateways
+const GATEWAY = "gateway"
+
+// MAX_HOSTS is the largest number of hosts a range can expand to, a /20
+const MAX_HOSTS = 4096
+
+// ROUTE_FILE is the Linux routing table, read to find the default gateways
+var ROUTE_FILE = "/proc/net/route"
+
+// Modem is a discovered modem
+type Modem struct {
+	Url        string
+	Driver     string
+	SystemInfo *device.SystemInfo
+}
+
+// Scanner probe candidate urls with the registered drivers
+type Scanner struct {
+	// Drivers tried in order, all the registered drivers if empty
+	Drivers []string
+	// Timeout of the TCP connection check done before any driver is tried, 1s if 0
+	Timeout time.Duration
+	// Workers probing in parallel, 32 if 0
+	Workers int
+}
+
+// Gateways return the default gateways of the host
+func Gateways() ([]net.IP, error) {
+	file, err := os.Open(ROUTE_FILE)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var gateways []net.IP
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ..., addresses in little endian hexadecimal
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" || fields[2] == "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		gateway := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gateway, binary.LittleEndian.Uint32(raw))
+		if seen[gateway.String()] == false {
+			seen[gateway.String()] = true
+			gateways = append(gateways, gateway)
+		}
+	}
+	return gateways, scanner.Err()
+}
+
+// Hosts return the host addresses of an IPv4 CIDR range, without network and broadcast addresses
+func Hosts(cidr string) ([]net.IP, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 ranges can be scanned: %s", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	if size > MAX_HOSTS {
+		return nil, fmt.Errorf("range too large: %s, at most %d hosts", cidr, MAX_HOSTS)
+	}
+
+	first := binary.BigEndian.Uint32(network.IP.To4())
+	var hosts []net.IP
+	for offset := uint32(0); offset < size; offset++ {
+		if size > 2 && (offset == 0 || offset == size-1) {
+			continue
+		}
+		host := make(net.IP, 4)
+		binary.BigEndian.PutUint32(host, first+offset)
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// Urls expand ranges into candidate urls. A range is "gateway", a CIDR range, an address or an url
+func Urls(ranges []string) ([]string, error) {
+	var urls []string
+	seen := map[string]bool{}
+	add := func(candidate string) {
+		if seen[candidate] == false {
+			seen[candidate] = true
+			urls = append(urls, candidate)
+		}
+	}
+
+	for _, entry := range ranges {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == GATEWAY:
+			gateways, err := Gateways()
+			if err != nil {
+				return nil, err
+			}
+			for _, gateway := range gateways {
+				add("http://" + gateway.String())
+			}
+		case strings.Contains(entry, "://"):
+			add(strings.TrimSuffix(entry, "/"))
+		case strings.Contains(entry, "/"):
+			hosts, err := Hosts(entry)
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range hosts {
+				add("http://" + host.String())
+			}
+		default:
+			add("http://" + entry)
+		}
+	}
+	return urls, nil
+}
+
+// Probe identify the modem at url, nil if nothing compatible answers
+func (scanner *Scanner) Probe(modemUrl string) *Modem {
+	if scanner.reachable(modemUrl) == false {
+		return nil
+	}
+
+	drivers := scanner.Drivers
+	if len(drivers) == 0 {
+		drivers = device.Drivers()
+	}
+	for _, driver := range drivers {
+		modem, err := device.Open(driver, modemUrl, device.Options{})
+		if err != nil {
+			log.Debugf("[%s] %s: %s", modemUrl, driver, err)
+			continue
+		}
+		systemInfo, err := modem.SystemInfo()
+		if err != nil {
+			log.Debugf("[%s] %s: %s", modemUrl, driver, err)
+			continue
+		}
+		// a web server answering anything with an empty document isn't a modem
+		if systemInfo.Model == "" && systemInfo.IMEI == "" {
+			continue
+		}
+		log.Infof("[%s] %s %s found with driver %s", modemUrl, systemInfo.Vendor, systemInfo.Model, driver)
+		return &Modem{Url: modemUrl, Driver: driver, SystemInfo: systemInfo}
+	}
+	return nil
+}
+
+// Scan probe the urls in parallel, the modems are returned in the urls order
+func (scanner *Scanner) Scan(urls []string) []*Modem {
+	workers := scanner.Workers
+	if workers <= 0 {
+		workers = 32
+	}
+
+	found := make([]*Modem, len(urls))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				found[index] = scanner.Probe(urls[index])
+			}
+		}()
+	}
+	for index := range urls {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	var modems []*Modem
+	for _, modem := range found {
+		if modem != nil {
+			modems = append(modems, modem)
+		}
+	}
+	return modems
+}
+
+// reachable check quickly that the url port accept connections, most of a range doesn't
+func (scanner *Scanner) reachable(modemUrl string) bool {
+	parsed, err := url.Parse(modemUrl)
+	if err != nil {
+		return false
+	}
+	address := parsed.Host
+	if parsed.Port() == "" {
+		port := "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(parsed.Hostname(), port)
+	}
+
+	timeout := scanner.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// TargetGroup is an entry of the Prometheus http_sd format
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// HTTPSD return the modems in the Prometheus http_sd format, one group per modem with its
+// identification in __meta_modem_* labels
+func HTTPSD(modems []*Modem) []TargetGroup {
+	groups := []TargetGroup{}
+	for _, modem := range modems {
+		host := modem.Url
+		if parsed, err := url.Parse(modem.Url); err == nil {
+			host = parsed.Host
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{host},
+			Labels: map[string]string{
+				"__meta_modem_url":              modem.Url,
+				"__meta_modem_driver":           modem.Driver,
+				"__meta_modem_vendor":           modem.SystemInfo.Vendor,
+				"__meta_modem_model":            modem.SystemInfo.Model,
+				"__meta_modem_software_version": modem.SystemInfo.SoftwareVersion,
+			},
+		})
+	}
+	return groups
+}
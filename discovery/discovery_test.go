@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+func TestGateways(t *testing.T) {
+	file, err := ioutil.TempFile("", "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"wlan0\t00000000\t0100A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+		"wlan0\t0000A8C0\t00000000\t0001\t0\t0\t600\t00FFFFFF\t0\t0\t0\n")
+	file.Close()
+
+	ROUTE_FILE = file.Name()
+	defer func() { ROUTE_FILE = "/proc/net/route" }()
+
+	gateways, err := Gateways()
+	if err != nil {
+		t.Fatalf("[TestGateways] Error: %s", err)
+	}
+	if len(gateways) != 1 || gateways[0].String() != "192.168.0.1" {
+		t.Logf("Expected gateway 192.168.0.1, got: %v", gateways)
+		t.Fail()
+	}
+}
+
+func TestHosts(t *testing.T) {
+	hosts, err := Hosts("192.168.8.0/30")
+	if err != nil {
+		t.Fatalf("[TestHosts] Error: %s", err)
+	}
+	if len(hosts) != 2 || hosts[0].String() != "192.168.8.1" || hosts[1].String() != "192.168.8.2" {
+		t.Logf("Unexpected hosts: %v", hosts)
+		t.Fail()
+	}
+
+	_, err = Hosts("10.0.0.0/8")
+	if err == nil {
+		t.Logf("Expected an error on a /8")
+		t.Fail()
+	}
+}
+
+func TestScan(t *testing.T) {
+	server := modemtest.NewServer()
+	defer server.Close()
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+
+	urls, err := Urls([]string{other.URL, server.URL, "127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("[TestScan] Error: %s", err)
+	}
+	modems := (&Scanner{}).Scan(urls)
+	if len(modems) != 1 {
+		t.Fatalf("Expected 1 modem, got: %d", len(modems))
+	}
+	if modems[0].Url != server.URL || modems[0].Driver != "tcl" || modems[0].SystemInfo.Model != "MW40V" {
+		t.Logf("Unexpected modem: %+v %+v", modems[0], modems[0].SystemInfo)
+		t.Fail()
+	}
+
+	groups := HTTPSD(modems)
+	if len(groups) != 1 || groups[0].Labels["__meta_modem_model"] != "MW40V" {
+		t.Logf("Unexpected target groups: %+v", groups)
+		t.Fail()
+	}
+}
@@ -0,0 +1,376 @@
+// Package extract read values from the SMS sent by operators, like the remaining balance or data
+// allowance of a prepaid plan, and expose them as gauges. Rules match the sender and the text with
+// regular expressions, and their named groups are the values, converted to a base unit.
+//
+//	Tem 2,3GB disponiveis ate 12/11
+//
+// with the pattern `Tem (?P<data>[0-9.,]+) ?(?P<unit>[KMGT]B)` and the unit group "unit" set
+// modem_operator_reported_data_remaining_bytes to 2300000000.
+package extract
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Kinds of values
+const (
+	KIND_BYTES   = "bytes"
+	KIND_SECONDS = "seconds"
+)
+
+// DEFAULT_INTERVAL is the time between two polls of the inboxes
+const DEFAULT_INTERVAL = 5 * time.Minute
+
+// LAST_MATCH_METRIC is the time of the last message matching a rule
+const LAST_MATCH_METRIC = "modem_operator_sms_last_match_timestamp_seconds"
+
+var metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Config of the extraction, read from a JSON file
+type Config struct {
+	// Interval between two polls, like "5m"
+	Interval string `json:"interval,omitempty"`
+	Rules    []Rule `json:"rules"`
+
+	interval time.Duration
+	helps    map[string]string
+}
+
+// Rule extract Values from the messages whose sender match Sender and text match Pattern
+type Rule struct {
+	Name    string  `json:"name"`
+	Sender  string  `json:"sender,omitempty"`
+	Pattern string  `json:"pattern"`
+	Values  []Value `json:"values"`
+
+	sender  *regexp.Regexp
+	pattern *regexp.Regexp
+}
+
+// Value is the named group Group of a pattern, exposed as Metric. Kind is bytes, seconds or empty
+// for a value without unit conversion. The unit is read from the group UnitGroup, Unit otherwise,
+// and Base is 1000 (the default) or 1024 for bytes
+type Value struct {
+	Group     string `json:"group"`
+	Metric    string `json:"metric"`
+	Help      string `json:"help,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	UnitGroup string `json:"unit_group,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+	Base      int    `json:"base,omitempty"`
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config and compile its regular expressions
+func (config *Config) Validate() error {
+	var err error
+	config.interval, err = poll.Duration("interval", config.Interval, DEFAULT_INTERVAL)
+	if err != nil {
+		return err
+	}
+	if len(config.Rules) == 0 {
+		return fmt.Errorf("no rule")
+	}
+
+	config.helps = map[string]string{}
+	names := map[string]bool{}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("rule %d: missing or duplicate name", i)
+		}
+		names[rule.Name] = true
+
+		rule.sender, err = regexp.Compile(rule.Sender)
+		if err != nil {
+			return fmt.Errorf("rule %s: sender: %s", rule.Name, err)
+		}
+		rule.pattern, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %s: pattern: %s", rule.Name, err)
+		}
+		groups := map[string]bool{}
+		for _, group := range rule.pattern.SubexpNames() {
+			groups[group] = true
+		}
+		if len(rule.Values) == 0 {
+			return fmt.Errorf("rule %s: no value", rule.Name)
+		}
+
+		for _, value := range rule.Values {
+			err = value.Check(groups)
+			if err == nil && value.Metric == LAST_MATCH_METRIC {
+				err = fmt.Errorf("invalid metric name %s", value.Metric)
+			}
+			if err != nil {
+				return fmt.Errorf("rule %s: %s", rule.Name, err)
+			}
+
+			// a metric set by several rules has one help
+			previous, ok := config.helps[value.Metric]
+			switch {
+			case value.Help != "" && previous != "" && previous != value.Help:
+				return fmt.Errorf("rule %s: metric %s has another help", rule.Name, value.Metric)
+			case value.Help != "" || ok == false:
+				config.helps[value.Metric] = value.Help
+			}
+		}
+	}
+	for metric, help := range config.helps {
+		if help == "" {
+			config.helps[metric] = "Value reported by the operator by SMS"
+		}
+	}
+	return nil
+}
+
+// Match return the named groups of the first match of pattern in text, nil if it doesn't match
+func Match(pattern *regexp.Regexp, text string) map[string]string {
+	match := pattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	groups := map[string]string{}
+	for i, name := range pattern.SubexpNames() {
+		if name != "" {
+			groups[name] = match[i]
+		}
+	}
+	return groups
+}
+
+// Check return an error if the value is invalid for a pattern with the named groups
+func (value *Value) Check(groups map[string]bool) error {
+	switch {
+	case groups[value.Group] == false || value.Group == "":
+		return fmt.Errorf("no group %s in the pattern", value.Group)
+	case value.UnitGroup != "" && groups[value.UnitGroup] == false:
+		return fmt.Errorf("no group %s in the pattern", value.UnitGroup)
+	case metricNameRegexp.MatchString(value.Metric) == false:
+		return fmt.Errorf("invalid metric name %s", value.Metric)
+	case value.Kind != "" && value.Kind != KIND_BYTES && value.Kind != KIND_SECONDS:
+		return fmt.Errorf("kind: expected bytes or seconds, got %s", value.Kind)
+	case value.Base != 0 && value.Base != 1000 && value.Base != 1024:
+		return fmt.Errorf("base: expected 1000 or 1024, got %d", value.Base)
+	}
+	if value.Unit != "" {
+		_, err := Convert(1, value.Unit, value.Kind, value.Base)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse return the value from the groups of a match, converted to the base unit
+func (value *Value) Parse(groups map[string]string) (float64, error) {
+	parsed, err := ParseNumber(groups[value.Group])
+	if err != nil {
+		return 0, err
+	}
+	unit := value.Unit
+	if value.UnitGroup != "" && groups[value.UnitGroup] != "" {
+		unit = groups[value.UnitGroup]
+	}
+	return Convert(parsed, unit, value.Kind, value.Base)
+}
+
+// ParseNumber parse a number written with a decimal comma or point, with or without thousands
+// separator: "2,3", "2.3", "1.234,56" and "1,234.56". A single separator is the decimal one
+func ParseNumber(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	comma, point := strings.LastIndex(text, ","), strings.LastIndex(text, ".")
+	switch {
+	case comma >= 0 && point >= 0 && comma > point:
+		text = strings.Replace(strings.Replace(text, ".", "", -1), ",", ".", 1)
+	case comma >= 0 && point >= 0:
+		text = strings.Replace(text, ",", "", -1)
+	case strings.Count(text, ",") == 1:
+		text = strings.Replace(text, ",", ".", 1)
+	case comma >= 0:
+		text = strings.Replace(text, ",", "", -1)
+	case strings.Count(text, ".") > 1:
+		text = strings.Replace(text, ".", "", -1)
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// Convert a value in unit to bytes or seconds, the unit of the other kinds is ignored
+func Convert(value float64, unit string, kind string, base int) (float64, error) {
+	if base == 0 {
+		base = 1000
+	}
+	unit = strings.ToLower(strings.TrimSpace(unit))
+
+	switch kind {
+	case KIND_BYTES:
+		unit = strings.TrimSuffix(strings.TrimSuffix(unit, "b"), "i")
+		exponent := strings.Index("kmgt", unit) + 1
+		if unit == "" {
+			exponent = 0
+		} else if len(unit) != 1 || exponent == 0 {
+			return 0, fmt.Errorf("unknown bytes unit: %s", unit)
+		}
+		return value * math.Pow(float64(base), float64(exponent)), nil
+
+	case KIND_SECONDS:
+		switch unit {
+		case "", "s", "sec", "seg", "segundos", "seconds":
+			return value, nil
+		case "m", "min", "mins", "minutos", "minutes":
+			return value * 60, nil
+		case "h", "hr", "horas", "hours":
+			return value * 3600, nil
+		case "d", "dia", "dias", "day", "days":
+			return value * 86400, nil
+		}
+		return 0, fmt.Errorf("unknown time unit: %s", unit)
+	}
+	return value, nil
+}
+
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// Extractor keep the last values of each modem, it is the collector of the gauges
+type Extractor struct {
+	Config *Config
+
+	mutex sync.Mutex
+	// values by metric and modem, matches by rule and modem
+	values  map[string]map[string]sample
+	matches map[string]map[string]time.Time
+}
+
+// New return an extractor, Run must be called to read the messages
+func New(config *Config) *Extractor {
+	return &Extractor{
+		Config:  config,
+		values:  map[string]map[string]sample{},
+		matches: map[string]map[string]time.Time{},
+	}
+}
+
+// Process extract the values of a message received by a modem, and return the number of values
+// set. The values of an older message than the last one setting a metric are ignored
+func (extractor *Extractor) Process(modem string, number string, messageTime time.Time, text string) int {
+	extractor.mutex.Lock()
+	defer extractor.mutex.Unlock()
+
+	set := 0
+	for i := range extractor.Config.Rules {
+		rule := &extractor.Config.Rules[i]
+		if rule.sender.MatchString(number) == false {
+			continue
+		}
+		groups := Match(rule.pattern, text)
+		if groups == nil {
+			continue
+		}
+
+		matched := false
+		for _, value := range rule.Values {
+			parsed, err := value.Parse(groups)
+			if err != nil {
+				log.Warnf("[Extract] %s: rule %s, %s: %s", modem, rule.Name, value.Group, err)
+				continue
+			}
+			matched = true
+			if extractor.values[value.Metric] == nil {
+				extractor.values[value.Metric] = map[string]sample{}
+			}
+			if previous, ok := extractor.values[value.Metric][modem]; ok && messageTime.Before(previous.time) {
+				continue
+			}
+			extractor.values[value.Metric][modem] = sample{value: parsed, time: messageTime}
+			set++
+		}
+
+		if matched {
+			if extractor.matches[rule.Name] == nil {
+				extractor.matches[rule.Name] = map[string]time.Time{}
+			}
+			if messageTime.After(extractor.matches[rule.Name][modem]) {
+				extractor.matches[rule.Name][modem] = messageTime
+			}
+		}
+	}
+	return set
+}
+
+// Poll process the received messages of a source
+func (extractor *Extractor) Poll(source poll.Source) error {
+	reader, err := source.SMSReader()
+	if err != nil {
+		return err
+	}
+	messages, err := reader.ReceivedSMS()
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		extractor.Process(source.Name, message.Number, message.Time, message.Text)
+	}
+	return nil
+}
+
+// Run poll the sources every interval, until ctx is done
+func (extractor *Extractor) Run(ctx context.Context, sources []poll.Source) {
+	poll.Run(ctx, "Extract", sources, extractor.Config.interval, func(ctx context.Context, source poll.Source) error {
+		return extractor.Poll(source)
+	})
+}
+
+func (extractor *Extractor) desc(metric string) *prometheus.Desc {
+	if metric == LAST_MATCH_METRIC {
+		return prometheus.NewDesc(LAST_MATCH_METRIC, "Time of the last SMS matching a rule", []string{"modem", "rule"}, nil)
+	}
+	return prometheus.NewDesc(metric, extractor.Config.helps[metric], []string{"modem"}, nil)
+}
+
+// Describe implement prometheus.Collector
+func (extractor *Extractor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- extractor.desc(LAST_MATCH_METRIC)
+	for metric := range extractor.Config.helps {
+		ch <- extractor.desc(metric)
+	}
+}
+
+// Collect implement prometheus.Collector
+func (extractor *Extractor) Collect(ch chan<- prometheus.Metric) {
+	extractor.mutex.Lock()
+	defer extractor.mutex.Unlock()
+
+	for metric, modems := range extractor.values {
+		desc := extractor.desc(metric)
+		for modem, sample := range modems {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, sample.value, modem)
+		}
+	}
+	desc := extractor.desc(LAST_MATCH_METRIC)
+	for rule, modems := range extractor.matches {
+		for modem, matchTime := range modems {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(matchTime.Unix()), modem, rule)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package extract
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := map[string]float64{
+		"2,3":       2.3,
+		"2.3":       2.3,
+		"1.234,56":  1234.56,
+		"1,234.56":  1234.56,
+		"1.234.567": 1234567,
+		"15":        15,
+	}
+	for text, expected := range tests {
+		value, err := ParseNumber(text)
+		if err != nil || value != expected {
+			t.Logf("ParseNumber(%q): expected %f, got %f %v", text, expected, value, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value    float64
+		unit     string
+		kind     string
+		base     int
+		expected float64
+	}{
+		{2.5, "GB", KIND_BYTES, 0, 2.5e9},
+		{512, "MB", KIND_BYTES, 1024, 512 * 1024 * 1024},
+		{1, "KiB", KIND_BYTES, 1024, 1024},
+		{100, "b", KIND_BYTES, 0, 100},
+		{90, "min", KIND_SECONDS, 0, 5400},
+		{3, "dias", KIND_SECONDS, 0, 259200},
+		{10.5, "EUR", "", 0, 10.5},
+	}
+	for _, test := range tests {
+		value, err := Convert(test.value, test.unit, test.kind, test.base)
+		if err != nil || value != test.expected {
+			t.Logf("Convert(%f, %s, %s, %d): expected %f, got %f %v", test.value, test.unit, test.kind, test.base, test.expected, value, err)
+			t.Fail()
+		}
+	}
+	if _, err := Convert(1, "PB", KIND_BYTES, 0); err == nil {
+		t.Logf("Expected an unknown unit error")
+		t.Fail()
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	config := &Config{Rules: []Rule{
+		{
+			Name:    "data",
+			Sender:  "^NOS$",
+			Pattern: `(?i)tem (?P<data>[0-9.,]+) ?(?P<unit>[KMG]B) disponiveis`,
+			Values:  []Value{{Group: "data", Metric: "modem_operator_reported_data_remaining_bytes", Help: "Data allowance left", Kind: KIND_BYTES, UnitGroup: "unit"}},
+		},
+		{
+			Name:    "balance",
+			Sender:  "^NOS$",
+			Pattern: `Saldo: (?P<balance>[0-9.,]+) ?EUR`,
+			Values:  []Value{{Group: "balance", Metric: "modem_operator_reported_balance"}},
+		},
+	}}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[TestExtractor] Error: %s", err)
+	}
+
+	extractor := New(config)
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	extractor.Process("home", "NOS", now, "Tem 2,3GB disponiveis ate 12/11")
+	extractor.Process("home", "NOS", now.Add(-time.Hour), "Tem 5GB disponiveis ate 12/11")
+	extractor.Process("home", "+351910000000", now.Add(time.Hour), "Tem 9GB disponiveis ate 12/11")
+	extractor.Process("home", "NOS", now, "Saldo: 1.234,50EUR")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(extractor)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("[TestExtractor] Error: %s", err)
+	}
+	var output bytes.Buffer
+	for _, family := range families {
+		expfmt.MetricFamilyToText(&output, family)
+	}
+	for _, expected := range []string{
+		`modem_operator_reported_data_remaining_bytes{modem="home"} 2.3e+09`,
+		`modem_operator_reported_balance{modem="home"} 1234.5`,
+		`modem_operator_sms_last_match_timestamp_seconds{modem="home",rule="data"} 1.5525576e+09`,
+	} {
+		if strings.Contains(output.String(), expected) == false {
+			t.Logf("Expected %s in:\n%s", expected, output.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{},
+		{Rules: []Rule{{Name: "data", Pattern: `(?P<data>\d+)`, Values: []Value{{Group: "value", Metric: "data"}}}}},
+		{Rules: []Rule{{Name: "data", Pattern: `(?P<data>\d+)`, Values: []Value{{Group: "data", Metric: "data-left"}}}}},
+		{Rules: []Rule{{Name: "data", Pattern: `(?P<data>\d+)`, Values: []Value{{Group: "data", Metric: "data", Kind: KIND_BYTES, Unit: "XB"}}}}},
+		{Rules: []Rule{{Name: "data", Pattern: `(?P<data>\d+`, Values: []Value{{Group: "data", Metric: "data"}}}}},
+	}
+	for i := range configs {
+		if configs[i].Validate() == nil {
+			t.Logf("Expected config %d invalid", i)
+			t.Fail()
+		}
+	}
+}
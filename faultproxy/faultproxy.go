@@ -0,0 +1,311 @@
+// Package faultproxy is a reverse proxy between a client and a modem jrd/webapi, injecting faults per
+// JSON-RPC method: latency, dropped connections, HTTP status codes, corrupted bodies and expired sessions.
+package faultproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ANY_METHOD match every method without a specific fault
+const ANY_METHOD = "*"
+
+// Body corruptions
+const (
+	CORRUPT_TRUNCATE = "truncate"
+	CORRUPT_GARBAGE  = "garbage"
+	CORRUPT_HTML     = "html"
+	CORRUPT_EMPTY    = "empty"
+)
+
+// LOGIN_PAGE is returned instead of JSON by the CORRUPT_HTML corruption, like some firmwares do on reboot
+const LOGIN_PAGE = `<!DOCTYPE html><html><head><title>Login</title></head><body><form action="/index.html#login"></form></body></html>`
+
+// Duration is a time.Duration read from a string like "30s"
+type Duration struct {
+	time.Duration
+}
+
+func (duration *Duration) UnmarshalJSON(content []byte) error {
+	var str string
+	err := json.Unmarshal(content, &str)
+	if err != nil {
+		return err
+	}
+	duration.Duration, err = time.ParseDuration(str)
+	return err
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(duration.String())
+}
+
+// Fault is injected with Probability, between 0 and 1, 0 meaning always
+type Fault struct {
+	Probability float64  `json:"probability,omitempty"`
+	Latency     Duration `json:"latency,omitempty"`
+	// Drop close the connection without response
+	Drop bool `json:"drop,omitempty"`
+	// Status answer with this HTTP status without forwarding the request
+	Status int `json:"status,omitempty"`
+	// Corrupt the modem response body: truncate, garbage, html or empty
+	Corrupt string `json:"corrupt,omitempty"`
+	// ExpireSession answer with a not authorized modem error without forwarding the request
+	ExpireSession bool `json:"expire_session,omitempty"`
+}
+
+func (fault Fault) String() string {
+	content, _ := json.Marshal(fault)
+	return string(content)
+}
+
+// Faults by method name, or ANY_METHOD
+type Faults map[string][]Fault
+
+// Validate check the faults are consistent
+func (faults Faults) Validate() error {
+	for method, methodFaults := range faults {
+		for _, fault := range methodFaults {
+			if fault.Probability < 0 || fault.Probability > 1 {
+				return fmt.Errorf("%s: probability must be between 0 and 1", method)
+			}
+			switch fault.Corrupt {
+			case "", CORRUPT_TRUNCATE, CORRUPT_GARBAGE, CORRUPT_HTML, CORRUPT_EMPTY:
+			default:
+				return fmt.Errorf("%s: unknown corruption %s", method, fault.Corrupt)
+			}
+		}
+	}
+	return nil
+}
+
+// Proxy forward requests to Target, injecting faults
+type Proxy struct {
+	Target string
+	Client *http.Client
+	// Random return a number in [0, 1), rand.Float64 by default
+	Random func() float64
+
+	mutex  sync.Mutex
+	faults Faults
+}
+
+// New return a proxy to target, without fault
+func New(target string) *Proxy {
+	return &Proxy{
+		Target: strings.TrimSuffix(target, "/"),
+		Client: &http.Client{Timeout: time.Minute},
+		Random: rand.Float64,
+		faults: Faults{},
+	}
+}
+
+// SetFaults replace the injected faults
+func (proxy *Proxy) SetFaults(faults Faults) error {
+	err := faults.Validate()
+	if err != nil {
+		return err
+	}
+
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	proxy.faults = faults
+	return nil
+}
+
+// Faults return the injected faults
+func (proxy *Proxy) Faults() Faults {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+	return proxy.faults
+}
+
+// pick return the fault to inject for a method, nil for none
+func (proxy *Proxy) pick(method string) *Fault {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	methodFaults, ok := proxy.faults[method]
+	if ok == false {
+		methodFaults = proxy.faults[ANY_METHOD]
+	}
+	for _, fault := range methodFaults {
+		if fault.Probability == 0 || proxy.Random() < fault.Probability {
+			picked := fault
+			return &picked
+		}
+	}
+	return nil
+}
+
+func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string `json:"method"`
+		Id     string `json:"id"`
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &request)
+	if err != nil || request.Method == "" {
+		request.Method = r.URL.Query().Get("api")
+	}
+
+	fault := proxy.pick(request.Method)
+	if fault != nil {
+		log.Infof("[Proxy] %s: injecting %s", request.Method, fault)
+	}
+
+	if fault != nil && fault.Latency.Duration > 0 {
+		select {
+		case <-time.After(fault.Latency.Duration):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if fault != nil && fault.Drop {
+		hijacker, ok := w.(http.Hijacker)
+		if ok == false {
+			http.Error(w, "connection can't be dropped", http.StatusInternalServerError)
+			return
+		}
+		conn, _, err := hijacker.Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	if fault != nil && fault.Status != 0 {
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+
+	if fault != nil && fault.ExpireSession {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"error":   map[string]string{"code": "-32699", "message": "Request is not authorized"},
+			"id":      request.Id,
+		})
+		return
+	}
+
+	status, header, responseBody, err := proxy.forward(r, body)
+	if err != nil {
+		log.Warnf("[Proxy] %s: %s", request.Method, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if fault != nil && fault.Corrupt != "" {
+		responseBody = corrupt(fault.Corrupt, responseBody)
+		if fault.Corrupt == CORRUPT_HTML {
+			header.Set("Content-Type", "text/html")
+		}
+	}
+
+	for key, values := range header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(responseBody)
+}
+
+// forward send the request to the target and return the response
+func (proxy *Proxy) forward(r *http.Request, body []byte) (int, http.Header, []byte, error) {
+	req, err := http.NewRequest(r.Method, proxy.Target+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for key, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := proxy.Client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return resp.StatusCode, resp.Header, responseBody, nil
+}
+
+func corrupt(corruption string, body []byte) []byte {
+	switch corruption {
+	case CORRUPT_TRUNCATE:
+		return body[:len(body)/2]
+	case CORRUPT_GARBAGE:
+		garbage := append([]byte(nil), body...)
+		for i := 0; i < len(garbage); i += 7 {
+			garbage[i] ^= 0x5a
+		}
+		return garbage
+	case CORRUPT_HTML:
+		return []byte(LOGIN_PAGE)
+	case CORRUPT_EMPTY:
+		return []byte{}
+	}
+	return body
+}
+
+// ControlHandler read the faults with GET and replace them with PUT
+func (proxy *Proxy) ControlHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT", "POST":
+			var faults Faults
+			err := json.NewDecoder(r.Body).Decode(&faults)
+			if err == nil {
+				err = proxy.SetFaults(faults)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Infof("[Proxy] faults updated: %d method(s)", len(faults))
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.Faults())
+	})
+}
+
+// LoadFaults read faults from a JSON file
+func LoadFaults(file string) (Faults, error) {
+	var faults Faults
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &faults)
+	if err != nil {
+		return nil, err
+	}
+	return faults, faults.Validate()
+}
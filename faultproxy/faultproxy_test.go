@@ -0,0 +1,111 @@
+package faultproxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+func runProxy(t *testing.T, faults Faults) (*modemtest.Server, *httptest.Server) {
+	server := modemtest.NewServer()
+	proxy := New(server.URL)
+	err := proxy.SetFaults(faults)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	return server, httptest.NewServer(proxy)
+}
+
+func TestNoFault(t *testing.T) {
+	server, ts := runProxy(t, Faults{})
+	defer server.Close()
+	defer ts.Close()
+
+	systemInfo, err := modem_alcatel_mw40v.New(ts.URL).GetSystemInfo()
+	if err != nil {
+		t.Fatalf("[TestNoFault] Error: %s", err)
+	}
+	if systemInfo.IMEI != server.Modem.SystemInfo.IMEI {
+		t.Logf("Expected IMEI: %s, got: %s", server.Modem.SystemInfo.IMEI, systemInfo.IMEI)
+		t.Fail()
+	}
+}
+
+func TestFaults(t *testing.T) {
+	server, ts := runProxy(t, Faults{
+		"GetSystemInfo":      {{Status: 500}},
+		"GetSystemStatus":    {{Corrupt: CORRUPT_HTML}},
+		"GetConnectionState": {{Corrupt: CORRUPT_TRUNCATE}},
+		"GetSMSStorageState": {{Drop: true}},
+		"GetLoginState":      {{ExpireSession: true}},
+	})
+	defer server.Close()
+	defer ts.Close()
+	modem := modem_alcatel_mw40v.New(ts.URL)
+
+	_, err := modem.GetSystemInfo()
+	if err == nil || strings.Contains(err.Error(), "500") == false {
+		t.Logf("Expected an HTTP 500 error, got: %v", err)
+		t.Fail()
+	}
+	if server.Modem.Calls("GetSystemInfo") != 0 {
+		t.Logf("Expected request not to be forwarded")
+		t.Fail()
+	}
+	_, err = modem.GetSystemStatus()
+	if err == nil {
+		t.Logf("Expected an error on HTML body")
+		t.Fail()
+	}
+	_, err = modem.GetConnectionState()
+	if err == nil {
+		t.Logf("Expected an error on truncated body")
+		t.Fail()
+	}
+	_, err = modem.GetSMSStorageState()
+	if err == nil {
+		t.Logf("Expected an error on dropped connection")
+		t.Fail()
+	}
+	_, err = modem.GetLoginState()
+	if _, ok := err.(*modem_alcatel_mw40v.RPCError); ok == false {
+		t.Logf("Expected a modem error on expired session, got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLatencyAndProbability(t *testing.T) {
+	server := modemtest.NewServer()
+	defer server.Close()
+	proxy := New(server.URL)
+	proxy.SetFaults(Faults{ANY_METHOD: {{Probability: 0.5, Latency: Duration{50 * time.Millisecond}}}})
+	ts := httptest.NewServer(proxy)
+	defer ts.Close()
+	modem := modem_alcatel_mw40v.New(ts.URL)
+
+	for _, random := range []float64{0.7, 0.2} {
+		proxy.Random = func() float64 { return random }
+		start := time.Now()
+		_, err := modem.GetSystemStatus()
+		if err != nil {
+			t.Fatalf("[TestLatencyAndProbability] Error: %s", err)
+		}
+		delayed := time.Since(start) >= 50*time.Millisecond
+		if delayed != (random < 0.5) {
+			t.Logf("Expected delayed: %t with random %f", random < 0.5, random)
+			t.Fail()
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := Faults{"GetSystemInfo": {{Corrupt: "unknown"}}}.Validate()
+	if err == nil {
+		t.Logf("Expected an error for an unknown corruption")
+		t.Fail()
+	}
+}
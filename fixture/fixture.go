@@ -0,0 +1,168 @@
+// Package fixture record jrd/webapi responses from a real modem into a fixture
+// directory and replay them through an HTTP handler.
+//
+// A fixture directory contains one <method>.json file per method, named like
+// testdata/getSystemInfo.json. A file is either a single JSON-RPC response, or
+// a list of samples recorded at different time offsets.
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SESSION_FILE describe the recording, it is not a method response
+const SESSION_FILE = "session.json"
+
+// Sample is a response recorded Offset seconds after the beginning of the recording
+type Sample struct {
+	Offset   float64         `json:"offset"`
+	Response json.RawMessage `json:"response"`
+}
+
+// Session is a recorded set of responses
+type Session struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Url        string    `json:"url,omitempty"`
+	Samples    int       `json:"samples"`
+	Interval   float64   `json:"interval"`
+	// Methods responses, sorted by offset
+	Methods map[string][]Sample `json:"-"`
+}
+
+// Load read a fixture directory
+func Load(dir string) (*Session, error) {
+	session := Session{Methods: map[string][]Sample{}}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, SESSION_FILE))
+	if err == nil {
+		err = json.Unmarshal(content, &session)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", SESSION_FILE, err)
+		}
+	} else if os.IsNotExist(err) == false {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if filepath.Base(file) == SESSION_FILE {
+			continue
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		samples, err := parseSamples(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		session.Methods[MethodName(file)] = samples
+	}
+
+	if len(session.Methods) == 0 {
+		return nil, fmt.Errorf("no fixture found in %s", dir)
+	}
+	return &session, nil
+}
+
+// Save write a fixture directory
+func (session *Session) Save(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSON(filepath.Join(dir, SESSION_FILE), session)
+	if err != nil {
+		return err
+	}
+	for method, samples := range session.Methods {
+		err = writeJSON(filepath.Join(dir, FileName(method)), samples)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add append a response to a method samples
+func (session *Session) Add(method string, offset time.Duration, response []byte) {
+	if session.Methods == nil {
+		session.Methods = map[string][]Sample{}
+	}
+	session.Methods[method] = append(session.Methods[method], Sample{Offset: offset.Seconds(), Response: response})
+}
+
+// Response return the response of a method at the given offset: the last sample recorded before offset,
+// or the first one. Return nil if the method hasn't been recorded
+func (session *Session) Response(method string, offset time.Duration) []byte {
+	samples := session.Methods[method]
+	if len(samples) == 0 {
+		return nil
+	}
+
+	index := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Offset > offset.Seconds()
+	})
+	if index > 0 {
+		index--
+	}
+	return samples[index].Response
+}
+
+// FileName return the fixture file name of a method, GetSystemInfo => getSystemInfo.json
+func FileName(method string) string {
+	r, size := utf8.DecodeRuneInString(method)
+	return string(unicode.ToLower(r)) + method[size:] + ".json"
+}
+
+// MethodName return the method of a fixture file, getSystemInfo.json => GetSystemInfo
+func MethodName(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".json")
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// parseSamples accept both a list of samples and a single response, as made by curl_commands.txt
+func parseSamples(content []byte) ([]Sample, error) {
+	var samples []Sample
+
+	trimmed := strings.TrimSpace(string(content))
+	if strings.HasPrefix(trimmed, "[") == false {
+		var response json.RawMessage
+		err := json.Unmarshal([]byte(trimmed), &response)
+		if err != nil {
+			return nil, err
+		}
+		return []Sample{{Offset: 0, Response: response}}, nil
+	}
+
+	err := json.Unmarshal(content, &samples)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Offset < samples[j].Offset
+	})
+	return samples, nil
+}
+
+func writeJSON(file string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(content, '\n'), 0644)
+}
@@ -0,0 +1,135 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+func TestLoadTestdata(t *testing.T) {
+	session, err := Load("../modem_alcatel_mw40v/testdata")
+	if err != nil {
+		t.Fatalf("[TestLoadTestdata] Error: %s", err)
+	}
+
+	for _, method := range []string{"GetSystemInfo", "GetSystemStatus", "GetConnectionState", "GetSMSStorageState"} {
+		if session.Response(method, 0) == nil {
+			t.Logf("Expected a response for %s", method)
+			t.Fail()
+		}
+	}
+}
+
+func TestScrub(t *testing.T) {
+	content := []byte(`{"result":{"IMEI":"123456789012345","MacAddress":"c4:43:13:c5:12:34\n","IPv4Adrress":"89.180.91.116","IPv6Adrress":"0::0","HwVersion":"MW40-V-V1.0"}}`)
+
+	scrubbed, err := Scrub(content)
+	if err != nil {
+		t.Fatalf("[TestScrub] Error: %s", err)
+	}
+	again, _ := Scrub(content)
+	if string(scrubbed) != string(again) {
+		t.Logf("Expected deterministic scrubbing, got: %s and %s", scrubbed, again)
+		t.Fail()
+	}
+
+	for _, identifier := range []string{"123456789012345", "c4:43:13:c5:12:34", "89.180.91.116"} {
+		if strings.Contains(string(scrubbed), identifier) {
+			t.Logf("Expected %s to be scrubbed, got: %s", identifier, scrubbed)
+			t.Fail()
+		}
+	}
+	for _, kept := range []string{`"MW40-V-V1.0"`, `"0::0"`, `"192.0.2.`} {
+		if strings.Contains(string(scrubbed), kept) == false {
+			t.Logf("Expected %s in: %s", kept, scrubbed)
+			t.Fail()
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	testdata, err := Load("../modem_alcatel_mw40v/testdata")
+	if err != nil {
+		t.Fatalf("[TestRecordReplay] Error: %s", err)
+	}
+	ts := httptest.NewServer(NewReplay(testdata))
+	defer ts.Close()
+
+	recorder := Recorder{Modem: modem_alcatel_mw40v.New(ts.URL), Samples: 2}
+	session, err := recorder.Record()
+	if err != nil {
+		t.Fatalf("[TestRecordReplay] Error: %s", err)
+	}
+	if len(session.Methods["GetConnectionState"]) != 2 {
+		t.Logf("Expected 2 GetConnectionState samples, got: %d", len(session.Methods["GetConnectionState"]))
+		t.Fail()
+	}
+
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = session.Save(dir)
+	if err != nil {
+		t.Fatalf("[TestRecordReplay] Error: %s", err)
+	}
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatalf("[TestRecordReplay] Error: %s", err)
+	}
+
+	replay := httptest.NewServer(NewReplay(loaded))
+	defer replay.Close()
+
+	systemInfo, err := modem_alcatel_mw40v.New(replay.URL).GetSystemInfo()
+	if err != nil {
+		t.Fatalf("[TestRecordReplay] Error: %s", err)
+	}
+	if systemInfo.HardwareVersion != "MW40-V-V1.0" {
+		t.Logf("Expected hardware version: MW40-V-V1.0, got: %s", systemInfo.HardwareVersion)
+		t.Fail()
+	}
+	if systemInfo.IMEI == "123456789012345" || len(systemInfo.IMEI) != 15 {
+		t.Logf("Expected a scrubbed IMEI, got: %s", systemInfo.IMEI)
+		t.Fail()
+	}
+}
+
+func TestReplaySequence(t *testing.T) {
+	session := &Session{}
+	session.Add("GetConnectionState", 0, []byte(`{"jsonrpc":"2.0","result":{"DlBytes":100},"id":"3.1"}`))
+	session.Add("GetConnectionState", 10*time.Second, []byte(`{"jsonrpc":"2.0","result":{"DlBytes":200},"id":"3.1"}`))
+
+	now := time.Now()
+	replay := NewReplay(session)
+	replay.Now = func() time.Time { return now }
+	ts := httptest.NewServer(replay)
+	defer ts.Close()
+
+	modem := modem_alcatel_mw40v.New(ts.URL)
+	for _, expected := range []float64{100, 100, 200, 200} {
+		connectionState, err := modem.GetConnectionState()
+		if err != nil {
+			t.Fatalf("[TestReplaySequence] Error: %s", err)
+		}
+		if connectionState.DownloadBytes != expected {
+			t.Logf("Expected DownloadBytes: %f, got: %f", expected, connectionState.DownloadBytes)
+			t.Fail()
+		}
+		now = now.Add(6 * time.Second)
+	}
+
+	_, err := modem.GetSystemInfo()
+	if err != device.ErrNotSupported {
+		t.Logf("Expected a not supported error for a method not recorded, got: %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,68 @@
+package fixture
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+// Recorder walk the read only methods of a modem
+type Recorder struct {
+	Modem    *modem_alcatel_mw40v.Modem
+	Username string
+	Password string
+	// Samples is the number of time each method is called
+	Samples  int
+	Interval time.Duration
+}
+
+// Record call every read only method Samples times, Interval apart, and return the scrubbed responses.
+// Methods needing an authenticated session are skipped if no password is set
+func (recorder *Recorder) Record() (*Session, error) {
+	modem := recorder.Modem
+
+	if recorder.Password != "" {
+		err := modem.Login(recorder.Username, recorder.Password)
+		if err != nil {
+			return nil, err
+		}
+		defer modem.Logout()
+	}
+
+	samples := recorder.Samples
+	if samples < 1 {
+		samples = 1
+	}
+	session := &Session{RecordedAt: time.Now().UTC(), Samples: samples, Interval: recorder.Interval.Seconds()}
+
+	start := time.Now()
+	for i := 0; i < samples; i++ {
+		if i > 0 {
+			time.Sleep(start.Add(time.Duration(i) * recorder.Interval).Sub(time.Now()))
+		}
+		for _, name := range modem_alcatel_mw40v.ReadOnlyMethods() {
+			if modem_alcatel_mw40v.Methods[name].Login && modem.LoggedIn() == false {
+				log.Debugf("[Record] %s skipped: login needed", name)
+				continue
+			}
+
+			offset := time.Since(start)
+			body, err := modem.Call(name, nil)
+			if err != nil {
+				log.Warnf("[Record] %s: %s", name, err)
+				continue
+			}
+			scrubbed, err := Scrub(body)
+			if err != nil {
+				log.Warnf("[Record] %s: invalid response: %s", name, err)
+				continue
+			}
+			session.Add(name, offset, scrubbed)
+		}
+		log.Infof("[Record] sample %d/%d recorded", i+1, samples)
+	}
+
+	return session, nil
+}
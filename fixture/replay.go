@@ -0,0 +1,75 @@
+package fixture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Replay serve a recorded session as a modem jrd/webapi. Samples are chosen from the time elapsed since
+// the first request, so counters move like on the recorded modem
+type Replay struct {
+	Session *Session
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex sync.Mutex
+	start time.Time
+}
+
+// NewReplay return a replay handler for a session
+func NewReplay(session *Session) *Replay {
+	return &Replay{Session: session, Now: time.Now}
+}
+
+// sessionMethods are answered even when not recorded, credentials are never recorded
+var sessionMethods = map[string]string{
+	"Login":     `{"token":"1"}`,
+	"Logout":    `{}`,
+	"HeartBeat": `{}`,
+}
+
+func (replay *Replay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string `json:"method"`
+		Id     string `json:"id"`
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &request)
+	if err != nil || request.Method == "" {
+		request.Method = r.URL.Query().Get("api")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	response := replay.Session.Response(request.Method, replay.elapsed())
+	if response == nil {
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": request.Id}
+		result, ok := sessionMethods[request.Method]
+		if ok == false {
+			log.Debugf("[Replay] %s not recorded", request.Method)
+			reply["error"] = map[string]string{"code": "-32601", "message": "Method not found"}
+		} else {
+			reply["result"] = json.RawMessage(result)
+		}
+		response, _ = json.Marshal(reply)
+	}
+	w.Write(response)
+}
+
+// elapsed return the time since the first request
+func (replay *Replay) elapsed() time.Duration {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+
+	now := replay.Now()
+	if replay.start.IsZero() {
+		replay.start = now
+	}
+	return now.Sub(replay.start)
+}
@@ -0,0 +1,115 @@
+package fixture
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ScrubbedFields are the JSON keys holding identifiers, compared case insensitively
+var ScrubbedFields = []string{
+	"IMEI", "IMSI", "ICCID", "MSISDN", "sn", "MacAddress",
+	"IPv4Adrress", "IPv6Adrress", "IPv4Address", "IPv6Address", "IPAddress", "DeviceName",
+	"SSID", "WlanPassword", "WpaKey", "WepKey", "Password", "PhoneNumber", "Number", "SMSContent",
+}
+
+// Scrub replace the identifiers found in a JSON document. Replacement is deterministic so a value
+// keep the same replacement across samples, and keep the shape of the original value
+func Scrub(content []byte) ([]byte, error) {
+	var document interface{}
+
+	err := json.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(scrubValue("", document))
+}
+
+func scrubValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = scrubValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = scrubValue(key, item)
+		}
+		return v
+	case string:
+		if isScrubbedField(key) {
+			return scrubString(v)
+		}
+	case float64:
+		if isScrubbedField(key) && v != 0 {
+			var replaced float64
+			fmt.Sscanf(scrubString(fmt.Sprintf("%.0f", v)), "%f", &replaced)
+			return replaced
+		}
+	}
+	return value
+}
+
+func isScrubbedField(key string) bool {
+	for _, field := range ScrubbedFields {
+		if strings.EqualFold(field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubString map IP addresses to documentation ranges, and other values char by char:
+// digits to digits, hexadecimal digits to hexadecimal digits, letters to letters
+func scrubString(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return value
+	}
+	hash := sha256.Sum256([]byte(trimmed))
+
+	ip := net.ParseIP(trimmed)
+	if ip != nil {
+		if ip.IsUnspecified() {
+			return value
+		}
+		if ip.To4() != nil {
+			return fmt.Sprintf("192.0.2.%d", 1+int(hash[0])%254)
+		}
+		return fmt.Sprintf("2001:db8::%x:%x", hash[0:2], hash[2:4])
+	}
+
+	isHex := isHexadecimal(trimmed)
+	scrubbed := []byte(value)
+	for i, c := range scrubbed {
+		h := hash[i%len(hash)] ^ byte(i/len(hash))
+		switch {
+		case c >= '0' && c <= '9':
+			scrubbed[i] = '0' + h%10
+		case isHex && c >= 'a' && c <= 'f':
+			scrubbed[i] = "0123456789abcdef"[h%16]
+		case isHex && c >= 'A' && c <= 'F':
+			scrubbed[i] = "0123456789ABCDEF"[h%16]
+		case c >= 'a' && c <= 'z':
+			scrubbed[i] = 'a' + h%26
+		case c >= 'A' && c <= 'Z':
+			scrubbed[i] = 'A' + h%26
+		}
+	}
+	return string(scrubbed)
+}
+
+// isHexadecimal return true for MAC address like values
+func isHexadecimal(value string) bool {
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == ':', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
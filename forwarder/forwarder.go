@@ -0,0 +1,356 @@
+// Package forwarder push the SMS received by the modems to webhooks and email. The inboxes are
+// polled, and a persistent cursor, the keys of the forwarded messages still stored per modem, keep
+// a message from being forwarded twice across restarts.
+package forwarder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// What is done with a message once forwarded
+const (
+	AFTER_NONE   = ""
+	AFTER_READ   = "read"
+	AFTER_DELETE = "delete"
+)
+
+// DEFAULT_INTERVAL is the time between two polls of the inboxes
+const DEFAULT_INTERVAL = time.Minute
+
+// SEND_TIMEOUT is the time a sink has to forward a message
+const SEND_TIMEOUT = 30 * time.Second
+
+// Config of the forwarder, read from a JSON file
+type Config struct {
+	// Interval between two polls, like "1m"
+	Interval string `json:"interval,omitempty"`
+	// After is what is done with a forwarded message: nothing, "read" or "delete"
+	After string `json:"after_forward,omitempty"`
+	// CursorFile keep the forwarded messages still stored per modem
+	CursorFile string    `json:"cursor_file"`
+	Webhooks   []Webhook `json:"webhooks,omitempty"`
+	Emails     []Email   `json:"emails,omitempty"`
+
+	interval time.Duration
+	sinks    []Sink
+}
+
+// Message is a received SMS, and the data of the templates
+type Message struct {
+	// Modem is the alias of the modem that received the message
+	Modem  string    `json:"modem"`
+	Id     int       `json:"id"`
+	Number string    `json:"number"`
+	Time   time.Time `json:"time"`
+	Text   string    `json:"text"`
+}
+
+// Sink forward a message
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, message *Message) error
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config and compile its templates
+func (config *Config) Validate() error {
+	var err error
+	config.interval, err = poll.Duration("interval", config.Interval, DEFAULT_INTERVAL)
+	if err != nil {
+		return err
+	}
+	switch config.After {
+	case AFTER_NONE, AFTER_READ, AFTER_DELETE:
+	default:
+		return fmt.Errorf("after_forward: expected read or delete, got %s", config.After)
+	}
+	if config.CursorFile == "" {
+		return fmt.Errorf("no cursor_file")
+	}
+
+	config.sinks = nil
+	for i := range config.Webhooks {
+		err = config.Webhooks[i].compile(i)
+		if err != nil {
+			return err
+		}
+		config.sinks = append(config.sinks, &config.Webhooks[i])
+	}
+	for i := range config.Emails {
+		err = config.Emails[i].compile(i)
+		if err != nil {
+			return err
+		}
+		config.sinks = append(config.sinks, &config.Emails[i])
+	}
+	if len(config.sinks) == 0 {
+		return fmt.Errorf("no webhook nor email")
+	}
+	return nil
+}
+
+// Forwarder poll the sources, it is also the collector of its metrics
+type Forwarder struct {
+	Config  *Config
+	Sources []poll.Source
+	Cursor  *Cursor
+
+	forwarded *prometheus.CounterVec
+}
+
+// New return a forwarder with the cursor of the config, Run must be called to forward the messages
+func New(config *Config, sources []poll.Source) (*Forwarder, error) {
+	cursor, err := LoadCursor(config.CursorFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Forwarder{
+		Config:  config,
+		Sources: sources,
+		Cursor:  cursor,
+		forwarded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_sms_forwarded_total",
+			Help: "Received SMS forwarded, by modem, sink and result: success or failure",
+		}, []string{"modem", "sink", "result"}),
+	}, nil
+}
+
+// Describe implement prometheus.Collector
+func (forwarder *Forwarder) Describe(ch chan<- *prometheus.Desc) {
+	forwarder.forwarded.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (forwarder *Forwarder) Collect(ch chan<- prometheus.Metric) {
+	forwarder.forwarded.Collect(ch)
+}
+
+// Run poll the sources every interval, until ctx is done
+func (forwarder *Forwarder) Run(ctx context.Context) {
+	forwarder.pollAll(ctx)
+	forwarder.run(ctx)
+}
+
+// Start poll the sources once before returning, so the cursor of a new source is taken before
+// another poller mark its messages read or delete them, then poll them every interval in the
+// background until ctx is done
+func (forwarder *Forwarder) Start(ctx context.Context) {
+	forwarder.pollAll(ctx)
+	go forwarder.run(ctx)
+}
+
+func (forwarder *Forwarder) run(ctx context.Context) {
+	for poll.Wait(ctx, forwarder.Config.interval) {
+		forwarder.pollAll(ctx)
+	}
+}
+
+func (forwarder *Forwarder) pollAll(ctx context.Context) {
+	poll.Each(ctx, "Forwarder", forwarder.Sources, forwarder.Poll)
+}
+
+// Poll forward the new messages of a source, oldest first. The first time a source is polled
+// only its unread messages are forwarded. A message a sink failed to forward stop the poll, it is
+// forwarded again to every sink by the next one
+func (forwarder *Forwarder) Poll(ctx context.Context, source poll.Source) error {
+	reader, err := source.SMSReader()
+	if err != nil {
+		return err
+	}
+	messages, err := reader.ReceivedSMS()
+	if err != nil {
+		return err
+	}
+
+	known := forwarder.Cursor.Known(source.Name)
+	var forwarded []string
+	for _, received := range messages {
+		key := messageKey(received)
+		if forwarder.Cursor.Forwarded(source.Name, received) || (known == false && received.Unread == false) {
+			forwarded = append(forwarded, key)
+			continue
+		}
+		if err != nil {
+			// a message failed, the newer ones wait for the next poll
+			continue
+		}
+
+		message := &Message{Modem: source.Name, Id: received.Id, Number: received.Number, Time: received.Time, Text: received.Text}
+		err = forwarder.forward(ctx, message)
+		if err != nil {
+			continue
+		}
+		forwarded = append(forwarded, key)
+
+		switch forwarder.Config.After {
+		case AFTER_READ:
+			err = reader.MarkReceivedSMSRead(received)
+		case AFTER_DELETE:
+			err = reader.DeleteReceivedSMS(received)
+		}
+		if err != nil {
+			log.Errorf("[Forwarder] %s: message %d forwarded, but: %s", source.Name, received.Id, err)
+			err = nil
+		}
+	}
+
+	saveErr := forwarder.Cursor.Set(source.Name, forwarded)
+	if saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// forward send a message to every sink, and return the first error
+func (forwarder *Forwarder) forward(ctx context.Context, message *Message) error {
+	var firstErr error
+	for _, sink := range forwarder.Config.sinks {
+		sendCtx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+		err := sink.Send(sendCtx, message)
+		cancel()
+		if err != nil {
+			log.Errorf("[Forwarder] %s: message %d to %s: %s", message.Modem, message.Id, sink.Name(), err)
+			forwarder.forwarded.WithLabelValues(message.Modem, sink.Name(), "failure").Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Infof("[Forwarder] %s: message %d from %s forwarded to %s", message.Modem, message.Id, message.Number, sink.Name())
+		forwarder.forwarded.WithLabelValues(message.Modem, sink.Name(), "success").Inc()
+	}
+	return firstErr
+}
+
+// messageKey identify a message across polls, see device.ReceivedSMS
+func messageKey(message device.ReceivedSMS) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s", message.Number, message.Time.Unix(), message.Text)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// Cursor is the keys of the forwarded messages still stored per source, saved in a JSON file
+type Cursor struct {
+	File string
+
+	mutex     sync.Mutex
+	forwarded map[string]map[string]bool
+	// lastIds are the ids of the last message forwarded of the sources of a cursor file written
+	// before the keys, until the sources are polled again
+	lastIds map[string]int
+}
+
+// LoadCursor read a cursor file, empty if it doesn't exist
+func LoadCursor(file string) (*Cursor, error) {
+	cursor := &Cursor{File: file, forwarded: map[string]map[string]bool{}, lastIds: map[string]int{}}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cursor, nil
+		}
+		return nil, err
+	}
+	var sources map[string]json.RawMessage
+	err = json.Unmarshal(content, &sources)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	for name, source := range sources {
+		var keys []string
+		if json.Unmarshal(source, &keys) == nil {
+			cursor.forwarded[name] = map[string]bool{}
+			for _, key := range keys {
+				cursor.forwarded[name][key] = true
+			}
+			continue
+		}
+		var lastId int
+		err = json.Unmarshal(source, &lastId)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", file, name, err)
+		}
+		cursor.lastIds[name] = lastId
+	}
+	return cursor, nil
+}
+
+// Known return true if a source was polled, so its read messages aren't skipped
+func (cursor *Cursor) Known(name string) bool {
+	cursor.mutex.Lock()
+	defer cursor.mutex.Unlock()
+	_, ok := cursor.forwarded[name]
+	_, old := cursor.lastIds[name]
+	return ok || old
+}
+
+// Forwarded return true if a message of a source was forwarded
+func (cursor *Cursor) Forwarded(name string, message device.ReceivedSMS) bool {
+	cursor.mutex.Lock()
+	defer cursor.mutex.Unlock()
+	if lastId, ok := cursor.lastIds[name]; ok {
+		return message.Id <= lastId
+	}
+	return cursor.forwarded[name][messageKey(message)]
+}
+
+// Set save the keys of the forwarded messages a source still store
+func (cursor *Cursor) Set(name string, keys []string) error {
+	cursor.mutex.Lock()
+	defer cursor.mutex.Unlock()
+	forwarded := map[string]bool{}
+	for _, key := range keys {
+		forwarded[key] = true
+	}
+	_, old := cursor.lastIds[name]
+	if previous, ok := cursor.forwarded[name]; ok && old == false && equalKeys(previous, forwarded) {
+		return nil
+	}
+	cursor.forwarded[name] = forwarded
+	delete(cursor.lastIds, name)
+
+	content := map[string]interface{}{}
+	for source, lastId := range cursor.lastIds {
+		content[source] = lastId
+	}
+	for source, forwarded := range cursor.forwarded {
+		keys := []string{}
+		for key := range forwarded {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		content[source] = keys
+	}
+	return poll.SaveJSON(cursor.File, content)
+}
+
+func equalKeys(a map[string]bool, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if b[key] == false {
+			return false
+		}
+	}
+	return true
+}
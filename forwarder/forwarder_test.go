@@ -0,0 +1,241 @@
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var bodies []map[string]interface{}
+	failing := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SIGNATURE_HEADER) != "sha256="+Sign("secret", content) {
+			t.Errorf("Unexpected signature: %s", r.Header.Get(SIGNATURE_HEADER))
+		}
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var body map[string]interface{}
+		json.Unmarshal(content, &body)
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	config := &Config{
+		After:      AFTER_DELETE,
+		CursorFile: filepath.Join(dir, "cursor.json"),
+		Webhooks:   []Webhook{{URL: server.URL, Secret: "secret"}},
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	inbox := &modemtest.Inbox{Messages: []device.ReceivedSMS{
+		{Id: 3, Number: "NOS", Time: now, Text: "already read"},
+		{Id: 5, Number: "NOS", Time: now, Text: "Saldo: 2,00 GB", Unread: true},
+	}}
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return inbox, nil }}
+	forwarder, err := New(config, []poll.Source{source})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+
+	// the first poll forward the unread messages only
+	err = forwarder.Poll(context.Background(), source)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	if len(bodies) != 1 || bodies[0]["text"] != "Saldo: 2,00 GB" || bodies[0]["modem"] != "home" || bodies[0]["from"] != "NOS" {
+		t.Fatalf("Unexpected webhook bodies: %v", bodies)
+	}
+	if len(inbox.Deleted) != 1 || inbox.Deleted[0] != 5 {
+		t.Logf("Expected message 5 deleted, got: %v", inbox.Deleted)
+		t.Fail()
+	}
+
+	// a failed message is forwarded again by the next poll, even after a restart
+	inbox.Messages = append(inbox.Messages, device.ReceivedSMS{Id: 6, Number: "+351910000000", Text: "read later"})
+	failing = true
+	if forwarder.Poll(context.Background(), source) == nil {
+		t.Fatalf("Expected the webhook error")
+	}
+	failing = false
+	forwarder, err = New(config, []poll.Source{source})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	forwarder.Poll(context.Background(), source)
+	forwarder.Poll(context.Background(), source)
+	if len(bodies) != 2 || bodies[1]["text"] != "read later" {
+		t.Logf("Expected message 6 forwarded once, got: %v", bodies)
+		t.Fail()
+	}
+	if forwarder.Cursor.Forwarded("home", inbox.Messages[2]) == false {
+		t.Logf("Expected message 6 in the cursor")
+		t.Fail()
+	}
+
+	// a new message stored under the id of a deleted one is forwarded
+	inbox.Messages = append(inbox.Messages, device.ReceivedSMS{Id: 5, Number: "NOS", Time: now.Add(time.Hour), Text: "Saldo: 1,00 GB"})
+	forwarder.Poll(context.Background(), source)
+	if len(bodies) != 3 || bodies[2]["text"] != "Saldo: 1,00 GB" {
+		t.Logf("Expected the message reusing id 5 forwarded, got: %v", bodies)
+		t.Fail()
+	}
+
+	// Start take the cursor of a new source before returning
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	forwarder.Sources = append(forwarder.Sources, poll.Source{Name: "office", Open: source.Open})
+	forwarder.Start(ctx)
+	if forwarder.Cursor.Known("office") == false || forwarder.Cursor.Forwarded("office", inbox.Messages[2]) == false {
+		t.Logf("Expected the read messages in the cursor once started")
+		t.Fail()
+	}
+}
+
+func TestCursorIds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwarder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cursor.json")
+	err = ioutil.WriteFile(file, []byte(`{"home": 5}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a cursor file keeping the id of the last message forwarded is read, until the next poll
+	cursor, err := LoadCursor(file)
+	if err != nil {
+		t.Fatalf("[TestCursorIds] Error: %s", err)
+	}
+	old := device.ReceivedSMS{Id: 5, Number: "NOS", Text: "Saldo: 2,00 GB"}
+	if cursor.Known("home") == false || cursor.Forwarded("home", old) == false || cursor.Forwarded("home", device.ReceivedSMS{Id: 6}) {
+		t.Fatalf("Expected the messages up to id 5 forwarded")
+	}
+	err = cursor.Set("home", []string{messageKey(old)})
+	if err != nil {
+		t.Fatalf("[TestCursorIds] Error: %s", err)
+	}
+	cursor, err = LoadCursor(file)
+	if err != nil {
+		t.Fatalf("[TestCursorIds] Error: %s", err)
+	}
+	if cursor.Forwarded("home", old) == false || cursor.Forwarded("home", device.ReceivedSMS{Id: 4}) {
+		t.Logf("Expected the cursor saved as keys")
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{CursorFile: "cursor.json"},
+		{Webhooks: []Webhook{{URL: "http://localhost"}}},
+		{CursorFile: "cursor.json", After: "archive", Webhooks: []Webhook{{URL: "http://localhost"}}},
+		{CursorFile: "cursor.json", Webhooks: []Webhook{{URL: "http://localhost", Template: "{{ .Text"}}},
+		{CursorFile: "cursor.json", Emails: []Email{{Server: "localhost", From: "modem@example.com", To: []string{"ops@example.com"}}}},
+	}
+	for i := range configs {
+		if configs[i].Validate() == nil {
+			t.Logf("Expected config %d invalid", i)
+			t.Fail()
+		}
+	}
+}
+
+// serveSMTP accept one email and send its data to received
+func serveSMTP(t *testing.T, listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case command == "DATA":
+			reply("354 go ahead")
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data = append(data, line)
+			}
+			reply("250 queued")
+			received <- strings.Join(data, "")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveSMTP(t, listener, received)
+
+	config := &Config{
+		CursorFile: "cursor.json",
+		Emails:     []Email{{Server: listener.Addr().String(), From: "modem@example.com", To: []string{"ops@example.com"}, Subject: "SMS {{ .Number }} ✉"}},
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("[TestEmail] Error: %s", err)
+	}
+
+	message := &Message{Modem: "home", Number: "NOS", Time: time.Now(), Text: "Carregamento de 10,00€ efetuado"}
+	err = config.Emails[0].Send(context.Background(), message)
+	if err != nil {
+		t.Fatalf("[TestEmail] Error: %s", err)
+	}
+	data := <-received
+	if strings.Contains(data, "Subject: =?utf-8?q?SMS_NOS_=E2=9C=89?=") == false || strings.Contains(data, "10,00=E2=82=AC efetuado") == false {
+		t.Logf("Unexpected email: %s", data)
+		t.Fail()
+	}
+}
@@ -0,0 +1,205 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DEFAULT_WEBHOOK_TEMPLATE render the JSON body of a webhook without template
+const DEFAULT_WEBHOOK_TEMPLATE = `{"modem": {{ json .Modem }}, "id": {{ .Id }}, "from": {{ json .Number }}, "time": {{ json .Time }}, "text": {{ json .Text }}}`
+
+// Default email templates
+const (
+	DEFAULT_SUBJECT_TEMPLATE = `SMS from {{ .Number }}`
+	DEFAULT_BODY_TEMPLATE    = "{{ .Text }}\n\n-- \nReceived by {{ .Modem }} on {{ .Time.Format \"2006-01-02 15:04:05\" }}"
+)
+
+// SIGNATURE_HEADER is the webhook header with the hex HMAC-SHA256 of the body, prefixed by sha256=
+const SIGNATURE_HEADER = "X-Signature-256"
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		content, err := json.Marshal(value)
+		return string(content), err
+	},
+}
+
+// Webhook POST a message as JSON to URL. When Secret is set the body is signed in SIGNATURE_HEADER
+type Webhook struct {
+	URL      string            `json:"url"`
+	Template string            `json:"template,omitempty"`
+	Secret   string            `json:"secret,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	template *template.Template
+}
+
+func (webhook *Webhook) compile(i int) error {
+	if webhook.URL == "" {
+		return fmt.Errorf("webhook %d: no url", i)
+	}
+	text := webhook.Template
+	if text == "" {
+		text = DEFAULT_WEBHOOK_TEMPLATE
+	}
+	var err error
+	webhook.template, err = template.New(fmt.Sprintf("webhook %d", i)).Funcs(templateFuncs).Parse(text)
+	return err
+}
+
+func (webhook *Webhook) Name() string {
+	return webhook.URL
+}
+
+func (webhook *Webhook) Send(ctx context.Context, message *Message) error {
+	var body bytes.Buffer
+	err := webhook.template.Execute(&body, message)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	err = json.Unmarshal(body.Bytes(), &value)
+	if err != nil {
+		return fmt.Errorf("template is not JSON: %s", err)
+	}
+	return webhook.Post(ctx, body.Bytes())
+}
+
+// Post send a JSON body to the webhook, without the template. It is signed when Secret is set
+func (webhook *Webhook) Post(ctx context.Context, body []byte) error {
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+	if webhook.Secret != "" {
+		request.Header.Set(SIGNATURE_HEADER, "sha256="+Sign(webhook.Secret, body))
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s", response.Status)
+	}
+	return nil
+}
+
+// Sign return the hex HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Email send a message by SMTP through Server, like "smtp.example.com:587". STARTTLS is used when
+// the server offer it, Username and Password authenticate with PLAIN
+type Email struct {
+	Server   string   `json:"server"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Subject  string   `json:"subject,omitempty"`
+	Body     string   `json:"body,omitempty"`
+
+	subject *template.Template
+	body    *template.Template
+}
+
+func (email *Email) compile(i int) error {
+	if email.Server == "" || email.From == "" || len(email.To) == 0 {
+		return fmt.Errorf("email %d: server, from and to are required", i)
+	}
+	_, _, err := net.SplitHostPort(email.Server)
+	if err != nil {
+		return fmt.Errorf("email %d: %s", i, err)
+	}
+
+	subject, body := email.Subject, email.Body
+	if subject == "" {
+		subject = DEFAULT_SUBJECT_TEMPLATE
+	}
+	if body == "" {
+		body = DEFAULT_BODY_TEMPLATE
+	}
+	email.subject, err = template.New(fmt.Sprintf("email %d subject", i)).Funcs(templateFuncs).Parse(subject)
+	if err != nil {
+		return err
+	}
+	email.body, err = template.New(fmt.Sprintf("email %d body", i)).Funcs(templateFuncs).Parse(body)
+	return err
+}
+
+func (email *Email) Name() string {
+	return "mailto:" + strings.Join(email.To, ",")
+}
+
+// Send the message, net/smtp has no context so ctx only bound the wait for the result
+func (email *Email) Send(ctx context.Context, message *Message) error {
+	content, err := email.render(message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if email.Username != "" {
+		host, _, _ := net.SplitHostPort(email.Server)
+		auth = smtp.PlainAuth("", email.Username, email.Password, host)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(email.Server, auth, email.From, email.To, content)
+	}()
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// render return the email with its headers, the body is quoted-printable UTF-8
+func (email *Email) render(message *Message, now time.Time) ([]byte, error) {
+	var subject, body bytes.Buffer
+	err := email.subject.Execute(&subject, message)
+	if err != nil {
+		return nil, err
+	}
+	err = email.body.Execute(&body, message)
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "From: %s\r\n", email.From)
+	fmt.Fprintf(&content, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&content, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&content, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	content.WriteString("MIME-Version: 1.0\r\n")
+	content.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	content.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(&content)
+	writer.Write([]byte(strings.Replace(body.String(), "\n", "\r\n", -1)))
+	writer.Close()
+	return content.Bytes(), nil
+}
@@ -0,0 +1,282 @@
+// Package housekeeping delete SMS from the modems before their storage is full, since a full modem
+// refuse the new messages. The policy delete the read and sent messages older than some days, keep
+// the last messages of each contact, and keep a number of free slots. The received messages not
+// forwarded yet are kept, messages are archived before they are deleted, and a dry run only log
+// what would be deleted.
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Deletion reasons
+const (
+	REASON_AGE         = "age"
+	REASON_PER_CONTACT = "per_contact"
+	REASON_FREE_SLOTS  = "free_slots"
+)
+
+// DEFAULT_INTERVAL is the time between two runs of the policy
+const DEFAULT_INTERVAL = time.Hour
+
+// Config is the policy, read from a JSON file. A zero value disable a rule
+type Config struct {
+	// Interval between two runs, like "1h"
+	Interval string `json:"interval,omitempty"`
+	// MaxAgeDays delete the read and sent messages older than this number of days
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	// KeepPerContact delete the read and sent messages of a contact but the last ones
+	KeepPerContact int `json:"keep_per_contact,omitempty"`
+	// MinFree delete the oldest messages, read and sent first, until this number of slots is free
+	MinFree int `json:"min_free,omitempty"`
+	// DryRun only log the messages that would be deleted
+	DryRun bool `json:"dry_run,omitempty"`
+
+	interval time.Duration
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config
+func (config *Config) Validate() error {
+	var err error
+	config.interval, err = poll.Duration("interval", config.Interval, DEFAULT_INTERVAL)
+	if err != nil {
+		return err
+	}
+	if config.MaxAgeDays < 0 || config.KeepPerContact < 0 || config.MinFree < 0 {
+		return fmt.Errorf("max_age_days, keep_per_contact and min_free can't be negative")
+	}
+	if config.MaxAgeDays == 0 && config.KeepPerContact == 0 && config.MinFree == 0 {
+		return fmt.Errorf("no rule, set max_age_days, keep_per_contact or min_free")
+	}
+	return nil
+}
+
+// Deletion is a message to delete and why. Sent is true for the messages which weren't received
+type Deletion struct {
+	Message device.ReceivedSMS
+	Sent    bool
+	Reason  string
+}
+
+// Plan return the messages to delete from a storage, oldest first. The sent messages are deleted
+// like the read ones
+func (config *Config) Plan(received []device.ReceivedSMS, sent []device.ReceivedSMS, storage *device.SMSStorageState, now time.Time) []Deletion {
+	var messages []Deletion
+	for _, message := range received {
+		messages = append(messages, Deletion{Message: message})
+	}
+	for _, message := range sent {
+		messages = append(messages, Deletion{Message: message, Sent: true})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Message.Time.Equal(messages[j].Message.Time) {
+			return messages[i].Message.Id < messages[j].Message.Id
+		}
+		return messages[i].Message.Time.Before(messages[j].Message.Time)
+	})
+
+	if config.MaxAgeDays > 0 {
+		maxAge := time.Duration(config.MaxAgeDays) * 24 * time.Hour
+		for i := range messages {
+			message := &messages[i]
+			if message.Message.Unread == false && now.Sub(message.Message.Time) > maxAge {
+				message.Reason = REASON_AGE
+			}
+		}
+	}
+
+	if config.KeepPerContact > 0 {
+		count := map[string]int{}
+		for i := len(messages) - 1; i >= 0; i-- {
+			message := &messages[i]
+			count[message.Message.Number]++
+			if count[message.Message.Number] > config.KeepPerContact && message.Message.Unread == false && message.Reason == "" {
+				message.Reason = REASON_PER_CONTACT
+			}
+		}
+	}
+
+	if config.MinFree > 0 && storage != nil && storage.MaxCount > 0 {
+		free := int(storage.LeftCount)
+		for _, message := range messages {
+			if message.Reason != "" {
+				free += slots(message.Message)
+			}
+		}
+		// the read and sent messages first, then the unread ones
+		for _, unread := range []bool{false, true} {
+			for i := range messages {
+				message := &messages[i]
+				if free >= config.MinFree {
+					break
+				}
+				if message.Message.Unread == unread && message.Reason == "" {
+					message.Reason = REASON_FREE_SLOTS
+					free += slots(message.Message)
+				}
+			}
+		}
+	}
+
+	var deletions []Deletion
+	for _, message := range messages {
+		if message.Reason != "" {
+			deletions = append(deletions, message)
+		}
+	}
+	return deletions
+}
+
+// slots return the number of storage slots of a message
+func slots(message device.ReceivedSMS) int {
+	if len(message.PartIds) > 1 {
+		return len(message.PartIds)
+	}
+	return 1
+}
+
+// ArchiveFunc archive the messages of a modem before they are deleted
+type ArchiveFunc func(modem string, deletions []Deletion) error
+
+// ForwardedFunc return true if a received message of a modem was forwarded, false for every message
+// if the modem wasn't polled by the forwarder yet
+type ForwardedFunc func(modem string, message device.ReceivedSMS) bool
+
+// Housekeeper apply the policy to the sources, it is also the collector of its metrics
+type Housekeeper struct {
+	Config  *Config
+	Sources []poll.Source
+	// Archive is called before deleting messages, nil to delete them without archive
+	Archive ArchiveFunc
+	// Forwarded keep the received messages the forwarder didn't forward yet, nil if the messages
+	// aren't forwarded
+	Forwarded ForwardedFunc
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	deleted *prometheus.CounterVec
+}
+
+// New return a housekeeper, Run must be called to apply the policy
+func New(config *Config, sources []poll.Source, archive ArchiveFunc) *Housekeeper {
+	return &Housekeeper{
+		Config:  config,
+		Sources: sources,
+		Archive: archive,
+		Now:     time.Now,
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_sms_housekeeping_deleted_total",
+			Help: "SMS deleted by the housekeeping, by modem and reason: age, per_contact or free_slots",
+		}, []string{"modem", "reason"}),
+	}
+}
+
+// Describe implement prometheus.Collector
+func (housekeeper *Housekeeper) Describe(ch chan<- *prometheus.Desc) {
+	housekeeper.deleted.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (housekeeper *Housekeeper) Collect(ch chan<- prometheus.Metric) {
+	housekeeper.deleted.Collect(ch)
+}
+
+// Run apply the policy to the sources every interval, until ctx is done
+func (housekeeper *Housekeeper) Run(ctx context.Context) {
+	poll.Run(ctx, "Housekeeping", housekeeper.Sources, housekeeper.Config.interval, func(ctx context.Context, source poll.Source) error {
+		_, err := housekeeper.Clean(source)
+		return err
+	})
+}
+
+// Clean apply the policy to a source and return the messages deleted, or that would be in a dry run
+func (housekeeper *Housekeeper) Clean(source poll.Source) ([]Deletion, error) {
+	modem, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := modem.(device.SMSReader)
+	if ok == false {
+		return nil, device.ErrNotSupported
+	}
+	storage, err := modem.SMSStorageState()
+	if err != nil {
+		return nil, err
+	}
+	received, err := reader.ReceivedSMS()
+	if err != nil {
+		return nil, err
+	}
+	if housekeeper.Forwarded != nil {
+		received = forwarded(received, housekeeper.Forwarded, source.Name)
+	}
+	var sent []device.ReceivedSMS
+	if sentReader, ok := modem.(device.SentSMSReader); ok {
+		sent, err = sentReader.StoredSentSMS()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deletions := housekeeper.Config.Plan(received, sent, storage, housekeeper.Now())
+	if len(deletions) == 0 {
+		return nil, nil
+	}
+	if housekeeper.Config.DryRun {
+		for _, deletion := range deletions {
+			log.Infof("[Housekeeping] %s: dry run, message %d from %s of %s would be deleted: %s", source.Name, deletion.Message.Id, deletion.Message.Number, deletion.Message.Time.Format(time.RFC3339), deletion.Reason)
+		}
+		return deletions, nil
+	}
+
+	if housekeeper.Archive != nil {
+		err = housekeeper.Archive(source.Name, deletions)
+		if err != nil {
+			return nil, fmt.Errorf("archive before delete: %s", err)
+		}
+	}
+
+	var deleted []Deletion
+	for _, deletion := range deletions {
+		if deletion.Sent {
+			err = modem.(device.SentSMSReader).DeleteStoredSentSMS(deletion.Message)
+		} else {
+			err = reader.DeleteReceivedSMS(deletion.Message)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		log.Infof("[Housekeeping] %s: message %d from %s of %s deleted: %s", source.Name, deletion.Message.Id, deletion.Message.Number, deletion.Message.Time.Format(time.RFC3339), deletion.Reason)
+		housekeeper.deleted.WithLabelValues(source.Name, deletion.Reason).Inc()
+		deleted = append(deleted, deletion)
+	}
+	return deleted, nil
+}
+
+// forwarded return the messages of a modem the forwarder already forwarded, none if it didn't
+// poll the modem yet
+func forwarded(messages []device.ReceivedSMS, forwardedFunc ForwardedFunc, modem string) []device.ReceivedSMS {
+	var done []device.ReceivedSMS
+	for _, message := range messages {
+		if forwardedFunc(modem, message) {
+			done = append(done, message)
+		}
+	}
+	return done
+}
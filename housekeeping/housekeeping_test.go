@@ -0,0 +1,133 @@
+package housekeeping
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	messages := []device.ReceivedSMS{
+		{Id: 1, Number: "NOS", Time: now.Add(-40 * day)},
+		{Id: 2, Number: "+351910000000", Time: now.Add(-20 * day), Unread: true},
+		{Id: 3, Number: "NOS", Time: now.Add(-10 * day)},
+		{Id: 4, Number: "NOS", Time: now.Add(-5 * day), PartIds: []int{4, 5}},
+		{Id: 6, Number: "NOS", Time: now.Add(-day)},
+		{Id: 7, Number: "+351910000000", Time: now.Add(-day)},
+	}
+	sent := []device.ReceivedSMS{
+		{Id: 8, Number: "+351910000000", Time: now.Add(-35 * day)},
+	}
+
+	tests := []struct {
+		config   Config
+		left     float64
+		expected string
+	}{
+		{Config{MaxAgeDays: 30}, 10, "1 age, sent 8 age"},
+		{Config{KeepPerContact: 2}, 10, "1 per_contact, sent 8 per_contact, 3 per_contact"},
+		// the sent messages count as messages of the contact
+		{Config{KeepPerContact: 1}, 10, "1 per_contact, sent 8 per_contact, 3 per_contact, 4 per_contact"},
+		{Config{MaxAgeDays: 30, KeepPerContact: 2}, 10, "1 age, sent 8 age, 3 per_contact"},
+		// the read and sent messages are deleted first, a concatenated message free its parts
+		{Config{MinFree: 4}, 1, "1 free_slots, sent 8 free_slots, 3 free_slots"},
+		{Config{MinFree: 8}, 0, "1 free_slots, sent 8 free_slots, 2 free_slots, 3 free_slots, 4 free_slots, 6 free_slots, 7 free_slots"},
+		{Config{MaxAgeDays: 30, MinFree: 2}, 0, "1 age, sent 8 age"},
+	}
+	for _, test := range tests {
+		deletions := test.config.Plan(messages, sent, &device.SMSStorageState{MaxCount: 10, LeftCount: test.left}, now)
+		result := ""
+		for i, deletion := range deletions {
+			if i > 0 {
+				result += ", "
+			}
+			if deletion.Sent {
+				result += "sent "
+			}
+			result += fmt.Sprintf("%d %s", deletion.Message.Id, deletion.Reason)
+		}
+		if result != test.expected {
+			t.Logf("%+v with %.0f free: expected %s, got %s", test.config, test.left, test.expected, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	server := modemtest.NewServer()
+	defer server.Close()
+	server.Modem.SMSMaxCount = 5
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		sentAt := now.Add(time.Duration(i-5) * time.Minute)
+		server.Modem.Now = func() time.Time { return sentAt }
+		server.Modem.Deliver("NOS", fmt.Sprintf("message %d", i))
+	}
+
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestClean] Error: %s", err)
+	}
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return modem, nil }}
+	var archived []device.ReceivedSMS
+	config := &Config{MinFree: 2, DryRun: true}
+	housekeeper := New(config, []poll.Source{source}, func(name string, deletions []Deletion) error {
+		for _, deletion := range deletions {
+			archived = append(archived, deletion.Message)
+		}
+		return nil
+	})
+
+	// the messages are unread until the first fetch
+	deletions, err := housekeeper.Clean(source)
+	if err != nil {
+		t.Fatalf("[TestClean] Error: %s", err)
+	}
+	if len(deletions) != 2 || len(server.Modem.Inbox()) != 5 || len(archived) != 0 {
+		t.Fatalf("Expected 2 messages to delete in the dry run, got: %+v", deletions)
+	}
+
+	config.DryRun = false
+	deletions, err = housekeeper.Clean(source)
+	if err != nil {
+		t.Fatalf("[TestClean] Error: %s", err)
+	}
+	inbox := server.Modem.Inbox()
+	if len(deletions) != 2 || deletions[0].Reason != REASON_FREE_SLOTS || len(inbox) != 3 || inbox[0].Content != "message 2" {
+		t.Logf("Expected the 2 oldest messages deleted, got: %+v, inbox: %+v", deletions, inbox)
+		t.Fail()
+	}
+	if len(archived) != 2 || archived[0].Text != "message 0" {
+		t.Logf("Expected the deleted messages archived, got: %+v", archived)
+		t.Fail()
+	}
+
+	// the messages not forwarded yet are kept
+	for i := 5; i < 7; i++ {
+		sentAt := now.Add(time.Duration(i) * time.Minute)
+		server.Modem.Now = func() time.Time { return sentAt }
+		server.Modem.Deliver("NOS", fmt.Sprintf("message %d", i))
+	}
+	config.MinFree = 5
+	housekeeper.Forwarded = func(name string, message device.ReceivedSMS) bool { return message.Text == inbox[0].Content }
+	deletions, err = housekeeper.Clean(source)
+	if err != nil {
+		t.Fatalf("[TestClean] Error: %s", err)
+	}
+	if inbox = server.Modem.Inbox(); len(deletions) != 1 || len(inbox) != 4 || inbox[0].Content != "message 3" {
+		t.Logf("Expected only the forwarded message deleted, got: %+v, inbox: %+v", deletions, inbox)
+		t.Fail()
+	}
+	housekeeper.Forwarded = func(name string, message device.ReceivedSMS) bool { return false }
+	if deletions, err = housekeeper.Clean(source); err != nil || len(deletions) != 0 {
+		t.Logf("Expected nothing deleted before the first forward, got: %+v %v", deletions, err)
+		t.Fail()
+	}
+}
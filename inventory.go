@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/inventory"
+)
+
+// startInventory keep the inventory of the clients of the targets, and serve it on the inventory API
+// when the control API token is set
+func startInventory(configFile string, targets []*target, token string) error {
+	config, err := inventory.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	clientInventory, err := inventory.Open(config, pollSources(targets))
+	if err != nil {
+		return err
+	}
+	err = prometheus.Register(clientInventory)
+	if err != nil {
+		return err
+	}
+	go clientInventory.Run(context.Background())
+	log.Infof("Client inventory in %s, %d allowed client(s)", config.File, len(config.Allow))
+	if token == "" {
+		log.Infof("Client inventory API disabled, it need CONTROL_API_TOKEN")
+		return nil
+	}
+	api, err := control.Protect(token, clientInventory)
+	if err != nil {
+		return err
+	}
+	http.Handle(inventory.API_PATH, api)
+	return nil
+}
@@ -0,0 +1,99 @@
+//go:build ignore
+// +build ignore
+
+// gen_oui write oui_table.go, the vendor table embedded in the exporter, from the IEEE MA-L
+// registry. It is run by go generate:
+//
+//	go generate ./inventory
+//
+// The registry is downloaded from OUI_URL, or read from the file of -in.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/inventory"
+)
+
+// OUI_URL is the IEEE MA-L registry
+const OUI_URL = "https://standards-oui.ieee.org/oui/oui.csv"
+
+func main() {
+	in := flag.String("in", "", "IEEE oui.csv or oui.txt, downloaded from "+OUI_URL+" if empty")
+	out := flag.String("out", "oui_table.go", "Generated file")
+	flag.Parse()
+
+	file := *in
+	if file == "" {
+		var err error
+		file, err = download(OUI_URL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(file)
+	}
+	vendors, err := inventory.LoadOUI(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var ouis []string
+	for oui := range vendors {
+		ouis = append(ouis, oui)
+	}
+	sort.Strings(ouis)
+
+	var source bytes.Buffer
+	fmt.Fprintf(&source, "// Code generated by gen_oui.go from the IEEE MA-L registry; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&source, "package inventory\n\n")
+	fmt.Fprintf(&source, "// ouiVendors is the IEEE MA-L registry, indexed by the 6 uppercase hexadecimal digits of the OUI\n")
+	fmt.Fprintf(&source, "var ouiVendors = map[string]string{\n")
+	for _, oui := range ouis {
+		fmt.Fprintf(&source, "\t%q: %q,\n", oui, vendors[oui])
+	}
+	fmt.Fprintf(&source, "}\n")
+
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = ioutil.WriteFile(*out, formatted, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("%d vendors written to %s", len(ouis), *out)
+}
+
+// download save url in a temporary file and return its name
+func download(url string) (string, error) {
+	response, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, response.Status)
+	}
+
+	output, err := ioutil.TempFile("", "oui")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+	_, err = io.Copy(output, response.Body)
+	if err != nil {
+		os.Remove(output.Name())
+		return "", err
+	}
+	return output.Name(), nil
+}
@@ -0,0 +1,388 @@
+// Package inventory keep a persistent list of every client seen on the modems, with the vendor
+// of its MAC address. Clients not on the allow list are counted by modem_unknown_clients, reported
+// to webhooks the first time they are seen, and optionally blocked with the modem MAC filter.
+package inventory
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/forwarder"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// DEFAULT_INTERVAL is the time between two polls of the client lists
+const DEFAULT_INTERVAL = time.Minute
+
+// API_PATH is the path of the inventory API
+const API_PATH = "/api/v1/inventory"
+
+// EVENT_UNKNOWN_CLIENT is sent to the webhooks when a client not allowed is seen the first time
+const EVENT_UNKNOWN_CLIENT = "unknown_client"
+
+// WEBHOOK_TIMEOUT is the time a webhook has to answer an event
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// Config of the inventory, read from a JSON file
+type Config struct {
+	// Interval between two polls, like "1m"
+	Interval string `json:"interval,omitempty"`
+	// File is the inventory, kept across restarts
+	File string `json:"file"`
+	// OUIFile is the IEEE registry, oui.csv or oui.txt, used before the embedded vendor table
+	OUIFile string `json:"oui_file,omitempty"`
+	// Allow are the known clients, by MAC address or by OUI, like "a0:b1:c2"
+	Allow []string `json:"allow"`
+	// Webhooks receive the unknown client events
+	Webhooks []forwarder.Webhook `json:"webhooks,omitempty"`
+	// Block add the unknown clients to the MAC filter of the modem
+	Block bool `json:"block,omitempty"`
+
+	interval time.Duration
+	vendors  map[string]string
+	allow    map[string]bool
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config, normalize the allow list and load the OUI file
+func (config *Config) Validate() error {
+	var err error
+	config.interval, err = poll.Duration("interval", config.Interval, DEFAULT_INTERVAL)
+	if err != nil {
+		return err
+	}
+	if config.File == "" {
+		return fmt.Errorf("no inventory file")
+	}
+	config.allow = map[string]bool{}
+	for _, address := range config.Allow {
+		normalized, ok := NormalizeMAC(address)
+		if ok == false {
+			// an OUI is the first 3 bytes of an address
+			digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(address))
+			oui, err := hex.DecodeString(digits)
+			if err != nil || len(oui) != 3 {
+				return fmt.Errorf("allow: invalid MAC address or OUI: %s", address)
+			}
+			normalized = net.HardwareAddr(append(oui, 0, 0, 0)).String()[:8]
+		}
+		config.allow[normalized] = true
+	}
+	for i, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("webhook %d: no url", i)
+		}
+		// the events are posted as is
+		if webhook.Template != "" {
+			return fmt.Errorf("webhook %d: no template for the events", i)
+		}
+	}
+	config.vendors = map[string]string{}
+	if config.OUIFile != "" {
+		config.vendors, err = LoadOUI(config.OUIFile)
+		if err != nil {
+			return fmt.Errorf("oui_file: %s", err)
+		}
+	}
+	return nil
+}
+
+// Allowed return true if a normalized MAC address, or its OUI, is on the allow list
+func (config *Config) Allowed(mac string) bool {
+	return config.allow[mac] || (len(mac) >= 8 && config.allow[mac[:8]])
+}
+
+// Vendor return the vendor of a normalized MAC address, "" if it is unknown
+func (config *Config) Vendor(mac string) string {
+	return lookupVendor(config.vendors, mac)
+}
+
+// NormalizeMAC return a MAC address in lowercase with colons, whatever its separators
+func NormalizeMAC(address string) (string, bool) {
+	hardware, err := net.ParseMAC(strings.TrimSpace(address))
+	if err != nil || len(hardware) != 6 {
+		return "", false
+	}
+	return hardware.String(), true
+}
+
+// Client is a client seen on a modem, with its last hostname, address and modem
+type Client struct {
+	MAC       string     `json:"mac"`
+	Vendor    string     `json:"vendor,omitempty"`
+	Hostname  string     `json:"hostname,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	Modem     string     `json:"modem"`
+	FirstSeen time.Time  `json:"first_seen"`
+	LastSeen  time.Time  `json:"last_seen"`
+	Allowed   bool       `json:"allowed"`
+	BlockedAt *time.Time `json:"blocked_at,omitempty"`
+}
+
+// Event is the JSON body sent to the webhooks
+type Event struct {
+	Event   string `json:"event"`
+	Blocked bool   `json:"blocked"`
+	Client
+}
+
+// Inventory is the clients seen, it is also the collector of its metrics
+type Inventory struct {
+	Config  *Config
+	Sources []poll.Source
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex   sync.Mutex
+	clients map[string]*Client
+	unknown map[string]int
+
+	blocked *prometheus.CounterVec
+	events  *prometheus.CounterVec
+}
+
+// Open read the inventory file, a missing file is an empty inventory
+func Open(config *Config, sources []poll.Source) (*Inventory, error) {
+	inventory := &Inventory{
+		Config:  config,
+		Sources: sources,
+		Now:     time.Now,
+		clients: map[string]*Client{},
+		unknown: map[string]int{},
+		blocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_clients_blocked_total",
+			Help: "Unknown clients added to the MAC filter, by modem",
+		}, []string{"modem"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "modem_inventory_events_total",
+			Help: "Unknown client events sent to the webhooks, by result: success or failure",
+		}, []string{"result"}),
+	}
+
+	content, err := ioutil.ReadFile(config.File)
+	if os.IsNotExist(err) {
+		return inventory, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	err = json.Unmarshal(content, &clients)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", config.File, err)
+	}
+	for _, client := range clients {
+		inventory.clients[client.MAC] = client
+	}
+	return inventory, nil
+}
+
+// Clients return the inventory, the last seen first
+func (inventory *Inventory) Clients() []Client {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+
+	var clients []Client
+	for _, client := range inventory.clients {
+		clients = append(clients, *client)
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		if clients[i].LastSeen.Equal(clients[j].LastSeen) == false {
+			return clients[i].LastSeen.After(clients[j].LastSeen)
+		}
+		return clients[i].MAC < clients[j].MAC
+	})
+	return clients
+}
+
+// Run poll the sources every interval, until ctx is done
+func (inventory *Inventory) Run(ctx context.Context) {
+	poll.Run(ctx, "Inventory", inventory.Sources, inventory.Config.interval, inventory.Poll)
+}
+
+// Poll add the clients of a source to the inventory, block the unknown ones if enabled, and send
+// the events of the new unknown clients
+func (inventory *Inventory) Poll(ctx context.Context, source poll.Source) error {
+	modem, err := source.Open()
+	if err != nil {
+		return err
+	}
+	lister, ok := modem.(device.ClientLister)
+	if ok == false {
+		return device.ErrNotSupported
+	}
+	connected, err := lister.ConnectedClients()
+	if err != nil {
+		return err
+	}
+
+	now := inventory.Now()
+	var events []Event
+	unknown := 0
+	inventory.mutex.Lock()
+	for _, connectedClient := range connected {
+		mac, ok := NormalizeMAC(connectedClient.MAC)
+		if ok == false {
+			log.Warnf("[Inventory] %s: invalid MAC address %s", source.Name, connectedClient.MAC)
+			continue
+		}
+		client, known := inventory.clients[mac]
+		if known == false {
+			client = &Client{MAC: mac, Vendor: inventory.Config.Vendor(mac), FirstSeen: now}
+			inventory.clients[mac] = client
+		}
+		if connectedClient.Hostname != "" {
+			client.Hostname = connectedClient.Hostname
+		}
+		client.IP = connectedClient.IP
+		client.Type = connectedClient.Type
+		client.Modem = source.Name
+		client.LastSeen = now
+		client.Allowed = inventory.Config.Allowed(mac)
+		if client.Allowed {
+			continue
+		}
+
+		unknown++
+		blocked := false
+		if inventory.Config.Block && client.BlockedAt == nil {
+			err = block(modem, mac)
+			if err != nil {
+				log.Errorf("[Inventory] %s: block %s: %s", source.Name, mac, err)
+			} else {
+				log.Warnf("[Inventory] %s: unknown client %s (%s, %s) blocked", source.Name, mac, client.Vendor, client.Hostname)
+				blockedAt := now
+				client.BlockedAt = &blockedAt
+				blocked = true
+				inventory.blocked.WithLabelValues(source.Name).Inc()
+			}
+		}
+		if known == false {
+			log.Warnf("[Inventory] %s: new unknown client %s (%s, %s)", source.Name, mac, client.Vendor, client.Hostname)
+			events = append(events, Event{Event: EVENT_UNKNOWN_CLIENT, Blocked: blocked, Client: *client})
+		}
+	}
+	inventory.unknown[source.Name] = unknown
+	err = inventory.save()
+	inventory.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		inventory.notify(ctx, event)
+	}
+	return nil
+}
+
+// block add mac to the MAC filter of a modem
+func block(modem device.Device, mac string) error {
+	filtering, ok := modem.(device.MACFiltering)
+	if ok == false {
+		return device.ErrNotSupported
+	}
+	filter, err := filtering.MACFilter()
+	if err != nil {
+		return err
+	}
+	if filter.Block(mac) == false {
+		return nil
+	}
+	return filtering.SetMACFilter(filter)
+}
+
+// save write the inventory file, the caller must hold the mutex
+func (inventory *Inventory) save() error {
+	var clients []*Client
+	for _, client := range inventory.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].MAC < clients[j].MAC })
+	return poll.SaveJSON(inventory.Config.File, clients)
+}
+
+// notify send an event to the webhooks, failures are logged
+func (inventory *Inventory) notify(ctx context.Context, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("[Inventory] %s", err)
+		return
+	}
+	for _, webhook := range inventory.Config.Webhooks {
+		err = post(ctx, webhook, body)
+		if err != nil {
+			log.Errorf("[Inventory] webhook %s: %s", webhook.URL, err)
+			inventory.events.WithLabelValues("failure").Inc()
+			continue
+		}
+		inventory.events.WithLabelValues("success").Inc()
+	}
+}
+
+// post send an event body to a webhook, which has WEBHOOK_TIMEOUT to answer
+func post(ctx context.Context, webhook forwarder.Webhook, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+	return webhook.Post(ctx, body)
+}
+
+// ServeHTTP return the inventory as JSON
+func (inventory *Inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clients := inventory.Clients()
+	if clients == nil {
+		clients = []Client{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
+
+var (
+	unknownClientsDesc = prometheus.NewDesc("modem_unknown_clients", "Connected clients not on the allow list, by modem", []string{"modem"}, nil)
+	devicesDesc        = prometheus.NewDesc("modem_inventory_clients", "Clients in the inventory", nil, nil)
+)
+
+// Describe implement prometheus.Collector
+func (inventory *Inventory) Describe(ch chan<- *prometheus.Desc) {
+	ch <- unknownClientsDesc
+	ch <- devicesDesc
+	inventory.blocked.Describe(ch)
+	inventory.events.Describe(ch)
+}
+
+// Collect implement prometheus.Collector
+func (inventory *Inventory) Collect(ch chan<- prometheus.Metric) {
+	inventory.mutex.Lock()
+	for modem, unknown := range inventory.unknown {
+		ch <- prometheus.MustNewConstMetric(unknownClientsDesc, prometheus.GaugeValue, float64(unknown), modem)
+	}
+	ch <- prometheus.MustNewConstMetric(devicesDesc, prometheus.GaugeValue, float64(len(inventory.clients)))
+	inventory.mutex.Unlock()
+
+	inventory.blocked.Collect(ch)
+	inventory.events.Collect(ch)
+}
@@ -0,0 +1,154 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/forwarder"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func TestVendor(t *testing.T) {
+	config := &Config{File: "inventory.json"}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[TestVendor] Error: %s", err)
+	}
+	// the registry names are like "VMware, Inc."
+	tests := map[string]string{
+		"b8:27:eb:12:34:56": "Raspberry Pi",
+		"00:0c:29:12:34:56": "VMware",
+		"da:a1:19:12:34:56": RANDOM_VENDOR,
+		"02:00:00:12:34:56": RANDOM_VENDOR,
+	}
+	for mac, expected := range tests {
+		if vendor := config.Vendor(mac); strings.HasPrefix(vendor, expected) == false {
+			t.Logf("Vendor(%s): expected %q, got %q", mac, expected, vendor)
+			t.Fail()
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"oui.csv": "Registry,Assignment,Organization Name,Organization Address\nMA-L,000001,\"Xerox, Inc.\",Rochester\n",
+		"oui.txt": "OUI/MA-L\t\t\tOrganization\n00-00-01   (hex)\t\tXerox, Inc.\n000001     (base 16)\t\tXerox, Inc.\n",
+	} {
+		file := filepath.Join(dir, name)
+		ioutil.WriteFile(file, []byte(content), 0600)
+		config.OUIFile = file
+		err = config.Validate()
+		if err != nil || config.Vendor("00:00:01:12:34:56") != "Xerox, Inc." {
+			t.Logf("Expected the vendor from %s, got: %q %v", name, config.Vendor("00:00:01:12:34:56"), err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var events []Event
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(forwarder.SIGNATURE_HEADER) != "sha256="+forwarder.Sign("secret", body) {
+			t.Logf("Invalid signature: %s", r.Header.Get(forwarder.SIGNATURE_HEADER))
+			t.Fail()
+		}
+		var event Event
+		json.Unmarshal(body, &event)
+		events = append(events, event)
+	}))
+	defer webhook.Close()
+
+	server := modemtest.NewServer()
+	defer server.Close()
+	server.Modem.SetConnectedDevices([]modem_alcatel_mw40v.ConnectedDevice{
+		{Id: 1, DeviceName: "laptop", MacAddress: "A0:B1:C2:D3:E4:F5", IPAddress: "192.168.1.100"},
+		{Id: 2, DeviceName: "pi", MacAddress: "B8:27:EB:12:34:56", IPAddress: "192.168.1.101"},
+	})
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	source := poll.Source{Name: "home", Open: func() (device.Device, error) { return modem, nil }}
+
+	config := &Config{
+		File:     filepath.Join(dir, "inventory.json"),
+		Allow:    []string{"a0-b1-c2"},
+		Webhooks: []forwarder.Webhook{{URL: webhook.URL, Secret: "secret"}},
+		Block:    true,
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	inventory, err := Open(config, []poll.Source{source})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = inventory.Poll(context.Background(), source)
+		if err != nil {
+			t.Fatalf("[TestPoll] Error: %s", err)
+		}
+	}
+
+	// the unknown client is reported once, and blocked
+	if len(events) != 1 || events[0].MAC != "b8:27:eb:12:34:56" || events[0].Vendor != "Raspberry Pi" || events[0].Blocked == false {
+		t.Fatalf("Expected one event for the unknown client, got: %+v", events)
+	}
+	filter := server.Modem.MacFilter()
+	if filter.MacFilterMode != modem_alcatel_mw40v.MAC_FILTER_DENY || len(filter.MacList) != 1 || filter.MacList[0].MacAddress != "b8:27:eb:12:34:56" {
+		t.Logf("Expected the unknown client denied, got: %+v", filter)
+		t.Fail()
+	}
+	if inventory.unknown["home"] != 1 {
+		t.Logf("Expected 1 unknown client, got: %d", inventory.unknown["home"])
+		t.Fail()
+	}
+
+	// reopen from the file
+	inventory, err = Open(config, []poll.Source{source})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	clients := inventory.Clients()
+	if len(clients) != 2 || clients[0].Allowed == false || clients[1].BlockedAt == nil || clients[1].Hostname != "pi" {
+		t.Logf("Unexpected inventory: %+v", clients)
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{},
+		{File: "inventory.json", Allow: []string{"a0:b1"}},
+		{File: "inventory.json", Interval: "1 minute"},
+		{File: "inventory.json", Webhooks: []forwarder.Webhook{{}}},
+		{File: "inventory.json", OUIFile: "missing.csv"},
+	}
+	for i := range configs {
+		if configs[i].Validate() == nil {
+			t.Logf("Expected config %d invalid", i)
+			t.Fail()
+		}
+	}
+}
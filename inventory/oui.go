@@ -0,0 +1,85 @@
+package inventory
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RANDOM_VENDOR is the vendor of locally administered addresses, like the private addresses of
+// phones and laptops, which have no OUI
+const RANDOM_VENDOR = "Random address"
+
+// The embedded vendor table, ouiVendors in oui_table.go, is a curated subset of the IEEE MA-L
+// registry, go generate replace it with the whole registry
+//go:generate go run gen_oui.go
+
+var ouiLineRegexp = regexp.MustCompile(`^\s*([0-9A-Fa-f]{2})-([0-9A-Fa-f]{2})-([0-9A-Fa-f]{2})\s+\(hex\)\s+(.+)$`)
+
+// LoadOUI read the IEEE MA-L registry, either the oui.csv or the oui.txt file of
+// https://standards-oui.ieee.org, and return the vendors indexed by OUI
+func LoadOUI(file string) (map[string]string, error) {
+	input, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	vendors := map[string]string{}
+	reader := bufio.NewReader(input)
+	start, _ := reader.Peek(8)
+	if strings.HasPrefix(string(start), "Registry") {
+		records := csv.NewReader(reader)
+		records.FieldsPerRecord = -1
+		for {
+			record, err := records.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(record) >= 3 && len(record[1]) == 6 {
+				if _, err := strconv.ParseUint(record[1], 16, 32); err == nil {
+					vendors[strings.ToUpper(record[1])] = strings.TrimSpace(record[2])
+				}
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			match := ouiLineRegexp.FindStringSubmatch(scanner.Text())
+			if match != nil {
+				vendors[strings.ToUpper(match[1]+match[2]+match[3])] = strings.TrimSpace(match[4])
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(vendors) == 0 {
+		return nil, fmt.Errorf("%s: no OUI found", file)
+	}
+	return vendors, nil
+}
+
+// lookupVendor return the vendor of a normalized MAC address from vendors, then from the embedded
+// table, or "" if it is unknown
+func lookupVendor(vendors map[string]string, mac string) string {
+	digits := strings.ToUpper(strings.Replace(mac, ":", "", -1))
+	if len(digits) < 6 {
+		return ""
+	}
+	if first, err := strconv.ParseUint(digits[:2], 16, 8); err == nil && first&0x02 != 0 {
+		return RANDOM_VENDOR
+	}
+	if vendor, ok := vendors[digits[:6]]; ok {
+		return vendor
+	}
+	return ouiVendors[digits[:6]]
+}
@@ -0,0 +1,139 @@
+package inventory
+
+// ouiVendors is a curated subset of the IEEE MA-L registry, the vendors common on the modem LANs
+// with short names, indexed by the 6 uppercase hexadecimal digits of the OUI. go generate replace
+// it with the whole registry, under the full names of the vendors
+var ouiVendors = map[string]string{
+	"00000C": "Cisco",
+	"000393": "Apple",
+	"00055D": "D-Link",
+	"000569": "VMware",
+	"00065B": "Dell",
+	"00095B": "Netgear",
+	"000A95": "Apple",
+	"000AE4": "Wistron",
+	"000B86": "Aruba",
+	"000BDB": "Dell",
+	"000C29": "VMware",
+	"000C41": "Cisco-Linksys",
+	"000C6E": "ASUSTek",
+	"000D3A": "Microsoft",
+	"000D88": "D-Link",
+	"000EC6": "ASIX",
+	"000F20": "Hewlett Packard",
+	"000F66": "Cisco-Linksys",
+	"000FB5": "Netgear",
+	"001018": "Broadcom",
+	"001195": "D-Link",
+	"001247": "Samsung",
+	"001346": "D-Link",
+	"001372": "Dell",
+	"0013E8": "Intel",
+	"001422": "Dell",
+	"001438": "Hewlett Packard",
+	"00146C": "Netgear",
+	"0014BF": "Cisco-Linksys",
+	"00155D": "Microsoft",
+	"00156D": "Ubiquiti",
+	"001599": "Samsung",
+	"0015E9": "D-Link",
+	"001636": "Quanta",
+	"00163E": "Xen",
+	"00166C": "Samsung",
+	"0016B6": "Cisco-Linksys",
+	"0016CB": "Apple",
+	"0016CF": "Hon Hai",
+	"00179A": "D-Link",
+	"0017A4": "Hewlett Packard",
+	"0017C9": "Samsung",
+	"0017F2": "Apple",
+	"001839": "Cisco-Linksys",
+	"00184D": "Netgear",
+	"001882": "Huawei",
+	"00195B": "D-Link",
+	"00197D": "Hon Hai",
+	"0019B9": "Dell",
+	"0019E3": "Apple",
+	"001A11": "Google",
+	"001A1E": "Aruba",
+	"001A4B": "Hewlett Packard",
+	"001A70": "Cisco-Linksys",
+	"001A8A": "Samsung",
+	"001A92": "ASUSTek",
+	"001AA0": "Dell",
+	"001B11": "D-Link",
+	"001B21": "Intel",
+	"001B2F": "Netgear",
+	"001B63": "Apple",
+	"001B78": "Hewlett Packard",
+	"001BFC": "ASUSTek",
+	"001C14": "VMware",
+	"001C23": "Dell",
+	"001C26": "Hon Hai",
+	"001C42": "Parallels",
+	"001CC4": "Hewlett Packard",
+	"001CF0": "D-Link",
+	"001D0F": "TP-Link",
+	"001D25": "Samsung",
+	"001D60": "ASUSTek",
+	"001D72": "Wistron",
+	"001E0B": "Hewlett Packard",
+	"001E2A": "Netgear",
+	"001E4C": "Hon Hai",
+	"001E4F": "Dell",
+	"001E52": "Apple",
+	"001E58": "D-Link",
+	"001E65": "Intel",
+	"001E68": "Quanta",
+	"001E8C": "ASUSTek",
+	"001EC2": "Apple",
+	"001EE1": "Samsung",
+	"001F16": "Wistron",
+	"001F29": "Hewlett Packard",
+	"001F33": "Netgear",
+	"001F3B": "Intel",
+	"001FC6": "ASUSTek",
+	"001FCC": "Samsung",
+	"001FE1": "Hon Hai",
+	"001FF3": "Apple",
+	"002119": "Samsung",
+	"00215A": "Hewlett Packard",
+	"00216A": "Intel",
+	"00223F": "Netgear",
+	"002268": "Hon Hai",
+	"002314": "Intel",
+	"002339": "Samsung",
+	"00237D": "Hewlett Packard",
+	"00238B": "Quanta",
+	"0023DF": "Apple",
+	"00242B": "Hon Hai",
+	"002436": "Apple",
+	"002454": "Samsung",
+	"00248C": "ASUSTek",
+	"0024E8": "Dell",
+	"002500": "Apple",
+	"00259E": "Huawei",
+	"0025B3": "Hewlett Packard",
+	"002618": "ASUSTek",
+	"00262D": "Wistron",
+	"002637": "Samsung",
+	"00265E": "Hon Hai",
+	"0026B9": "Dell",
+	"0026BB": "Apple",
+	"002719": "TP-Link",
+	"002722": "Ubiquiti",
+	"005056": "VMware",
+	"0050F2": "Microsoft",
+	"00E04C": "Realtek",
+	"00E0FC": "Huawei",
+	"0418D6": "Ubiquiti",
+	"080027": "VirtualBox",
+	"24A43C": "Ubiquiti",
+	"28CFE9": "Apple",
+	"3C5AB4": "Google",
+	"3CD92B": "Hewlett Packard",
+	"50C7BF": "TP-Link",
+	"B827EB": "Raspberry Pi",
+	"DCA632": "Raspberry Pi",
+	"E45F01": "Raspberry Pi",
+}
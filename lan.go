@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+const lanUsage = "usage: lan [-target <url>] [-force] show | set <gateway|netmask|dhcp|dhcp_start|dhcp_end|lease_hours|dns>=<value>..."
+
+// runLAN show or change the LAN and DHCP settings of a modem. A change making the target, or the
+// MODEM_URL of the exporter, unreachable is refused without -force
+func runLAN(args []string) {
+	flags := flag.NewFlagSet("lan", flag.ExitOnError)
+	modemTarget := flags.String("target", "http://192.168.1.1", "Modem, [driver+]url")
+	force := flags.Bool("force", false, "Apply a change making the modem unreachable at its current url")
+	flags.Parse(args)
+
+	command := flags.Args()
+	if len(command) == 0 || (command[0] != "show" && command[0] != "set") || (command[0] == "set" && len(command) == 1) {
+		log.Fatal(lanUsage)
+	}
+
+	options := device.Options{Username: os.Getenv("MODEM_USERNAME"), Password: os.Getenv("MODEM_PASSWORD")}
+	targets, err := parseTargets(*modemTarget, modem_alcatel_mw40v.DRIVER, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	modem, _, err := targets[0].open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	configurer, ok := modem.(device.LANConfigurer)
+	if ok == false {
+		log.Fatal(device.ErrNotSupported)
+	}
+	settings, err := configurer.LANSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if command[0] == "set" {
+		for _, assignment := range command[1:] {
+			err = setLANField(settings, assignment)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		err = settings.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		unreachable := false
+		for _, modemUrl := range []string{targets[0].Url, os.Getenv("MODEM_URL")} {
+			if settings.Reaches(modemUrl) == false {
+				log.Warnf("%s would be unreachable, the modem gateway becomes %s", modemUrl, settings.Gateway)
+				unreachable = true
+			}
+		}
+		if unreachable && *force == false {
+			log.Fatal("not applied, use -force to apply anyway")
+		}
+		err = configurer.SetLANSettings(settings)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("gateway\t%s\n", settings.Gateway)
+	fmt.Printf("netmask\t%s\n", settings.Netmask)
+	fmt.Printf("dhcp\t%v\n", settings.DHCP)
+	fmt.Printf("dhcp_start\t%s\n", settings.DHCPStart)
+	fmt.Printf("dhcp_end\t%s\n", settings.DHCPEnd)
+	fmt.Printf("lease_hours\t%d\n", settings.LeaseHours)
+	fmt.Printf("dns_mode\t%s\n", settings.DNSMode)
+	for _, server := range settings.DNS {
+		fmt.Printf("dns\t%s\n", server)
+	}
+}
+
+// setLANField set a field from a <field>=<value> argument, dns is auto or a comma separated list
+// of servers
+func setLANField(settings *device.LANSettings, assignment string) error {
+	index := strings.Index(assignment, "=")
+	if index < 0 {
+		return fmt.Errorf("invalid setting %s, expected <field>=<value>", assignment)
+	}
+	field, value := assignment[:index], assignment[index+1:]
+
+	var err error
+	switch field {
+	case "gateway":
+		settings.Gateway = value
+	case "netmask":
+		settings.Netmask = value
+	case "dhcp":
+		settings.DHCP, err = strconv.ParseBool(value)
+	case "dhcp_start":
+		settings.DHCPStart = value
+	case "dhcp_end":
+		settings.DHCPEnd = value
+	case "lease_hours":
+		settings.LeaseHours, err = strconv.Atoi(value)
+	case "dns":
+		settings.DNSMode, settings.DNS = device.DNS_MODE_AUTO, nil
+		if value != device.DNS_MODE_AUTO {
+			settings.DNSMode, settings.DNS = device.DNS_MODE_MANUAL, strings.Split(value, ",")
+		}
+	default:
+		return fmt.Errorf("unknown setting %s", field)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", field, err)
+	}
+	return nil
+}
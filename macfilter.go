@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+const macFilterUsage = "usage: macfilter [-target <url>] [-force] show | mode <disabled|allow|deny> | add <mac> | remove <mac> | block <mac> | unblock <mac>"
+
+// runMACFilter show or change the Wi-Fi MAC filter of a modem. A change disconnecting connected
+// clients, other than the device of the command, is refused without -force
+func runMACFilter(args []string) {
+	flags := flag.NewFlagSet("macfilter", flag.ExitOnError)
+	modemTarget := flags.String("target", "http://192.168.1.1", "Modem, [driver+]url")
+	force := flags.Bool("force", false, "Apply a change disconnecting connected clients")
+	flags.Parse(args)
+
+	command := flags.Args()
+	if len(command) == 0 || (command[0] != "show" && len(command) != 2) {
+		log.Fatal(macFilterUsage)
+	}
+
+	options := device.Options{Username: os.Getenv("MODEM_USERNAME"), Password: os.Getenv("MODEM_PASSWORD")}
+	targets, err := parseTargets(*modemTarget, modem_alcatel_mw40v.DRIVER, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	modem, _, err := targets[0].open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	filtering, ok := modem.(device.MACFiltering)
+	if ok == false {
+		log.Fatal(device.ErrNotSupported)
+	}
+	filter, err := filtering.MACFilter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	previous := device.MACFilter{Mode: filter.Mode, MACs: append([]string{}, filter.MACs...)}
+	changed := true
+	mac := ""
+	if command[0] == "mode" {
+		filter.Mode = command[1]
+	} else if command[0] != "show" {
+		hardware, err := net.ParseMAC(command[1])
+		if err != nil || len(hardware) != 6 {
+			log.Fatalf("invalid MAC address: %s", command[1])
+		}
+		mac = hardware.String()
+		switch command[0] {
+		case "add":
+			filter.MACs = append(filter.MACs, mac)
+		case "remove":
+			var macs []string
+			for _, address := range filter.MACs {
+				if strings.ToLower(address) != mac {
+					macs = append(macs, address)
+				}
+			}
+			changed = len(macs) != len(filter.MACs)
+			filter.MACs = macs
+		case "block":
+			changed = filter.Block(mac)
+		case "unblock":
+			changed = filter.Unblock(mac)
+		default:
+			log.Fatal(macFilterUsage)
+		}
+	}
+	if command[0] != "show" && changed {
+		lockout, err := control.Lockout(modem, targets[0].Url, &previous, filter, mac)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if lockout != "" {
+			log.Warnf("the filter would disconnect %s", lockout)
+			if *force == false {
+				log.Fatal("not applied, use -force to apply anyway")
+			}
+		}
+		err = filtering.SetMACFilter(filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("mode\t%s\n", filter.Mode)
+	for _, mac := range filter.MACs {
+		fmt.Printf("mac\t%s\n", mac)
+	}
+}
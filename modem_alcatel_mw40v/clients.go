@@ -0,0 +1,37 @@
+package modem_alcatel_mw40v
+
+// Connect modes, as reported by GetConnectedDeviceList
+const (
+	CONNECT_MODE_USB  = 0
+	CONNECT_MODE_WIFI = 1
+)
+
+// Connected device list
+type ConnectedDeviceList struct {
+	ConnectedList []ConnectedDevice
+}
+
+// ConnectedDevice is a Wi-Fi or USB client. AssociationTime is the connected time in seconds, the
+// traffic counters are only reported by some firmwares
+type ConnectedDevice struct {
+	Id              int
+	DeviceName      string
+	MacAddress      string
+	IPAddress       string
+	DeviceType      int
+	ConnectMode     int
+	AssociationTime float64
+	DlBytes         *float64 `json:",omitempty"`
+	UlBytes         *float64 `json:",omitempty"`
+}
+
+// GetConnectedDeviceList get the clients connected by Wi-Fi or USB
+func (modem *Modem) GetConnectedDeviceList() (*ConnectedDeviceList, error) {
+	var connectedDeviceList ConnectedDeviceList
+	err := modem.call("GetConnectedDeviceList", nil, &connectedDeviceList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectedDeviceList, nil
+}
@@ -0,0 +1,629 @@
+package modem_alcatel_mw40v
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// ReconnectDelay is the time between the disconnection and the connection of Reconnect
+var ReconnectDelay = 2 * time.Second
+
+// DRIVER is the device driver name of the TCL/Alcatel LinkZone and LinkHub models
+const DRIVER = "tcl"
+
+func init() {
+	device.Register(DRIVER, Open)
+}
+
+// Driver expose a Modem as a device.Device
+type Driver struct {
+	*Modem
+}
+
+// Open connect to a modem, login if a password is set, and select its model
+func Open(url string, options device.Options) (device.Device, error) {
+	modem := New(url)
+	if options.Password != "" {
+		username := options.Username
+		if username == "" {
+			username = "admin"
+		}
+		err := modem.Login(username, options.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := modem.DetectModel()
+	if err != nil {
+		return nil, err
+	}
+	return &Driver{Modem: modem}, nil
+}
+
+func (driver *Driver) Driver() string {
+	return DRIVER
+}
+
+func (driver *Driver) SystemInfo() (*device.SystemInfo, error) {
+	systemInfo, err := driver.GetSystemInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.SystemInfo{
+		Vendor:          "TCL",
+		Model:           driver.model().Name,
+		DeviceName:      systemInfo.DeviceName,
+		SoftwareVersion: systemInfo.SoftwareVersion,
+		HardwareVersion: systemInfo.HardwareVersion,
+		MacAddress:      systemInfo.MacAddress,
+		IMEI:            systemInfo.IMEI,
+		IMSI:            systemInfo.IMSI,
+		ICCID:           systemInfo.ICCID,
+	}, nil
+}
+
+func (driver *Driver) SystemStatus() (*device.SystemStatus, error) {
+	systemStatus, err := driver.GetSystemStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &device.SystemStatus{
+		Roaming:           systemStatus.Roaming == 0,
+		SignalStrength:    systemStatus.SignalStrength,
+		CurrentConnection: systemStatus.CurrentConnection,
+		TotalConnection:   systemStatus.TotalConnection,
+	}
+	if driver.model().Battery {
+		status.Battery = &device.Battery{Capacity: systemStatus.BatteryCapacity, Level: systemStatus.BatteryLevel}
+	}
+	return status, nil
+}
+
+func (driver *Driver) ConnectionState() (*device.ConnectionState, error) {
+	connectionState, err := driver.GetConnectionState()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.ConnectionState{
+		ConnectionStatus: connectionState.ConnectionStatus,
+		IPv4Address:      connectionState.IPv4Address,
+		IPv6Address:      connectionState.IPv6Address,
+		SpeedDownload:    connectionState.SpeedDownload,
+		SpeedUpload:      connectionState.SpeedUpload,
+		DownloadRate:     connectionState.DownloadRate,
+		UploadRate:       connectionState.UploadRate,
+		ConnectionTime:   connectionState.ConnectionTime,
+		DownloadBytes:    connectionState.DownloadBytes,
+		UploadBytes:      connectionState.UploadBytes,
+	}, nil
+}
+
+func (driver *Driver) Signal() (*device.Signal, error) {
+	networkInfo, err := driver.GetNetworkInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.Signal{
+		NetworkType: NetworkTypes[networkInfo.NetworkType],
+		NetworkName: networkInfo.NetworkName,
+		Strength:    networkInfo.SignalStrength,
+		RSSI:        device.ParseSignal(networkInfo.RSSI),
+		RSRP:        device.ParseSignal(networkInfo.RSRP),
+		RSRQ:        device.ParseSignal(networkInfo.RSRQ),
+		SINR:        device.ParseSignal(networkInfo.SINR),
+		CellId:      networkInfo.CellId,
+	}, nil
+}
+
+func (driver *Driver) SMSStorageState() (*device.SMSStorageState, error) {
+	smsStorageState, err := driver.GetSMSStorageState()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.SMSStorageState{
+		UnreadSMSCount: smsStorageState.UnreadSMSCount + float64(driver.FetchedUnreadCount()),
+		UsedCount:      smsStorageState.TUseCount,
+		MaxCount:       smsStorageState.MaxCount,
+		LeftCount:      smsStorageState.LeftCount,
+	}, nil
+}
+
+func (driver *Driver) SendSMS(ctx context.Context, to []string, text string) ([]device.SentSMS, error) {
+	sent, err := driver.Modem.SendSMS(ctx, to, text)
+
+	var messages []device.SentSMS
+	for _, message := range sent {
+		messages = append(messages, device.SentSMS{
+			Number:    message.Number,
+			Reference: strconv.Itoa(message.Reference),
+			Encoding:  message.Encoding,
+			Parts:     message.Parts,
+			Time:      message.Time,
+		})
+	}
+	return messages, err
+}
+
+func (driver *Driver) ReceivedSMS() ([]device.ReceivedSMS, error) {
+	return driver.storedSMS(true)
+}
+
+// StoredSentSMS return the messages of the storage which weren't received: the sent and failed
+// messages, the drafts and the delivery reports
+func (driver *Driver) StoredSentSMS() ([]device.ReceivedSMS, error) {
+	return driver.storedSMS(false)
+}
+
+func (driver *Driver) DeleteStoredSentSMS(message device.ReceivedSMS) error {
+	return driver.DeleteReceivedSMS(message)
+}
+
+// storedSMS return the incoming messages of the storage, or the other ones, oldest first
+func (driver *Driver) storedSMS(incoming bool) ([]device.ReceivedSMS, error) {
+	inbox, err := driver.SMSInbox()
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []device.ReceivedSMS
+	for _, message := range inbox {
+		if message.Incoming() != incoming {
+			continue
+		}
+		number := ""
+		if len(message.Numbers) > 0 {
+			number = message.Numbers[0]
+		}
+		messages = append(messages, device.ReceivedSMS{
+			Id:      message.Id,
+			PartIds: message.PartIds,
+			Thread:  message.ContactId,
+			Number:  number,
+			Time:    message.Time,
+			Text:    message.Content,
+			Unread:  message.Unread(),
+		})
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].Time.Equal(messages[j].Time) {
+			return messages[i].Id < messages[j].Id
+		}
+		return messages[i].Time.Before(messages[j].Time)
+	})
+	return messages, nil
+}
+
+// MarkReceivedSMSRead mark the message as read. The firmware did when it was fetched, it is only
+// no longer reported unread
+func (driver *Driver) MarkReceivedSMSRead(message device.ReceivedSMS) error {
+	driver.forgetUnread(message.PartIds)
+	return nil
+}
+
+func (driver *Driver) DeleteReceivedSMS(message device.ReceivedSMS) error {
+	return driver.DeleteSMSMessage(SMS{PartIds: message.PartIds, ContactId: message.Thread})
+}
+
+// Reconnect disconnect the mobile data, and connect it again after ReconnectDelay
+func (driver *Driver) Reconnect() error {
+	err := driver.Disconnect()
+	if err != nil {
+		return err
+	}
+	time.Sleep(ReconnectDelay)
+	return driver.Connect()
+}
+
+func (driver *Driver) SetWiFi(enabled bool) error {
+	state := WLAN_STATE_OFF
+	if enabled {
+		state = WLAN_STATE_ON
+	}
+	return driver.SetWlanState(state)
+}
+
+var wlanSecurityModes = map[int]string{
+	WLAN_SECURITY_DISABLED: device.WIFI_SECURITY_OPEN,
+	WLAN_SECURITY_WEP:      device.WIFI_SECURITY_WEP,
+	WLAN_SECURITY_WPA:      device.WIFI_SECURITY_WPA,
+	WLAN_SECURITY_WPA2:     device.WIFI_SECURITY_WPA2,
+	WLAN_SECURITY_WPA_WPA2: device.WIFI_SECURITY_WPA_WPA2,
+}
+
+var wlanAPModes = map[int]string{
+	WLAN_AP_MODE_2_4GHZ: device.WIFI_BAND_2_4GHZ,
+	WLAN_AP_MODE_5GHZ:   device.WIFI_BAND_5GHZ,
+	WLAN_AP_MODE_DUAL:   device.WIFI_BAND_DUAL,
+}
+
+// WiFiSettings return the settings of the active access point, the 2.4GHz one in dual mode. The
+// settings need a login so they aren't supported without password
+func (driver *Driver) WiFiSettings() (*device.WiFiSettings, error) {
+	if driver.hasCredentials() == false {
+		return nil, device.ErrNotSupported
+	}
+	wlanState, err := driver.GetWlanState()
+	if err != nil {
+		return nil, err
+	}
+	wlanSettings, err := driver.GetWlanSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	ap := &wlanSettings.AP2G
+	if wlanSettings.WlanAPMode == WLAN_AP_MODE_5GHZ && wlanSettings.AP5G != nil {
+		ap = wlanSettings.AP5G
+	}
+	settings := &device.WiFiSettings{
+		Enabled:         wlanState.WlanState == WLAN_STATE_ON,
+		SSID:            ap.Ssid,
+		Security:        wlanSecurityModes[ap.SecurityMode],
+		Passphrase:      ap.WpaKey,
+		Band:            wlanAPModes[wlanSettings.WlanAPMode],
+		Channel:         ap.Channel,
+		Hidden:          ap.SsidHidden != 0,
+		MaxClients:      ap.MaxNumsta,
+		CurrentChannels: map[string]int{},
+	}
+	if ap.SecurityMode == WLAN_SECURITY_WEP {
+		settings.Passphrase = ap.WepKey
+	}
+	if wlanSettings.WlanAPMode != WLAN_AP_MODE_5GHZ {
+		settings.CurrentChannels[device.WIFI_BAND_2_4GHZ] = wlanSettings.AP2G.CurChannel
+	}
+	if wlanSettings.WlanAPMode != WLAN_AP_MODE_2_4GHZ && wlanSettings.AP5G != nil {
+		settings.CurrentChannels[device.WIFI_BAND_5GHZ] = wlanSettings.AP5G.CurChannel
+	}
+	return settings, nil
+}
+
+// SetWiFiSettings validate the settings and apply them to the access points, then turn the Wi-Fi on
+// or off if its state changed. In dual mode the channel is the 2.4GHz one, the 5GHz one is kept
+func (driver *Driver) SetWiFiSettings(settings *device.WiFiSettings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+	wlanSettings, err := driver.GetWlanSettings()
+	if err != nil {
+		return err
+	}
+
+	wlanSettings.WlanAPMode = -1
+	for mode, band := range wlanAPModes {
+		if band == settings.Band {
+			wlanSettings.WlanAPMode = mode
+		}
+	}
+	if wlanSettings.WlanAPMode != WLAN_AP_MODE_2_4GHZ && wlanSettings.AP5G == nil {
+		return fmt.Errorf("band: %s isn't supported by this model", settings.Band)
+	}
+	aps := []*WlanAP{&wlanSettings.AP2G}
+	if wlanSettings.AP5G != nil {
+		aps = append(aps, wlanSettings.AP5G)
+	}
+	for _, ap := range aps {
+		for mode, security := range wlanSecurityModes {
+			if security == settings.Security {
+				ap.SecurityMode = mode
+			}
+		}
+		ap.Ssid = settings.SSID
+		ap.SsidHidden = 0
+		if settings.Hidden {
+			ap.SsidHidden = 1
+		}
+		ap.MaxNumsta = settings.MaxClients
+		if settings.Security == device.WIFI_SECURITY_WEP {
+			ap.WepKey = settings.Passphrase
+		} else if settings.Security != device.WIFI_SECURITY_OPEN {
+			ap.WpaKey = settings.Passphrase
+		}
+	}
+	switch wlanSettings.WlanAPMode {
+	case WLAN_AP_MODE_2_4GHZ, WLAN_AP_MODE_DUAL:
+		wlanSettings.AP2G.Channel = settings.Channel
+	case WLAN_AP_MODE_5GHZ:
+		wlanSettings.AP5G.Channel = settings.Channel
+	}
+	err = driver.SetWlanSettings(wlanSettings)
+	if err != nil {
+		return err
+	}
+	wlanState, err := driver.GetWlanState()
+	if err != nil || (wlanState.WlanState == WLAN_STATE_ON) == settings.Enabled {
+		return err
+	}
+	return driver.SetWiFi(settings.Enabled)
+}
+
+func (driver *Driver) StartWPS() error {
+	return driver.SetWPSPbc()
+}
+
+// ConnectedClients list the clients, the list need a login so it isn't supported without password
+func (driver *Driver) ConnectedClients() ([]device.ConnectedClient, error) {
+	if driver.hasCredentials() == false {
+		return nil, device.ErrNotSupported
+	}
+	connectedDeviceList, err := driver.GetConnectedDeviceList()
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []device.ConnectedClient
+	for _, connected := range connectedDeviceList.ConnectedList {
+		client := device.ConnectedClient{
+			MAC:       strings.ToLower(connected.MacAddress),
+			IP:        connected.IPAddress,
+			Hostname:  connected.DeviceName,
+			Type:      device.CLIENT_WIFI,
+			Connected: time.Duration(connected.AssociationTime) * time.Second,
+		}
+		if connected.ConnectMode == CONNECT_MODE_USB {
+			client.Type = device.CLIENT_USB
+		}
+		if connected.DlBytes != nil && connected.UlBytes != nil {
+			client.Traffic = &device.ClientTraffic{DownloadBytes: *connected.DlBytes, UploadBytes: *connected.UlBytes}
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+var macFilterModes = map[int]string{
+	MAC_FILTER_DISABLED: device.MAC_FILTER_DISABLED,
+	MAC_FILTER_ALLOW:    device.MAC_FILTER_ALLOW,
+	MAC_FILTER_DENY:     device.MAC_FILTER_DENY,
+}
+
+func (driver *Driver) MACFilter() (*device.MACFilter, error) {
+	settings, err := driver.GetMacFilterSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &device.MACFilter{Mode: macFilterModes[settings.MacFilterMode]}
+	for _, entry := range settings.MacList {
+		filter.MACs = append(filter.MACs, strings.ToLower(entry.MacAddress))
+	}
+	return filter, nil
+}
+
+// SetMACFilter validate and replace the MAC filter, the names of the devices already in the list are
+// kept
+func (driver *Driver) SetMACFilter(filter *device.MACFilter) error {
+	err := filter.Validate()
+	if err != nil {
+		return err
+	}
+	settings := &MacFilterSettings{}
+	for mode, name := range macFilterModes {
+		if name == filter.Mode {
+			settings.MacFilterMode = mode
+		}
+	}
+
+	names := map[string]string{}
+	current, err := driver.GetMacFilterSettings()
+	if err != nil {
+		return err
+	}
+	for _, entry := range current.MacList {
+		names[strings.ToLower(entry.MacAddress)] = entry.DeviceName
+	}
+	for _, mac := range filter.MACs {
+		mac = strings.ToLower(mac)
+		settings.MacList = append(settings.MacList, MacFilterEntry{MacAddress: mac, DeviceName: names[mac]})
+	}
+	return driver.SetMacFilterSettings(settings)
+}
+
+var protocols = map[int]string{
+	PROTOCOL_TCP:  device.PROTOCOL_TCP,
+	PROTOCOL_UDP:  device.PROTOCOL_UDP,
+	PROTOCOL_BOTH: device.PROTOCOL_BOTH,
+}
+
+// PortForwardings list the port forwarding rules, the list need a login so it isn't supported
+// without password
+func (driver *Driver) PortForwardings() ([]device.PortForwarding, error) {
+	if driver.hasCredentials() == false {
+		return nil, device.ErrNotSupported
+	}
+	settings, err := driver.GetPortForwardingSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []device.PortForwarding
+	for _, rule := range settings.PortForwardingList {
+		rules = append(rules, device.PortForwarding{
+			ID:       strconv.Itoa(rule.Id),
+			Name:     rule.Name,
+			Enabled:  rule.Status == STATUS_ENABLED,
+			Protocol: protocols[rule.Protocol],
+			WANPort:  rule.WanPort,
+			LANIP:    rule.LanIp,
+			LANPort:  rule.LanPort,
+		})
+	}
+	return rules, nil
+}
+
+func (driver *Driver) AddPortForwarding(rule *device.PortForwarding) (*device.PortForwarding, error) {
+	err := rule.Validate()
+	if err != nil {
+		return nil, err
+	}
+	added := PortForwardingRule{Name: rule.Name, WanPort: rule.WANPort, LanIp: rule.LANIP, LanPort: rule.LANPort}
+	if rule.Enabled {
+		added.Status = STATUS_ENABLED
+	}
+	for protocol, name := range protocols {
+		if name == rule.Protocol {
+			added.Protocol = protocol
+		}
+	}
+	result, err := driver.Modem.AddPortForwarding(added)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = strconv.Itoa(result.Id)
+	return rule, nil
+}
+
+func (driver *Driver) RemovePortForwarding(id string) error {
+	number, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("no port forwarding rule %s", id)
+	}
+	return driver.Modem.RemovePortForwarding(number)
+}
+
+func (driver *Driver) FirewallSettings() (*device.FirewallSettings, error) {
+	if driver.hasCredentials() == false {
+		return nil, device.ErrNotSupported
+	}
+	firewall, err := driver.GetFirewallSettings()
+	if err != nil {
+		return nil, err
+	}
+	upnp, err := driver.GetUPnPSettings()
+	if err != nil {
+		return nil, err
+	}
+	dmz, err := driver.GetDMZSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &device.FirewallSettings{
+		Firewall: firewall.FirewallStatus == STATUS_ENABLED,
+		WANPing:  firewall.WanPingStatus == STATUS_ENABLED,
+		UPnP:     upnp.UpnpStatus == STATUS_ENABLED,
+	}
+	if dmz.DMZStatus == STATUS_ENABLED {
+		settings.DMZ = dmz.DMZIp
+	}
+	return settings, nil
+}
+
+// SetFirewallSettings validate and apply the settings, a disabled DMZ keep the address of the last
+// DMZ host
+func (driver *Driver) SetFirewallSettings(settings *device.FirewallSettings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+	status := func(enabled bool) int {
+		if enabled {
+			return STATUS_ENABLED
+		}
+		return STATUS_DISABLED
+	}
+
+	err = driver.Modem.SetFirewallSettings(&FirewallSettings{FirewallStatus: status(settings.Firewall), WanPingStatus: status(settings.WANPing)})
+	if err != nil {
+		return err
+	}
+	err = driver.Modem.SetUPnPSettings(&UPnPSettings{UpnpStatus: status(settings.UPnP)})
+	if err != nil {
+		return err
+	}
+	dmz, err := driver.GetDMZSettings()
+	if err != nil {
+		return err
+	}
+	dmz.DMZStatus = status(settings.DMZ != "")
+	if settings.DMZ != "" {
+		dmz.DMZIp = settings.DMZ
+	}
+	return driver.SetDMZSettings(dmz)
+}
+
+func (driver *Driver) LANSettings() (*device.LANSettings, error) {
+	lanSettings, err := driver.GetLanSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &device.LANSettings{
+		Gateway:    lanSettings.IPv4IPAddress,
+		Netmask:    lanSettings.SubnetMask,
+		DHCP:       lanSettings.DHCPServerStatus == STATUS_ENABLED,
+		DHCPStart:  lanSettings.StartIPAddress,
+		DHCPEnd:    lanSettings.EndIPAddress,
+		LeaseHours: lanSettings.DHCPLeaseTime,
+		DNSMode:    device.DNS_MODE_AUTO,
+	}
+	if lanSettings.DNSMode == DNS_MODE_MANUAL {
+		settings.DNSMode = device.DNS_MODE_MANUAL
+		for _, address := range []string{lanSettings.DNSAddress1, lanSettings.DNSAddress2} {
+			if address != "" {
+				settings.DNS = append(settings.DNS, address)
+			}
+		}
+	}
+	return settings, nil
+}
+
+// SetLANSettings validate and apply the settings, the DHCP range and the DNS servers of the modem
+// are kept when they are disabled
+func (driver *Driver) SetLANSettings(settings *device.LANSettings) error {
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+	lanSettings, err := driver.GetLanSettings()
+	if err != nil {
+		return err
+	}
+
+	lanSettings.IPv4IPAddress = settings.Gateway
+	lanSettings.SubnetMask = settings.Netmask
+	lanSettings.DHCPServerStatus = STATUS_DISABLED
+	if settings.DHCP {
+		lanSettings.DHCPServerStatus = STATUS_ENABLED
+		lanSettings.StartIPAddress = settings.DHCPStart
+		lanSettings.EndIPAddress = settings.DHCPEnd
+		lanSettings.DHCPLeaseTime = settings.LeaseHours
+	}
+	lanSettings.DNSMode = DNS_MODE_AUTO
+	if settings.DNSMode == device.DNS_MODE_MANUAL {
+		lanSettings.DNSMode = DNS_MODE_MANUAL
+		lanSettings.DNSAddress1, lanSettings.DNSAddress2 = settings.DNS[0], ""
+		if len(settings.DNS) > 1 {
+			lanSettings.DNSAddress2 = settings.DNS[1]
+		}
+	}
+	return driver.SetLanSettings(lanSettings)
+}
+
+func (driver *Driver) SendUSSD(ctx context.Context, code string) (*device.USSDReply, error) {
+	return ussdReply(driver.Modem.SendUSSD(ctx, code))
+}
+
+func (driver *Driver) ReplyUSSD(ctx context.Context, text string) (*device.USSDReply, error) {
+	return ussdReply(driver.Modem.ReplyUSSD(ctx, text))
+}
+
+func ussdReply(reply *USSDReply, err error) (*device.USSDReply, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &device.USSDReply{Text: reply.Text, SessionOpen: reply.SessionOpen}, nil
+}
@@ -0,0 +1,190 @@
+package modem_alcatel_mw40v
+
+import (
+	"fmt"
+	"net"
+)
+
+// Port forwarding protocols
+const (
+	PROTOCOL_TCP  = 0
+	PROTOCOL_UDP  = 1
+	PROTOCOL_BOTH = 2
+)
+
+// Setting states
+const (
+	STATUS_DISABLED = 0
+	STATUS_ENABLED  = 1
+)
+
+// Port forwarding settings
+type PortForwardingSettings struct {
+	PortForwardingList []PortForwardingRule
+}
+
+// PortForwardingRule forward the WanPort of the modem to the LanPort of LanIp. Status is
+// STATUS_ENABLED for the active rules
+type PortForwardingRule struct {
+	Id       int
+	Name     string
+	Status   int
+	WanPort  int
+	LanIp    string
+	LanPort  int
+	Protocol int
+}
+
+// DMZ settings, every port not forwarded is forwarded to DMZIp while DMZStatus is STATUS_ENABLED
+type DMZSettings struct {
+	DMZStatus int
+	DMZIp     string
+}
+
+// Firewall settings, WanPingStatus is STATUS_ENABLED when the modem answer ping from the WAN
+type FirewallSettings struct {
+	FirewallStatus int
+	WanPingStatus  int
+}
+
+// UPnP settings
+type UPnPSettings struct {
+	UpnpStatus int
+}
+
+// GetPortForwardingSettings get the port forwarding rules
+func (modem *Modem) GetPortForwardingSettings() (*PortForwardingSettings, error) {
+	var portForwardingSettings PortForwardingSettings
+	err := modem.call("GetPortForwardingSettings", nil, &portForwardingSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &portForwardingSettings, nil
+}
+
+// SetPortForwardingSettings replace the port forwarding rules
+func (modem *Modem) SetPortForwardingSettings(settings *PortForwardingSettings) error {
+	if settings.PortForwardingList == nil {
+		settings.PortForwardingList = []PortForwardingRule{}
+	}
+	return modem.call("SetPortForwardingSettings", settings, nil)
+}
+
+// AddPortForwarding add a rule and return it with its id. A rule can't forward a WAN port already
+// forwarded for the same protocol
+func (modem *Modem) AddPortForwarding(rule PortForwardingRule) (*PortForwardingRule, error) {
+	err := rule.validate()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := modem.GetPortForwardingSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Id = 1
+	for _, existing := range settings.PortForwardingList {
+		overlap := existing.Protocol == rule.Protocol || existing.Protocol == PROTOCOL_BOTH || rule.Protocol == PROTOCOL_BOTH
+		if existing.WanPort == rule.WanPort && overlap {
+			return nil, fmt.Errorf("WAN port %d is already forwarded by %s", rule.WanPort, existing.Name)
+		}
+		if existing.Id >= rule.Id {
+			rule.Id = existing.Id + 1
+		}
+	}
+	settings.PortForwardingList = append(settings.PortForwardingList, rule)
+	err = modem.SetPortForwardingSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// RemovePortForwarding remove a rule by id
+func (modem *Modem) RemovePortForwarding(id int) error {
+	settings, err := modem.GetPortForwardingSettings()
+	if err != nil {
+		return err
+	}
+	var rules []PortForwardingRule
+	for _, rule := range settings.PortForwardingList {
+		if rule.Id != id {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(settings.PortForwardingList) {
+		return fmt.Errorf("no port forwarding rule %d", id)
+	}
+	settings.PortForwardingList = rules
+	return modem.SetPortForwardingSettings(settings)
+}
+
+func (rule *PortForwardingRule) validate() error {
+	if rule.Name == "" {
+		return fmt.Errorf("port forwarding: a rule need a name")
+	}
+	if rule.WanPort < 1 || rule.WanPort > 65535 || rule.LanPort < 1 || rule.LanPort > 65535 {
+		return fmt.Errorf("port forwarding: ports are from 1 to 65535")
+	}
+	if ip := net.ParseIP(rule.LanIp); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("port forwarding: invalid LAN address %s", rule.LanIp)
+	}
+	if rule.Protocol < PROTOCOL_TCP || rule.Protocol > PROTOCOL_BOTH {
+		return fmt.Errorf("port forwarding: invalid protocol %d", rule.Protocol)
+	}
+	return nil
+}
+
+// GetDMZSettings get the DMZ host
+func (modem *Modem) GetDMZSettings() (*DMZSettings, error) {
+	var dmzSettings DMZSettings
+	err := modem.call("GetDMZSettings", nil, &dmzSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dmzSettings, nil
+}
+
+// SetDMZSettings set the DMZ host, the address is kept while the DMZ is disabled
+func (modem *Modem) SetDMZSettings(settings *DMZSettings) error {
+	if settings.DMZStatus == STATUS_ENABLED {
+		if ip := net.ParseIP(settings.DMZIp); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("DMZ: invalid address %s", settings.DMZIp)
+		}
+	}
+	return modem.call("SetDMZSettings", settings, nil)
+}
+
+// GetFirewallSettings get the firewall and ping from WAN state
+func (modem *Modem) GetFirewallSettings() (*FirewallSettings, error) {
+	var firewallSettings FirewallSettings
+	err := modem.call("GetFirewallSettings", nil, &firewallSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &firewallSettings, nil
+}
+
+// SetFirewallSettings turn the firewall and the ping from WAN on or off
+func (modem *Modem) SetFirewallSettings(settings *FirewallSettings) error {
+	return modem.call("SetFirewallSettings", settings, nil)
+}
+
+// GetUPnPSettings get the UPnP state
+func (modem *Modem) GetUPnPSettings() (*UPnPSettings, error) {
+	var upnpSettings UPnPSettings
+	err := modem.call("GetUPnPSettings", nil, &upnpSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnpSettings, nil
+}
+
+// SetUPnPSettings turn UPnP on or off, UPnP let the LAN devices open ports on the WAN
+func (modem *Modem) SetUPnPSettings(settings *UPnPSettings) error {
+	return modem.call("SetUPnPSettings", settings, nil)
+}
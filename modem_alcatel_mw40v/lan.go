@@ -0,0 +1,38 @@
+package modem_alcatel_mw40v
+
+// DNS modes
+const (
+	DNS_MODE_AUTO   = 0
+	DNS_MODE_MANUAL = 1
+)
+
+// LAN settings, the modem is the gateway at IPv4IPAddress. DHCPLeaseTime is in hours, and the
+// DNS addresses are only used in DNS_MODE_MANUAL
+type LanSettings struct {
+	IPv4IPAddress    string
+	SubnetMask       string
+	DHCPServerStatus int
+	StartIPAddress   string
+	EndIPAddress     string
+	DHCPLeaseTime    int
+	DNSMode          int
+	DNSAddress1      string
+	DNSAddress2      string
+}
+
+// GetLanSettings get the LAN and DHCP server settings
+func (modem *Modem) GetLanSettings() (*LanSettings, error) {
+	var lanSettings LanSettings
+	err := modem.call("GetLanSettings", nil, &lanSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lanSettings, nil
+}
+
+// SetLanSettings set the LAN and DHCP server settings. The modem restart its LAN, and is only
+// reachable at the new IPv4IPAddress afterwards
+func (modem *Modem) SetLanSettings(settings *LanSettings) error {
+	return modem.call("SetLanSettings", settings, nil)
+}
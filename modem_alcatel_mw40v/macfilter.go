@@ -0,0 +1,97 @@
+package modem_alcatel_mw40v
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MAC filter modes, as reported by GetMacFilterSettings
+const (
+	MAC_FILTER_DISABLED = 0
+	MAC_FILTER_ALLOW    = 1
+	MAC_FILTER_DENY     = 2
+)
+
+// MAC filter settings, MacList is the allowed or denied devices depending on MacFilterMode
+type MacFilterSettings struct {
+	MacFilterMode int
+	MacList       []MacFilterEntry
+}
+
+type MacFilterEntry struct {
+	MacAddress string
+	DeviceName string
+}
+
+// GetMacFilterSettings get the Wi-Fi MAC filter
+func (modem *Modem) GetMacFilterSettings() (*MacFilterSettings, error) {
+	var macFilterSettings MacFilterSettings
+	err := modem.call("GetMacFilterSettings", nil, &macFilterSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &macFilterSettings, nil
+}
+
+// SetMacFilterSettings replace the Wi-Fi MAC filter
+func (modem *Modem) SetMacFilterSettings(settings *MacFilterSettings) error {
+	if settings.MacList == nil {
+		settings.MacList = []MacFilterEntry{}
+	}
+	return modem.call("SetMacFilterSettings", settings, nil)
+}
+
+// SetMacFilterMode change the mode of the MAC filter, keeping its list
+func (modem *Modem) SetMacFilterMode(mode int) error {
+	if mode < MAC_FILTER_DISABLED || mode > MAC_FILTER_DENY {
+		return fmt.Errorf("invalid MAC filter mode: %d", mode)
+	}
+	settings, err := modem.GetMacFilterSettings()
+	if err != nil {
+		return err
+	}
+	settings.MacFilterMode = mode
+	return modem.SetMacFilterSettings(settings)
+}
+
+// AddMacFilterEntry add a device to the MAC filter list, or rename it if it is already listed
+func (modem *Modem) AddMacFilterEntry(mac string, name string) error {
+	hardware, err := net.ParseMAC(mac)
+	if err != nil || len(hardware) != 6 {
+		return fmt.Errorf("invalid MAC address: %s", mac)
+	}
+	settings, err := modem.GetMacFilterSettings()
+	if err != nil {
+		return err
+	}
+	for i, entry := range settings.MacList {
+		if strings.EqualFold(entry.MacAddress, hardware.String()) {
+			settings.MacList[i].DeviceName = name
+			return modem.SetMacFilterSettings(settings)
+		}
+	}
+	settings.MacList = append(settings.MacList, MacFilterEntry{MacAddress: hardware.String(), DeviceName: name})
+	return modem.SetMacFilterSettings(settings)
+}
+
+// RemoveMacFilterEntry remove a device from the MAC filter list, nothing is sent to the modem if it
+// isn't listed
+func (modem *Modem) RemoveMacFilterEntry(mac string) error {
+	settings, err := modem.GetMacFilterSettings()
+	if err != nil {
+		return err
+	}
+	var list []MacFilterEntry
+	for _, entry := range settings.MacList {
+		if strings.EqualFold(entry.MacAddress, mac) == false {
+			list = append(list, entry)
+		}
+	}
+	if len(list) == len(settings.MacList) {
+		return nil
+	}
+	settings.MacList = list
+	return modem.SetMacFilterSettings(settings)
+}
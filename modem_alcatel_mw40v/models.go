@@ -0,0 +1,136 @@
+package modem_alcatel_mw40v
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Model describe how a TCL/Alcatel model differ from the MW40V on the jrd/webapi
+type Model struct {
+	Name string
+	// DeviceNames and HardwarePrefixes identify the model from GetSystemInfo DeviceName and HwVersion
+	DeviceNames      []string
+	HardwarePrefixes []string
+	// Ids override the method ids
+	Ids map[string]string
+	// Fields rename the result fields of a method, from the model field name to the MW40V field name
+	Fields map[string]map[string]string
+	// Unsupported methods are not sent to the modem, ErrNotSupported is returned instead
+	Unsupported []string
+	// Battery is false for LinkHub home routers
+	Battery bool
+}
+
+// MW40V is the default model
+var MW40V = &Model{
+	Name:             "MW40V",
+	DeviceNames:      []string{"MW40"},
+	HardwarePrefixes: []string{"MW40"},
+	Battery:          true,
+}
+
+// newerFirmwareFields are the fields renamed since the MW41, which fixed the IPv4Adrress typo
+var newerFirmwareFields = map[string]map[string]string{
+	"GetConnectionState": {
+		"IPv4Address": "IPv4Adrress",
+		"IPv6Address": "IPv6Adrress",
+	},
+}
+
+// Models known by the driver, the first matching model is selected
+var Models = []*Model{
+	MW40V,
+	{
+		Name:             "MW41",
+		DeviceNames:      []string{"MW41"},
+		HardwarePrefixes: []string{"MW41"},
+		Fields:           newerFirmwareFields,
+		Battery:          true,
+	},
+	{
+		Name:             "MW45",
+		DeviceNames:      []string{"MW45"},
+		HardwarePrefixes: []string{"MW45"},
+		Fields:           newerFirmwareFields,
+		Battery:          true,
+	},
+	{
+		Name:             "HH40",
+		DeviceNames:      []string{"HH40"},
+		HardwarePrefixes: []string{"HH40"},
+		Fields:           newerFirmwareFields,
+	},
+	{
+		Name:             "HH41",
+		DeviceNames:      []string{"HH41"},
+		HardwarePrefixes: []string{"HH41"},
+		Fields:           newerFirmwareFields,
+	},
+}
+
+// LookupModel return the model matching a system info, MW40V if none match
+func LookupModel(systemInfo *SystemInfo) *Model {
+	deviceName := strings.ToUpper(strings.TrimSpace(systemInfo.DeviceName))
+	hardwareVersion := strings.ToUpper(strings.TrimSpace(systemInfo.HardwareVersion))
+
+	for _, model := range Models {
+		for _, name := range model.DeviceNames {
+			if deviceName != "" && strings.HasPrefix(deviceName, name) {
+				return model
+			}
+		}
+		for _, prefix := range model.HardwarePrefixes {
+			if strings.HasPrefix(hardwareVersion, prefix) {
+				return model
+			}
+		}
+	}
+
+	log.Warnf("Unknown model %s (%s), using %s", systemInfo.DeviceName, systemInfo.HardwareVersion, MW40V.Name)
+	return MW40V
+}
+
+// Supports return false if the model doesn't support a method
+func (model *Model) Supports(method string) bool {
+	for _, unsupported := range model.Unsupported {
+		if unsupported == method {
+			return false
+		}
+	}
+	return true
+}
+
+// id return the model id of a method
+func (model *Model) id(method Method) string {
+	if id, ok := model.Ids[method.Name]; ok {
+		return id
+	}
+	return method.Id
+}
+
+// renameFields rename the result fields of a method to the MW40V names
+func (model *Model) renameFields(method string, result json.RawMessage) json.RawMessage {
+	fields, ok := model.Fields[method]
+	if ok == false {
+		return result
+	}
+
+	var object map[string]json.RawMessage
+	err := json.Unmarshal(result, &object)
+	if err != nil {
+		return result
+	}
+	for from, to := range fields {
+		if value, ok := object[from]; ok {
+			object[to] = value
+			delete(object, from)
+		}
+	}
+	renamed, err := json.Marshal(object)
+	if err != nil {
+		return result
+	}
+	return renamed
+}
@@ -3,26 +3,166 @@ package modem_alcatel_mw40v
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
 )
 
 // HTTP_TIMEOUT in second
 const HTTP_TIMEOUT = 10
 
+// VERIFICATION_KEY is the static key sent by the web UI in _TclRequestVerificationKey header
+const VERIFICATION_KEY = "KSDHSDFOGQ5WERYTUIQWERTYUISDFG1HJZXCVCXBN2GDSMNDHKVKFsVBNf"
+
+// encryptionKey used by the web UI to obfuscate login credentials
+const encryptionKey = "e5dl12XYVggihggafXWf0f2YSf2Xngd1"
+
 type Modem struct {
-	Url string
+	Url             string
+	VerificationKey string
+	// Model is selected by DetectModel, MW40V if nil
+	Model *Model
+
+	// mutex guard the session, loginMutex serialize the logins renewing an expired session
+	mutex      sync.Mutex
+	loginMutex sync.Mutex
+	token      string
+	username   string
+	password   string
+	// smsMutex serialize the SMS sent, GetSendSMSResult only report the last one
+	smsMutex sync.Mutex
+	// ussdMutex serialize the USSD requests, the modem has a single USSD session
+	ussdMutex sync.Mutex
+	// unread keep the contact of the message parts fetched unread until they are marked read or
+	// deleted, the firmware mark them read as soon as they are fetched
+	unreadMutex sync.Mutex
+	unread      map[int]int
+}
+
+// Method describe a jrd/webapi JSON-RPC method supported by this client
+type Method struct {
+	Name string
+	Id   string
+	// Login is true when the method need an authenticated session
+	Login bool
+	// ReadOnly is true when the method doesn't change the modem state
+	ReadOnly bool
+}
+
+// Methods supported by this client, indexed by name
+var Methods = map[string]Method{
+	"Login":                     {Name: "Login", Id: "1.1"},
+	"Logout":                    {Name: "Logout", Id: "1.2", Login: true},
+	"GetLoginState":             {Name: "GetLoginState", Id: "1.3", ReadOnly: true},
+	"HeartBeat":                 {Name: "HeartBeat", Id: "1.5", Login: true},
+	"GetConnectionState":        {Name: "GetConnectionState", Id: "3.1", ReadOnly: true},
+	"GetNetworkInfo":            {Name: "GetNetworkInfo", Id: "4.1", ReadOnly: true},
+	"Connect":                   {Name: "Connect", Id: "3.2", Login: true},
+	"DisConnect":                {Name: "DisConnect", Id: "3.3", Login: true},
+	"GetSMSContactList":         {Name: "GetSMSContactList", Id: "6.2", Login: true, ReadOnly: true},
+	"GetSMSContentList":         {Name: "GetSMSContentList", Id: "6.3", Login: true},
+	"GetSMSStorageState":        {Name: "GetSMSStorageState", Id: "6.4", ReadOnly: true},
+	"DeleteSMS":                 {Name: "DeleteSMS", Id: "6.5", Login: true},
+	"SendSMS":                   {Name: "SendSMS", Id: "6.6", Login: true},
+	"GetSendSMSResult":          {Name: "GetSendSMSResult", Id: "6.7", Login: true},
+	"GetSystemInfo":             {Name: "GetSystemInfo", Id: "13.1", ReadOnly: true},
+	"GetSystemStatus":           {Name: "GetSystemStatus", Id: "13.4", ReadOnly: true},
+	"SetDeviceReboot":           {Name: "SetDeviceReboot", Id: "13.5", Login: true},
+	"GetWlanState":              {Name: "GetWlanState", Id: "5.1", Login: true, ReadOnly: true},
+	"SetWlanState":              {Name: "SetWlanState", Id: "5.2", Login: true},
+	"GetWlanSettings":           {Name: "GetWlanSettings", Id: "5.4", Login: true, ReadOnly: true},
+	"SetWlanSettings":           {Name: "SetWlanSettings", Id: "5.5", Login: true},
+	"SetWPSPbc":                 {Name: "SetWPSPbc", Id: "5.7", Login: true},
+	"SendUSSD":                  {Name: "SendUSSD", Id: "8.1", Login: true},
+	"GetUSSDSendResult":         {Name: "GetUSSDSendResult", Id: "8.2", Login: true},
+	"SetUSSDEnd":                {Name: "SetUSSDEnd", Id: "8.3", Login: true},
+	"GetConnectedDeviceList":    {Name: "GetConnectedDeviceList", Id: "14.1", Login: true, ReadOnly: true},
+	"GetMacFilterSettings":      {Name: "GetMacFilterSettings", Id: "5.10", Login: true, ReadOnly: true},
+	"SetMacFilterSettings":      {Name: "SetMacFilterSettings", Id: "5.11", Login: true},
+	"GetPortForwardingSettings": {Name: "GetPortForwardingSettings", Id: "16.1", Login: true, ReadOnly: true},
+	"SetPortForwardingSettings": {Name: "SetPortForwardingSettings", Id: "16.2", Login: true},
+	"GetDMZSettings":            {Name: "GetDMZSettings", Id: "16.3", Login: true, ReadOnly: true},
+	"SetDMZSettings":            {Name: "SetDMZSettings", Id: "16.4", Login: true},
+	"GetFirewallSettings":       {Name: "GetFirewallSettings", Id: "16.5", Login: true, ReadOnly: true},
+	"SetFirewallSettings":       {Name: "SetFirewallSettings", Id: "16.6", Login: true},
+	"GetUPnPSettings":           {Name: "GetUPnPSettings", Id: "16.7", Login: true, ReadOnly: true},
+	"SetUPnPSettings":           {Name: "SetUPnPSettings", Id: "16.8", Login: true},
+	"GetLanSettings":            {Name: "GetLanSettings", Id: "15.1", Login: true, ReadOnly: true},
+	"SetLanSettings":            {Name: "SetLanSettings", Id: "15.2", Login: true},
 }
 
-type AuthResponse struct {
-	Result struct {
-		Token float64
+// ReadOnlyMethods return the name of the methods which only read the modem state, sorted by name
+func ReadOnlyMethods() []string {
+	var names []string
+	for name, method := range Methods {
+		if method.ReadOnly {
+			names = append(names, name)
+		}
 	}
-	Id string
+	sort.Strings(names)
+	return names
+}
+
+// Request is a jrd/webapi JSON-RPC request
+type Request struct {
+	JsonRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Id      string      `json:"id"`
+}
+
+// Response is a jrd/webapi JSON-RPC response, result is kept raw
+type Response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+	Id     string          `json:"id"`
+}
+
+// RPCError is an error returned by the modem
+type RPCError struct {
+	Code    interface{} `json:"code"`
+	Message string      `json:"message"`
+}
+
+// Error codes
+const (
+	// ERROR_METHOD_NOT_FOUND is returned by firmwares without the method
+	ERROR_METHOD_NOT_FOUND = "-32601"
+	// ERROR_NOT_AUTHORIZED is returned when a method need a login
+	ERROR_NOT_AUTHORIZED = "-32699"
+)
+
+func (err *RPCError) Error() string {
+	return fmt.Sprintf("modem error %v: %s", err.Code, err.Message)
+}
+
+// MethodNotFound return true if the firmware doesn't have the method
+func (err *RPCError) MethodNotFound() bool {
+	return fmt.Sprintf("%v", err.Code) == ERROR_METHOD_NOT_FOUND
+}
+
+// NotAuthorized return true if the request need a valid login session
+func (err *RPCError) NotAuthorized() bool {
+	return fmt.Sprintf("%v", err.Code) == ERROR_NOT_AUTHORIZED
+}
+
+// Login
+type LoginResult struct {
+	Token json.RawMessage `json:"token"`
+}
+
+type LoginState struct {
+	State               float64 `json:"State"`
+	LoginRemainingTime  float64 `json:"LoginRemainingTimes"`
+	LockedRemainingTime float64 `json:"LockedRemainingTime"`
 }
 
 // system status
@@ -50,6 +190,7 @@ type SystemInfoResult struct {
 type SystemInfo struct {
 	SoftwareVersion string `json:"SwVersion"`
 	HardwareVersion string `json:"HwVersion"`
+	DeviceName      string `json:"DeviceName"`
 	MacAddress      string `json:"MacAddress"`
 	IMEI            string `json:"IMEI"`
 	IMSI            string `json:"IMSI"`
@@ -76,6 +217,56 @@ type ConnectionState struct {
 	DownloadBytes    float64 `json:"DlBytes"`
 }
 
+// Network info
+type NetworkInfo struct {
+	PLMN           string  `json:"PLMN"`
+	NetworkType    float64 `json:"NetworkType"`
+	NetworkName    string  `json:"NetworkName"`
+	SpnName        string  `json:"SpnName"`
+	LAC            string  `json:"LAC"`
+	CellId         string  `json:"CellId"`
+	Roaming        float64 `json:"Roaming"`
+	SignalStrength float64 `json:"SignalStrength"`
+	RSSI           string  `json:"RSSI"`
+	RSRP           string  `json:"RSRP"`
+	RSRQ           string  `json:"RSRQ"`
+	SINR           string  `json:"SINR"`
+	Band           string  `json:"Band"`
+}
+
+// Network types, as reported by GetNetworkInfo
+var NetworkTypes = map[float64]string{
+	0:  "No service",
+	1:  "GPRS",
+	2:  "EDGE",
+	3:  "HSDPA",
+	4:  "HSUPA",
+	5:  "UMTS",
+	6:  "HSPA",
+	7:  "HSPA+",
+	8:  "LTE",
+	9:  "LTE+",
+	10: "DC-HSPA+",
+}
+
+// Connection status values
+const (
+	CONNECTION_STATUS_DISCONNECTED  = 0
+	CONNECTION_STATUS_CONNECTING    = 1
+	CONNECTION_STATUS_CONNECTED     = 2
+	CONNECTION_STATUS_DISCONNECTING = 3
+)
+
+// Wi-Fi states
+const (
+	WLAN_STATE_OFF = 0
+	WLAN_STATE_ON  = 1
+)
+
+type WlanState struct {
+	WlanState int
+}
+
 // SMS storage state
 type SMSStorageStateResult struct {
 	Result SMSStorageState `json:"result"`
@@ -96,85 +287,269 @@ func New(url string) *Modem {
 		tmpUrl = tmpUrl + "/"
 	}
 
-	return &Modem{Url: tmpUrl}
+	return &Modem{Url: tmpUrl, VerificationKey: VERIFICATION_KEY}
 }
 
-// GetSystemInfo get modem identification Software & hardware version, mac address, IMEI, IMSI and ICCID
-func (modem *Modem) GetSystemInfo() (*SystemInfo, error) {
-	var systemInfoResult SystemInfoResult
+// Login open an authenticated session, needed by the methods changing the modem state
+func (modem *Modem) Login(username string, password string) error {
+	var loginResult LoginResult
+	params := map[string]string{
+		"UserName": encrypt(username),
+		"Password": encrypt(password),
+	}
+	err := modem.call("Login", params, &loginResult)
+	if err != nil {
+		return err
+	}
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.username = username
+	modem.password = password
+
+	// token is a number on MW40V firmwares and a string on the most recent ones
+	modem.token = strings.Trim(string(loginResult.Token), `"`)
+	return nil
+}
 
-	var jsonStr = []byte(`{"jsonrpc":"2.0","method":"GetSystemInfo","params":null,"id":"13.1"}`)
+// Logout close the authenticated session
+func (modem *Modem) Logout() error {
+	err := modem.call("Logout", nil, nil)
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.token = ""
+	modem.password = ""
+	return err
+}
 
-	body, err := modem.postRequest("jrd/webapi?api=GetSystemInfo", jsonStr)
+// LoggedIn return true if Login has been successfully called
+func (modem *Modem) LoggedIn() bool {
+	return modem.sessionToken() != ""
+}
+
+// sessionToken return the token of the authenticated session, empty without session
+func (modem *Modem) sessionToken() string {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.token
+}
+
+// hasCredentials return true if a password is kept to login again
+func (modem *Modem) hasCredentials() bool {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.password != ""
+}
+
+// GetLoginState get login state: 0 logged out, 1 logged in
+func (modem *Modem) GetLoginState() (*LoginState, error) {
+	var loginState LoginState
+	err := modem.call("GetLoginState", nil, &loginState)
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("[GetSystemInfo] Body: %+s\n", string(body))
 
-	err = json.Unmarshal(body, &systemInfoResult)
+	return &loginState, nil
+}
+
+// HeartBeat keep the authenticated session alive
+func (modem *Modem) HeartBeat() error {
+	return modem.call("HeartBeat", nil, nil)
+}
+
+// GetSystemInfo get modem identification Software & hardware version, mac address, IMEI, IMSI and ICCID
+func (modem *Modem) GetSystemInfo() (*SystemInfo, error) {
+	var systemInfo SystemInfo
+	err := modem.call("GetSystemInfo", nil, &systemInfo)
 	if err != nil {
 		return nil, err
 	}
 
 	// Remove \n suffix
-	systemInfoResult.Result.SoftwareVersion = strings.TrimSuffix(systemInfoResult.Result.SoftwareVersion, "\n")
-	systemInfoResult.Result.MacAddress = strings.TrimSuffix(systemInfoResult.Result.MacAddress, "\n")
+	systemInfo.SoftwareVersion = strings.TrimSuffix(systemInfo.SoftwareVersion, "\n")
+	systemInfo.MacAddress = strings.TrimSuffix(systemInfo.MacAddress, "\n")
 
-	return &systemInfoResult.Result, nil
+	return &systemInfo, nil
 }
 
 // GetSystemStatus get modem status: battery capacity, battery level, roaming, domestic roaming, signal strength, number device(s) connected, total device(s) connected
 func (modem *Modem) GetSystemStatus() (*SystemStatus, error) {
-	var systemStatusResult SystemStatusResult
-	var jsonStr = []byte(`{"jsonrpc":"2.0","method":"GetSystemStatus","params":null,"id":"13.4"}`)
-	body, err := modem.postRequest("jrd/webapi?api=GetSystemStatus", jsonStr)
+	var systemStatus SystemStatus
+	err := modem.call("GetSystemStatus", nil, &systemStatus)
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("[GetSystemStatus] Body: %+s\n", string(body))
 
-	err = json.Unmarshal(body, &systemStatusResult)
+	return &systemStatus, nil
+}
+
+// GetConnectionState
+func (modem *Modem) GetConnectionState() (*ConnectionState, error) {
+	var connectionState ConnectionState
+	err := modem.call("GetConnectionState", nil, &connectionState)
 	if err != nil {
 		return nil, err
 	}
 
-	return &systemStatusResult.Result, nil
+	return &connectionState, nil
 }
 
-// GetConnectionState
-func (modem *Modem) GetConnectionState() (*ConnectionState, error) {
-	var connectionStateResult ConnectionStateResult
-	var jsonStr = []byte(`{"jsonrpc":"2.0","method":"GetConnectionState","params":null,"id":"3.1"}`)
-	body, err := modem.postRequest("jrd/webapi?api=GetConnectionState", jsonStr)
+// DetectModel select the model from GetSystemInfo
+func (modem *Modem) DetectModel() (*Model, error) {
+	systemInfo, err := modem.GetSystemInfo()
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("[GetConnectionState] Body: %+s\n", string(body))
+	modem.Model = LookupModel(systemInfo)
+	return modem.Model, nil
+}
 
-	err = json.Unmarshal(body, &connectionStateResult)
+// GetNetworkInfo get the network operator, type and signal quality
+func (modem *Modem) GetNetworkInfo() (*NetworkInfo, error) {
+	var networkInfo NetworkInfo
+	err := modem.call("GetNetworkInfo", nil, &networkInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	return &connectionStateResult.Result, nil
+	return &networkInfo, nil
+}
+
+// Connect start the mobile data connection
+func (modem *Modem) Connect() error {
+	return modem.call("Connect", nil, nil)
+}
+
+// Disconnect stop the mobile data connection
+func (modem *Modem) Disconnect() error {
+	return modem.call("DisConnect", nil, nil)
+}
+
+// Reboot restart the modem, it doesn't answer until it is up again
+func (modem *Modem) Reboot() error {
+	return modem.call("SetDeviceReboot", nil, nil)
+}
+
+// GetWlanState get the Wi-Fi state
+func (modem *Modem) GetWlanState() (*WlanState, error) {
+	var wlanState WlanState
+	err := modem.call("GetWlanState", nil, &wlanState)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wlanState, nil
+}
+
+// SetWlanState turn the Wi-Fi on (WLAN_STATE_ON) or off (WLAN_STATE_OFF)
+func (modem *Modem) SetWlanState(state int) error {
+	return modem.call("SetWlanState", map[string]int{"WlanState": state}, nil)
 }
 
 // GetSMSStorageState
 func (modem *Modem) GetSMSStorageState() (*SMSStorageState, error) {
-	var smsStorageStateResult SMSStorageStateResult
-	var jsonStr = []byte(`{"jsonrpc":"2.0","method":"GetSMSStorageState","params":null,"id":"6.4"}`)
-	body, err := modem.postRequest("jrd/webapi?api=GetSMSStorageState", jsonStr)
+	var smsStorageState SMSStorageState
+	err := modem.call("GetSMSStorageState", nil, &smsStorageState)
+	if err != nil {
+		return nil, err
+	}
+
+	return &smsStorageState, nil
+}
+
+// Call send a JSON-RPC request for a supported method and return the raw response body
+func (modem *Modem) Call(methodName string, params interface{}) ([]byte, error) {
+	method, ok := Methods[methodName]
+	if ok == false {
+		return nil, fmt.Errorf("unsupported method: %s", methodName)
+	}
+	model := modem.model()
+	if model.Supports(methodName) == false {
+		return nil, device.ErrNotSupported
+	}
+
+	jsonStr, err := json.Marshal(Request{JsonRPC: "2.0", Method: method.Name, Params: params, Id: model.id(method)})
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("[GetSMSStorageState] Body: %+s\n", string(body))
 
-	err = json.Unmarshal(body, &smsStorageStateResult)
+	body, err := modem.postRequest("jrd/webapi?api="+method.Name, jsonStr)
 	if err != nil {
 		return nil, err
 	}
+	log.Debugf("[%s] Body: %+s\n", method.Name, string(body))
+
+	return body, nil
+}
+
+// call send a JSON-RPC request and decode the result into result, if not nil.
+// An expired session is renewed once if Login has been called
+func (modem *Modem) call(methodName string, params interface{}, result interface{}) error {
+	token := modem.sessionToken()
+	err := modem.callOnce(methodName, params, result)
+	if rpcErr, ok := err.(*RPCError); ok && rpcErr.NotAuthorized() && methodName != "Login" {
+		renewed, loginErr := modem.renewLogin(methodName, token)
+		if loginErr != nil {
+			return loginErr
+		}
+		if renewed {
+			err = modem.callOnce(methodName, params, result)
+		}
+	}
+	return err
+}
 
-	return &smsStorageStateResult.Result, nil
+// renewLogin login again if Login has been called and the session is still expired, the one the
+// failed call used. The concurrent calls failing with the same session login once, and return true
+// to retry with the new session
+func (modem *Modem) renewLogin(methodName string, expired string) (bool, error) {
+	modem.loginMutex.Lock()
+	defer modem.loginMutex.Unlock()
+
+	modem.mutex.Lock()
+	token, username, password := modem.token, modem.username, modem.password
+	modem.mutex.Unlock()
+	if password == "" {
+		return false, nil
+	}
+	if token != expired {
+		// renewed by another call meanwhile
+		return true, nil
+	}
+	log.Debugf("[%s] session expired, login again", methodName)
+	return true, modem.Login(username, password)
+}
+
+func (modem *Modem) callOnce(methodName string, params interface{}, result interface{}) error {
+	var response Response
+
+	body, err := modem.Call(methodName, params)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		if response.Error.MethodNotFound() {
+			return device.ErrNotSupported
+		}
+		return response.Error
+	}
+
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(modem.model().renameFields(methodName, response.Result), result)
+}
+
+// model return the selected model, MW40V by default
+func (modem *Modem) model() *Model {
+	if modem.Model == nil {
+		return MW40V
+	}
+	return modem.Model
 }
 
 // postRequest
@@ -184,6 +559,14 @@ func (modem *Modem) postRequest(url string, jsonStr []byte) ([]byte, error) {
 	requestUrl := modem.Url + url
 
 	req, err := http.NewRequest("POST", requestUrl, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return emptyByte, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("_TclRequestVerificationKey", modem.VerificationKey)
+	if token := modem.sessionToken(); token != "" {
+		req.Header.Set("_TclRequestVerificationToken", token)
+	}
 
 	client := &http.Client{
 		Timeout: HTTP_TIMEOUT * time.Second,
@@ -195,7 +578,22 @@ func (modem *Modem) postRequest(url string, jsonStr []byte) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return emptyByte, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
 	body, _ := ioutil.ReadAll(resp.Body)
 
 	return body, nil
 }
+
+// encrypt obfuscate a credential the same way the web UI does
+func encrypt(str string) string {
+	var encrypted []byte
+	for i := 0; i < len(str); i++ {
+		key := encryptionKey[i%len(encryptionKey)]
+		encrypted = append(encrypted, (key&0xf0)|((str[i]&0xf)^(key&0xf)))
+		encrypted = append(encrypted, (key&0xf0)|((str[i]>>4)^(key&0xf)))
+	}
+	return string(encrypted)
+}
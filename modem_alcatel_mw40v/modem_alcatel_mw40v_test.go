@@ -5,6 +5,7 @@ import (
 	_ "io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -43,7 +44,7 @@ func TestGetSystemInfo(t *testing.T) {
 
 	modem := New(ts.URL)
 
-	err, systemInfo := modem.GetSystemInfo()
+	systemInfo, err := modem.GetSystemInfo()
 	if err != nil {
 		t.Logf("[TestGetSystemStatus] Error: %s", err.Error())
 		t.Fail()
@@ -93,7 +94,7 @@ func TestGetSystemStatus(t *testing.T) {
 
 	modem := New(ts.URL)
 
-	err, systemStatus := modem.GetSystemStatus()
+	systemStatus, err := modem.GetSystemStatus()
 	if err != nil {
 		t.Logf("[TestGetSystemStatus] Error: %s", err.Error())
 		t.Fail()
@@ -152,7 +153,7 @@ func TestGetConnectionState(t *testing.T) {
 
 	modem := New(ts.URL)
 
-	err, connectionState := modem.GetConnectionState()
+	connectionState, err := modem.GetConnectionState()
 	if err != nil {
 		t.Logf("[TestGetConnectionState] Error: %s", err.Error())
 		t.Fail()
@@ -221,7 +222,7 @@ func TestGetSMSStorageState(t *testing.T) {
 
 	modem := New(ts.URL)
 
-	err, smsStorageState := modem.GetSMSStorageState()
+	smsStorageState, err := modem.GetSMSStorageState()
 	if err != nil {
 		t.Logf("[TestGetConnectionState] Error: %s", err.Error())
 		t.Fail()
@@ -249,3 +250,104 @@ func TestGetSMSStorageState(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestGetNetworkInfo(t *testing.T) {
+	expectedUrl := "/jrd/webapi?api=GetNetworkInfo"
+	expectedMethod := "POST"
+
+	ts := runTestServer(t, expectedUrl, expectedMethod, "testdata/getNetworkInfo.json")
+	defer ts.Close()
+
+	modem := New(ts.URL)
+
+	networkInfo, err := modem.GetNetworkInfo()
+	if err != nil {
+		t.Logf("[TestGetNetworkInfo] Error: %s", err.Error())
+		t.Fail()
+		return
+	}
+
+	if NetworkTypes[networkInfo.NetworkType] != "LTE" {
+		t.Logf("Expected NetworkType: LTE, got: %f", networkInfo.NetworkType)
+		t.Fail()
+	}
+	if networkInfo.RSRP != "-95" {
+		t.Logf("Expected RSRP: -95, got: %s", networkInfo.RSRP)
+		t.Fail()
+	}
+}
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		systemInfo SystemInfo
+		expected   string
+	}{
+		{SystemInfo{DeviceName: "MW40", HardwareVersion: "MW40-V-V1.0"}, "MW40V"},
+		{SystemInfo{DeviceName: "MW41", HardwareVersion: "MW41-2AALPT1-V1.0"}, "MW41"},
+		{SystemInfo{HardwareVersion: "HH41V-1.0"}, "HH41"},
+		{SystemInfo{DeviceName: "Y900"}, "MW40V"},
+	}
+
+	for _, test := range tests {
+		model := LookupModel(&test.systemInfo)
+		if model.Name != test.expected {
+			t.Logf("Expected model: %s for %+v, got: %s", test.expected, test.systemInfo, model.Name)
+			t.Fail()
+		}
+	}
+}
+
+func TestModelFields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "jsonrpc": "2.0", "result": { "ConnectionStatus": 2, "IPv4Address": "192.0.2.1" }, "id": "3.1" }`)
+	}))
+	defer ts.Close()
+
+	modem := New(ts.URL)
+	modem.Model = Models[1]
+
+	connectionState, err := modem.GetConnectionState()
+	if err != nil {
+		t.Logf("[TestModelFields] Error: %s", err.Error())
+		t.Fail()
+		return
+	}
+	if connectionState.IPv4Address != "192.0.2.1" {
+		t.Logf("Expected IPv4Address: 192.0.2.1, got: %s", connectionState.IPv4Address)
+		t.Fail()
+	}
+}
+
+func TestSMSThread(t *testing.T) {
+	ts := runTestServer(t, "/jrd/webapi?api=GetSMSContentList", "POST", "testdata/getSMSContentList.json")
+	defer ts.Close()
+
+	messages, err := New(ts.URL).SMSThread(3)
+	if err != nil {
+		t.Fatalf("[TestSMSThread] Error: %s", err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages, got: %d", len(messages))
+	}
+
+	// the two parts received at the same time are joined, the first one is full
+	message := messages[1]
+	if message.Id != 17 || len(message.PartIds) != 2 || message.PartIds[1] != 18 {
+		t.Logf("Unexpected message ids: %d %v", message.Id, message.PartIds)
+		t.Fail()
+	}
+	if message.Unread() == false || message.Numbers[0] != "NOS" || message.Encoding != "GSM-7" {
+		t.Logf("Unexpected message: %+v", message)
+		t.Fail()
+	}
+	if message.Time.Format(SMS_TIME_LAYOUT) != "2019-03-14 10:21:33" || strings.HasSuffix(message.Content, "numero 12345. Obrigado.") == false {
+		t.Logf("Unexpected message content: %s %s", message.Time, message.Content)
+		t.Fail()
+	}
+
+	// short messages received in the same second are kept apart
+	if messages[2].Content != "Saldo: 5,00 EUR." || len(messages[2].PartIds) != 1 || messages[3].Id != 20 {
+		t.Logf("Expected separate messages, got: %+v %+v", messages[2], messages[3])
+		t.Fail()
+	}
+}
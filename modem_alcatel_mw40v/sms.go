@@ -0,0 +1,515 @@
+package modem_alcatel_mw40v
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/sms"
+)
+
+// SMS types, as reported by GetSMSContactList and GetSMSContentList
+const (
+	SMS_TYPE_READ        = 0
+	SMS_TYPE_UNREAD      = 1
+	SMS_TYPE_SENT        = 2
+	SMS_TYPE_SEND_FAILED = 3
+	SMS_TYPE_REPORT      = 4
+	SMS_TYPE_FLASH       = 5
+	SMS_TYPE_DRAFT       = 6
+)
+
+// DeleteSMS flags
+const (
+	SMS_DELETE_ALL     = 0
+	SMS_DELETE_CONTACT = 1
+	SMS_DELETE_MESSAGE = 2
+)
+
+// SMS_TIME_LAYOUT is the layout of SMSTime, in the modem time zone
+const SMS_TIME_LAYOUT = "2006-01-02 15:04:05"
+
+// Send status, as reported by GetSendSMSResult
+const (
+	SEND_STATUS_NONE             = 0
+	SEND_STATUS_SENDING          = 1
+	SEND_STATUS_SUCCESS          = 2
+	SEND_STATUS_FAIL_SENDING     = 3
+	SEND_STATUS_FAIL_MEMORY_FULL = 4
+	SEND_STATUS_FAIL             = 5
+)
+
+// SMS_MAX_PARTS is the number of parts the firmware concatenate in one message, longer texts are
+// sent as several messages
+const SMS_MAX_PARTS = 5
+
+// SMS_SEND_TIMEOUT is the time SendSMS wait for the send result when the context has no deadline
+const SMS_SEND_TIMEOUT = 60 * time.Second
+
+// SMSSendPollInterval is the interval between two GetSendSMSResult calls
+var SMSSendPollInterval = time.Second
+
+// SMS contact list, a contact is a thread with its last message
+type SMSContactList struct {
+	SMSContactList []SMSContact `json:"SMSContactList"`
+	Page           int          `json:"Page"`
+	TotalPageCount int          `json:"TotalPageCount"`
+}
+
+type SMSContact struct {
+	ContactId   int      `json:"ContactId"`
+	PhoneNumber []string `json:"PhoneNumber"`
+	SMSId       int      `json:"SMSId"`
+	SMSType     int      `json:"SMSType"`
+	SMSTime     string   `json:"SMSTime"`
+	SMSContent  string   `json:"SMSContent"`
+	UnreadCount int      `json:"UnreadCount"`
+	TSMSCount   int      `json:"TSMSCount"`
+}
+
+// SMS content list, the messages of a contact
+type SMSContentList struct {
+	ContactId      int          `json:"ContactId"`
+	PhoneNumber    []string     `json:"PhoneNumber"`
+	Page           int          `json:"Page"`
+	TotalPageCount int          `json:"TotalPageCount"`
+	SMSContentList []SMSContent `json:"SMSContentList"`
+}
+
+type SMSContent struct {
+	SMSId      int    `json:"SMSId"`
+	SMSType    int    `json:"SMSType"`
+	SMSTime    string `json:"SMSTime"`
+	SMSContent string `json:"SMSContent"`
+}
+
+type SendSMSResult struct {
+	SendStatus int `json:"SendStatus"`
+}
+
+// SendSMSError is a message the modem failed to send
+type SendSMSError struct {
+	Status int
+}
+
+func (err *SendSMSError) Error() string {
+	switch err.Status {
+	case SEND_STATUS_FAIL_MEMORY_FULL:
+		return "SMS not sent: storage full"
+	default:
+		return fmt.Sprintf("SMS not sent: status %d", err.Status)
+	}
+}
+
+// SentSMS is a message sent to a number. Reference is the modem id of the sent message, used to
+// match delivery reports, 0 if it couldn't be found
+type SentSMS struct {
+	Number    string
+	ContactId int
+	Reference int
+	Encoding  string
+	Parts     int
+	Time      time.Time
+}
+
+// SMS is a decoded message. PartIds are the modem ids of its parts, a concatenated message the
+// firmware stored as separate messages is joined
+type SMS struct {
+	Id        int
+	PartIds   []int
+	ContactId int
+	Numbers   []string
+	Type      int
+	Time      time.Time
+	Content   string
+	// Encoding is the encoding the content needs, sms.GSM7 or sms.UCS2
+	Encoding string
+
+	// lastPart is the content of the last part, a full part is followed by the next one
+	lastPart string
+}
+
+// Unread return true if the message wasn't read before being fetched
+func (message *SMS) Unread() bool {
+	return message.Type == SMS_TYPE_UNREAD
+}
+
+// Incoming return true if the message was received, not sent
+func (message *SMS) Incoming() bool {
+	return message.Type == SMS_TYPE_READ || message.Type == SMS_TYPE_UNREAD || message.Type == SMS_TYPE_FLASH
+}
+
+// GetSMSContactList get a page of the SMS contacts, most recent first
+func (modem *Modem) GetSMSContactList(page int) (*SMSContactList, error) {
+	var smsContactList SMSContactList
+	err := modem.call("GetSMSContactList", map[string]int{"Page": page}, &smsContactList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &smsContactList, nil
+}
+
+// GetSMSContentList get a page of the messages of a contact, the firmware mark them as read
+func (modem *Modem) GetSMSContentList(contactId int, page int) (*SMSContentList, error) {
+	var smsContentList SMSContentList
+	err := modem.call("GetSMSContentList", map[string]int{"Page": page, "ContactId": contactId}, &smsContentList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &smsContentList, nil
+}
+
+// DeleteSMS delete every message (SMS_DELETE_ALL), the messages of a contact (SMS_DELETE_CONTACT)
+// or a single message (SMS_DELETE_MESSAGE)
+func (modem *Modem) DeleteSMS(flag int, contactId int, smsId int) error {
+	err := modem.call("DeleteSMS", map[string]int{"DelFlag": flag, "ContactId": contactId, "SMSId": smsId}, nil)
+	if err != nil {
+		return err
+	}
+	switch flag {
+	case SMS_DELETE_ALL:
+		modem.forgetUnread(modem.unreadParts(-1))
+	case SMS_DELETE_CONTACT:
+		modem.forgetUnread(modem.unreadParts(contactId))
+	case SMS_DELETE_MESSAGE:
+		modem.forgetUnread([]int{smsId})
+	}
+	return nil
+}
+
+// SMSContacts get the SMS contacts of every page
+func (modem *Modem) SMSContacts() ([]SMSContact, error) {
+	var contacts []SMSContact
+	for page := 0; ; page++ {
+		smsContactList, err := modem.GetSMSContactList(page)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, smsContactList.SMSContactList...)
+		if page+1 >= smsContactList.TotalPageCount {
+			return contacts, nil
+		}
+	}
+}
+
+// SMSThread get the messages of a contact from every page. Like in the web UI, the firmware mark
+// them as read, the messages fetched unread are still reported unread until MarkSMSRead
+func (modem *Modem) SMSThread(contactId int) ([]SMS, error) {
+	var messages []SMS
+	for page := 0; ; page++ {
+		smsContentList, err := modem.GetSMSContentList(contactId, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range smsContentList.SMSContentList {
+			messages = appendSMS(messages, contactId, smsContentList.PhoneNumber, content)
+		}
+		if page+1 >= smsContentList.TotalPageCount {
+			modem.keepUnread(contactId, messages)
+			return messages, nil
+		}
+	}
+}
+
+// keepUnread remember the parts of the messages of a thread fetched unread, and report the ones
+// fetched unread before as unread. The parts no longer in the thread are forgotten
+func (modem *Modem) keepUnread(contactId int, messages []SMS) {
+	modem.unreadMutex.Lock()
+	defer modem.unreadMutex.Unlock()
+	if modem.unread == nil {
+		modem.unread = map[int]int{}
+	}
+
+	fetched := map[int]bool{}
+	for i := range messages {
+		message := &messages[i]
+		for _, id := range message.PartIds {
+			fetched[id] = true
+			if message.Unread() {
+				modem.unread[id] = contactId
+			}
+		}
+		for _, id := range message.PartIds {
+			if _, ok := modem.unread[id]; ok && message.Incoming() {
+				message.Type = SMS_TYPE_UNREAD
+			}
+		}
+	}
+	for id, contact := range modem.unread {
+		if contact == contactId && fetched[id] == false {
+			delete(modem.unread, id)
+		}
+	}
+}
+
+// unreadParts return the unread parts kept for a contact, or for every contact if contactId is -1
+func (modem *Modem) unreadParts(contactId int) []int {
+	modem.unreadMutex.Lock()
+	defer modem.unreadMutex.Unlock()
+	var ids []int
+	for id, contact := range modem.unread {
+		if contactId == -1 || contact == contactId {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// forgetUnread stop reporting message parts as unread
+func (modem *Modem) forgetUnread(ids []int) {
+	modem.unreadMutex.Lock()
+	defer modem.unreadMutex.Unlock()
+	for _, id := range ids {
+		delete(modem.unread, id)
+	}
+}
+
+// FetchedUnreadCount return the number of message parts fetched unread and not marked read yet, the
+// firmware doesn't count them in UnreadSMSCount
+func (modem *Modem) FetchedUnreadCount() int {
+	modem.unreadMutex.Lock()
+	defer modem.unreadMutex.Unlock()
+	return len(modem.unread)
+}
+
+// SMSInbox get the messages of every contact, the firmware mark them as read
+func (modem *Modem) SMSInbox() ([]SMS, error) {
+	contacts, err := modem.SMSContacts()
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []SMS
+	for _, contact := range contacts {
+		thread, err := modem.SMSThread(contact.ContactId)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, thread...)
+	}
+	return messages, nil
+}
+
+// MarkSMSRead mark the messages of a contact as read, the firmware does it when they are fetched.
+// The messages received meanwhile are still reported unread
+func (modem *Modem) MarkSMSRead(contactId int) error {
+	ids := modem.unreadParts(contactId)
+	_, err := modem.SMSThread(contactId)
+	if err != nil {
+		return err
+	}
+	modem.forgetUnread(ids)
+	return nil
+}
+
+// DeleteSMSMessage delete a message with all its parts
+func (modem *Modem) DeleteSMSMessage(message SMS) error {
+	for _, id := range message.PartIds {
+		err := modem.DeleteSMS(SMS_DELETE_MESSAGE, message.ContactId, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSMSThread delete the messages of a contact
+func (modem *Modem) DeleteSMSThread(contactId int) error {
+	return modem.DeleteSMS(SMS_DELETE_CONTACT, contactId, 0)
+}
+
+// SendSMS send text to numbers and wait for the send result, until the context is done or
+// SMS_SEND_TIMEOUT after the previous send if it has no deadline. Texts longer than SMS_MAX_PARTS parts are sent as several
+// messages, one SentSMS is returned per number and message. When the context is done before the
+// result, device.ErrSendUnconfirmed is returned
+func (modem *Modem) SendSMS(ctx context.Context, to []string, text string) ([]SentSMS, error) {
+	if len(to) == 0 {
+		return nil, fmt.Errorf("SMS without recipient")
+	}
+
+	// the timeout start once the previous send is done
+	modem.smsMutex.Lock()
+	defer modem.smsMutex.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); ok == false {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, SMS_SEND_TIMEOUT)
+		defer cancel()
+	}
+
+	encoding, parts := sms.Split(text)
+	var sent []SentSMS
+	for start := 0; start < len(parts); start += SMS_MAX_PARTS {
+		end := start + SMS_MAX_PARTS
+		if end > len(parts) {
+			end = len(parts)
+		}
+		content := ""
+		for _, part := range parts[start:end] {
+			content += part
+		}
+
+		now := time.Now()
+		err := modem.call("SendSMS", map[string]interface{}{
+			"SMSId":       -1,
+			"SMSContent":  content,
+			"PhoneNumber": to,
+			"SMSTime":     now.Format(SMS_TIME_LAYOUT),
+		}, nil)
+		if err != nil {
+			return sent, err
+		}
+		err = modem.waitSendSMSResult(ctx)
+		if err == ctx.Err() && err != nil {
+			// the modem accepted the message, it may still be sent
+			return sent, device.ErrSendUnconfirmed
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		for _, number := range to {
+			sent = append(sent, SentSMS{Number: number, Encoding: encoding, Parts: end - start, Time: now})
+		}
+	}
+
+	modem.findSentReferences(sent)
+	return sent, nil
+}
+
+// GetSendSMSResult get the status of the last message sent
+func (modem *Modem) GetSendSMSResult() (*SendSMSResult, error) {
+	var sendSMSResult SendSMSResult
+	err := modem.call("GetSendSMSResult", nil, &sendSMSResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sendSMSResult, nil
+}
+
+// SMSDelivered return true if a delivery report was received for a sent message. The reports are
+// stored in the contact thread, which is marked as read. A report doesn't tell its message, so the
+// reports are matched to the messages sent before them in order
+func (modem *Modem) SMSDelivered(sent SentSMS) (bool, error) {
+	if sent.ContactId == 0 || sent.Reference == 0 {
+		return false, fmt.Errorf("unknown message sent to %s", sent.Number)
+	}
+	thread, err := modem.SMSThread(sent.ContactId)
+	if err != nil {
+		return false, err
+	}
+	sort.Slice(thread, func(i, j int) bool { return thread[i].Id < thread[j].Id })
+
+	var waiting []int
+	for _, message := range thread {
+		switch message.Type {
+		case SMS_TYPE_SENT:
+			waiting = append(waiting, message.Id)
+		case SMS_TYPE_REPORT:
+			if len(waiting) == 0 {
+				continue
+			}
+			if waiting[0] == sent.Reference {
+				return true, nil
+			}
+			waiting = waiting[1:]
+		}
+	}
+	return false, nil
+}
+
+// waitSendSMSResult poll GetSendSMSResult until the message is sent or failed. The first poll is
+// after SMSSendPollInterval, right after SendSMS the result may still be the previous message one
+func (modem *Modem) waitSendSMSResult(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(SMSSendPollInterval):
+		}
+
+		result, err := modem.GetSendSMSResult()
+		if err != nil {
+			return err
+		}
+		switch result.SendStatus {
+		case SEND_STATUS_SUCCESS:
+			return nil
+		case SEND_STATUS_NONE, SEND_STATUS_SENDING:
+		default:
+			return &SendSMSError{Status: result.SendStatus}
+		}
+	}
+}
+
+// findSentReferences set the contact of the sent messages, and the id of the last message sent to
+// each number, from the contact list which doesn't mark threads as read
+func (modem *Modem) findSentReferences(sent []SentSMS) {
+	contacts, err := modem.SMSContacts()
+	if err != nil {
+		log.Warnf("[SendSMS] sent messages references not found: %s", err)
+		return
+	}
+	referenced := map[string]bool{}
+	for i := len(sent) - 1; i >= 0; i-- {
+		for _, contact := range contacts {
+			if len(contact.PhoneNumber) == 0 || contact.PhoneNumber[0] != sent[i].Number {
+				continue
+			}
+			sent[i].ContactId = contact.ContactId
+			if contact.SMSType == SMS_TYPE_SENT && referenced[sent[i].Number] == false {
+				sent[i].Reference = contact.SMSId
+				referenced[sent[i].Number] = true
+			}
+		}
+	}
+}
+
+// appendSMS decode a message and append it to messages, or to the previous one when it is the next
+// part of a concatenated message the firmware didn't join
+func appendSMS(messages []SMS, contactId int, numbers []string, content SMSContent) []SMS {
+	smsTime, _ := time.ParseInLocation(SMS_TIME_LAYOUT, content.SMSTime, time.Local)
+	message := SMS{
+		Id:        content.SMSId,
+		PartIds:   []int{content.SMSId},
+		ContactId: contactId,
+		Numbers:   numbers,
+		Type:      content.SMSType,
+		Time:      smsTime,
+		Content:   content.SMSContent,
+	}
+
+	if last := len(messages) - 1; last >= 0 && concatenated(&messages[last], &message) {
+		previous := &messages[last]
+		previous.PartIds = append(previous.PartIds, message.Id)
+		previous.Content += message.Content
+		previous.lastPart = content.SMSContent
+		previous.Encoding = sms.Encoding(previous.Content)
+		if message.Unread() {
+			previous.Type = SMS_TYPE_UNREAD
+		}
+		return messages
+	}
+
+	message.Encoding = sms.Encoding(message.Content)
+	message.lastPart = content.SMSContent
+	return append(messages, message)
+}
+
+// concatenated return true if part is the next part of a concatenated message: both are received
+// at the same time, their ids follow each other, and the last part of the message is full
+func concatenated(message *SMS, part *SMS) bool {
+	if message.Incoming() == false || part.Incoming() == false || message.Time.Equal(part.Time) == false {
+		return false
+	}
+	return part.Id == message.PartIds[len(message.PartIds)-1]+1 && sms.Full(message.lastPart)
+}
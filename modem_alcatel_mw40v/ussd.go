@@ -0,0 +1,133 @@
+package modem_alcatel_mw40v
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// USSD types, sent with SendUSSD and reported by GetUSSDSendResult. A result of type
+// USSD_TYPE_REPLY means the network wait for a reply, the session stays open
+const (
+	USSD_TYPE_NEW   = 1
+	USSD_TYPE_REPLY = 2
+)
+
+// USSD send states, as reported by GetUSSDSendResult
+const (
+	USSD_STATE_NONE        = 0
+	USSD_STATE_SENDING     = 1
+	USSD_STATE_SUCCESS     = 2
+	USSD_STATE_FAILED      = 3
+	USSD_STATE_NO_RESPONSE = 4
+)
+
+// USSD_TIMEOUT is the time SendUSSD and ReplyUSSD wait for the answer when the context has no deadline
+const USSD_TIMEOUT = 30 * time.Second
+
+// USSDPollInterval is the interval between two GetUSSDSendResult calls
+var USSDPollInterval = time.Second
+
+// USSD send result
+type USSDSendResult struct {
+	UssdType       int
+	SendState      int
+	UssdContent    string
+	UssdContentLen int
+}
+
+// USSDReply is the network answer to a USSD code or reply
+type USSDReply struct {
+	Text string
+	// SessionOpen is true when the network wait for a reply, see ReplyUSSD and CancelUSSD
+	SessionOpen bool
+}
+
+// USSDError is a USSD request the network didn't answer
+type USSDError struct {
+	State int
+}
+
+func (err *USSDError) Error() string {
+	if err.State == USSD_STATE_NO_RESPONSE {
+		return "USSD failed: no response from the network"
+	}
+	return fmt.Sprintf("USSD failed: state %d", err.State)
+}
+
+// SendUSSD send a USSD code, like *#123#, and wait for the answer until ctx is done, or
+// USSD_TIMEOUT if it has no deadline. If the session stays open, ReplyUSSD or CancelUSSD must be
+// called before another code is sent
+func (modem *Modem) SendUSSD(ctx context.Context, code string) (*USSDReply, error) {
+	return modem.ussd(ctx, USSD_TYPE_NEW, code)
+}
+
+// ReplyUSSD answer an open USSD session, usually with a menu choice
+func (modem *Modem) ReplyUSSD(ctx context.Context, text string) (*USSDReply, error) {
+	return modem.ussd(ctx, USSD_TYPE_REPLY, text)
+}
+
+// CancelUSSD end the USSD session
+func (modem *Modem) CancelUSSD() error {
+	modem.ussdMutex.Lock()
+	defer modem.ussdMutex.Unlock()
+	return modem.call("SetUSSDEnd", nil, nil)
+}
+
+// GetUSSDSendResult get the status and answer of the last USSD request
+func (modem *Modem) GetUSSDSendResult() (*USSDSendResult, error) {
+	var ussdSendResult USSDSendResult
+	err := modem.call("GetUSSDSendResult", nil, &ussdSendResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ussdSendResult, nil
+}
+
+// ussd send a USSD request and poll GetUSSDSendResult until it is answered or failed
+func (modem *Modem) ussd(ctx context.Context, ussdType int, content string) (*USSDReply, error) {
+	if content == "" {
+		return nil, fmt.Errorf("empty USSD request")
+	}
+
+	// the timeout start once the previous request is done
+	modem.ussdMutex.Lock()
+	defer modem.ussdMutex.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); ok == false {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, USSD_TIMEOUT)
+		defer cancel()
+	}
+
+	err := modem.call("SendUSSD", map[string]interface{}{
+		"UssdContent": content,
+		"UssdType":    ussdType,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// right after SendUSSD the result may still be the previous request one
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(USSDPollInterval):
+		}
+
+		result, err := modem.GetUSSDSendResult()
+		if err != nil {
+			return nil, err
+		}
+		switch result.SendState {
+		case USSD_STATE_SUCCESS:
+			return &USSDReply{Text: result.UssdContent, SessionOpen: result.UssdType == USSD_TYPE_REPLY}, nil
+		case USSD_STATE_NONE, USSD_STATE_SENDING:
+		default:
+			return nil, &USSDError{State: result.SendState}
+		}
+	}
+}
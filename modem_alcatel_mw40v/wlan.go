@@ -0,0 +1,73 @@
+package modem_alcatel_mw40v
+
+// Access point modes, as reported in WlanAPMode
+const (
+	WLAN_AP_MODE_2_4GHZ = 0
+	WLAN_AP_MODE_5GHZ   = 1
+	WLAN_AP_MODE_DUAL   = 2
+)
+
+// Security modes of an access point
+const (
+	WLAN_SECURITY_DISABLED = 0
+	WLAN_SECURITY_WEP      = 1
+	WLAN_SECURITY_WPA      = 2
+	WLAN_SECURITY_WPA2     = 3
+	WLAN_SECURITY_WPA_WPA2 = 4
+)
+
+// WPA encryption types
+const (
+	WPA_TYPE_TKIP = 0
+	WPA_TYPE_AES  = 1
+	WPA_TYPE_AUTO = 2
+)
+
+// Wlan settings, AP2G and AP5G are the access points of each band, the active ones depend on
+// WlanAPMode. Models without 5GHz only report AP2G
+type WlanSettings struct {
+	WlanAPMode int
+	AP2G       WlanAP
+	AP5G       *WlanAP `json:",omitempty"`
+}
+
+// WlanAP is an access point. Channel is 0 for the automatic selection, CurChannel is the channel
+// in use and MaxNumsta the maximum number of clients
+type WlanAP struct {
+	ApStatus     int
+	WMode        int
+	Ssid         string
+	SsidHidden   int
+	Channel      int
+	CurChannel   int
+	SecurityMode int
+	WpaType      int
+	WpaKey       string
+	WepType      int
+	WepKey       string
+	ApIsolation  int
+	MaxNumsta    int `json:"max_numsta"`
+	CurrNum      int `json:"curr_num"`
+	CountryCode  string
+}
+
+// GetWlanSettings get the access points settings
+func (modem *Modem) GetWlanSettings() (*WlanSettings, error) {
+	var wlanSettings WlanSettings
+	err := modem.call("GetWlanSettings", nil, &wlanSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wlanSettings, nil
+}
+
+// SetWlanSettings replace the access points settings, the Wi-Fi restart and the clients reconnect
+func (modem *Modem) SetWlanSettings(settings *WlanSettings) error {
+	return modem.call("SetWlanSettings", settings, nil)
+}
+
+// SetWPSPbc start the WPS push button pairing, open for 2 minutes
+func (modem *Modem) SetWPSPbc() error {
+	return modem.call("SetWPSPbc", nil, nil)
+}
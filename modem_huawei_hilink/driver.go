@@ -0,0 +1,155 @@
+package modem_huawei_hilink
+
+import (
+	"strconv"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// DRIVER is the device driver name of the Huawei HiLink modems
+const DRIVER = "hilink"
+
+func init() {
+	device.Register(DRIVER, Open)
+}
+
+// Driver expose a Modem as a device.Device
+type Driver struct {
+	*Modem
+}
+
+// Open connect to a modem and login if a password is set
+func Open(url string, options device.Options) (device.Device, error) {
+	modem := New(url)
+	if options.Password != "" {
+		username := options.Username
+		if username == "" {
+			username = "admin"
+		}
+		err := modem.Login(username, options.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Driver{Modem: modem}, nil
+}
+
+func (driver *Driver) Driver() string {
+	return DRIVER
+}
+
+func (driver *Driver) SystemInfo() (*device.SystemInfo, error) {
+	deviceInformation, err := driver.GetDeviceInformation()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.SystemInfo{
+		Vendor:          "Huawei",
+		Model:           deviceInformation.DeviceName,
+		DeviceName:      deviceInformation.DeviceName,
+		SoftwareVersion: deviceInformation.SoftwareVersion,
+		HardwareVersion: deviceInformation.HardwareVersion,
+		MacAddress:      deviceInformation.MacAddress1,
+		IMEI:            deviceInformation.Imei,
+		IMSI:            deviceInformation.Imsi,
+		ICCID:           deviceInformation.Iccid,
+	}, nil
+}
+
+func (driver *Driver) SystemStatus() (*device.SystemStatus, error) {
+	monitoringStatus, err := driver.GetMonitoringStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &device.SystemStatus{
+		Roaming:           monitoringStatus.RoamingStatus != 0,
+		SignalStrength:    monitoringStatus.SignalIcon,
+		CurrentConnection: monitoringStatus.CurrentWifiUser,
+		TotalConnection:   monitoringStatus.TotalWifiUser,
+	}
+	// home routers like the B315 have empty battery fields
+	capacity, err := strconv.ParseFloat(monitoringStatus.BatteryPercent, 64)
+	if err == nil {
+		level, _ := strconv.ParseFloat(monitoringStatus.BatteryLevel, 64)
+		status.Battery = &device.Battery{Capacity: capacity, Level: level}
+	}
+	return status, nil
+}
+
+func (driver *Driver) ConnectionState() (*device.ConnectionState, error) {
+	monitoringStatus, err := driver.GetMonitoringStatus()
+	if err != nil {
+		return nil, err
+	}
+	trafficStatistics, err := driver.GetTrafficStatistics()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.ConnectionState{
+		ConnectionStatus: connectionStatus(monitoringStatus.ConnectionStatus),
+		IPv4Address:      monitoringStatus.WanIPAddress,
+		IPv6Address:      monitoringStatus.WanIPv6Address,
+		SpeedDownload:    trafficStatistics.CurrentDownloadRate,
+		SpeedUpload:      trafficStatistics.CurrentUploadRate,
+		ConnectionTime:   trafficStatistics.CurrentConnectTime,
+		DownloadBytes:    trafficStatistics.CurrentDownload,
+		UploadBytes:      trafficStatistics.CurrentUpload,
+	}, nil
+}
+
+func (driver *Driver) Signal() (*device.Signal, error) {
+	signal, err := driver.GetSignal()
+	if err != nil {
+		return nil, err
+	}
+	monitoringStatus, err := driver.GetMonitoringStatus()
+	if err != nil {
+		return nil, err
+	}
+	currentPLMN, err := driver.GetCurrentPLMN()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.Signal{
+		NetworkType: NetworkTypes[monitoringStatus.CurrentNetworkType],
+		NetworkName: currentPLMN.ShortName,
+		Strength:    monitoringStatus.SignalIcon,
+		RSSI:        device.ParseSignal(signal.Rssi),
+		RSRP:        device.ParseSignal(signal.Rsrp),
+		RSRQ:        device.ParseSignal(signal.Rsrq),
+		SINR:        device.ParseSignal(signal.Sinr),
+		CellId:      signal.CellId,
+	}, nil
+}
+
+func (driver *Driver) SMSStorageState() (*device.SMSStorageState, error) {
+	smsCount, err := driver.GetSMSCount()
+	if err != nil {
+		return nil, err
+	}
+
+	used := smsCount.LocalInbox + smsCount.LocalOutbox + smsCount.LocalDraft
+	return &device.SMSStorageState{
+		UnreadSMSCount: smsCount.LocalUnread + smsCount.SimUnread,
+		UsedCount:      used,
+		MaxCount:       smsCount.LocalMax,
+		LeftCount:      smsCount.LocalMax - used,
+	}, nil
+}
+
+// connectionStatus map a HiLink connection status to the device values
+func connectionStatus(status float64) float64 {
+	switch status {
+	case CONNECTION_STATUS_CONNECTING:
+		return device.CONNECTING
+	case CONNECTION_STATUS_CONNECTED:
+		return device.CONNECTED
+	case CONNECTION_STATUS_DISCONNECTING:
+		return device.DISCONNECTING
+	}
+	return device.DISCONNECTED
+}
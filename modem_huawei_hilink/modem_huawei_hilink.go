@@ -0,0 +1,457 @@
+// Package modem_huawei_hilink is a client for the HiLink XML API of Huawei modems and routers,
+// like the E5785 and B315
+package modem_huawei_hilink
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// HTTP_TIMEOUT in second
+const HTTP_TIMEOUT = 10
+
+// Error codes
+const (
+	ERROR_NOT_SUPPORTED   = "100002"
+	ERROR_NO_RIGHT        = "100003"
+	ERROR_WRONG_TOKEN     = "125002"
+	ERROR_WRONG_SESSION   = "125003"
+	ERROR_WRONG_PASSWORD  = "108006"
+	ERROR_ALREADY_LOGGED  = "108003"
+	ERROR_LOGIN_TOO_OFTEN = "108007"
+)
+
+// Connection status values, as reported by /api/monitoring/status
+const (
+	CONNECTION_STATUS_CONNECTING    = 900
+	CONNECTION_STATUS_CONNECTED     = 901
+	CONNECTION_STATUS_DISCONNECTED  = 902
+	CONNECTION_STATUS_DISCONNECTING = 903
+)
+
+type Modem struct {
+	Url string
+
+	mutex     sync.Mutex
+	sessionId string
+	token     string
+	username  string
+	password  string
+}
+
+// Error is an error returned by the modem
+type Error struct {
+	XMLName xml.Name `xml:"error"`
+	Code    string   `xml:"code"`
+	Message string   `xml:"message"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("modem error %s: %s", err.Code, err.Message)
+}
+
+// sessionExpired return true if the session or the token must be renewed
+func (err *Error) sessionExpired() bool {
+	return err.Code == ERROR_WRONG_TOKEN || err.Code == ERROR_WRONG_SESSION || err.Code == ERROR_NO_RIGHT
+}
+
+// Session and token
+type SesTokInfo struct {
+	SesInfo string `xml:"SesInfo"`
+	TokInfo string `xml:"TokInfo"`
+}
+
+// Device information
+type DeviceInformation struct {
+	DeviceName      string `xml:"DeviceName"`
+	SerialNumber    string `xml:"SerialNumber"`
+	Imei            string `xml:"Imei"`
+	Imsi            string `xml:"Imsi"`
+	Iccid           string `xml:"Iccid"`
+	Msisdn          string `xml:"Msisdn"`
+	HardwareVersion string `xml:"HardwareVersion"`
+	SoftwareVersion string `xml:"SoftwareVersion"`
+	WebUIVersion    string `xml:"WebUIVersion"`
+	MacAddress1     string `xml:"MacAddress1"`
+	MacAddress2     string `xml:"MacAddress2"`
+	ProductFamily   string `xml:"ProductFamily"`
+	Classify        string `xml:"Classify"`
+}
+
+// Signal, values are strings with units like "-95dBm"
+type Signal struct {
+	Pci    string `xml:"pci"`
+	CellId string `xml:"cell_id"`
+	Rssi   string `xml:"rssi"`
+	Rsrp   string `xml:"rsrp"`
+	Rsrq   string `xml:"rsrq"`
+	Sinr   string `xml:"sinr"`
+	Mode   string `xml:"mode"`
+	Band   string `xml:"band"`
+}
+
+// Monitoring status
+type MonitoringStatus struct {
+	ConnectionStatus   float64 `xml:"ConnectionStatus"`
+	SignalIcon         float64 `xml:"SignalIcon"`
+	CurrentNetworkType float64 `xml:"CurrentNetworkType"`
+	RoamingStatus      float64 `xml:"RoamingStatus"`
+	BatteryStatus      string  `xml:"BatteryStatus"`
+	BatteryLevel       string  `xml:"BatteryLevel"`
+	BatteryPercent     string  `xml:"BatteryPercent"`
+	WanIPAddress       string  `xml:"WanIPAddress"`
+	WanIPv6Address     string  `xml:"WanIPv6Address"`
+	CurrentWifiUser    float64 `xml:"CurrentWifiUser"`
+	TotalWifiUser      float64 `xml:"TotalWifiUser"`
+}
+
+// Traffic statistics, in bytes, bytes per second and seconds
+type TrafficStatistics struct {
+	CurrentConnectTime  float64 `xml:"CurrentConnectTime"`
+	CurrentUpload       float64 `xml:"CurrentUpload"`
+	CurrentDownload     float64 `xml:"CurrentDownload"`
+	CurrentDownloadRate float64 `xml:"CurrentDownloadRate"`
+	CurrentUploadRate   float64 `xml:"CurrentUploadRate"`
+	TotalUpload         float64 `xml:"TotalUpload"`
+	TotalDownload       float64 `xml:"TotalDownload"`
+	TotalConnectTime    float64 `xml:"TotalConnectTime"`
+}
+
+// SMS count
+type SMSCount struct {
+	LocalUnread  float64 `xml:"LocalUnread"`
+	LocalInbox   float64 `xml:"LocalInbox"`
+	LocalOutbox  float64 `xml:"LocalOutbox"`
+	LocalDraft   float64 `xml:"LocalDraft"`
+	LocalDeleted float64 `xml:"LocalDeleted"`
+	SimUnread    float64 `xml:"SimUnread"`
+	SimInbox     float64 `xml:"SimInbox"`
+	SimOutbox    float64 `xml:"SimOutbox"`
+	SimDraft     float64 `xml:"SimDraft"`
+	LocalMax     float64 `xml:"LocalMax"`
+	SimMax       float64 `xml:"SimMax"`
+	NewMsg       float64 `xml:"NewMsg"`
+}
+
+// Current network operator
+type CurrentPLMN struct {
+	State     string `xml:"State"`
+	FullName  string `xml:"FullName"`
+	ShortName string `xml:"ShortName"`
+	Numeric   string `xml:"Numeric"`
+	Rat       string `xml:"Rat"`
+}
+
+// Network types, as reported by CurrentNetworkType
+var NetworkTypes = map[float64]string{
+	0:    "No service",
+	1:    "GSM",
+	2:    "GPRS",
+	3:    "EDGE",
+	4:    "WCDMA",
+	5:    "HSDPA",
+	6:    "HSUPA",
+	7:    "HSPA",
+	9:    "HSPA+",
+	19:   "LTE",
+	101:  "LTE",
+	1011: "LTE+",
+}
+
+func New(url string) *Modem {
+	return &Modem{Url: strings.TrimSuffix(url, "/")}
+}
+
+// GetSesTokInfo get a new session id and request verification token
+func (modem *Modem) GetSesTokInfo() (*SesTokInfo, error) {
+	var sesTokInfo SesTokInfo
+	body, err := modem.request("GET", "/api/webserver/SesTokInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = decode(body, &sesTokInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &sesTokInfo, nil
+}
+
+// Login open an authenticated session, needed by some firmwares for every API
+func (modem *Modem) Login(username string, password string) error {
+	err := modem.renewSession()
+	if err != nil {
+		return err
+	}
+
+	modem.mutex.Lock()
+	token := modem.token
+	modem.mutex.Unlock()
+
+	request := struct {
+		XMLName      xml.Name `xml:"request"`
+		Username     string   `xml:"Username"`
+		Password     string   `xml:"Password"`
+		PasswordType int      `xml:"password_type"`
+	}{Username: username, Password: hashPassword(username, password, token), PasswordType: 4}
+
+	// no retry, retry would login again
+	content, err := encode(request)
+	if err != nil {
+		return err
+	}
+	body, err := modem.request("POST", "/api/user/login", content)
+	if err == nil {
+		err = decode(body, nil)
+	}
+	if err != nil {
+		if hilinkErr, ok := err.(*Error); ok == false || hilinkErr.Code != ERROR_ALREADY_LOGGED {
+			return err
+		}
+	}
+
+	modem.mutex.Lock()
+	modem.username = username
+	modem.password = password
+	modem.mutex.Unlock()
+	return nil
+}
+
+// GetDeviceInformation get the modem identification
+func (modem *Modem) GetDeviceInformation() (*DeviceInformation, error) {
+	var deviceInformation DeviceInformation
+	err := modem.get("/api/device/information", &deviceInformation)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceInformation, nil
+}
+
+// GetSignal get the radio signal quality
+func (modem *Modem) GetSignal() (*Signal, error) {
+	var signal Signal
+	err := modem.get("/api/device/signal", &signal)
+	if err != nil {
+		return nil, err
+	}
+	return &signal, nil
+}
+
+// GetMonitoringStatus get the connection, battery and Wi-Fi clients state
+func (modem *Modem) GetMonitoringStatus() (*MonitoringStatus, error) {
+	var monitoringStatus MonitoringStatus
+	err := modem.get("/api/monitoring/status", &monitoringStatus)
+	if err != nil {
+		return nil, err
+	}
+	return &monitoringStatus, nil
+}
+
+// GetTrafficStatistics get the byte counters of the current and all connections
+func (modem *Modem) GetTrafficStatistics() (*TrafficStatistics, error) {
+	var trafficStatistics TrafficStatistics
+	err := modem.get("/api/monitoring/traffic-statistics", &trafficStatistics)
+	if err != nil {
+		return nil, err
+	}
+	return &trafficStatistics, nil
+}
+
+// GetSMSCount get the SMS storage usage
+func (modem *Modem) GetSMSCount() (*SMSCount, error) {
+	var smsCount SMSCount
+	err := modem.get("/api/sms/sms-count", &smsCount)
+	if err != nil {
+		return nil, err
+	}
+	return &smsCount, nil
+}
+
+// GetCurrentPLMN get the network operator
+func (modem *Modem) GetCurrentPLMN() (*CurrentPLMN, error) {
+	var currentPLMN CurrentPLMN
+	err := modem.get("/api/net/current-plmn", &currentPLMN)
+	if err != nil {
+		return nil, err
+	}
+	return &currentPLMN, nil
+}
+
+// get read an API, the session is renewed once if expired
+func (modem *Modem) get(path string, result interface{}) error {
+	return modem.retry(func() error {
+		body, err := modem.request("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		log.Debugf("[%s] Body: %+s\n", path, string(body))
+		err = decode(body, result)
+		if hilinkErr, ok := err.(*Error); ok && hilinkErr.Code == ERROR_NOT_SUPPORTED {
+			return device.ErrNotSupported
+		}
+		return err
+	})
+}
+
+// post write an API, the session is renewed once if expired
+func (modem *Modem) post(path string, request interface{}, result interface{}) error {
+	content, err := encode(request)
+	if err != nil {
+		return err
+	}
+
+	return modem.retry(func() error {
+		body, err := modem.request("POST", path, content)
+		if err != nil {
+			return err
+		}
+		log.Debugf("[%s] Body: %+s\n", path, string(body))
+		return decode(body, result)
+	})
+}
+
+func (modem *Modem) retry(do func() error) error {
+	modem.mutex.Lock()
+	hasSession := modem.sessionId != ""
+	modem.mutex.Unlock()
+	if hasSession == false {
+		err := modem.renewSession()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := do()
+	if hilinkErr, ok := err.(*Error); ok && hilinkErr.sessionExpired() {
+		log.Debugf("session expired: %s", hilinkErr)
+		err = modem.renewSession()
+		if err != nil {
+			return err
+		}
+		modem.mutex.Lock()
+		username, password := modem.username, modem.password
+		modem.mutex.Unlock()
+		if password != "" {
+			err = modem.Login(username, password)
+			if err != nil {
+				return err
+			}
+		}
+		err = do()
+	}
+	return err
+}
+
+// renewSession get a new session id and token
+func (modem *Modem) renewSession() error {
+	modem.mutex.Lock()
+	modem.sessionId = ""
+	modem.token = ""
+	modem.mutex.Unlock()
+
+	sesTokInfo, err := modem.GetSesTokInfo()
+	if err != nil {
+		return err
+	}
+
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.sessionId = strings.TrimPrefix(sesTokInfo.SesInfo, "SessionID=")
+	modem.token = sesTokInfo.TokInfo
+	return nil
+}
+
+// request send an HTTP request with the session cookie and token, and keep the token sent back
+func (modem *Modem) request(method string, path string, content []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, modem.Url+path, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	modem.mutex.Lock()
+	if modem.sessionId != "" {
+		req.AddCookie(&http.Cookie{Name: "SessionID", Value: modem.sessionId})
+	}
+	if modem.token != "" {
+		req.Header.Set("__RequestVerificationToken", modem.token)
+	}
+	modem.mutex.Unlock()
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	}
+
+	client := &http.Client{
+		Timeout: HTTP_TIMEOUT * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	modem.mutex.Lock()
+	// tokens are single use for POST, the next one is sent back in headers
+	if token := resp.Header.Get("__RequestVerificationToken"); token != "" {
+		modem.token = strings.Split(token, "#")[0]
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "SessionID" {
+			modem.sessionId = cookie.Value
+		}
+	}
+	modem.mutex.Unlock()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// encode a <request> document
+func encode(request interface{}) ([]byte, error) {
+	content, err := xml.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// decode a <response> document, or return the <error>
+func decode(body []byte, result interface{}) error {
+	trimmed := bytes.TrimSpace(body)
+	if bytes.Contains(trimmed, []byte("<error>")) {
+		var hilinkErr Error
+		err := xml.Unmarshal(trimmed, &hilinkErr)
+		if err != nil {
+			return err
+		}
+		return &hilinkErr
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(trimmed, result)
+}
+
+// hashPassword is the password_type 4 hash:
+// base64(sha256(username + base64(sha256(password)) + token)), sha256 in lower case hexadecimal
+func hashPassword(username string, password string, token string) string {
+	passwordHash := sha256.Sum256([]byte(password))
+	encoded := base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(passwordHash[:])))
+	hash := sha256.Sum256([]byte(username + encoded + token))
+	return base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(hash[:])))
+}
@@ -0,0 +1,178 @@
+package modem_huawei_hilink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+const testSessionId = "9VgKpzd8T7Ok6WdfsvYjh0YQhBrXwXzLRSTJPAmUNLylsVfS4DsiWSYE6X4whzNMWxhyUtF0yR7zNy5JmpNJ35eaXlJOUyrpqgmvJvXUyhyoUXhuvNmQ2M0ehLkIQzgm"
+
+// runTestServer serve the testdata files by API path, API calls must send the session cookie
+func runTestServer(t *testing.T, files map[string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.URL.Path]
+		if ok == false {
+			t.Logf("Unexpected URL: %s", r.URL)
+			t.Fail()
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/api/webserver/SesTokInfo" {
+			cookie, err := r.Cookie("SessionID")
+			if err != nil || cookie.Value != testSessionId {
+				t.Logf("Expected session cookie on %s", r.URL)
+				t.Fail()
+			}
+		}
+		http.ServeFile(w, r, file)
+	}))
+	return ts
+}
+
+var testFiles = map[string]string{
+	"/api/webserver/SesTokInfo":          "testdata/sesTokInfo.xml",
+	"/api/device/information":            "testdata/deviceInformation.xml",
+	"/api/device/signal":                 "testdata/signal.xml",
+	"/api/monitoring/status":             "testdata/monitoringStatus.xml",
+	"/api/monitoring/traffic-statistics": "testdata/trafficStatistics.xml",
+	"/api/sms/sms-count":                 "testdata/smsCount.xml",
+	"/api/net/current-plmn":              "testdata/currentPlmn.xml",
+}
+
+func TestGetDeviceInformation(t *testing.T) {
+	ts := runTestServer(t, testFiles)
+	defer ts.Close()
+
+	deviceInformation, err := New(ts.URL).GetDeviceInformation()
+	if err != nil {
+		t.Fatalf("[TestGetDeviceInformation] Error: %s", err)
+	}
+	if deviceInformation.DeviceName != "E5785Lh-22c" {
+		t.Logf("Expected device name: E5785Lh-22c, got: %s", deviceInformation.DeviceName)
+		t.Fail()
+	}
+	if deviceInformation.Imei != "861234567890123" {
+		t.Logf("Expected IMEI: 861234567890123, got: %s", deviceInformation.Imei)
+		t.Fail()
+	}
+}
+
+func TestDriver(t *testing.T) {
+	ts := runTestServer(t, testFiles)
+	defer ts.Close()
+
+	modem, err := device.Open(DRIVER, ts.URL, device.Options{})
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+
+	systemStatus, err := modem.SystemStatus()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if systemStatus.Battery == nil || systemStatus.Battery.Capacity != 78 {
+		t.Logf("Expected battery capacity: 78, got: %+v", systemStatus.Battery)
+		t.Fail()
+	}
+	if systemStatus.CurrentConnection != 3 {
+		t.Logf("Expected current connection: 3, got: %f", systemStatus.CurrentConnection)
+		t.Fail()
+	}
+
+	connectionState, err := modem.ConnectionState()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if connectionState.ConnectionStatus != device.CONNECTED {
+		t.Logf("Expected connection status: %d, got: %f", device.CONNECTED, connectionState.ConnectionStatus)
+		t.Fail()
+	}
+	if connectionState.DownloadBytes != 98765432 {
+		t.Logf("Expected download bytes: 98765432, got: %f", connectionState.DownloadBytes)
+		t.Fail()
+	}
+
+	signal, err := modem.Signal()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if signal.RSRP != -97 || signal.RSRQ != -11 || signal.NetworkType != "LTE" || signal.NetworkName != "NOS" {
+		t.Logf("Unexpected signal: %+v", signal)
+		t.Fail()
+	}
+
+	smsStorageState, err := modem.SMSStorageState()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if smsStorageState.UnreadSMSCount != 2 || smsStorageState.LeftCount != 492 {
+		t.Logf("Unexpected SMS storage state: %+v", smsStorageState)
+		t.Fail()
+	}
+}
+
+func TestSessionRenewal(t *testing.T) {
+	sessions := 0
+	expired := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/webserver/SesTokInfo":
+			sessions++
+			http.ServeFile(w, r, "testdata/sesTokInfo.xml")
+		case "/api/device/signal":
+			if expired {
+				expired = false
+				http.ServeFile(w, r, "testdata/errorWrongToken.xml")
+				return
+			}
+			http.ServeFile(w, r, "testdata/signal.xml")
+		}
+	}))
+	defer ts.Close()
+
+	signal, err := New(ts.URL).GetSignal()
+	if err != nil {
+		t.Fatalf("[TestSessionRenewal] Error: %s", err)
+	}
+	if signal.Rsrp != "-97dBm" {
+		t.Logf("Expected rsrp: -97dBm, got: %s", signal.Rsrp)
+		t.Fail()
+	}
+	if sessions != 2 {
+		t.Logf("Expected 2 sessions, got: %d", sessions)
+		t.Fail()
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/webserver/SesTokInfo":
+			http.ServeFile(w, r, "testdata/sesTokInfo.xml")
+		case "/api/user/login":
+			body, _ := ioutil.ReadAll(r.Body)
+			expected := hashPassword("admin", "secret", "ZLzd0CTlUgvpQs2PR6KwG3hfgc3wfgEe")
+			if r.Header.Get("__RequestVerificationToken") != "ZLzd0CTlUgvpQs2PR6KwG3hfgc3wfgEe" || strings.Contains(string(body), expected) == false {
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><error><code>108006</code><message></message></error>`))
+				return
+			}
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><response>OK</response>`))
+		}
+	}))
+	defer ts.Close()
+
+	err := New(ts.URL).Login("admin", "secret")
+	if err != nil {
+		t.Fatalf("[TestLogin] Error: %s", err)
+	}
+	err = New(ts.URL).Login("admin", "wrong")
+	if hilinkErr, ok := err.(*Error); ok == false || hilinkErr.Code != ERROR_WRONG_PASSWORD {
+		t.Logf("Expected wrong password error, got: %v", err)
+		t.Fail()
+	}
+}
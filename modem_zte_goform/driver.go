@@ -0,0 +1,150 @@
+package modem_zte_goform
+
+import (
+	"math"
+	"strings"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// DRIVER is the device driver name of the ZTE goform modems
+const DRIVER = "zte"
+
+func init() {
+	device.Register(DRIVER, Open)
+}
+
+// Driver expose a Modem as a device.Device
+type Driver struct {
+	*Modem
+}
+
+// Open connect to a modem and login if a password is set
+func Open(url string, options device.Options) (device.Device, error) {
+	modem := New(url)
+	if options.Password != "" {
+		err := modem.Login(options.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Driver{Modem: modem}, nil
+}
+
+func (driver *Driver) Driver() string {
+	return DRIVER
+}
+
+func (driver *Driver) SystemInfo() (*device.SystemInfo, error) {
+	deviceInfo, err := driver.GetDeviceInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.SystemInfo{
+		Vendor:          "ZTE",
+		Model:           deviceInfo.ModelName,
+		DeviceName:      deviceInfo.ModelName,
+		SoftwareVersion: deviceInfo.InnerVersion,
+		HardwareVersion: deviceInfo.HardwareVersion,
+		MacAddress:      deviceInfo.MacAddress,
+		IMEI:            deviceInfo.IMEI,
+		IMSI:            deviceInfo.IMSI,
+		ICCID:           deviceInfo.ICCID,
+	}, nil
+}
+
+func (driver *Driver) SystemStatus() (*device.SystemStatus, error) {
+	connectionInfo, err := driver.GetConnectionInfo()
+	if err != nil {
+		return nil, err
+	}
+	networkInfo, err := driver.GetNetworkInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &device.SystemStatus{
+		Roaming:           strings.EqualFold(networkInfo.Roaming, "Internal") || strings.EqualFold(networkInfo.Roaming, "Roaming"),
+		SignalStrength:    parseFloat(networkInfo.SignalBar),
+		CurrentConnection: parseFloat(connectionInfo.StationCount),
+		TotalConnection:   parseFloat(connectionInfo.StationCount),
+	}
+	// routers without battery send empty battery fields
+	if strings.TrimSpace(connectionInfo.BatteryPercent) != "" {
+		status.Battery = &device.Battery{
+			Capacity: parseFloat(connectionInfo.BatteryPercent),
+			Level:    parseFloat(connectionInfo.BatteryLevel),
+		}
+	}
+	return status, nil
+}
+
+func (driver *Driver) ConnectionState() (*device.ConnectionState, error) {
+	connectionInfo, err := driver.GetConnectionInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &device.ConnectionState{
+		ConnectionStatus: connectionStatus(connectionInfo.PPPStatus),
+		IPv4Address:      connectionInfo.WanIPAddress,
+		IPv6Address:      connectionInfo.IPv6WanIPAddress,
+		SpeedDownload:    parseFloat(connectionInfo.RealtimeRxThrpt),
+		SpeedUpload:      parseFloat(connectionInfo.RealtimeTxThrpt),
+		ConnectionTime:   parseFloat(connectionInfo.RealtimeTime),
+		DownloadBytes:    parseFloat(connectionInfo.RealtimeRxBytes),
+		UploadBytes:      parseFloat(connectionInfo.RealtimeTxBytes),
+	}, nil
+}
+
+func (driver *Driver) Signal() (*device.Signal, error) {
+	networkInfo, err := driver.GetNetworkInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	rssi := device.ParseSignal(networkInfo.LteRSSI)
+	if math.IsNaN(rssi) {
+		rssi = device.ParseSignal(networkInfo.RSSI)
+	}
+	return &device.Signal{
+		NetworkType: networkInfo.NetworkType,
+		NetworkName: networkInfo.NetworkProvider,
+		Strength:    parseFloat(networkInfo.SignalBar),
+		RSSI:        rssi,
+		RSRP:        device.ParseSignal(networkInfo.LteRSRP),
+		RSRQ:        device.ParseSignal(networkInfo.LteRSRQ),
+		SINR:        device.ParseSignal(networkInfo.LteSNR),
+		CellId:      networkInfo.CellId,
+	}, nil
+}
+
+func (driver *Driver) SMSStorageState() (*device.SMSStorageState, error) {
+	smsInfo, err := driver.GetSMSInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	max := parseFloat(smsInfo.NvTotal)
+	used := parseFloat(smsInfo.NvUsed)
+	return &device.SMSStorageState{
+		UnreadSMSCount: parseFloat(smsInfo.UnreadCount),
+		UsedCount:      used,
+		MaxCount:       max,
+		LeftCount:      max - used,
+	}, nil
+}
+
+// connectionStatus map a ppp_status to the device values
+func connectionStatus(status string) float64 {
+	switch status {
+	case PPP_CONNECTING:
+		return device.CONNECTING
+	case PPP_CONNECTED:
+		return device.CONNECTED
+	case PPP_DISCONNECTING:
+		return device.DISCONNECTING
+	}
+	return device.DISCONNECTED
+}
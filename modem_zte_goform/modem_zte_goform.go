@@ -0,0 +1,373 @@
+// Package modem_zte_goform is a client for the goform API of ZTE MF-series modems and routers
+package modem_zte_goform
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTP_TIMEOUT in second
+const HTTP_TIMEOUT = 10
+
+// Login results
+const (
+	LOGIN_OK             = "0"
+	LOGIN_DUPLICATE_USER = "1"
+	LOGIN_WRONG_PASSWORD = "3"
+	LOGIN_LOCKED         = "5"
+)
+
+// RESULT_FAILURE is the result of the requests refused, when the session expired among others
+const RESULT_FAILURE = "failure"
+
+// PPP status values, as reported by ppp_status
+const (
+	PPP_CONNECTED     = "ppp_connected"
+	PPP_CONNECTING    = "ppp_connecting"
+	PPP_DISCONNECTED  = "ppp_disconnected"
+	PPP_DISCONNECTING = "ppp_disconnecting"
+)
+
+type Modem struct {
+	Url string
+
+	mutex    sync.Mutex
+	cookies  []*http.Cookie
+	password string
+}
+
+// Error is a failed set command, or a refused read
+type Error struct {
+	GoformId string
+	Result   string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("modem error on %s: result %s", err.GoformId, err.Result)
+}
+
+// Device information
+type DeviceInfo struct {
+	InnerVersion    string `json:"wa_inner_version"`
+	CrVersion       string `json:"cr_version"`
+	HardwareVersion string `json:"hardware_version"`
+	ModelName       string `json:"model_name"`
+	IMEI            string `json:"imei"`
+	IMSI            string `json:"sim_imsi"`
+	ICCID           string `json:"iccid"`
+	MacAddress      string `json:"mac_address"`
+}
+
+// DeviceInfoFields are the multi_data fields of DeviceInfo
+var DeviceInfoFields = []string{"wa_inner_version", "cr_version", "hardware_version", "model_name", "imei", "sim_imsi", "iccid", "mac_address"}
+
+// Network and signal, values are strings like "-95"
+type NetworkInfo struct {
+	NetworkType     string `json:"network_type"`
+	NetworkProvider string `json:"network_provider"`
+	SignalBar       string `json:"signalbar"`
+	RSSI            string `json:"rssi"`
+	LteRSRP         string `json:"lte_rsrp"`
+	LteRSRQ         string `json:"lte_rsrq"`
+	LteSNR          string `json:"lte_snr"`
+	LteRSSI         string `json:"lte_rssi"`
+	CellId          string `json:"cell_id"`
+	Roaming         string `json:"simcard_roam"`
+}
+
+// NetworkInfoFields are the multi_data fields of NetworkInfo
+var NetworkInfoFields = []string{"network_type", "network_provider", "signalbar", "rssi", "lte_rsrp", "lte_rsrq", "lte_snr", "lte_rssi", "cell_id", "simcard_roam"}
+
+// Connection state and traffic, bytes, bytes per second and seconds
+type ConnectionInfo struct {
+	PPPStatus        string `json:"ppp_status"`
+	WanIPAddress     string `json:"wan_ipaddr"`
+	IPv6WanIPAddress string `json:"ipv6_wan_ipaddr"`
+	RealtimeTxBytes  string `json:"realtime_tx_bytes"`
+	RealtimeRxBytes  string `json:"realtime_rx_bytes"`
+	RealtimeTime     string `json:"realtime_time"`
+	RealtimeTxThrpt  string `json:"realtime_tx_thrpt"`
+	RealtimeRxThrpt  string `json:"realtime_rx_thrpt"`
+	MonthlyTxBytes   string `json:"monthly_tx_bytes"`
+	MonthlyRxBytes   string `json:"monthly_rx_bytes"`
+	StationCount     string `json:"sta_count"`
+	BatteryPercent   string `json:"battery_vol_percent"`
+	BatteryLevel     string `json:"battery_pers"`
+}
+
+// ConnectionInfoFields are the multi_data fields of ConnectionInfo
+var ConnectionInfoFields = []string{"ppp_status", "wan_ipaddr", "ipv6_wan_ipaddr", "realtime_tx_bytes", "realtime_rx_bytes", "realtime_time", "realtime_tx_thrpt", "realtime_rx_thrpt", "monthly_tx_bytes", "monthly_rx_bytes", "sta_count", "battery_vol_percent", "battery_pers"}
+
+// SMS storage
+type SMSInfo struct {
+	UnreadCount    string `json:"sms_unread_num"`
+	DevUnreadCount string `json:"sms_dev_unread_num"`
+	NvTotal        string `json:"sms_nv_total"`
+	NvUsed         string `json:"sms_nvused_total"`
+	SimTotal       string `json:"sms_sim_total"`
+}
+
+// SMSInfoFields are the multi_data fields of SMSInfo
+var SMSInfoFields = []string{"sms_unread_num", "sms_dev_unread_num", "sms_nv_total", "sms_nvused_total", "sms_sim_total"}
+
+func New(url string) *Modem {
+	return &Modem{Url: strings.TrimSuffix(url, "/")}
+}
+
+// Get read fields with goform_get_cmd_process, several fields are read with multi_data
+func (modem *Modem) Get(fields ...string) (map[string]string, error) {
+	var result map[string]string
+	err := modem.read(fields, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetDeviceInfo get the modem identification
+func (modem *Modem) GetDeviceInfo() (*DeviceInfo, error) {
+	var deviceInfo DeviceInfo
+	err := modem.read(DeviceInfoFields, &deviceInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &deviceInfo, nil
+}
+
+// GetNetworkInfo get the network operator and signal quality
+func (modem *Modem) GetNetworkInfo() (*NetworkInfo, error) {
+	var networkInfo NetworkInfo
+	err := modem.read(NetworkInfoFields, &networkInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &networkInfo, nil
+}
+
+// GetConnectionInfo get the connection state, traffic, clients and battery
+func (modem *Modem) GetConnectionInfo() (*ConnectionInfo, error) {
+	var connectionInfo ConnectionInfo
+	err := modem.read(ConnectionInfoFields, &connectionInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &connectionInfo, nil
+}
+
+// GetSMSInfo get the SMS storage usage
+func (modem *Modem) GetSMSInfo() (*SMSInfo, error) {
+	var smsInfo SMSInfo
+	err := modem.read(SMSInfoFields, &smsInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &smsInfo, nil
+}
+
+// Login open an authenticated session, renewed with the same password once expired. Firmwares
+// sending a LD salt expect SHA256(SHA256(password) + LD) in upper case hexadecimal, older ones the
+// password in base64
+func (modem *Modem) Login(password string) error {
+	params := url.Values{}
+
+	// no retry, retry would login again
+	var ld map[string]string
+	err := modem.get([]string{"LD"}, &ld)
+	if err == nil && ld["LD"] != "" {
+		params.Set("password", strings.ToUpper(sha256Hex(strings.ToUpper(sha256Hex(password))+ld["LD"])))
+		err = modem.signedSet("LOGIN", params)
+	} else {
+		params.Set("password", base64.StdEncoding.EncodeToString([]byte(password)))
+		err = modem.set("LOGIN", params)
+	}
+	if err != nil {
+		return err
+	}
+
+	modem.mutex.Lock()
+	modem.password = password
+	modem.mutex.Unlock()
+	return nil
+}
+
+// Set run a command with goform_set_cmd_process, signed with the AD parameter on firmwares needing it
+func (modem *Modem) Set(goformId string, params url.Values) error {
+	return modem.retry(func() error {
+		return modem.signedSet(goformId, params)
+	})
+}
+
+func (modem *Modem) signedSet(goformId string, params url.Values) error {
+	ad, err := modem.ad()
+	if err != nil {
+		return err
+	}
+	if ad != "" {
+		params.Set("AD", ad)
+	}
+	return modem.set(goformId, params)
+}
+
+// ad compute the AD parameter: MD5(MD5(wa_inner_version + cr_version) + RD), empty if not needed
+func (modem *Modem) ad() (string, error) {
+	var versions, rd map[string]string
+	err := modem.get([]string{"wa_inner_version", "cr_version"}, &versions)
+	if err != nil {
+		return "", err
+	}
+	err = modem.get([]string{"RD"}, &rd)
+	if err != nil || rd["RD"] == "" {
+		return "", nil
+	}
+	return md5Hex(md5Hex(versions["wa_inner_version"]+versions["cr_version"]) + rd["RD"]), nil
+}
+
+func (modem *Modem) set(goformId string, params url.Values) error {
+	params.Set("isTest", "false")
+	params.Set("goformId", goformId)
+
+	body, err := modem.request("POST", "/goform/goform_set_cmd_process", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	log.Debugf("[%s] Body: %+s\n", goformId, string(body))
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+	if result.Result != "success" && result.Result != LOGIN_OK {
+		return &Error{GoformId: goformId, Result: result.Result}
+	}
+	return nil
+}
+
+// read read fields into result, the session is renewed once if expired
+func (modem *Modem) read(fields []string, result interface{}) error {
+	return modem.retry(func() error {
+		return modem.get(fields, result)
+	})
+}
+
+// retry run do again after a new login if the modem refused it, once logged in with a password
+func (modem *Modem) retry(do func() error) error {
+	err := do()
+	if zteErr, ok := err.(*Error); ok && zteErr.Result == RESULT_FAILURE {
+		modem.mutex.Lock()
+		password := modem.password
+		modem.mutex.Unlock()
+		if password == "" {
+			return err
+		}
+		log.Debugf("session expired: %s", zteErr)
+		err = modem.Login(password)
+		if err != nil {
+			return err
+		}
+		err = do()
+	}
+	return err
+}
+
+// get read fields into result
+func (modem *Modem) get(fields []string, result interface{}) error {
+	query := url.Values{}
+	query.Set("isTest", "false")
+	query.Set("cmd", strings.Join(fields, ","))
+	if len(fields) > 1 {
+		query.Set("multi_data", "1")
+	}
+	query.Set("_", strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+
+	body, err := modem.request("GET", "/goform/goform_get_cmd_process?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	log.Debugf("[%s] Body: %+s\n", query.Get("cmd"), string(body))
+
+	// the fields needing a session are refused once it expired
+	var refused struct {
+		Result string `json:"result"`
+	}
+	if json.Unmarshal(body, &refused) == nil && refused.Result == RESULT_FAILURE {
+		return &Error{GoformId: query.Get("cmd"), Result: refused.Result}
+	}
+	return json.Unmarshal(body, result)
+}
+
+// request send an HTTP request, goform needs the Referer header and keep the session in cookies
+func (modem *Modem) request(method string, path string, body *strings.Reader) ([]byte, error) {
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequest(method, modem.Url+path, nil)
+	} else {
+		req, err = http.NewRequest(method, modem.Url+path, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", modem.Url+"/index.html")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+	modem.mutex.Lock()
+	for _, cookie := range modem.cookies {
+		req.AddCookie(cookie)
+	}
+	modem.mutex.Unlock()
+
+	client := &http.Client{
+		Timeout: HTTP_TIMEOUT * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		modem.mutex.Lock()
+		modem.cookies = cookies
+		modem.mutex.Unlock()
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func sha256Hex(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func md5Hex(value string) string {
+	hash := md5.Sum([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// parseFloat parse a goform value, 0 if empty
+func parseFloat(value string) float64 {
+	number, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return number
+}
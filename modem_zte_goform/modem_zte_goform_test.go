@@ -0,0 +1,189 @@
+package modem_zte_goform
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// runTestServer serve the testdata file matching the first field of the cmd parameter
+func runTestServer(t *testing.T) *httptest.Server {
+	files := map[string]string{
+		"wa_inner_version": "testdata/deviceInfo.json",
+		"network_type":     "testdata/networkInfo.json",
+		"ppp_status":       "testdata/connectionInfo.json",
+		"sms_unread_num":   "testdata/smsInfo.json",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/goform/goform_get_cmd_process" {
+			t.Logf("Unexpected URL: %s", r.URL)
+			t.Fail()
+		}
+		if strings.HasSuffix(r.Header.Get("Referer"), "/index.html") == false {
+			t.Logf("Expected Referer header, got: %s", r.Header.Get("Referer"))
+			t.Fail()
+		}
+		cmd := strings.Split(r.URL.Query().Get("cmd"), ",")
+		if len(cmd) > 1 && r.URL.Query().Get("multi_data") != "1" {
+			t.Logf("Expected multi_data for %s", cmd)
+			t.Fail()
+		}
+		http.ServeFile(w, r, files[cmd[0]])
+	}))
+	return ts
+}
+
+func TestGetDeviceInfo(t *testing.T) {
+	ts := runTestServer(t)
+	defer ts.Close()
+
+	deviceInfo, err := New(ts.URL).GetDeviceInfo()
+	if err != nil {
+		t.Fatalf("[TestGetDeviceInfo] Error: %s", err)
+	}
+	if deviceInfo.ModelName != "MF971RS" {
+		t.Logf("Expected model name: MF971RS, got: %s", deviceInfo.ModelName)
+		t.Fail()
+	}
+	if deviceInfo.IMEI != "866123456789012" {
+		t.Logf("Expected IMEI: 866123456789012, got: %s", deviceInfo.IMEI)
+		t.Fail()
+	}
+}
+
+func TestDriver(t *testing.T) {
+	ts := runTestServer(t)
+	defer ts.Close()
+
+	modem, err := device.Open(DRIVER, ts.URL, device.Options{})
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+
+	systemStatus, err := modem.SystemStatus()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if systemStatus.Battery == nil || systemStatus.Battery.Capacity != 64 || systemStatus.CurrentConnection != 2 {
+		t.Logf("Unexpected system status: %+v", systemStatus)
+		t.Fail()
+	}
+
+	connectionState, err := modem.ConnectionState()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if connectionState.ConnectionStatus != device.CONNECTED || connectionState.DownloadBytes != 23456789 {
+		t.Logf("Unexpected connection state: %+v", connectionState)
+		t.Fail()
+	}
+
+	signal, err := modem.Signal()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if signal.RSRP != -101 || signal.SINR != 6.4 || signal.RSSI != -71 || signal.NetworkName != "NOS" {
+		t.Logf("Unexpected signal: %+v", signal)
+		t.Fail()
+	}
+
+	smsStorageState, err := modem.SMSStorageState()
+	if err != nil {
+		t.Fatalf("[TestDriver] Error: %s", err)
+	}
+	if smsStorageState.UnreadSMSCount != 1 || smsStorageState.LeftCount != 88 {
+		t.Logf("Unexpected SMS storage state: %+v", smsStorageState)
+		t.Fail()
+	}
+}
+
+func TestLogin(t *testing.T) {
+	const ld = "2B8F3E8C9A1D4F6E"
+	expected := strings.ToUpper(sha256Hex(strings.ToUpper(sha256Hex("secret")) + ld))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/goform/goform_get_cmd_process":
+			switch r.URL.Query().Get("cmd") {
+			case "LD":
+				fmt.Fprintf(w, `{"LD":"%s"}`, ld)
+			case "RD":
+				fmt.Fprint(w, `{"RD":"A1B2C3"}`)
+			default:
+				fmt.Fprint(w, `{"wa_inner_version":"BD_MF971RSV1.0.0B05","cr_version":"CR_ZTEMF971RSV1.0.0B03"}`)
+			}
+		case "/goform/goform_set_cmd_process":
+			r.ParseForm()
+			expectedAD := md5Hex(md5Hex("BD_MF971RSV1.0.0B05CR_ZTEMF971RSV1.0.0B03") + "A1B2C3")
+			if r.PostForm.Get("goformId") != "LOGIN" || r.PostForm.Get("password") != expected || r.PostForm.Get("AD") != expectedAD {
+				fmt.Fprint(w, `{"result":"3"}`)
+				return
+			}
+			fmt.Fprint(w, `{"result":"0"}`)
+		}
+	}))
+	defer ts.Close()
+
+	err := New(ts.URL).Login("secret")
+	if err != nil {
+		t.Fatalf("[TestLogin] Error: %s", err)
+	}
+	err = New(ts.URL).Login("wrong")
+	if zteErr, ok := err.(*Error); ok == false || zteErr.Result != LOGIN_WRONG_PASSWORD {
+		t.Logf("Expected wrong password error, got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestSessionRenewal(t *testing.T) {
+	logins := 0
+	loggedIn := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/goform/goform_get_cmd_process":
+			switch cmd := r.URL.Query().Get("cmd"); {
+			case cmd == "LD" || cmd == "RD":
+				fmt.Fprintf(w, `{"%s":""}`, cmd)
+			case loggedIn == false:
+				fmt.Fprint(w, `{"result":"failure"}`)
+			default:
+				http.ServeFile(w, r, "testdata/networkInfo.json")
+			}
+		case "/goform/goform_set_cmd_process":
+			logins++
+			loggedIn = true
+			fmt.Fprint(w, `{"result":"0"}`)
+		}
+	}))
+	defer ts.Close()
+
+	modem := New(ts.URL)
+	_, err := modem.GetNetworkInfo()
+	if zteErr, ok := err.(*Error); ok == false || zteErr.Result != RESULT_FAILURE {
+		t.Logf("Expected failure without password, got: %v", err)
+		t.Fail()
+	}
+
+	err = modem.Login("secret")
+	if err != nil {
+		t.Fatalf("[TestSessionRenewal] Error: %s", err)
+	}
+	loggedIn = false
+	networkInfo, err := modem.GetNetworkInfo()
+	if err != nil {
+		t.Fatalf("[TestSessionRenewal] Error: %s", err)
+	}
+	if networkInfo.NetworkProvider != "NOS" {
+		t.Logf("Expected network provider: NOS, got: %s", networkInfo.NetworkProvider)
+		t.Fail()
+	}
+	if logins != 2 {
+		t.Logf("Expected 2 logins, got: %d", logins)
+		t.Fail()
+	}
+}
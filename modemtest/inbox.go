@@ -0,0 +1,61 @@
+package modemtest
+
+import (
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// Inbox is a device.Device reading its received and sent messages from memory, to test the SMS
+// pollers without a fake modem. Only the device.SMSReader and device.SentSMSReader methods are
+// supported
+type Inbox struct {
+	Messages []device.ReceivedSMS
+	Sent     []device.ReceivedSMS
+	// Deleted are the ids of the messages deleted, the messages are kept
+	Deleted []int
+}
+
+func (inbox *Inbox) Driver() string {
+	return "inbox"
+}
+
+func (inbox *Inbox) SystemInfo() (*device.SystemInfo, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (inbox *Inbox) SystemStatus() (*device.SystemStatus, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (inbox *Inbox) ConnectionState() (*device.ConnectionState, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (inbox *Inbox) Signal() (*device.Signal, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (inbox *Inbox) SMSStorageState() (*device.SMSStorageState, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (inbox *Inbox) ReceivedSMS() ([]device.ReceivedSMS, error) {
+	return inbox.Messages, nil
+}
+
+func (inbox *Inbox) MarkReceivedSMSRead(message device.ReceivedSMS) error {
+	return nil
+}
+
+func (inbox *Inbox) DeleteReceivedSMS(message device.ReceivedSMS) error {
+	inbox.Deleted = append(inbox.Deleted, message.Id)
+	return nil
+}
+
+func (inbox *Inbox) StoredSentSMS() ([]device.ReceivedSMS, error) {
+	return inbox.Sent, nil
+}
+
+func (inbox *Inbox) DeleteStoredSentSMS(message device.ReceivedSMS) error {
+	inbox.Deleted = append(inbox.Deleted, message.Id)
+	return nil
+}
@@ -0,0 +1,780 @@
+// Package modemtest provide a stateful fake MW40V jrd/webapi, to test code built on
+// modem_alcatel_mw40v without hardware.
+//
+//	server := modemtest.NewServer()
+//	defer server.Close()
+//	modem := modem_alcatel_mw40v.New(server.URL)
+package modemtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+// Error codes returned by the fake modem
+const (
+	ERROR_METHOD_NOT_FOUND = "-32601"
+	ERROR_NOT_AUTHORIZED   = "-32699"
+	ERROR_LOGIN_FAILED     = "010101"
+)
+
+// SMS_PAGE_SIZE is the number of contacts or messages per page of the SMS lists
+const SMS_PAGE_SIZE = 10
+
+// SMS is a message stored in the fake modem. Type is 0 for received messages, SMS_TYPE_SENT or
+// SMS_TYPE_REPORT otherwise
+type SMS struct {
+	Id        int
+	ContactId int
+	Type      int
+	Number    string
+	Content   string
+	Time      time.Time
+	Read      bool
+}
+
+// Modem is a fake MW40V. Exported fields can be changed before the first request, the state is then
+// changed through methods
+type Modem struct {
+	SystemInfo modem_alcatel_mw40v.SystemInfo
+	Username   string
+	Password   string
+	// SessionTimeout is the inactivity time after which a login session expire
+	SessionTimeout time.Duration
+	// DownloadRate and UploadRate are the bytes per second added to the counters while connected
+	DownloadRate float64
+	UploadRate   float64
+	// SMSMaxCount is the SMS storage capacity
+	SMSMaxCount int
+	// USSD are the answers to the USSD codes, indexed by code. The answer to a reply is indexed
+	// by the code and replies joined by ">", like "*100#>1", a session stays open while there are
+	// answers to its replies. The other codes are not answered
+	USSD map[string]string
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex         sync.Mutex
+	token         string
+	lastActivity  time.Time
+	tokens        int
+	connected     bool
+	connectedAt   time.Time
+	downloadBytes float64
+	uploadBytes   float64
+	clients       int
+	devices       []modem_alcatel_mw40v.ConnectedDevice
+	macFilter     modem_alcatel_mw40v.MacFilterSettings
+	forwarding    modem_alcatel_mw40v.PortForwardingSettings
+	dmz           modem_alcatel_mw40v.DMZSettings
+	firewall      modem_alcatel_mw40v.FirewallSettings
+	upnp          modem_alcatel_mw40v.UPnPSettings
+	lan           modem_alcatel_mw40v.LanSettings
+	inbox         []SMS
+	nextSMSId     int
+	contacts      map[string]int
+	sendStatus    int
+	sendPolls     int
+	sendFailure   int
+	wlanState     int
+	wlanSettings  modem_alcatel_mw40v.WlanSettings
+	reboots       int
+	ussdSession   string
+	ussdResult    modem_alcatel_mw40v.USSDSendResult
+	errors        map[string]*modem_alcatel_mw40v.RPCError
+	httpStatus    map[string]int
+	calls         map[string]int
+}
+
+// NewModem return a connected fake modem with the identifiers of testdata/getSystemInfo.json
+func NewModem() *Modem {
+	return &Modem{
+		SystemInfo: modem_alcatel_mw40v.SystemInfo{
+			SoftwareVersion: "MW40_E6_02.00_05",
+			HardwareVersion: "MW40-V-V1.0",
+			DeviceName:      "MW40",
+			MacAddress:      "c4:43:13:c5:12:34",
+			IMEI:            "123456789012345",
+			IMSI:            "987654321098765",
+			ICCID:           "0123456789012345678p",
+		},
+		Username:       "admin",
+		Password:       "admin",
+		SessionTimeout: 5 * time.Minute,
+		DownloadRate:   1000000,
+		UploadRate:     100000,
+		SMSMaxCount:    100,
+		Now:            time.Now,
+		connected:      true,
+		clients:        1,
+		wlanState:      modem_alcatel_mw40v.WLAN_STATE_ON,
+		nextSMSId:      1,
+		contacts:       map[string]int{},
+		USSD:           map[string]string{},
+		errors:         map[string]*modem_alcatel_mw40v.RPCError{},
+		httpStatus:     map[string]int{},
+		calls:          map[string]int{},
+		devices: []modem_alcatel_mw40v.ConnectedDevice{
+			{Id: 1, DeviceName: "laptop", MacAddress: "A0:B1:C2:D3:E4:F5", IPAddress: "192.168.1.100", ConnectMode: modem_alcatel_mw40v.CONNECT_MODE_WIFI},
+		},
+		wlanSettings: modem_alcatel_mw40v.WlanSettings{
+			WlanAPMode: modem_alcatel_mw40v.WLAN_AP_MODE_2_4GHZ,
+			AP2G: modem_alcatel_mw40v.WlanAP{
+				ApStatus:     1,
+				WMode:        3,
+				Ssid:         "MW40V_1234",
+				CurChannel:   6,
+				SecurityMode: modem_alcatel_mw40v.WLAN_SECURITY_WPA2,
+				WpaType:      modem_alcatel_mw40v.WPA_TYPE_AES,
+				WpaKey:       "12345678",
+				MaxNumsta:    15,
+				CurrNum:      1,
+				CountryCode:  "PT",
+			},
+		},
+		forwarding: modem_alcatel_mw40v.PortForwardingSettings{PortForwardingList: []modem_alcatel_mw40v.PortForwardingRule{}},
+		firewall:   modem_alcatel_mw40v.FirewallSettings{FirewallStatus: modem_alcatel_mw40v.STATUS_ENABLED},
+		upnp:       modem_alcatel_mw40v.UPnPSettings{UpnpStatus: modem_alcatel_mw40v.STATUS_ENABLED},
+		lan: modem_alcatel_mw40v.LanSettings{
+			IPv4IPAddress:    "192.168.1.1",
+			SubnetMask:       "255.255.255.0",
+			DHCPServerStatus: modem_alcatel_mw40v.STATUS_ENABLED,
+			StartIPAddress:   "192.168.1.100",
+			EndIPAddress:     "192.168.1.200",
+			DHCPLeaseTime:    12,
+			DNSMode:          modem_alcatel_mw40v.DNS_MODE_AUTO,
+		},
+	}
+}
+
+// Server is a fake modem served by an httptest.Server
+type Server struct {
+	*httptest.Server
+	Modem *Modem
+}
+
+// NewServer start a server for a new fake modem, the caller should call Close when finished
+func NewServer() *Server {
+	return NewServerFor(NewModem())
+}
+
+// NewServerFor start a server for the given fake modem
+func NewServerFor(modem *Modem) *Server {
+	return &Server{Server: httptest.NewServer(modem), Modem: modem}
+}
+
+// SetError make every call to method fail with an error, nil remove the error
+func (modem *Modem) SetError(method string, err *modem_alcatel_mw40v.RPCError) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	if err == nil {
+		delete(modem.errors, method)
+		return
+	}
+	modem.errors[method] = err
+}
+
+// SetHTTPStatus make every call to method answer with an HTTP status, 0 remove the status
+func (modem *Modem) SetHTTPStatus(method string, status int) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	if status == 0 {
+		delete(modem.httpStatus, method)
+		return
+	}
+	modem.httpStatus[method] = status
+}
+
+// Calls return the number of calls to method
+func (modem *Modem) Calls(method string) int {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.calls[method]
+}
+
+// SetConnected connect or disconnect the mobile data
+func (modem *Modem) SetConnected(connected bool) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.setConnected(connected)
+}
+
+// Connected return the mobile data state
+func (modem *Modem) Connected() bool {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.connected
+}
+
+// SetClients set the number of connected Wi-Fi/USB clients
+func (modem *Modem) SetClients(clients int) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.clients = clients
+}
+
+// SetConnectedDevices set the connected device list, and the number of clients
+func (modem *Modem) SetConnectedDevices(devices []modem_alcatel_mw40v.ConnectedDevice) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.devices = devices
+	modem.clients = len(devices)
+}
+
+// MacFilter return the Wi-Fi MAC filter
+func (modem *Modem) MacFilter() modem_alcatel_mw40v.MacFilterSettings {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.macFilter
+}
+
+// PortForwarding return the port forwarding rules
+func (modem *Modem) PortForwarding() []modem_alcatel_mw40v.PortForwardingRule {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.forwarding.PortForwardingList
+}
+
+// SetPortForwarding replace the port forwarding rules
+func (modem *Modem) SetPortForwarding(rules []modem_alcatel_mw40v.PortForwardingRule) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.forwarding.PortForwardingList = rules
+}
+
+// Firewall return the firewall, UPnP and DMZ settings
+func (modem *Modem) Firewall() (modem_alcatel_mw40v.FirewallSettings, modem_alcatel_mw40v.UPnPSettings, modem_alcatel_mw40v.DMZSettings) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.firewall, modem.upnp, modem.dmz
+}
+
+// LanSettings return the LAN settings
+func (modem *Modem) LanSettings() modem_alcatel_mw40v.LanSettings {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.lan
+}
+
+// WiFi return true if the Wi-Fi is on
+func (modem *Modem) WiFi() bool {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.wlanState == modem_alcatel_mw40v.WLAN_STATE_ON
+}
+
+// WlanSettings return the access points settings
+func (modem *Modem) WlanSettings() modem_alcatel_mw40v.WlanSettings {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.wlanSettings
+}
+
+// SetWlanSettings replace the access points settings, like a dual band model with AP5G set
+func (modem *Modem) SetWlanSettings(settings modem_alcatel_mw40v.WlanSettings) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.wlanSettings = settings
+}
+
+// Reboots return the number of reboots requested
+func (modem *Modem) Reboots() int {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.reboots
+}
+
+// ExpireSession invalidate the current login session
+func (modem *Modem) ExpireSession() {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.token = ""
+}
+
+// Deliver store an incoming unread SMS, return false if the storage is full
+func (modem *Modem) Deliver(number string, content string) bool {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	if len(modem.inbox) >= modem.SMSMaxCount {
+		return false
+	}
+	modem.inbox = append(modem.inbox, SMS{Id: modem.nextSMSId, ContactId: modem.contactId(number), Number: number, Content: content, Time: modem.Now()})
+	modem.nextSMSId++
+	return true
+}
+
+// DeliverReport store a delivery report for the last message sent to number
+func (modem *Modem) DeliverReport(number string) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.inbox = append(modem.inbox, SMS{Id: modem.nextSMSId, ContactId: modem.contactId(number), Type: modem_alcatel_mw40v.SMS_TYPE_REPORT, Number: number, Content: "Delivered", Time: modem.Now(), Read: true})
+	modem.nextSMSId++
+}
+
+// SetSendFailure make the next messages sent fail with a GetSendSMSResult status, 0 to succeed
+func (modem *Modem) SetSendFailure(status int) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.sendFailure = status
+}
+
+// contactId return the contact of a phone number, created on the first message
+func (modem *Modem) contactId(number string) int {
+	id, ok := modem.contacts[number]
+	if ok == false {
+		id = len(modem.contacts) + 1
+		modem.contacts[number] = id
+	}
+	return id
+}
+
+// Inbox return a copy of the stored SMS
+func (modem *Modem) Inbox() []SMS {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return append([]SMS(nil), modem.inbox...)
+}
+
+func (modem *Modem) setConnected(connected bool) {
+	if connected == modem.connected {
+		return
+	}
+	if connected {
+		modem.connectedAt = modem.Now()
+	} else {
+		modem.downloadBytes, modem.uploadBytes = modem.counters()
+	}
+	modem.connected = connected
+}
+
+// counters return the byte counters at the current time
+func (modem *Modem) counters() (float64, float64) {
+	if modem.connected == false {
+		return modem.downloadBytes, modem.uploadBytes
+	}
+	elapsed := modem.Now().Sub(modem.connectedAt).Seconds()
+	return modem.downloadBytes + elapsed*modem.DownloadRate, modem.uploadBytes + elapsed*modem.UploadRate
+}
+
+func (modem *Modem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request modem_alcatel_mw40v.Request
+
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &request)
+	if err != nil || request.Method == "" {
+		request.Method = r.URL.Query().Get("api")
+	}
+
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+
+	modem.calls[request.Method]++
+	if status, ok := modem.httpStatus[request.Method]; ok {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.Id}
+	result, rpcErr := modem.handle(request, r.Header.Get("_TclRequestVerificationToken"))
+	if err, ok := modem.errors[request.Method]; ok {
+		rpcErr = err
+	}
+	if rpcErr != nil {
+		response["error"] = rpcErr
+	} else {
+		response["result"] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handle run a method, called with the mutex locked
+func (modem *Modem) handle(request modem_alcatel_mw40v.Request, token string) (interface{}, *modem_alcatel_mw40v.RPCError) {
+	method, ok := modem_alcatel_mw40v.Methods[request.Method]
+	if ok == false {
+		return nil, &modem_alcatel_mw40v.RPCError{Code: ERROR_METHOD_NOT_FOUND, Message: "Method not found"}
+	}
+
+	now := modem.Now()
+	if modem.connected && modem.connectedAt.IsZero() {
+		modem.connectedAt = now
+	}
+	if modem.token != "" && now.Sub(modem.lastActivity) > modem.SessionTimeout {
+		modem.token = ""
+	}
+	if method.Login {
+		if modem.token == "" || token != modem.token {
+			return nil, &modem_alcatel_mw40v.RPCError{Code: ERROR_NOT_AUTHORIZED, Message: "Request is not authorized"}
+		}
+		modem.lastActivity = now
+	}
+
+	params := map[string]interface{}{}
+	json.Unmarshal(paramsJSON(request.Params), &params)
+
+	switch request.Method {
+	case "Login":
+		return modem.login(params)
+	case "Logout":
+		modem.token = ""
+		return struct{}{}, nil
+	case "GetLoginState":
+		state := 0
+		if modem.token != "" {
+			state = 1
+		}
+		return modem_alcatel_mw40v.LoginState{State: float64(state)}, nil
+	case "HeartBeat":
+		return struct{}{}, nil
+	case "GetSystemInfo":
+		return modem.SystemInfo, nil
+	case "GetSystemStatus":
+		status := modem_alcatel_mw40v.SystemStatus{
+			BatteryCapacity:   100,
+			BatteryLevel:      4,
+			SignalStrength:    4,
+			CurrentConnection: float64(modem.clients),
+			TotalConnection:   float64(modem.clients),
+		}
+		return status, nil
+	case "GetConnectionState":
+		return modem.connectionState(), nil
+	case "GetNetworkInfo":
+		return modem_alcatel_mw40v.NetworkInfo{
+			PLMN:           "26803",
+			NetworkType:    8,
+			NetworkName:    "NOS",
+			Roaming:        1,
+			SignalStrength: 4,
+			RSSI:           "-65",
+			RSRP:           "-95",
+			RSRQ:           "-10",
+			SINR:           "12",
+		}, nil
+	case "Connect":
+		modem.setConnected(true)
+		return struct{}{}, nil
+	case "DisConnect":
+		modem.setConnected(false)
+		return struct{}{}, nil
+	case "SetDeviceReboot":
+		// the session doesn't survive the reboot, the fake modem is up again at once
+		modem.reboots++
+		modem.token = ""
+		return struct{}{}, nil
+	case "GetWlanState":
+		return modem_alcatel_mw40v.WlanState{WlanState: modem.wlanState}, nil
+	case "SetWlanState":
+		modem.wlanState = intParam(params, "WlanState")
+		return struct{}{}, nil
+	case "GetWlanSettings":
+		return modem.wlanSettings, nil
+	case "SetWlanSettings":
+		var settings modem_alcatel_mw40v.WlanSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		// the channel in use follow a fixed channel
+		if settings.AP2G.Channel != 0 {
+			settings.AP2G.CurChannel = settings.AP2G.Channel
+		}
+		if settings.AP5G != nil && settings.AP5G.Channel != 0 {
+			settings.AP5G.CurChannel = settings.AP5G.Channel
+		}
+		modem.wlanSettings = settings
+		return struct{}{}, nil
+	case "SetWPSPbc":
+		return struct{}{}, nil
+	case "GetSMSContactList":
+		return modem.smsContactList(intParam(params, "Page")), nil
+	case "GetSMSContentList":
+		return modem.smsContentList(intParam(params, "ContactId"), intParam(params, "Page")), nil
+	case "DeleteSMS":
+		modem.deleteSMS(intParam(params, "DelFlag"), intParam(params, "ContactId"), intParam(params, "SMSId"))
+		return struct{}{}, nil
+	case "SendSMS":
+		return modem.sendSMS(params)
+	case "GetSendSMSResult":
+		// the message is sending during the first poll
+		if modem.sendStatus == modem_alcatel_mw40v.SEND_STATUS_SENDING && modem.sendPolls > 0 {
+			modem.sendPolls--
+		} else if modem.sendStatus == modem_alcatel_mw40v.SEND_STATUS_SENDING {
+			modem.sendStatus = modem_alcatel_mw40v.SEND_STATUS_SUCCESS
+			if modem.sendFailure != 0 {
+				modem.sendStatus = modem.sendFailure
+			}
+		}
+		return modem_alcatel_mw40v.SendSMSResult{SendStatus: modem.sendStatus}, nil
+	case "GetConnectedDeviceList":
+		devices := append([]modem_alcatel_mw40v.ConnectedDevice{}, modem.devices...)
+		return modem_alcatel_mw40v.ConnectedDeviceList{ConnectedList: devices}, nil
+	case "GetMacFilterSettings":
+		return modem.macFilter, nil
+	case "SetMacFilterSettings":
+		var settings modem_alcatel_mw40v.MacFilterSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		modem.macFilter = settings
+		return struct{}{}, nil
+	case "GetPortForwardingSettings":
+		return modem.forwarding, nil
+	case "SetPortForwardingSettings":
+		var settings modem_alcatel_mw40v.PortForwardingSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		modem.forwarding = settings
+		return struct{}{}, nil
+	case "GetDMZSettings":
+		return modem.dmz, nil
+	case "SetDMZSettings":
+		var settings modem_alcatel_mw40v.DMZSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		modem.dmz = settings
+		return struct{}{}, nil
+	case "GetFirewallSettings":
+		return modem.firewall, nil
+	case "SetFirewallSettings":
+		var settings modem_alcatel_mw40v.FirewallSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		modem.firewall = settings
+		return struct{}{}, nil
+	case "GetUPnPSettings":
+		return modem.upnp, nil
+	case "SetUPnPSettings":
+		modem.upnp = modem_alcatel_mw40v.UPnPSettings{UpnpStatus: intParam(params, "UpnpStatus")}
+		return struct{}{}, nil
+	case "GetLanSettings":
+		return modem.lan, nil
+	case "SetLanSettings":
+		var settings modem_alcatel_mw40v.LanSettings
+		json.Unmarshal(paramsJSON(request.Params), &settings)
+		modem.lan = settings
+		return struct{}{}, nil
+	case "SendUSSD":
+		content, _ := params["UssdContent"].(string)
+		modem.sendUSSD(intParam(params, "UssdType"), content)
+		return struct{}{}, nil
+	case "GetUSSDSendResult":
+		return modem.ussdResult, nil
+	case "SetUSSDEnd":
+		modem.ussdSession = ""
+		modem.ussdResult = modem_alcatel_mw40v.USSDSendResult{}
+		return struct{}{}, nil
+	case "GetSMSStorageState":
+		unread := 0
+		for _, sms := range modem.inbox {
+			if sms.Type == 0 && sms.Read == false {
+				unread++
+			}
+		}
+		return modem_alcatel_mw40v.SMSStorageState{
+			LeftCount:      float64(modem.SMSMaxCount - len(modem.inbox)),
+			MaxCount:       float64(modem.SMSMaxCount),
+			TUseCount:      float64(len(modem.inbox)),
+			UnreadSMSCount: float64(unread),
+		}, nil
+	}
+	return nil, &modem_alcatel_mw40v.RPCError{Code: ERROR_METHOD_NOT_FOUND, Message: "Method not found"}
+}
+
+// sendUSSD answer a USSD code or a reply to the open session
+func (modem *Modem) sendUSSD(ussdType int, content string) {
+	session := content
+	if ussdType == modem_alcatel_mw40v.USSD_TYPE_REPLY {
+		session = modem.ussdSession + ">" + content
+	}
+	answer, ok := modem.USSD[session]
+	if ok == false || (ussdType == modem_alcatel_mw40v.USSD_TYPE_REPLY && modem.ussdSession == "") {
+		modem.ussdSession = ""
+		modem.ussdResult = modem_alcatel_mw40v.USSDSendResult{SendState: modem_alcatel_mw40v.USSD_STATE_NO_RESPONSE}
+		return
+	}
+
+	modem.ussdSession = ""
+	resultType := modem_alcatel_mw40v.USSD_TYPE_NEW
+	for key := range modem.USSD {
+		if strings.HasPrefix(key, session+">") {
+			modem.ussdSession = session
+			resultType = modem_alcatel_mw40v.USSD_TYPE_REPLY
+		}
+	}
+	modem.ussdResult = modem_alcatel_mw40v.USSDSendResult{
+		UssdType:       resultType,
+		SendState:      modem_alcatel_mw40v.USSD_STATE_SUCCESS,
+		UssdContent:    answer,
+		UssdContentLen: len(answer),
+	}
+}
+
+func (modem *Modem) login(params map[string]interface{}) (interface{}, *modem_alcatel_mw40v.RPCError) {
+	username, _ := params["UserName"].(string)
+	password, _ := params["Password"].(string)
+	if decrypt(username) != modem.Username || decrypt(password) != modem.Password {
+		return nil, &modem_alcatel_mw40v.RPCError{Code: ERROR_LOGIN_FAILED, Message: "Login failed"}
+	}
+
+	modem.tokens++
+	modem.token = strconv.Itoa(1000 + modem.tokens)
+	modem.lastActivity = modem.Now()
+	return modem_alcatel_mw40v.LoginResult{Token: json.RawMessage(modem.token)}, nil
+}
+
+func (modem *Modem) connectionState() modem_alcatel_mw40v.ConnectionState {
+	downloadBytes, uploadBytes := modem.counters()
+	state := modem_alcatel_mw40v.ConnectionState{
+		ConnectionStatus: modem_alcatel_mw40v.CONNECTION_STATUS_DISCONNECTED,
+		IPv6Address:      "0::0",
+		DownloadRate:     100000000,
+		UploadRate:       50000000,
+		DownloadBytes:    downloadBytes,
+		UploadBytes:      uploadBytes,
+	}
+	if modem.connected {
+		state.ConnectionStatus = modem_alcatel_mw40v.CONNECTION_STATUS_CONNECTED
+		state.IPv4Address = "192.0.2.10"
+		state.SpeedDownload = modem.DownloadRate
+		state.SpeedUpload = modem.UploadRate
+		state.ConnectionTime = modem.Now().Sub(modem.connectedAt).Seconds()
+	}
+	return state
+}
+
+// sendSMS store a sent message per number, the send result is then polled with GetSendSMSResult
+func (modem *Modem) sendSMS(params map[string]interface{}) (interface{}, *modem_alcatel_mw40v.RPCError) {
+	content, _ := params["SMSContent"].(string)
+	numbers, _ := params["PhoneNumber"].([]interface{})
+	if content == "" || len(numbers) == 0 {
+		return nil, &modem_alcatel_mw40v.RPCError{Code: "060601", Message: "Invalid parameters"}
+	}
+	if len(modem.inbox)+len(numbers) > modem.SMSMaxCount {
+		modem.sendStatus = modem_alcatel_mw40v.SEND_STATUS_FAIL_MEMORY_FULL
+		return struct{}{}, nil
+	}
+
+	for _, number := range numbers {
+		number, _ := number.(string)
+		modem.inbox = append(modem.inbox, SMS{Id: modem.nextSMSId, ContactId: modem.contactId(number), Type: modem_alcatel_mw40v.SMS_TYPE_SENT, Number: number, Content: content, Time: modem.Now(), Read: true})
+		modem.nextSMSId++
+	}
+	modem.sendStatus = modem_alcatel_mw40v.SEND_STATUS_SENDING
+	modem.sendPolls = 1
+	return struct{}{}, nil
+}
+
+// smsContactList return a page of the contacts with their last message, most recent first
+func (modem *Modem) smsContactList(page int) modem_alcatel_mw40v.SMSContactList {
+	var contacts []modem_alcatel_mw40v.SMSContact
+	indexes := map[int]int{}
+	for i := len(modem.inbox) - 1; i >= 0; i-- {
+		sms := modem.inbox[i]
+		index, ok := indexes[sms.ContactId]
+		if ok == false {
+			index = len(contacts)
+			indexes[sms.ContactId] = index
+			contacts = append(contacts, modem_alcatel_mw40v.SMSContact{
+				ContactId:   sms.ContactId,
+				PhoneNumber: []string{sms.Number},
+				SMSId:       sms.Id,
+				SMSType:     smsType(sms),
+				SMSTime:     sms.Time.Format(modem_alcatel_mw40v.SMS_TIME_LAYOUT),
+				SMSContent:  sms.Content,
+			})
+		}
+		contacts[index].TSMSCount++
+		if sms.Read == false {
+			contacts[index].UnreadCount++
+		}
+	}
+
+	start, end, total := pageRange(len(contacts), page)
+	return modem_alcatel_mw40v.SMSContactList{SMSContactList: contacts[start:end], Page: page, TotalPageCount: total}
+}
+
+// smsContentList return a page of the messages of a contact, oldest first, and mark them as read
+func (modem *Modem) smsContentList(contactId int, page int) modem_alcatel_mw40v.SMSContentList {
+	var indexes []int
+	var numbers []string
+	for i, sms := range modem.inbox {
+		if sms.ContactId == contactId {
+			indexes = append(indexes, i)
+			numbers = []string{sms.Number}
+		}
+	}
+
+	start, end, total := pageRange(len(indexes), page)
+	list := modem_alcatel_mw40v.SMSContentList{ContactId: contactId, PhoneNumber: numbers, Page: page, TotalPageCount: total}
+	for _, i := range indexes[start:end] {
+		sms := &modem.inbox[i]
+		list.SMSContentList = append(list.SMSContentList, modem_alcatel_mw40v.SMSContent{
+			SMSId:      sms.Id,
+			SMSType:    smsType(*sms),
+			SMSTime:    sms.Time.Format(modem_alcatel_mw40v.SMS_TIME_LAYOUT),
+			SMSContent: sms.Content,
+		})
+		sms.Read = true
+	}
+	return list
+}
+
+func (modem *Modem) deleteSMS(flag int, contactId int, smsId int) {
+	var kept []SMS
+	for _, sms := range modem.inbox {
+		switch {
+		case flag == modem_alcatel_mw40v.SMS_DELETE_ALL:
+		case flag == modem_alcatel_mw40v.SMS_DELETE_CONTACT && sms.ContactId == contactId:
+		case flag == modem_alcatel_mw40v.SMS_DELETE_MESSAGE && sms.Id == smsId:
+		default:
+			kept = append(kept, sms)
+		}
+	}
+	modem.inbox = kept
+}
+
+func smsType(sms SMS) int {
+	if sms.Type != 0 {
+		return sms.Type
+	}
+	if sms.Read {
+		return modem_alcatel_mw40v.SMS_TYPE_READ
+	}
+	return modem_alcatel_mw40v.SMS_TYPE_UNREAD
+}
+
+// pageRange return the bounds of a page and the page count
+func pageRange(count int, page int) (int, int, int) {
+	total := (count + SMS_PAGE_SIZE - 1) / SMS_PAGE_SIZE
+	start := page * SMS_PAGE_SIZE
+	if start > count || start < 0 {
+		start = count
+	}
+	end := start + SMS_PAGE_SIZE
+	if end > count {
+		end = count
+	}
+	return start, end, total
+}
+
+func intParam(params map[string]interface{}, name string) int {
+	value, _ := params[name].(float64)
+	return int(value)
+}
+
+func paramsJSON(params interface{}) []byte {
+	content, _ := json.Marshal(params)
+	return content
+}
+
+// decrypt reverse the web UI credential obfuscation, the low nibble is stored first
+func decrypt(str string) string {
+	const key = "e5dl12XYVggihggafXWf0f2YSf2Xngd1"
+	var decrypted []byte
+	for i := 0; i+1 < len(str); i += 2 {
+		k := key[(i/2)%len(key)] & 0xf
+		decrypted = append(decrypted, ((str[i+1]&0xf)^k)<<4|((str[i]&0xf)^k))
+	}
+	return string(decrypted)
+}
@@ -0,0 +1,643 @@
+package modemtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+func TestSystemInfo(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Modem.SystemInfo.IMEI = "111111111111111"
+
+	systemInfo, err := modem_alcatel_mw40v.New(server.URL).GetSystemInfo()
+	if err != nil {
+		t.Fatalf("[TestSystemInfo] Error: %s", err)
+	}
+	if systemInfo.IMEI != "111111111111111" {
+		t.Logf("Expected IMEI: 111111111111111, got: %s", systemInfo.IMEI)
+		t.Fail()
+	}
+}
+
+func TestLogin(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	modem := modem_alcatel_mw40v.New(server.URL)
+
+	err := modem.Disconnect()
+	if _, ok := err.(*modem_alcatel_mw40v.RPCError); ok == false {
+		t.Logf("Expected a modem error without login, got: %v", err)
+		t.Fail()
+	}
+
+	err = modem.Login("admin", "wrong")
+	if err == nil {
+		t.Logf("Expected a login error with a wrong password")
+		t.Fail()
+	}
+
+	err = modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestLogin] Error: %s", err)
+	}
+	err = modem.Disconnect()
+	if err != nil {
+		t.Fatalf("[TestLogin] Error: %s", err)
+	}
+	if server.Modem.Connected() {
+		t.Logf("Expected modem to be disconnected")
+		t.Fail()
+	}
+
+	server.Modem.ExpireSession()
+	err = modem.Connect()
+	if err != nil {
+		t.Logf("Expected the expired session to be renewed, got: %s", err)
+		t.Fail()
+	}
+	if server.Modem.Calls("Login") != 3 {
+		t.Logf("Expected 3 Login calls, got: %d", server.Modem.Calls("Login"))
+		t.Fail()
+	}
+}
+
+func TestConcurrentLogin(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Modem.Deliver("+351912345678", "hello")
+	opened, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestConcurrentLogin] Error: %s", err)
+	}
+	reader := opened.(device.SMSReader)
+	logins := server.Modem.Calls("Login")
+
+	// the callers sharing an expired session login once
+	server.Modem.ExpireSession()
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := reader.ReceivedSMS()
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Logf("Expected the session renewed, got: %s", err)
+			t.Fail()
+		}
+	}
+	if server.Modem.Calls("Login") != logins+1 {
+		t.Logf("Expected 1 Login call, got: %d", server.Modem.Calls("Login")-logins)
+		t.Fail()
+	}
+}
+
+func TestCounters(t *testing.T) {
+	now := time.Now()
+	fake := NewModem()
+	fake.Now = func() time.Time { return now }
+	server := NewServerFor(fake)
+	defer server.Close()
+	modem := modem_alcatel_mw40v.New(server.URL)
+
+	modem.GetConnectionState()
+	now = now.Add(10 * time.Second)
+	connectionState, err := modem.GetConnectionState()
+	if err != nil {
+		t.Fatalf("[TestCounters] Error: %s", err)
+	}
+	if connectionState.DownloadBytes != 10*fake.DownloadRate {
+		t.Logf("Expected DownloadBytes: %f, got: %f", 10*fake.DownloadRate, connectionState.DownloadBytes)
+		t.Fail()
+	}
+
+	fake.SetConnected(false)
+	now = now.Add(10 * time.Second)
+	connectionState, _ = modem.GetConnectionState()
+	if connectionState.DownloadBytes != 10*fake.DownloadRate || connectionState.ConnectionStatus != modem_alcatel_mw40v.CONNECTION_STATUS_DISCONNECTED {
+		t.Logf("Expected frozen counters while disconnected, got: %+v", connectionState)
+		t.Fail()
+	}
+}
+
+func TestSMSStorage(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Modem.Deliver("+351910000000", "hello")
+	server.Modem.Deliver("+351910000000", "world")
+
+	smsStorageState, err := modem_alcatel_mw40v.New(server.URL).GetSMSStorageState()
+	if err != nil {
+		t.Fatalf("[TestSMSStorage] Error: %s", err)
+	}
+	if smsStorageState.UnreadSMSCount != 2 || smsStorageState.LeftCount != 98 {
+		t.Logf("Expected 2 unread SMS and 98 free slots, got: %+v", smsStorageState)
+		t.Fail()
+	}
+}
+
+func TestSMSInbox(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.Local)
+	server.Modem.Now = func() time.Time { return now }
+	for i := 0; i < 12; i++ {
+		server.Modem.Deliver("+351910000000", fmt.Sprintf("message %d", i))
+		now = now.Add(time.Minute)
+	}
+	server.Modem.Deliver("NOS", "Saldo: 2,00 GB")
+
+	modem := modem_alcatel_mw40v.New(server.URL)
+	err := modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestSMSInbox] Error: %s", err)
+	}
+
+	contacts, err := modem.SMSContacts()
+	if err != nil {
+		t.Fatalf("[TestSMSInbox] Error: %s", err)
+	}
+	if len(contacts) != 2 || contacts[0].PhoneNumber[0] != "NOS" || contacts[1].UnreadCount != 12 {
+		t.Logf("Unexpected contacts: %+v", contacts)
+		t.Fail()
+	}
+
+	thread, err := modem.SMSThread(contacts[1].ContactId)
+	if err != nil {
+		t.Fatalf("[TestSMSInbox] Error: %s", err)
+	}
+	if len(thread) != 12 || thread[11].Content != "message 11" || thread[11].Unread() == false {
+		t.Fatalf("Expected the 12 messages of both pages, got: %+v", thread)
+	}
+
+	smsStorageState, _ := modem.GetSMSStorageState()
+	if smsStorageState.UnreadSMSCount != 1 {
+		t.Logf("Expected the thread marked as read, got: %+v", smsStorageState)
+		t.Fail()
+	}
+
+	err = modem.DeleteSMSMessage(thread[0])
+	if err != nil {
+		t.Fatalf("[TestSMSInbox] Error: %s", err)
+	}
+	err = modem.DeleteSMSThread(contacts[0].ContactId)
+	if err != nil {
+		t.Fatalf("[TestSMSInbox] Error: %s", err)
+	}
+	if inbox := server.Modem.Inbox(); len(inbox) != 11 || inbox[0].Content != "message 1" {
+		t.Logf("Unexpected inbox after delete: %+v", inbox)
+		t.Fail()
+	}
+}
+
+func TestUnreadKept(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Modem.Deliver("+351910000000", "hello")
+	server.Modem.Deliver("+351920000000", "world")
+	opened, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestUnreadKept] Error: %s", err)
+	}
+	reader := opened.(device.SMSReader)
+
+	// the firmware mark the messages read once fetched, the driver still report them unread
+	for i := 0; i < 2; i++ {
+		messages, err := reader.ReceivedSMS()
+		if err != nil {
+			t.Fatalf("[TestUnreadKept] Error: %s", err)
+		}
+		if len(messages) != 2 || messages[0].Unread == false || messages[1].Unread == false {
+			t.Fatalf("Expected 2 unread messages, got: %+v", messages)
+		}
+	}
+	smsStorageState, err := opened.SMSStorageState()
+	if err != nil {
+		t.Fatalf("[TestUnreadKept] Error: %s", err)
+	}
+	if smsStorageState.UnreadSMSCount != 2 {
+		t.Logf("Expected 2 unread SMS, got: %+v", smsStorageState)
+		t.Fail()
+	}
+
+	messages, _ := reader.ReceivedSMS()
+	err = reader.MarkReceivedSMSRead(messages[0])
+	if err != nil {
+		t.Fatalf("[TestUnreadKept] Error: %s", err)
+	}
+	err = reader.DeleteReceivedSMS(messages[1])
+	if err != nil {
+		t.Fatalf("[TestUnreadKept] Error: %s", err)
+	}
+	messages, _ = reader.ReceivedSMS()
+	if len(messages) != 1 || messages[0].Unread {
+		t.Logf("Expected the message marked read, got: %+v", messages)
+		t.Fail()
+	}
+	if smsStorageState, _ = opened.SMSStorageState(); smsStorageState.UnreadSMSCount != 0 {
+		t.Logf("Expected no unread SMS, got: %+v", smsStorageState)
+		t.Fail()
+	}
+}
+
+func TestSendSMS(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	modem_alcatel_mw40v.SMSSendPollInterval = time.Millisecond
+
+	modem := modem_alcatel_mw40v.New(server.URL)
+	err := modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestSendSMS] Error: %s", err)
+	}
+
+	// 6 GSM-7 parts, sent as 2 messages
+	sent, err := modem.SendSMS(context.Background(), []string{"+351910000000", "+351920000000"}, strings.Repeat("a", 153*6))
+	if err != nil {
+		t.Fatalf("[TestSendSMS] Error: %s", err)
+	}
+	if len(sent) != 4 || sent[0].Parts != 5 || sent[2].Parts != 1 || sent[2].Encoding != "GSM-7" {
+		t.Fatalf("Unexpected sent messages: %+v", sent)
+	}
+	if sent[0].Reference != 0 || sent[3].Reference == 0 || sent[3].ContactId == 0 {
+		t.Logf("Expected a reference on the last message of each number, got: %+v", sent)
+		t.Fail()
+	}
+	if server.Modem.Calls("GetSendSMSResult") != 4 {
+		t.Logf("Expected 2 polls per message, got: %d", server.Modem.Calls("GetSendSMSResult"))
+		t.Fail()
+	}
+
+	delivered, _ := modem.SMSDelivered(sent[3])
+	if delivered {
+		t.Logf("Expected no delivery report yet")
+		t.Fail()
+	}
+	// the first report is the one of the first message
+	server.Modem.DeliverReport("+351920000000")
+	delivered, err = modem.SMSDelivered(sent[3])
+	if err != nil || delivered {
+		t.Logf("Expected the report matched to the first message, got: %v %v", delivered, err)
+		t.Fail()
+	}
+	server.Modem.DeliverReport("+351920000000")
+	delivered, err = modem.SMSDelivered(sent[3])
+	if err != nil || delivered == false {
+		t.Logf("Expected a delivery report, got: %v %v", delivered, err)
+		t.Fail()
+	}
+
+	// the driver list the sent messages and the reports apart from the received ones
+	driver := &modem_alcatel_mw40v.Driver{Modem: modem}
+	stored, err := driver.StoredSentSMS()
+	if err != nil {
+		t.Fatalf("[TestSendSMS] Error: %s", err)
+	}
+	received, _ := driver.ReceivedSMS()
+	if len(stored) != 6 || stored[0].Number != "+351910000000" || len(received) != 0 {
+		t.Logf("Expected 4 sent messages and 2 reports, got: %+v, received: %+v", stored, received)
+		t.Fail()
+	}
+
+	server.Modem.SetSendFailure(modem_alcatel_mw40v.SEND_STATUS_FAIL_SENDING)
+	_, err = modem.SendSMS(context.Background(), []string{"+351910000000"}, "olá")
+	if sendErr, ok := err.(*modem_alcatel_mw40v.SendSMSError); ok == false || sendErr.Status != modem_alcatel_mw40v.SEND_STATUS_FAIL_SENDING {
+		t.Logf("Expected a send failure, got: %v", err)
+		t.Fail()
+	}
+
+	// nothing is sent when the context is done before
+	server.Modem.SetSendFailure(0)
+	calls := server.Modem.Calls("SendSMS")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = modem.SendSMS(ctx, []string{"+351910000000"}, "olá")
+	if err != context.Canceled || server.Modem.Calls("SendSMS") != calls {
+		t.Logf("Expected the send canceled, got: %v", err)
+		t.Fail()
+	}
+
+	// the modem accepted the message, the result is unknown when the context is done
+	modem_alcatel_mw40v.SMSSendPollInterval = time.Hour
+	defer func() { modem_alcatel_mw40v.SMSSendPollInterval = time.Millisecond }()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = modem.SendSMS(ctx, []string{"+351910000000"}, "olá")
+	if err != device.ErrSendUnconfirmed {
+		t.Logf("Expected an unconfirmed send, got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestUSSD(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Modem.USSD["*100#"] = "1: Saldo 2: Dados"
+	server.Modem.USSD["*100#>1"] = "Saldo: 5,00EUR"
+	modem_alcatel_mw40v.USSDPollInterval = time.Millisecond
+
+	modem := modem_alcatel_mw40v.New(server.URL)
+	err := modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestUSSD] Error: %s", err)
+	}
+
+	reply, err := modem.SendUSSD(context.Background(), "*100#")
+	if err != nil || reply.SessionOpen == false || reply.Text != "1: Saldo 2: Dados" {
+		t.Fatalf("Expected a menu, got: %+v %v", reply, err)
+	}
+	reply, err = modem.ReplyUSSD(context.Background(), "1")
+	if err != nil || reply.SessionOpen || reply.Text != "Saldo: 5,00EUR" {
+		t.Fatalf("Expected the balance, got: %+v %v", reply, err)
+	}
+
+	_, err = modem.ReplyUSSD(context.Background(), "2")
+	if ussdErr, ok := err.(*modem_alcatel_mw40v.USSDError); ok == false || ussdErr.State != modem_alcatel_mw40v.USSD_STATE_NO_RESPONSE {
+		t.Logf("Expected no response without session, got: %v", err)
+		t.Fail()
+	}
+	_, err = modem.SendUSSD(context.Background(), "*200#")
+	if err == nil {
+		t.Logf("Expected no response to an unknown code")
+		t.Fail()
+	}
+
+	reply, _ = modem.SendUSSD(context.Background(), "*100#")
+	err = modem.CancelUSSD()
+	if err != nil {
+		t.Fatalf("[TestUSSD] Error: %s", err)
+	}
+	_, err = modem.ReplyUSSD(context.Background(), "1")
+	if err == nil {
+		t.Logf("Expected the session cancelled")
+		t.Fail()
+	}
+}
+
+func TestConnectedClients(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	download, upload := 2000.0, 100.0
+	server.Modem.SetConnectedDevices([]modem_alcatel_mw40v.ConnectedDevice{
+		{Id: 1, DeviceName: "laptop", MacAddress: "A0:B1:C2:D3:E4:F5", IPAddress: "192.168.1.100", ConnectMode: modem_alcatel_mw40v.CONNECT_MODE_WIFI, AssociationTime: 60},
+		{Id: 2, DeviceName: "camera", MacAddress: "A0:B1:C2:D3:E4:F6", IPAddress: "192.168.1.101", ConnectMode: modem_alcatel_mw40v.CONNECT_MODE_USB, DlBytes: &download, UlBytes: &upload},
+	})
+
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{})
+	if err != nil {
+		t.Fatalf("[TestConnectedClients] Error: %s", err)
+	}
+	if _, err = modem.(device.ClientLister).ConnectedClients(); err != device.ErrNotSupported {
+		t.Fatalf("Expected the list not supported without login, got: %v", err)
+	}
+
+	modem, err = modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestConnectedClients] Error: %s", err)
+	}
+	clients, err := modem.(device.ClientLister).ConnectedClients()
+	if err != nil {
+		t.Fatalf("[TestConnectedClients] Error: %s", err)
+	}
+	if len(clients) != 2 || clients[0].MAC != "a0:b1:c2:d3:e4:f5" || clients[0].Connected != time.Minute || clients[0].Traffic != nil {
+		t.Fatalf("Unexpected clients: %+v", clients)
+	}
+	if clients[1].Type != device.CLIENT_USB || clients[1].Traffic == nil || clients[1].Traffic.DownloadBytes != 2000 {
+		t.Logf("Expected the USB client with its traffic, got: %+v", clients[1])
+		t.Fail()
+	}
+}
+
+func TestMacFilter(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	modem := modem_alcatel_mw40v.New(server.URL)
+	err := modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestMacFilter] Error: %s", err)
+	}
+
+	err = modem.AddMacFilterEntry("A0-B1-C2-D3-E4-F5", "laptop")
+	if err == nil {
+		err = modem.AddMacFilterEntry("b8:27:eb:12:34:56", "pi")
+	}
+	if err == nil {
+		err = modem.AddMacFilterEntry("a0:b1:c2:d3:e4:f5", "work laptop")
+	}
+	if err == nil {
+		err = modem.SetMacFilterMode(modem_alcatel_mw40v.MAC_FILTER_DENY)
+	}
+	if err != nil {
+		t.Fatalf("[TestMacFilter] Error: %s", err)
+	}
+	settings := server.Modem.MacFilter()
+	if settings.MacFilterMode != modem_alcatel_mw40v.MAC_FILTER_DENY || len(settings.MacList) != 2 || settings.MacList[0] != (modem_alcatel_mw40v.MacFilterEntry{MacAddress: "a0:b1:c2:d3:e4:f5", DeviceName: "work laptop"}) {
+		t.Fatalf("Unexpected MAC filter: %+v", settings)
+	}
+
+	err = modem.RemoveMacFilterEntry("A0:B1:C2:D3:E4:F5")
+	if err != nil {
+		t.Fatalf("[TestMacFilter] Error: %s", err)
+	}
+	calls := server.Modem.Calls("SetMacFilterSettings")
+	err = modem.RemoveMacFilterEntry("a0:b1:c2:d3:e4:f5")
+	if err != nil || server.Modem.Calls("SetMacFilterSettings") != calls {
+		t.Logf("Expected nothing sent for a device not listed, got: %v", err)
+		t.Fail()
+	}
+	if settings = server.Modem.MacFilter(); len(settings.MacList) != 1 || settings.MacList[0].DeviceName != "pi" {
+		t.Logf("Unexpected MAC filter: %+v", settings)
+		t.Fail()
+	}
+
+	if modem.AddMacFilterEntry("a0:b1:c2", "") == nil || modem.SetMacFilterMode(3) == nil {
+		t.Logf("Expected an invalid MAC address and mode")
+		t.Fail()
+	}
+
+	// the driver keep the names and refuse invalid filters
+	driver := &modem_alcatel_mw40v.Driver{Modem: modem}
+	filter, err := driver.MACFilter()
+	if err != nil {
+		t.Fatalf("[TestMacFilter] Error: %s", err)
+	}
+	filter.Block("a0:b1:c2:d3:e4:f7")
+	err = driver.SetMACFilter(filter)
+	if err != nil {
+		t.Fatalf("[TestMacFilter] Error: %s", err)
+	}
+	if settings = server.Modem.MacFilter(); len(settings.MacList) != 2 || settings.MacList[0].DeviceName != "pi" {
+		t.Logf("Unexpected MAC filter: %+v", settings)
+		t.Fail()
+	}
+
+	// blocking with a disabled filter keep the listed devices
+	filter = &device.MACFilter{Mode: device.MAC_FILTER_DISABLED, MACs: []string{"a0:b1:c2:d3:e4:f7"}}
+	if filter.Block("a0:b1:c2:d3:e4:f8") == false || filter.Mode != device.MAC_FILTER_DENY || len(filter.MACs) != 2 {
+		t.Logf("Expected the device added to a deny list, got: %+v", filter)
+		t.Fail()
+	}
+	if filter.Block("a0:b1:c2:d3:e4:f8") {
+		t.Logf("Expected the device already blocked")
+		t.Fail()
+	}
+	if driver.SetMACFilter(&device.MACFilter{Mode: "block"}) == nil || driver.SetMACFilter(&device.MACFilter{Mode: device.MAC_FILTER_DENY, MACs: []string{"pi"}}) == nil {
+		t.Logf("Expected invalid filters refused")
+		t.Fail()
+	}
+}
+
+func TestPortForwarding(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	modem := modem_alcatel_mw40v.New(server.URL)
+	err := modem.Login("admin", "admin")
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+
+	camera, err := modem.AddPortForwarding(modem_alcatel_mw40v.PortForwardingRule{Name: "camera", Status: modem_alcatel_mw40v.STATUS_ENABLED, WanPort: 8080, LanIp: "192.168.1.50", LanPort: 80, Protocol: modem_alcatel_mw40v.PROTOCOL_TCP})
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	_, err = modem.AddPortForwarding(modem_alcatel_mw40v.PortForwardingRule{Name: "stream", WanPort: 8080, LanIp: "192.168.1.51", LanPort: 554, Protocol: modem_alcatel_mw40v.PROTOCOL_UDP})
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	invalid := []modem_alcatel_mw40v.PortForwardingRule{
+		{Name: "web", WanPort: 8080, LanIp: "192.168.1.52", LanPort: 80, Protocol: modem_alcatel_mw40v.PROTOCOL_BOTH},
+		{Name: "web", WanPort: 0, LanIp: "192.168.1.52", LanPort: 80},
+		{Name: "web", WanPort: 80, LanIp: "camera", LanPort: 80},
+		{WanPort: 80, LanIp: "192.168.1.52", LanPort: 80},
+	}
+	for _, rule := range invalid {
+		if _, err = modem.AddPortForwarding(rule); err == nil {
+			t.Logf("Expected %+v refused", rule)
+			t.Fail()
+		}
+	}
+	rules := server.Modem.PortForwarding()
+	if camera.Id != 1 || len(rules) != 2 || rules[1].Id != 2 || rules[0] != *camera {
+		t.Fatalf("Unexpected rules: %+v", rules)
+	}
+
+	err = modem.RemovePortForwarding(1)
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	if modem.RemovePortForwarding(1) == nil || len(server.Modem.PortForwarding()) != 1 {
+		t.Logf("Expected the rule removed once, got %+v", server.Modem.PortForwarding())
+		t.Fail()
+	}
+
+	// the driver count the rules and translate the firewall settings
+	opened, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	driver := opened.(*modem_alcatel_mw40v.Driver)
+	forwardings, err := driver.PortForwardings()
+	if err != nil || len(forwardings) != 1 || forwardings[0] != (device.PortForwarding{ID: "2", Name: "stream", Protocol: device.PROTOCOL_UDP, WANPort: 8080, LANIP: "192.168.1.51", LANPort: 554}) {
+		t.Fatalf("Unexpected rules: %+v, %v", forwardings, err)
+	}
+	err = driver.SetFirewallSettings(&device.FirewallSettings{Firewall: true, WANPing: true, DMZ: "192.168.1.50"})
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	firewall, upnp, dmz := server.Modem.Firewall()
+	if firewall.WanPingStatus != modem_alcatel_mw40v.STATUS_ENABLED || upnp.UpnpStatus != modem_alcatel_mw40v.STATUS_DISABLED || dmz != (modem_alcatel_mw40v.DMZSettings{DMZStatus: modem_alcatel_mw40v.STATUS_ENABLED, DMZIp: "192.168.1.50"}) {
+		t.Logf("Unexpected settings: %+v %+v %+v", firewall, upnp, dmz)
+		t.Fail()
+	}
+	settings, err := driver.FirewallSettings()
+	if err != nil || *settings != (device.FirewallSettings{Firewall: true, WANPing: true, DMZ: "192.168.1.50"}) {
+		t.Logf("Unexpected settings: %+v, %v", settings, err)
+		t.Fail()
+	}
+	if driver.SetFirewallSettings(&device.FirewallSettings{DMZ: "camera"}) == nil {
+		t.Logf("Expected an invalid DMZ host refused")
+		t.Fail()
+	}
+
+	// without password the scrapes skip the rules
+	anonymous, err := modem_alcatel_mw40v.Open(server.URL, device.Options{})
+	if err != nil {
+		t.Fatalf("[TestPortForwarding] Error: %s", err)
+	}
+	if _, err = anonymous.(*modem_alcatel_mw40v.Driver).PortForwardings(); err != device.ErrNotSupported {
+		t.Logf("Expected the rules not supported without password, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestLanSettings(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	opened, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestLanSettings] Error: %s", err)
+	}
+	driver := opened.(*modem_alcatel_mw40v.Driver)
+
+	settings, err := driver.LANSettings()
+	if err != nil {
+		t.Fatalf("[TestLanSettings] Error: %s", err)
+	}
+	expected := device.LANSettings{Gateway: "192.168.1.1", Netmask: "255.255.255.0", DHCP: true, DHCPStart: "192.168.1.100", DHCPEnd: "192.168.1.200", LeaseHours: 12, DNSMode: device.DNS_MODE_AUTO}
+	if fmt.Sprintf("%+v", *settings) != fmt.Sprintf("%+v", expected) {
+		t.Fatalf("Unexpected settings: %+v", settings)
+	}
+
+	// the DHCP range is kept while the DHCP server is off
+	settings.Gateway = "172.16.8.1"
+	settings.Netmask = "255.255.0.0"
+	settings.DHCP = false
+	settings.DNSMode = device.DNS_MODE_MANUAL
+	settings.DNS = []string{"8.8.8.8"}
+	err = driver.SetLANSettings(settings)
+	if err != nil {
+		t.Fatalf("[TestLanSettings] Error: %s", err)
+	}
+	lan := server.Modem.LanSettings()
+	if lan.IPv4IPAddress != "172.16.8.1" || lan.SubnetMask != "255.255.0.0" || lan.DHCPServerStatus != modem_alcatel_mw40v.STATUS_DISABLED || lan.StartIPAddress != "192.168.1.100" || lan.DNSAddress1 != "8.8.8.8" || lan.DNSAddress2 != "" {
+		t.Logf("Unexpected modem settings: %+v", lan)
+		t.Fail()
+	}
+	if driver.SetLANSettings(&device.LANSettings{Gateway: "172.16.8.1", Netmask: "255.255.255.255", DNSMode: device.DNS_MODE_AUTO}) == nil {
+		t.Logf("Expected a /32 subnet refused")
+		t.Fail()
+	}
+}
+
+func TestInjectedErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	modem := modem_alcatel_mw40v.New(server.URL)
+
+	server.Modem.SetError("GetSystemStatus", &modem_alcatel_mw40v.RPCError{Code: "1", Message: "busy"})
+	_, err := modem.GetSystemStatus()
+	if err == nil || err.Error() != "modem error 1: busy" {
+		t.Logf("Expected injected error, got: %v", err)
+		t.Fail()
+	}
+
+	server.Modem.SetHTTPStatus("GetSystemInfo", http.StatusInternalServerError)
+	_, err = modem.GetSystemInfo()
+	if err == nil {
+		t.Logf("Expected an error on HTTP 500")
+		t.Fail()
+	}
+	if server.Modem.Calls("GetSystemInfo") != 1 {
+		t.Logf("Expected 1 GetSystemInfo call, got: %d", server.Modem.Calls("GetSystemInfo"))
+		t.Fail()
+	}
+}
@@ -0,0 +1,222 @@
+// Package mqtt is a minimal MQTT 3.1.1 publisher. Each Publish call connect to the broker, publish
+// the messages with QoS 0 and disconnect, which fit the rare state changes published by the
+// exporter without keepalive or reconnection logic.
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// TIMEOUT is the time to connect and publish
+const TIMEOUT = 10 * time.Second
+
+// Packet types
+const (
+	CONNECT    = 1
+	CONNACK    = 2
+	PUBLISH    = 3
+	DISCONNECT = 14
+)
+
+// Message is a message to publish, retained messages are sent to the new subscribers of the topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Client publish to Server, like "tcp://broker:1883", or "ssl://broker:8883" for TLS
+type Client struct {
+	Server   string `json:"server"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+}
+
+// ConnectError is a connection refused by the broker
+type ConnectError struct {
+	Code byte
+}
+
+func (err *ConnectError) Error() string {
+	reasons := map[byte]string{
+		1: "unacceptable protocol version",
+		2: "identifier rejected",
+		3: "server unavailable",
+		4: "bad user name or password",
+		5: "not authorized",
+	}
+	if reason, ok := reasons[err.Code]; ok {
+		return "MQTT connection refused: " + reason
+	}
+	return fmt.Sprintf("MQTT connection refused: code %d", err.Code)
+}
+
+// Validate check the server URL, and that a password come with a username as MQTT 3.1.1 require
+func (client *Client) Validate() error {
+	if client.Password != "" && client.Username == "" {
+		return fmt.Errorf("MQTT password without username")
+	}
+	_, _, err := client.address()
+	return err
+}
+
+func (client *Client) address() (string, bool, error) {
+	server, err := url.Parse(client.Server)
+	if err != nil {
+		return "", false, err
+	}
+	switch server.Scheme {
+	case "tcp", "mqtt":
+		return withPort(server.Host, "1883"), false, nil
+	case "ssl", "tls", "mqtts":
+		return withPort(server.Host, "8883"), true, nil
+	}
+	return "", false, fmt.Errorf("invalid MQTT server %s, expected tcp://host:port or ssl://host:port", client.Server)
+}
+
+func withPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Publish connect to the broker and publish the messages
+func (client *Client) Publish(messages ...Message) error {
+	address, secure, err := client.address()
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: TIMEOUT}
+	var conn net.Conn
+	if secure {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+
+	_, err = conn.Write(client.connectPacket())
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	packetType, body, err := ReadPacket(reader)
+	if err != nil {
+		return err
+	}
+	if packetType != CONNACK || len(body) != 2 {
+		return fmt.Errorf("MQTT: expected CONNACK, got packet type %d", packetType)
+	}
+	if body[1] != 0 {
+		return &ConnectError{Code: body[1]}
+	}
+
+	for _, message := range messages {
+		_, err = conn.Write(publishPacket(message))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write([]byte{DISCONNECT << 4, 0})
+	return err
+}
+
+// connectPacket return a CONNECT packet with a clean session
+func (client *Client) connectPacket() []byte {
+	var body bytes.Buffer
+	body.Write(encodeString("MQTT"))
+	// protocol level 4 is MQTT 3.1.1
+	body.WriteByte(4)
+	flags := byte(0x02)
+	if client.Username != "" {
+		flags |= 0x80
+	}
+	// a password without username is a protocol violation [MQTT-3.1.2-22]
+	if client.Username != "" && client.Password != "" {
+		flags |= 0x40
+	}
+	body.WriteByte(flags)
+	// keepalive in seconds, the connection is closed after publishing
+	body.Write([]byte{0, 60})
+	body.Write(encodeString(client.ClientId))
+	if client.Username != "" {
+		body.Write(encodeString(client.Username))
+	}
+	if flags&0x40 != 0 {
+		body.Write(encodeString(client.Password))
+	}
+	return packet(CONNECT<<4, body.Bytes())
+}
+
+// publishPacket return a QoS 0 PUBLISH packet
+func publishPacket(message Message) []byte {
+	header := byte(PUBLISH << 4)
+	if message.Retain {
+		header |= 0x01
+	}
+	body := append(encodeString(message.Topic), message.Payload...)
+	return packet(header, body)
+}
+
+func packet(header byte, body []byte) []byte {
+	return append(append([]byte{header}, encodeLength(len(body))...), body...)
+}
+
+func encodeString(value string) []byte {
+	return append([]byte{byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+// encodeLength encode the remaining length, 7 bits per byte, least significant first
+func encodeLength(length int) []byte {
+	var encoded []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+// ReadPacket read a packet and return its type and the bytes after the fixed header
+func ReadPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, fmt.Errorf("MQTT: malformed remaining length")
+		}
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return header >> 4, body, err
+}
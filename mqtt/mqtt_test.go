@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// broker accept one connection, answer CONNACK with code and return the packets received
+func broker(t *testing.T, code byte) (string, chan [][]byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := make(chan [][]byte, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var received [][]byte
+		for {
+			packetType, body, err := ReadPacket(reader)
+			if err != nil {
+				break
+			}
+			received = append(received, append([]byte{packetType}, body...))
+			if packetType == CONNECT {
+				conn.Write([]byte{CONNACK << 4, 2, 0, code})
+			}
+			if packetType == DISCONNECT {
+				break
+			}
+		}
+		packets <- received
+	}()
+	return "tcp://" + listener.Addr().String(), packets
+}
+
+func TestPublish(t *testing.T) {
+	server, packets := broker(t, 0)
+	client := &Client{Server: server, Username: "home", Password: "secret", ClientId: "exporter"}
+	err := client.Publish(
+		Message{Topic: "modem/presence/home/alice", Payload: []byte("home"), Retain: true},
+		Message{Topic: "modem/presence/home/bob", Payload: []byte(strings.Repeat("x", 200))},
+	)
+	if err != nil {
+		t.Fatalf("[TestPublish] Error: %s", err)
+	}
+
+	received := <-packets
+	if len(received) != 4 || received[0][0] != CONNECT || received[1][0] != PUBLISH || received[3][0] != DISCONNECT {
+		t.Fatalf("Expected CONNECT, 2 PUBLISH and DISCONNECT, got: %v", received)
+	}
+	connect := string(received[0][1:])
+	if strings.Contains(connect, "MQTT\x04\xc2") == false || strings.HasSuffix(connect, "\x00\x04home\x00\x06secret") == false {
+		t.Logf("Unexpected CONNECT: %q", connect)
+		t.Fail()
+	}
+	if publish := string(received[1][1:]); publish != "\x00\x19modem/presence/home/alicehome" {
+		t.Logf("Unexpected PUBLISH: %q", publish)
+		t.Fail()
+	}
+	// the 200 bytes payload need a 2 bytes remaining length
+	if len(received[2]) != 1+2+len("modem/presence/home/bob")+200 {
+		t.Logf("Unexpected PUBLISH length: %d", len(received[2]))
+		t.Fail()
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	server, _ := broker(t, 4)
+	client := &Client{Server: server, ClientId: "exporter"}
+	err := client.Publish(Message{Topic: "test", Payload: []byte("test")})
+	if connectErr, ok := err.(*ConnectError); ok == false || connectErr.Code != 4 {
+		t.Fatalf("Expected a bad password error, got: %v", err)
+	}
+
+	client.Server = "http://broker"
+	if client.Validate() == nil {
+		t.Logf("Expected an invalid server")
+		t.Fail()
+	}
+	client = &Client{Server: server, Password: "secret"}
+	if client.Validate() == nil {
+		t.Logf("Expected a password without username invalid")
+		t.Fail()
+	}
+}
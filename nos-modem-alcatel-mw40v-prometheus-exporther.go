@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 
+	"nos-modem-alcatel-mw40v-prometheus-exporther/clients"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
 	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	_ "nos-modem-alcatel-mw40v-prometheus-exporther/modem_huawei_hilink"
+	_ "nos-modem-alcatel-mw40v-prometheus-exporther/modem_zte_goform"
 )
 
 var BUILD_DATE = "Undefined"
@@ -104,6 +109,96 @@ var (
 		},
 		[]string{"IMEI", "IMSI", "MacAddress"},
 	)
+	smsStorageUsedRatioGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_sms_storage_used_ratio",
+			Help: "Used SMS storage slots, from 0 to 1, the modem refuse new messages at 1",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	// Signal
+	signalStrengthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_strength",
+			Help: "Signal strength in bars",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress", "network_type", "network_name"},
+	)
+	signalRSSIGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rssi_dbm",
+			Help: "Received signal strength indicator",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	signalRSRPGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rsrp_dbm",
+			Help: "Reference signal received power",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	signalRSRQGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_rsrq_db",
+			Help: "Reference signal received quality",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	signalSINRGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_signal_sinr_db",
+			Help: "Signal to interference plus noise ratio",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	// Device
+	infoGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_info",
+			Help: "Modem driver, model and versions, always 1",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress", "alias", "driver", "vendor", "model", "hardware_version", "software_version"},
+	)
+	featureSupportedGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_feature_supported",
+			Help: "1 if the modem model support the feature, 0 otherwise",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress", "feature"},
+	)
+	smsSentCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "modem_sms_sent_total",
+			Help: "SMS sent per recipient, by result: success, failure or timeout",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress", "result"},
+	)
+	// Wi-Fi
+	wifiEnabledGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_wifi_enabled",
+			Help: "1 if the Wi-Fi is on, 0 otherwise",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	wifiChannelGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_wifi_channel",
+			Help: "Wi-Fi channel in use, by band: 2.4GHz or 5GHz",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress", "band"},
+	)
+	// Port forwarding
+	portForwardingRulesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "modem_port_forwarding_rules",
+			Help: "Number of active port forwarding rules",
+		},
+		[]string{"IMEI", "IMSI", "MacAddress"},
+	)
+	// Clients
+	clientsCollector = clients.New([]string{"IMEI", "IMSI", "MacAddress"}, clients.DEFAULT_LIMIT, "")
 )
 
 func init() {
@@ -122,9 +217,60 @@ func init() {
 	prometheus.MustRegister(uploadBytesGauge)
 	// SMS
 	prometheus.MustRegister(unreadSMSCountGauge)
+	prometheus.MustRegister(smsStorageUsedRatioGauge)
+	// Signal
+	prometheus.MustRegister(signalStrengthGauge)
+	prometheus.MustRegister(signalRSSIGauge)
+	prometheus.MustRegister(signalRSRPGauge)
+	prometheus.MustRegister(signalRSRQGauge)
+	prometheus.MustRegister(signalSINRGauge)
+	// Device
+	prometheus.MustRegister(infoGauge)
+	prometheus.MustRegister(featureSupportedGauge)
+	// SMS sending
+	prometheus.MustRegister(smsSentCounter)
+	// Wi-Fi
+	prometheus.MustRegister(wifiEnabledGauge)
+	prometheus.MustRegister(wifiChannelGauge)
+	prometheus.MustRegister(portForwardingRulesGauge)
+	// Clients
+	prometheus.MustRegister(clientsCollector)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "record":
+			setLogLevel()
+			runRecord(os.Args[2:])
+			return
+		case "proxy":
+			setLogLevel()
+			runProxy(os.Args[2:])
+			return
+		case "send-sms":
+			setLogLevel()
+			runSendSMS(os.Args[2:])
+			return
+		case "discover":
+			setLogLevel()
+			runDiscover(os.Args[2:])
+			return
+		case "archive":
+			setLogLevel()
+			runArchive(os.Args[2:])
+			return
+		case "macfilter":
+			setLogLevel()
+			runMACFilter(os.Args[2:])
+			return
+		case "lan":
+			setLogLevel()
+			runLAN(os.Args[2:])
+			return
+		}
+	}
+
 	var cmdlineVersion = flag.Bool("v", false, "Version")
 	flag.Parse()
 
@@ -135,23 +281,23 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Set log level
-	logLevel := os.Getenv("LOG_LEVEL")
-	if strings.TrimSpace(logLevel) == "" {
-		logLevel = "info"
-	}
-	log.SetOutput(os.Stdout)
-	loglevel, err := log.ParseLevel(logLevel)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.SetLevel(loglevel)
+	setLogLevel()
 
 	modemUrl := os.Getenv("MODEM_URL")
 	if strings.TrimSpace(modemUrl) == "" {
 		modemUrl = "http://192.168.1.1"
 	}
 
+	replayDir := os.Getenv("REPLAY_DIR")
+	if strings.TrimSpace(replayDir) != "" {
+		replayUrl, err := startReplay(replayDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Replaying %s on %s", replayDir, replayUrl)
+		modemUrl = replayUrl
+	}
+
 	strUpdateInterval := os.Getenv("UPDATE_INTERVAL")
 	if strings.TrimSpace(strUpdateInterval) == "" {
 		strUpdateInterval = "10s"
@@ -167,48 +313,148 @@ func main() {
 
 	done := make(chan bool)
 
-	modem := modem_alcatel_mw40v.New(modemUrl)
-	systemInfo, err := modem.GetSystemInfo()
-	if err != nil {
-		log.Fatal(err)
+	clientLimit := os.Getenv("CLIENT_METRICS_LIMIT")
+	if strings.TrimSpace(clientLimit) != "" {
+		clientsCollector.Limit, err = strconv.Atoi(clientLimit)
+		if err != nil {
+			log.Fatalf("CLIENT_METRICS_LIMIT: %s", err)
+		}
 	}
+	clientsCollector.HashKey = os.Getenv("CLIENT_MAC_HASH_KEY")
 
-	scraper := func() error {
-		systemStatus, err := modem.GetSystemStatus()
+	modemDriver := os.Getenv("MODEM_DRIVER")
+	if strings.TrimSpace(modemDriver) == "" {
+		modemDriver = modem_alcatel_mw40v.DRIVER
+	}
+	options := device.Options{Username: os.Getenv("MODEM_USERNAME"), Password: os.Getenv("MODEM_PASSWORD")}
+
+	modemTargets := os.Getenv("MODEM_TARGETS")
+	modemDiscovery := os.Getenv("MODEM_DISCOVERY")
+	if strings.TrimSpace(replayDir) != "" {
+		modemTargets = modemUrl
+		modemDiscovery = ""
+	}
+
+	var targets []*target
+	if strings.TrimSpace(modemTargets) == "" && strings.TrimSpace(modemDiscovery) != "" {
+		// retry until a modem answers, it may still be booting
+		for {
+			targets, err = discoverTargets(modemDiscovery, options)
+			if err == nil {
+				break
+			}
+			log.Error(err)
+			time.Sleep(updateInterval)
+		}
+	} else {
+		if strings.TrimSpace(modemTargets) == "" {
+			modemTargets = modemUrl
+		}
+		targets, err = parseTargets(modemTargets, modemDriver, options)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		batteryCapacityGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(systemStatus.BatteryCapacity)
-		batteryLevelGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(systemStatus.BatteryLevel)
-		currentConnectionGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(systemStatus.CurrentConnection)
-		totalConnectionGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(systemStatus.TotalConnection)
+	}
+
+	// the APIs exposing the SMS or the users of the modems need the control API token
+	controlToken := strings.TrimSpace(os.Getenv("CONTROL_API_TOKEN"))
 
-		connectionState, err := modem.GetConnectionState()
+	// the forwarder first poll take the cursors before the other pollers read the inboxes
+	forwardConfig := os.Getenv("SMS_FORWARD_CONFIG")
+	if strings.TrimSpace(forwardConfig) != "" {
+		err = startForwarder(forwardConfig, targets)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		connectionStatusGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.ConnectionStatus)
-		speedDownloadGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.SpeedDownload)
-		speedUploadGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.SpeedUpload)
-		downloadRateGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.DownloadRate)
-		uploadRateGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.UploadRate)
-		downloadBytesGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.DownloadBytes)
-		uploadBytesGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(connectionState.UploadBytes)
+	}
 
-		smsStorageState, err := modem.GetSMSStorageState()
+	archiveFile := os.Getenv("SMS_ARCHIVE_FILE")
+	if strings.TrimSpace(archiveFile) != "" {
+		err = startArchive(archiveFile, os.Getenv("SMS_ARCHIVE_RETENTION"), targets, controlToken)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		unreadSMSCountGauge.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}).Set(smsStorageState.UnreadSMSCount)
-		return nil
 	}
 
-	// first run
-	err = scraper()
-	if err != nil {
-		log.Fatal(err)
+	extractConfig := os.Getenv("SMS_EXTRACT_CONFIG")
+	if strings.TrimSpace(extractConfig) != "" {
+		err = startExtract(extractConfig, targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	housekeepingConfig := os.Getenv("SMS_HOUSEKEEPING_CONFIG")
+	if strings.TrimSpace(housekeepingConfig) != "" {
+		err = startHousekeeping(housekeepingConfig, targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	alertmanagerConfig := os.Getenv("ALERTMANAGER_CONFIG")
+	outboxDir := os.Getenv("SMS_OUTBOX_DIR")
+	if strings.TrimSpace(alertmanagerConfig) != "" || strings.TrimSpace(outboxDir) != "" {
+		t, err := smsTarget(targets, os.Getenv("SMS_TARGET"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if strings.TrimSpace(alertmanagerConfig) != "" {
+			err = startAlertmanager(alertmanagerConfig, t, controlToken)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if strings.TrimSpace(outboxDir) != "" {
+			err = startOutbox(outboxDir, os.Getenv("SMS_RATE_LIMIT"), t, controlToken)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	commandConfig := os.Getenv("SMS_COMMAND_CONFIG")
+	if strings.TrimSpace(commandConfig) != "" {
+		err = startCommands(commandConfig, targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	inventoryConfig := os.Getenv("INVENTORY_CONFIG")
+	if strings.TrimSpace(inventoryConfig) != "" {
+		err = startInventory(inventoryConfig, targets, controlToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if controlToken != "" {
+		err = startControl(controlToken, targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	presenceConfig := os.Getenv("PRESENCE_CONFIG")
+	if strings.TrimSpace(presenceConfig) != "" {
+		err = startPresence(presenceConfig, targets, controlToken)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	ussdConfig := os.Getenv("USSD_CONFIG")
+	if strings.TrimSpace(ussdConfig) != "" {
+		err = startUSSD(ussdConfig, targets)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// first run
+	scrapeAll(targets)
+
 	go func() {
 		for {
 			select {
@@ -217,16 +463,29 @@ func main() {
 
 			case t := <-ticker.C:
 				log.Debug("Current time: ", t)
-				err = scraper()
-				if err != nil {
-					log.Error(err)
-				}
+				scrapeAll(targets)
 			}
 		}
 	}()
 
 	http.Handle("/metrics", prometheus.Handler())
+	http.Handle("/probe", probeHandler(targets))
+	http.Handle("/sd", sdHandler(targets, os.Getenv("MODEM_SITE")))
 	http.ListenAndServe(":8080", nil)
 
 	done <- true
 }
+
+// setLogLevel set log level from LOG_LEVEL environment variable
+func setLogLevel() {
+	logLevel := os.Getenv("LOG_LEVEL")
+	if strings.TrimSpace(logLevel) == "" {
+		logLevel = "info"
+	}
+	log.SetOutput(os.Stdout)
+	loglevel, err := log.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(loglevel)
+}
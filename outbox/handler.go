@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// API_PATH is the path of the REST API, messages are at API_PATH/{id}
+const API_PATH = "/api/v1/sms"
+
+// Request is the body of POST API_PATH
+type Request struct {
+	To   []string `json:"to"`
+	Text string   `json:"text"`
+}
+
+// ServeHTTP enqueue a message on POST API_PATH and return a message on GET API_PATH/{id}
+func (outbox *Outbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, API_PATH), "/")
+
+	switch {
+	case r.Method == "POST" && id == "":
+		var request Request
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		message, err := outbox.Enqueue(request.To, request.Text)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", API_PATH+"/"+message.Id)
+		writeJSON(w, http.StatusAccepted, message)
+
+	case r.Method == "GET" && id != "":
+		message := outbox.Get(id)
+		if message == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, message)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,387 @@
+// Package outbox is a durable SMS outbox. Messages are stored one JSON file each in a directory, so
+// the queue survive restarts, and sent by a single worker with retries, per recipient rate limits
+// and a check of the modem SMS storage before each message.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// Message and recipient status
+const (
+	STATUS_QUEUED = "queued"
+	STATUS_SENT   = "sent"
+	STATUS_FAILED = "failed"
+	// STATUS_UNKNOWN is a recipient whose message was accepted by the modem without send result,
+	// it isn't sent again so it can't get it twice
+	STATUS_UNKNOWN = "unknown"
+)
+
+// Defaults of the Outbox settings
+const (
+	DEFAULT_MAX_ATTEMPTS      = 5
+	DEFAULT_RETRY_DELAY       = 30 * time.Second
+	DEFAULT_RATE_LIMIT        = 10
+	DEFAULT_RATE_LIMIT_PERIOD = time.Hour
+	DEFAULT_RETENTION         = 7 * 24 * time.Hour
+	DEFAULT_SEND_TIMEOUT      = 2 * time.Minute
+	DEFAULT_POLL_INTERVAL     = 5 * time.Second
+)
+
+// Recipient is the delivery of a message to a phone number
+type Recipient struct {
+	Number        string     `json:"number"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	Error         string     `json:"error,omitempty"`
+	Reference     string     `json:"reference,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+}
+
+// Message is a queued SMS. Its status is sent when every recipient got it, failed when a recipient
+// failed and none is left, queued otherwise
+type Message struct {
+	Id         string       `json:"id"`
+	Text       string       `json:"text"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Status     string       `json:"status"`
+	Recipients []*Recipient `json:"recipients"`
+}
+
+// SendFunc send a SMS to a number and return its reference
+type SendFunc func(ctx context.Context, to string, text string) (string, error)
+
+// StorageFunc return the modem SMS storage state, a sent message is stored by the modem
+type StorageFunc func() (*device.SMSStorageState, error)
+
+// Outbox is the queue and its worker
+type Outbox struct {
+	Dir     string
+	Send    SendFunc
+	Storage StorageFunc
+	// MaxAttempts per recipient, the delay between attempts start at RetryDelay and double
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// RateLimit is the number of messages sent to a number per RateLimitPeriod, 0 for no limit
+	RateLimit       int
+	RateLimitPeriod time.Duration
+	// Retention is the time sent and failed messages are kept
+	Retention    time.Duration
+	SendTimeout  time.Duration
+	PollInterval time.Duration
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex    sync.Mutex
+	messages map[string]*Message
+	wake     chan bool
+}
+
+// Open load the messages stored in dir, created if needed
+func Open(dir string, send SendFunc) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	outbox := &Outbox{
+		Dir:             dir,
+		Send:            send,
+		MaxAttempts:     DEFAULT_MAX_ATTEMPTS,
+		RetryDelay:      DEFAULT_RETRY_DELAY,
+		RateLimit:       DEFAULT_RATE_LIMIT,
+		RateLimitPeriod: DEFAULT_RATE_LIMIT_PERIOD,
+		Retention:       DEFAULT_RETENTION,
+		SendTimeout:     DEFAULT_SEND_TIMEOUT,
+		PollInterval:    DEFAULT_POLL_INTERVAL,
+		Now:             time.Now,
+		messages:        map[string]*Message{},
+		wake:            make(chan bool, 1),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var message Message
+		err = json.Unmarshal(content, &message)
+		if err != nil {
+			log.Warnf("[Outbox] %s ignored: %s", file, err)
+			continue
+		}
+		outbox.messages[message.Id] = &message
+	}
+	return outbox, nil
+}
+
+// Enqueue store a message for the numbers
+func (outbox *Outbox) Enqueue(to []string, text string) (*Message, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("empty text")
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("no recipient")
+	}
+
+	id, err := newId()
+	if err != nil {
+		return nil, err
+	}
+	now := outbox.Now()
+	message := &Message{Id: id, Text: text, CreatedAt: now, Status: STATUS_QUEUED}
+	for _, number := range to {
+		number = strings.TrimSpace(number)
+		if number == "" {
+			return nil, fmt.Errorf("empty phone number")
+		}
+		message.Recipients = append(message.Recipients, &Recipient{Number: number, Status: STATUS_QUEUED, NextAttemptAt: now})
+	}
+
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	err = outbox.save(message)
+	if err != nil {
+		return nil, err
+	}
+	outbox.messages[id] = message
+
+	select {
+	case outbox.wake <- true:
+	default:
+	}
+	return copyMessage(message), nil
+}
+
+// Get return a copy of a message, nil if unknown
+func (outbox *Outbox) Get(id string) *Message {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	message, ok := outbox.messages[id]
+	if ok == false {
+		return nil
+	}
+	return copyMessage(message)
+}
+
+// Pending return the number of recipients waiting for a message
+func (outbox *Outbox) Pending() int {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	pending := 0
+	for _, message := range outbox.messages {
+		for _, recipient := range message.Recipients {
+			if recipient.Status == STATUS_QUEUED {
+				pending++
+			}
+		}
+	}
+	return pending
+}
+
+// Run send the messages until ctx is done
+func (outbox *Outbox) Run(ctx context.Context) {
+	for {
+		for outbox.Process(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-outbox.wake:
+		case <-time.After(outbox.PollInterval):
+		}
+	}
+}
+
+// Process send the next message to a recipient ready for it, and return false if there was none
+func (outbox *Outbox) Process(ctx context.Context) bool {
+	outbox.mutex.Lock()
+	outbox.expire()
+	message, recipient := outbox.next()
+	var text, number string
+	if recipient != nil {
+		text, number = message.Text, recipient.Number
+	}
+	outbox.mutex.Unlock()
+	if recipient == nil {
+		return false
+	}
+
+	if outbox.Storage != nil {
+		storage, err := outbox.Storage()
+		if err == nil && storage.MaxCount > 0 && storage.LeftCount < 1 {
+			log.Warnf("[Outbox] modem SMS storage full, message %s waiting", message.Id)
+			return false
+		}
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, outbox.SendTimeout)
+	reference, err := outbox.Send(sendCtx, number, text)
+	cancel()
+
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	now := outbox.Now()
+	recipient.Attempts++
+	if err == device.ErrSendUnconfirmed {
+		log.Warnf("[Outbox] message %s to %s, attempt %d: %s", message.Id, number, recipient.Attempts, err)
+		recipient.Status = STATUS_UNKNOWN
+		recipient.Error = err.Error()
+		// counted by the rate limit, it was probably sent
+		recipient.SentAt = &now
+	} else if err != nil {
+		log.Errorf("[Outbox] message %s to %s, attempt %d: %s", message.Id, number, recipient.Attempts, err)
+		recipient.Error = err.Error()
+		if recipient.Attempts >= outbox.MaxAttempts {
+			recipient.Status = STATUS_FAILED
+		} else {
+			recipient.NextAttemptAt = now.Add(outbox.RetryDelay << uint(recipient.Attempts-1))
+		}
+	} else {
+		log.Infof("[Outbox] message %s sent to %s", message.Id, number)
+		recipient.Status = STATUS_SENT
+		recipient.Error = ""
+		recipient.Reference = reference
+		recipient.SentAt = &now
+	}
+	message.Status = status(message)
+
+	err = outbox.save(message)
+	if err != nil {
+		log.Errorf("[Outbox] message %s: %s", message.Id, err)
+	}
+	return true
+}
+
+// next return the oldest recipient ready to be sent a message, called with the mutex locked
+func (outbox *Outbox) next() (*Message, *Recipient) {
+	var messages []*Message
+	for _, message := range outbox.messages {
+		if message.Status == STATUS_QUEUED {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+
+	// a number get its messages in order, a message waiting for a retry block the next ones
+	now := outbox.Now()
+	waiting := map[string]bool{}
+	for _, message := range messages {
+		for _, recipient := range message.Recipients {
+			if recipient.Status != STATUS_QUEUED || waiting[recipient.Number] {
+				continue
+			}
+			if recipient.NextAttemptAt.After(now) == false && outbox.rateLimited(recipient.Number, now) == false {
+				return message, recipient
+			}
+			waiting[recipient.Number] = true
+		}
+	}
+	return nil, nil
+}
+
+// rateLimited return true if the number got RateLimit messages in the last RateLimitPeriod
+func (outbox *Outbox) rateLimited(number string, now time.Time) bool {
+	if outbox.RateLimit <= 0 {
+		return false
+	}
+	sent := 0
+	for _, message := range outbox.messages {
+		for _, recipient := range message.Recipients {
+			if recipient.Number == number && recipient.SentAt != nil && now.Sub(*recipient.SentAt) < outbox.RateLimitPeriod {
+				sent++
+			}
+		}
+	}
+	return sent >= outbox.RateLimit
+}
+
+// expire delete the messages done for longer than Retention, called with the mutex locked
+func (outbox *Outbox) expire() {
+	now := outbox.Now()
+	for id, message := range outbox.messages {
+		if message.Status != STATUS_QUEUED && now.Sub(message.CreatedAt) > outbox.Retention {
+			err := os.Remove(outbox.file(id))
+			if err != nil && os.IsNotExist(err) == false {
+				log.Errorf("[Outbox] message %s: %s", id, err)
+				continue
+			}
+			delete(outbox.messages, id)
+		}
+	}
+}
+
+// save write a message atomically, called with the mutex locked
+func (outbox *Outbox) save(message *Message) error {
+	content, err := json.MarshalIndent(message, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary := outbox.file(message.Id) + ".tmp"
+	err = ioutil.WriteFile(temporary, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, outbox.file(message.Id))
+}
+
+func (outbox *Outbox) file(id string) string {
+	return filepath.Join(outbox.Dir, id+".json")
+}
+
+func status(message *Message) string {
+	result := STATUS_SENT
+	for _, recipient := range message.Recipients {
+		switch recipient.Status {
+		case STATUS_QUEUED:
+			return STATUS_QUEUED
+		case STATUS_FAILED:
+			result = STATUS_FAILED
+		case STATUS_UNKNOWN:
+			if result == STATUS_SENT {
+				result = STATUS_UNKNOWN
+			}
+		}
+	}
+	return result
+}
+
+func copyMessage(message *Message) *Message {
+	copied := *message
+	copied.Recipients = nil
+	for _, recipient := range message.Recipients {
+		copiedRecipient := *recipient
+		copied.Recipients = append(copied.Recipients, &copiedRecipient)
+	}
+	return &copied
+}
+
+// newId return a random message id
+func newId() (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
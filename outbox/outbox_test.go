@@ -0,0 +1,192 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sent []string
+	failures := 1
+	send := func(ctx context.Context, to string, text string) (string, error) {
+		if failures > 0 {
+			failures--
+			return "", fmt.Errorf("modem busy")
+		}
+		sent = append(sent, to)
+		return fmt.Sprintf("%d", len(sent)), nil
+	}
+
+	outbox, err := Open(dir, send)
+	if err != nil {
+		t.Fatalf("[TestOutbox] Error: %s", err)
+	}
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+	outbox.Now = func() time.Time { return now }
+	outbox.RateLimit = 1
+
+	first, _ := outbox.Enqueue([]string{"+351910000000", "+351920000000"}, "hello")
+	now = now.Add(time.Second)
+	second, _ := outbox.Enqueue([]string{"+351910000000"}, "again")
+
+	// the first attempt fail and is retried after RetryDelay
+	outbox.Process(context.Background())
+	outbox.Process(context.Background())
+	if outbox.Process(context.Background()) {
+		t.Fatalf("Expected the retry and the rate limited number to wait")
+	}
+	if len(sent) != 1 || sent[0] != "+351920000000" {
+		t.Fatalf("Unexpected sent messages: %v", sent)
+	}
+
+	// survive a restart
+	outbox, err = Open(dir, send)
+	if err != nil {
+		t.Fatalf("[TestOutbox] Error: %s", err)
+	}
+	outbox.Now = func() time.Time { return now }
+	outbox.RateLimit = 1
+	if outbox.Pending() != 2 {
+		t.Fatalf("Expected 2 pending recipients after restart, got: %d", outbox.Pending())
+	}
+
+	now = now.Add(DEFAULT_RETRY_DELAY)
+	for outbox.Process(context.Background()) {
+	}
+	message := outbox.Get(first.Id)
+	if message.Status != STATUS_SENT || message.Recipients[0].Attempts != 2 || message.Recipients[0].Reference != "2" {
+		t.Logf("Unexpected message: %+v %+v", message, message.Recipients[0])
+		t.Fail()
+	}
+	if outbox.Get(second.Id).Status != STATUS_QUEUED {
+		t.Logf("Expected the second message rate limited")
+		t.Fail()
+	}
+
+	now = now.Add(time.Hour)
+	for outbox.Process(context.Background()) {
+	}
+	if outbox.Get(second.Id).Status != STATUS_SENT {
+		t.Logf("Expected the second message sent after the rate limit period")
+		t.Fail()
+	}
+
+	now = now.Add(DEFAULT_RETENTION)
+	outbox.Process(context.Background())
+	if outbox.Get(first.Id) != nil {
+		t.Logf("Expected the message deleted after the retention")
+		t.Fail()
+	}
+}
+
+func TestStorageFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outbox, _ := Open(dir, func(ctx context.Context, to string, text string) (string, error) { return "", nil })
+	left := 0.0
+	outbox.Storage = func() (*device.SMSStorageState, error) {
+		return &device.SMSStorageState{MaxCount: 100, LeftCount: left}, nil
+	}
+	outbox.Enqueue([]string{"+351910000000"}, "hello")
+
+	if outbox.Process(context.Background()) {
+		t.Fatalf("Expected the message to wait while the storage is full")
+	}
+	left = 1
+	if outbox.Process(context.Background()) == false || outbox.Pending() != 0 {
+		t.Fatalf("Expected the message sent")
+	}
+}
+
+func TestSendUnconfirmed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	attempts := 0
+	outbox, _ := Open(dir, func(ctx context.Context, to string, text string) (string, error) {
+		attempts++
+		return "", device.ErrSendUnconfirmed
+	})
+	message, _ := outbox.Enqueue([]string{"+351910000000"}, "hello")
+
+	// a message the modem accepted isn't sent again
+	outbox.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	for outbox.Process(context.Background()) {
+	}
+	message = outbox.Get(message.Id)
+	if attempts != 1 || message.Status != STATUS_UNKNOWN || outbox.Pending() != 0 {
+		t.Logf("Expected 1 attempt and an unknown status, got %d: %+v", attempts, message)
+		t.Fail()
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outbox, _ := Open(dir, func(ctx context.Context, to string, text string) (string, error) { return "", nil })
+	server := httptest.NewServer(outbox)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+API_PATH, "application/json", strings.NewReader(`{"to":["+351910000000"],"text":"hello"}`))
+	if err != nil {
+		t.Fatalf("[TestHandler] Error: %s", err)
+	}
+	var message Message
+	json.NewDecoder(resp.Body).Decode(&message)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || message.Id == "" || message.Status != STATUS_QUEUED {
+		t.Fatalf("Unexpected response: %d %+v", resp.StatusCode, message)
+	}
+
+	outbox.Process(context.Background())
+	resp, err = http.Get(server.URL + resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("[TestHandler] Error: %s", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&message)
+	resp.Body.Close()
+	if message.Status != STATUS_SENT {
+		t.Logf("Expected the message sent, got: %+v", message)
+		t.Fail()
+	}
+
+	resp, _ = http.Post(server.URL+API_PATH, "application/json", strings.NewReader(`{"to":[],"text":"hello"}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Logf("Expected 400 without recipient, got: %d", resp.StatusCode)
+		t.Fail()
+	}
+	resp, _ = http.Get(server.URL + API_PATH + "/unknown")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Logf("Expected 404 on unknown message, got: %d", resp.StatusCode)
+		t.Fail()
+	}
+}
@@ -0,0 +1,142 @@
+// Package poll hold what the pollers of the modems share: the source of a modem, the loop polling
+// the sources, and the loading of their config and saving of their state files.
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// Source is a polled modem, opened on each poll
+type Source struct {
+	Name string
+	Open func() (device.Device, error)
+}
+
+// SMSReader open the modem, device.ErrNotSupported if it can't read its inbox
+func (source Source) SMSReader() (device.SMSReader, error) {
+	modem, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := modem.(device.SMSReader)
+	if ok == false {
+		return nil, device.ErrNotSupported
+	}
+	return reader, nil
+}
+
+// ClientLister open the modem, device.ErrNotSupported if it can't list its clients
+func (source Source) ClientLister() (device.ClientLister, error) {
+	modem, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := modem.(device.ClientLister)
+	if ok == false {
+		return nil, device.ErrNotSupported
+	}
+	return lister, nil
+}
+
+// USSDSender open the modem, device.ErrNotSupported if it can't run USSD sessions
+func (source Source) USSDSender() (device.USSDSender, error) {
+	modem, err := source.Open()
+	if err != nil {
+		return nil, err
+	}
+	sender, ok := modem.(device.USSDSender)
+	if ok == false {
+		return nil, device.ErrNotSupported
+	}
+	return sender, nil
+}
+
+// Func poll a source
+type Func func(ctx context.Context, source Source) error
+
+// Run poll the sources, then every interval until ctx is done
+func Run(ctx context.Context, prefix string, sources []Source, interval time.Duration, poll Func) {
+	for {
+		Each(ctx, prefix, sources, poll)
+		if Wait(ctx, interval) == false {
+			return
+		}
+	}
+}
+
+// Each poll the sources once and log the errors with prefix, the modems not supporting the poll at
+// debug level
+func Each(ctx context.Context, prefix string, sources []Source, poll Func) {
+	for _, source := range sources {
+		err := poll(ctx, source)
+		if err == device.ErrNotSupported {
+			log.Debugf("[%s] %s: %s", prefix, source.Name, err)
+		} else if err != nil {
+			log.Errorf("[%s] %s: %s", prefix, source.Name, err)
+		}
+	}
+}
+
+// Wait wait for interval, return false if ctx is done before
+func Wait(ctx context.Context, interval time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(interval):
+		return true
+	}
+}
+
+// Config is a config validated once loaded
+type Config interface {
+	Validate() error
+}
+
+// LoadConfig read a JSON config file into config and validate it
+func LoadConfig(file string, config Config) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, config)
+	if err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+// Duration parse the value of the name setting, fallback if it is empty
+func Duration(name string, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err)
+	}
+	return duration, nil
+}
+
+// SaveJSON write value to file as indented JSON. It is written to a temporary file renamed over
+// file, so a crash never leave file half written
+func SaveJSON(file string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporary := file + ".tmp"
+	err = ioutil.WriteFile(temporary, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, file)
+}
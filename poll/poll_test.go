@@ -0,0 +1,92 @@
+package poll
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+type testConfig struct {
+	Interval string `json:"interval"`
+
+	interval time.Duration
+}
+
+func (config *testConfig) Validate() error {
+	var err error
+	config.interval, err = Duration("interval", config.Interval, time.Minute)
+	return err
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+
+	for _, test := range []struct {
+		interval string
+		expected time.Duration
+		valid    bool
+	}{
+		{"", time.Minute, true},
+		{"30s", 30 * time.Second, true},
+		{"soon", 0, false},
+	} {
+		err = SaveJSON(file, map[string]string{"interval": test.interval})
+		if err != nil {
+			t.Fatalf("[TestConfig] Error: %s", err)
+		}
+		var config testConfig
+		err = LoadConfig(file, &config)
+		if (err == nil) != test.valid || config.interval != test.expected {
+			t.Logf("Interval %q: expected %s, got %s (%v)", test.interval, test.expected, config.interval, err)
+			t.Fail()
+		}
+	}
+	if _, err := os.Stat(file + ".tmp"); os.IsNotExist(err) == false {
+		t.Logf("Expected the temporary file renamed")
+		t.Fail()
+	}
+}
+
+func TestSource(t *testing.T) {
+	inbox := &modemtest.Inbox{}
+	source := Source{Name: "home", Open: func() (device.Device, error) { return inbox, nil }}
+	reader, err := source.SMSReader()
+	if err != nil || reader != inbox {
+		t.Logf("Expected the inbox, got: %v", err)
+		t.Fail()
+	}
+	_, err = source.ClientLister()
+	if err != device.ErrNotSupported {
+		t.Logf("Expected ErrNotSupported, got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestRun(t *testing.T) {
+	sources := []Source{{Name: "home"}, {Name: "office"}}
+	var polled []string
+	ctx, cancel := context.WithCancel(context.Background())
+	Run(ctx, "Test", sources, time.Millisecond, func(ctx context.Context, source Source) error {
+		polled = append(polled, source.Name)
+		if len(polled) == 4 {
+			cancel()
+		}
+		return fmt.Errorf("unreachable")
+	})
+	if fmt.Sprint(polled) != "[home office home office]" {
+		t.Logf("Expected every source polled twice, got: %v", polled)
+		t.Fail()
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/presence"
+)
+
+// startPresence track the people at the targets, publish their state to MQTT and serve it on the
+// presence API when the control API token is set
+func startPresence(configFile string, targets []*target, token string) error {
+	config, err := presence.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	tracker := presence.New(config, pollSources(targets))
+	err = prometheus.Register(tracker)
+	if err != nil {
+		return err
+	}
+	go tracker.Run(context.Background())
+	log.Infof("Presence tracking of %d person(s)", len(config.People))
+	if token == "" {
+		log.Infof("Presence API disabled, it need CONTROL_API_TOKEN")
+		return nil
+	}
+	api, err := control.Protect(token, tracker)
+	if err != nil {
+		return err
+	}
+	http.Handle(presence.API_PATH, api)
+	return nil
+}
@@ -0,0 +1,309 @@
+// Package presence tell who is at each site from the clients connected to its modem. People are
+// mapped to the MAC addresses of their devices, and their state change is debounced: a person is
+// home once a device stayed connected for the join grace period, and not home once none of their
+// devices was seen for the leave grace period, so a phone sleeping off the Wi-Fi doesn't flap.
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/inventory"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/mqtt"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Presence states
+const (
+	HOME     = "home"
+	NOT_HOME = "not_home"
+)
+
+// Defaults of the config
+const (
+	DEFAULT_INTERVAL     = 30 * time.Second
+	DEFAULT_LEAVE_GRACE  = 5 * time.Minute
+	DEFAULT_TOPIC_PREFIX = "modem/presence"
+	DEFAULT_CLIENT_ID    = "modem-exporter"
+)
+
+// API_PATH is the path of the presence API
+const API_PATH = "/api/v1/presence"
+
+// Config of the tracker, read from a JSON file
+type Config struct {
+	// Interval between two polls of the client lists, like "30s"
+	Interval string `json:"interval,omitempty"`
+	// JoinGrace is the time a device must stay connected before its owner is home, 0 by default
+	JoinGrace string `json:"join_grace,omitempty"`
+	// LeaveGrace is the time without any device connected before a person is not home
+	LeaveGrace string `json:"leave_grace,omitempty"`
+	// People are the MAC addresses of the devices of each person
+	People map[string][]string `json:"people"`
+	MQTT   *MQTT               `json:"mqtt,omitempty"`
+
+	interval   time.Duration
+	joinGrace  time.Duration
+	leaveGrace time.Duration
+	owners     map[string]string
+}
+
+// MQTT publish the state of each person, home or not_home, retained in <topic_prefix>/<modem>/<person>
+type MQTT struct {
+	mqtt.Client
+	TopicPrefix string `json:"topic_prefix,omitempty"`
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config and index the people by MAC address
+func (config *Config) Validate() error {
+	var err error
+	durations := []struct {
+		name     string
+		value    string
+		duration *time.Duration
+		fallback time.Duration
+	}{
+		{"interval", config.Interval, &config.interval, DEFAULT_INTERVAL},
+		{"join_grace", config.JoinGrace, &config.joinGrace, 0},
+		{"leave_grace", config.LeaveGrace, &config.leaveGrace, DEFAULT_LEAVE_GRACE},
+	}
+	for _, d := range durations {
+		*d.duration, err = poll.Duration(d.name, d.value, d.fallback)
+		if err != nil {
+			return err
+		}
+	}
+	if len(config.People) == 0 {
+		return fmt.Errorf("no people")
+	}
+
+	config.owners = map[string]string{}
+	for person, addresses := range config.People {
+		if person == "" || len(addresses) == 0 {
+			return fmt.Errorf("people: a person need a name and a MAC address")
+		}
+		for _, address := range addresses {
+			mac, ok := inventory.NormalizeMAC(address)
+			if ok == false {
+				return fmt.Errorf("people: %s: invalid MAC address %s", person, address)
+			}
+			if owner, ok := config.owners[mac]; ok && owner != person {
+				return fmt.Errorf("people: %s belong to %s and %s", mac, owner, person)
+			}
+			config.owners[mac] = person
+		}
+	}
+
+	if config.MQTT != nil {
+		if config.MQTT.TopicPrefix == "" {
+			config.MQTT.TopicPrefix = DEFAULT_TOPIC_PREFIX
+		}
+		if config.MQTT.ClientId == "" {
+			config.MQTT.ClientId = DEFAULT_CLIENT_ID
+		}
+		err = config.MQTT.Validate()
+		if err != nil {
+			return fmt.Errorf("mqtt: %s", err)
+		}
+	}
+	return nil
+}
+
+// State is the presence of a person at a modem
+type State struct {
+	Modem    string    `json:"modem"`
+	Person   string    `json:"person"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// seenSince is the start of the current connection, zero while no device is connected
+	seenSince time.Time
+	published bool
+}
+
+// Tracker keep the presence of the people at each modem, it is also the collector of its metrics
+type Tracker struct {
+	Config  *Config
+	Sources []poll.Source
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	mutex  sync.Mutex
+	states map[string]*State
+}
+
+// New return a tracker, everybody is not home until the first poll
+func New(config *Config, sources []poll.Source) *Tracker {
+	return &Tracker{
+		Config:  config,
+		Sources: sources,
+		Now:     time.Now,
+		states:  map[string]*State{},
+	}
+}
+
+// Run poll the sources every interval, until ctx is done
+func (tracker *Tracker) Run(ctx context.Context) {
+	poll.Run(ctx, "Presence", tracker.Sources, tracker.Config.interval, func(ctx context.Context, source poll.Source) error {
+		return tracker.Poll(source)
+	})
+}
+
+// Poll update the presence at a source and publish the changes. The states don't change while the
+// modem can't be reached
+func (tracker *Tracker) Poll(source poll.Source) error {
+	lister, err := source.ClientLister()
+	if err != nil {
+		return err
+	}
+	clients, err := lister.ConnectedClients()
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, client := range clients {
+		if mac, ok := inventory.NormalizeMAC(client.MAC); ok && tracker.Config.owners[mac] != "" {
+			present[tracker.Config.owners[mac]] = true
+		}
+	}
+
+	changed := tracker.Update(source.Name, present, tracker.Now())
+	if len(changed) == 0 || tracker.Config.MQTT == nil {
+		return nil
+	}
+	var messages []mqtt.Message
+	for _, state := range changed {
+		messages = append(messages, mqtt.Message{
+			Topic:   fmt.Sprintf("%s/%s/%s", tracker.Config.MQTT.TopicPrefix, state.Modem, state.Person),
+			Payload: []byte(state.State),
+			Retain:  true,
+		})
+	}
+	err = tracker.Config.MQTT.Publish(messages...)
+	if err != nil {
+		// published again on the next poll
+		tracker.mutex.Lock()
+		for _, state := range changed {
+			tracker.states[state.Modem+"/"+state.Person].published = false
+		}
+		tracker.mutex.Unlock()
+		return fmt.Errorf("mqtt: %s", err)
+	}
+	return nil
+}
+
+// Update apply the people present at a modem at a time, and return the states to publish: the
+// changes, and every state the first time
+func (tracker *Tracker) Update(modem string, present map[string]bool, now time.Time) []State {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	var changed []State
+	for person := range tracker.Config.People {
+		key := modem + "/" + person
+		state, ok := tracker.states[key]
+		if ok == false {
+			state = &State{Modem: modem, Person: person, State: NOT_HOME, Since: now}
+			tracker.states[key] = state
+		}
+
+		if present[person] {
+			state.LastSeen = now
+			if state.seenSince.IsZero() {
+				state.seenSince = now
+			}
+			if state.State == NOT_HOME && now.Sub(state.seenSince) >= tracker.Config.joinGrace {
+				state.State = HOME
+				state.Since = now
+				state.published = false
+				log.Infof("[Presence] %s: %s is home", modem, person)
+			}
+		} else {
+			state.seenSince = time.Time{}
+			if state.State == HOME && now.Sub(state.LastSeen) >= tracker.Config.leaveGrace {
+				state.State = NOT_HOME
+				state.Since = now
+				state.published = false
+				log.Infof("[Presence] %s: %s left", modem, person)
+			}
+		}
+
+		if state.published == false {
+			state.published = true
+			changed = append(changed, *state)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Person < changed[j].Person })
+	return changed
+}
+
+// States return the presence of the people at every modem, by modem and person
+func (tracker *Tracker) States() []State {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	states := []State{}
+	for _, state := range tracker.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Modem != states[j].Modem {
+			return states[i].Modem < states[j].Modem
+		}
+		return states[i].Person < states[j].Person
+	})
+	return states
+}
+
+// ServeHTTP return the presence as JSON
+func (tracker *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tracker.States())
+}
+
+var (
+	presenceDesc = prometheus.NewDesc("modem_presence", "Presence of a person at a modem, 1 for the current state: home or not_home", []string{"modem", "person", "state"}, nil)
+	lastSeenDesc = prometheus.NewDesc("modem_presence_last_seen_timestamp_seconds", "Time a device of the person was last seen connected", []string{"modem", "person"}, nil)
+)
+
+// Describe implement prometheus.Collector
+func (tracker *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- presenceDesc
+	ch <- lastSeenDesc
+}
+
+// Collect implement prometheus.Collector
+func (tracker *Tracker) Collect(ch chan<- prometheus.Metric) {
+	for _, state := range tracker.States() {
+		for _, value := range []string{HOME, NOT_HOME} {
+			current := 0.0
+			if state.State == value {
+				current = 1
+			}
+			ch <- prometheus.MustNewConstMetric(presenceDesc, prometheus.GaugeValue, current, state.Modem, state.Person, value)
+		}
+		if state.LastSeen.IsZero() == false {
+			ch <- prometheus.MustNewConstMetric(lastSeenDesc, prometheus.GaugeValue, float64(state.LastSeen.Unix()), state.Modem, state.Person)
+		}
+	}
+}
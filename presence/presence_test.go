@@ -0,0 +1,111 @@
+package presence
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func TestUpdate(t *testing.T) {
+	config := &Config{JoinGrace: "1m", LeaveGrace: "10m", People: map[string][]string{
+		"alice": {"a0:b1:c2:d3:e4:f5", "a0:b1:c2:d3:e4:f6"},
+		"bob":   {"b8-27-eb-12-34-56"},
+	}}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[TestUpdate] Error: %s", err)
+	}
+	tracker := New(config, nil)
+	now := time.Date(2019, 3, 14, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		minutes  int
+		present  map[string]bool
+		expected string
+	}{
+		// every state is published the first time
+		{0, map[string]bool{"alice": true}, "alice not_home, bob not_home"},
+		{1, map[string]bool{"alice": true}, "alice home"},
+		// a short disconnection doesn't change the state
+		{5, map[string]bool{}, ""},
+		{6, map[string]bool{"alice": true, "bob": true}, ""},
+		{7, map[string]bool{"bob": true}, "bob home"},
+		{15, map[string]bool{"bob": true}, ""},
+		{16, map[string]bool{"bob": true}, "alice not_home"},
+		{25, map[string]bool{}, ""},
+		{26, map[string]bool{}, "bob not_home"},
+	}
+	for _, test := range tests {
+		result := ""
+		for i, state := range tracker.Update("home", test.present, now.Add(time.Duration(test.minutes)*time.Minute)) {
+			if i > 0 {
+				result += ", "
+			}
+			result += state.Person + " " + state.State
+		}
+		if result != test.expected {
+			t.Logf("At %d minutes: expected %q, got %q", test.minutes, test.expected, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	server := modemtest.NewServer()
+	defer server.Close()
+	server.Modem.SetConnectedDevices([]modem_alcatel_mw40v.ConnectedDevice{
+		{Id: 1, DeviceName: "phone", MacAddress: "A0:B1:C2:D3:E4:F5", IPAddress: "192.168.1.100"},
+	})
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	source := poll.Source{Name: "beach", Open: func() (device.Device, error) { return modem, nil }}
+
+	config := &Config{People: map[string][]string{"alice": {"a0:b1:c2:d3:e4:f5"}, "bob": {"b8:27:eb:12:34:56"}}}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+	tracker := New(config, []poll.Source{source})
+	err = tracker.Poll(source)
+	if err != nil {
+		t.Fatalf("[TestPoll] Error: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	tracker.ServeHTTP(w, httptest.NewRequest("GET", API_PATH, nil))
+	var states []State
+	json.NewDecoder(w.Body).Decode(&states)
+	if len(states) != 2 || states[0].Person != "alice" || states[0].State != HOME || states[1].State != NOT_HOME || states[1].Modem != "beach" {
+		t.Logf("Unexpected presence: %+v", states)
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{},
+		{People: map[string][]string{"alice": {"a0:b1:c2"}}},
+		{People: map[string][]string{"alice": {"a0:b1:c2:d3:e4:f5"}, "bob": {"A0-B1-C2-D3-E4-F5"}}},
+		{LeaveGrace: "5 minutes", People: map[string][]string{"alice": {"a0:b1:c2:d3:e4:f5"}}},
+	}
+	for i := range configs {
+		if configs[i].Validate() == nil {
+			t.Logf("Expected config %d invalid", i)
+			t.Fail()
+		}
+	}
+	config := &Config{People: map[string][]string{"alice": {"a0:b1:c2:d3:e4:f5"}}}
+	json.Unmarshal([]byte(`{"mqtt": {"server": "http://broker"}}`), config)
+	if config.Validate() == nil {
+		t.Logf("Expected an invalid MQTT server")
+		t.Fail()
+	}
+}
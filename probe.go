@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/discovery"
+)
+
+// findTarget return the target by alias or url, nil if unknown
+func findTarget(targets []*target, name string) *target {
+	for _, t := range targets {
+		if t.Alias == name || t.Url == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// probeHandler scrape the target named by the target parameter now and serve its series only
+func probeHandler(targets []*target) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := findTarget(targets, r.URL.Query().Get("target"))
+		if t == nil {
+			http.Error(w, fmt.Sprintf("unknown target: %s", r.URL.Query().Get("target")), http.StatusNotFound)
+			return
+		}
+		err := t.scrape()
+		if err != nil {
+			log.Errorf("[%s] %s", t.Alias, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, systemInfo, _ := t.open()
+		identity := map[string]string{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}
+
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		contentType := expfmt.Negotiate(r.Header)
+		var buf bytes.Buffer
+		encoder := expfmt.NewEncoder(&buf, contentType)
+		for _, family := range families {
+			family = filterFamily(family, identity, t.Alias)
+			if family == nil {
+				continue
+			}
+			err = encoder.Encode(family)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", string(contentType))
+		w.Write(buf.Bytes())
+	}
+}
+
+// filterFamily return the metrics of family of a target, nil if none. The series of a target have
+// all its identity labels, so the modems without IMEI stay apart, or its alias in the modem label
+func filterFamily(family *dto.MetricFamily, identity map[string]string, alias string) *dto.MetricFamily {
+	var metrics []*dto.Metric
+	for _, metric := range family.Metric {
+		labels := map[string]string{}
+		for _, label := range metric.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		matched := 0
+		for name, value := range identity {
+			if current, ok := labels[name]; ok && current == value {
+				matched++
+			}
+		}
+		if matched == len(identity) || (labels["modem"] != "" && labels["modem"] == alias) {
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return &dto.MetricFamily{Name: family.Name, Help: family.Help, Type: family.Type, Metric: metrics}
+}
+
+// sdHandler list the targets in the Prometheus http_sd format, each one scraped through /probe
+// on the address the request reached the exporter with
+func sdHandler(targets []*target, site string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups := []discovery.TargetGroup{}
+		for _, t := range targets {
+			_, systemInfo, err := t.open()
+			if err != nil {
+				log.Errorf("[%s] %s", t.Alias, err)
+				continue
+			}
+			groups = append(groups, discovery.TargetGroup{
+				Targets: []string{r.Host},
+				Labels: map[string]string{
+					"__metrics_path__": "/probe",
+					"__param_target":   t.Alias,
+					"alias":            t.Alias,
+					"driver":           t.Driver,
+					"model":            systemInfo.Model,
+					"firmware":         systemInfo.SoftwareVersion,
+					"imei_hash":        hashIdentifier(systemInfo.IMEI),
+					"site":             site,
+				},
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// hashIdentifier hash an identifier like the IMEI, so it can be used as label without being disclosed
+func hashIdentifier(identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(identifier))
+	return hex.EncodeToString(hash[:8])
+}
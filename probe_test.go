@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/discovery"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+func TestProbeAndSD(t *testing.T) {
+	lisbon := modemtest.NewServer()
+	defer lisbon.Close()
+	// modems without IMEI are told apart by the other identity labels
+	porto := modemtest.NewModem()
+	porto.SystemInfo.IMEI = ""
+	portoServer := modemtest.NewServerFor(porto)
+	defer portoServer.Close()
+	braga := modemtest.NewModem()
+	braga.SystemInfo.IMEI = ""
+	braga.SystemInfo.MacAddress = "c4:43:13:c5:56:78"
+	bragaServer := modemtest.NewServerFor(braga)
+	defer bragaServer.Close()
+
+	targets, err := parseTargets("lisbon="+lisbon.URL+",porto="+portoServer.URL+",braga="+bragaServer.URL, "tcl", device.Options{})
+	if err != nil {
+		t.Fatalf("[TestProbeAndSD] Error: %s", err)
+	}
+	scrapeAll(targets)
+
+	mux := http.NewServeMux()
+	mux.Handle("/probe", probeHandler(targets))
+	mux.Handle("/sd", sdHandler(targets, "home"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/probe?target=porto")
+	if err != nil {
+		t.Fatalf("[TestProbeAndSD] Error: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), `alias="porto"`) == false || strings.Contains(string(body), "123456789012345") || strings.Contains(string(body), "c4:43:13:c5:56:78") {
+		t.Logf("Expected porto series only, got: %s", body)
+		t.Fail()
+	}
+
+	resp, err = http.Get(server.URL + "/probe?target=faro")
+	if err != nil {
+		t.Fatalf("[TestProbeAndSD] Error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Logf("Expected 404 on unknown target, got: %d", resp.StatusCode)
+		t.Fail()
+	}
+
+	resp, err = http.Get(server.URL + "/sd")
+	if err != nil {
+		t.Fatalf("[TestProbeAndSD] Error: %s", err)
+	}
+	var groups []discovery.TargetGroup
+	err = json.NewDecoder(resp.Body).Decode(&groups)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("[TestProbeAndSD] Error: %s", err)
+	}
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 target groups, got: %d", len(groups))
+	}
+	labels := groups[0].Labels
+	if groups[0].Targets[0] != strings.TrimPrefix(server.URL, "http://") || labels["__param_target"] != "lisbon" || labels["__metrics_path__"] != "/probe" {
+		t.Logf("Unexpected target group: %+v", groups[0])
+		t.Fail()
+	}
+	if labels["model"] != "MW40V" || labels["site"] != "home" || labels["imei_hash"] != hashIdentifier("123456789012345") || len(labels["imei_hash"]) != 16 {
+		t.Logf("Unexpected labels: %+v", labels)
+		t.Fail()
+	}
+}
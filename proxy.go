@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/faultproxy"
+)
+
+// runProxy serve a fault injecting proxy to a modem
+func runProxy(args []string) {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	listen := flags.String("listen", ":8081", "Listen address")
+	target := flags.String("target", "http://192.168.1.1", "Modem, or fake modem, url")
+	faultsFile := flags.String("faults", "", "JSON file with the faults per method")
+	flags.Parse(args)
+
+	proxy := faultproxy.New(*target)
+	if *faultsFile != "" {
+		faults, err := faultproxy.LoadFaults(*faultsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy.SetFaults(faults)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/_proxy/faults", proxy.ControlHandler())
+	mux.Handle("/", proxy)
+
+	log.Infof("Proxying %s on %s", *target, *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
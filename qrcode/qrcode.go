@@ -0,0 +1,534 @@
+// Package qrcode encode data into a QR code, in byte mode with the error correction level M, which
+// recover 15% of damaged modules. Versions 1 to 10 are supported, up to 213 bytes: enough for Wi-Fi
+// join codes and short URLs. Codes are rendered as PNG or SVG.
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// MAX_VERSION is the largest supported version, 57x57 modules
+const MAX_VERSION = 10
+
+// QUIET_ZONE is the white border around the code, in modules
+const QUIET_ZONE = 4
+
+// versionBlocks are the error correction blocks of each version at level M: the error correction
+// codewords per block, then the number of blocks and their data codewords, by group
+var versionBlocks = [MAX_VERSION + 1]struct {
+	ecc    int
+	groups [][2]int
+}{
+	1:  {10, [][2]int{{1, 16}}},
+	2:  {16, [][2]int{{1, 28}}},
+	3:  {26, [][2]int{{1, 44}}},
+	4:  {18, [][2]int{{2, 32}}},
+	5:  {24, [][2]int{{2, 43}}},
+	6:  {16, [][2]int{{4, 27}}},
+	7:  {18, [][2]int{{4, 31}}},
+	8:  {22, [][2]int{{2, 38}, {2, 39}}},
+	9:  {22, [][2]int{{3, 36}, {2, 37}}},
+	10: {26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+// alignmentPositions are the row and column centers of the alignment patterns
+var alignmentPositions = [MAX_VERSION + 1][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// Code is a QR code, Size modules wide
+type Code struct {
+	Version int
+	Size    int
+	Mask    int
+
+	modules  [][]bool
+	function [][]bool
+}
+
+// Encode return the smallest QR code holding data
+func Encode(data []byte) (*Code, error) {
+	for version := 1; version <= MAX_VERSION; version++ {
+		if len(data) <= capacity(version) {
+			return encode(data, version), nil
+		}
+	}
+	return nil, fmt.Errorf("qrcode: %d bytes, at most %d fit in a QR code", len(data), capacity(MAX_VERSION))
+}
+
+// Dark return true if the module at column x and row y is dark
+func (code *Code) Dark(x int, y int) bool {
+	return code.modules[y][x]
+}
+
+// capacity return the data bytes of a version: the mode takes 4 bits, the length 8 bits up to
+// version 9 and 16 bits after
+func capacity(version int) int {
+	lengthBits := 8
+	if version >= 10 {
+		lengthBits = 16
+	}
+	return (dataCodewords(version)*8 - 4 - lengthBits) / 8
+}
+
+func dataCodewords(version int) int {
+	count := 0
+	for _, group := range versionBlocks[version].groups {
+		count += group[0] * group[1]
+	}
+	return count
+}
+
+func encode(data []byte, version int) *Code {
+	size := version*4 + 17
+	code := &Code{Version: version, Size: size}
+	code.modules = make([][]bool, size)
+	code.function = make([][]bool, size)
+	for y := range code.modules {
+		code.modules[y] = make([]bool, size)
+		code.function[y] = make([]bool, size)
+	}
+	code.drawFunctionPatterns()
+	code.drawCodewords(codewords(data, version))
+
+	// keep the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		penalty := code.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask)
+	}
+	code.Mask = best
+	code.applyMask(best)
+	code.drawFormatBits(best)
+	return code
+}
+
+// codewords return the data codewords followed by the error correction codewords, interleaved by
+// block
+func codewords(data []byte, version int) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	total := dataCodewords(version) * 8
+	terminator := total - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < total; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	encoded := bits.bytes()
+
+	blocks := versionBlocks[version]
+	divisor := reedSolomonDivisor(blocks.ecc)
+	var dataBlocks, eccBlocks [][]byte
+	for _, group := range blocks.groups {
+		for i := 0; i < group[0]; i++ {
+			block := encoded[:group[1]]
+			encoded = encoded[group[1]:]
+			dataBlocks = append(dataBlocks, block)
+			eccBlocks = append(eccBlocks, reedSolomonRemainder(block, divisor))
+		}
+	}
+
+	var result []byte
+	for i := 0; ; i++ {
+		added := false
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+				added = true
+			}
+		}
+		if added == false {
+			break
+		}
+	}
+	for i := 0; i < blocks.ecc; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+type bitBuffer []bool
+
+// append the low count bits of value, most significant first
+func (bits *bitBuffer) append(value int, count int) {
+	for i := count - 1; i >= 0; i-- {
+		*bits = append(*bits, (value>>uint(i))&1 != 0)
+	}
+}
+
+func (bits bitBuffer) bytes() []byte {
+	result := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// reedSolomonDivisor return the generator polynomial of a degree, highest coefficient first
+// without the leading 1
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder return the error correction codewords of data
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (code *Code) setFunction(x int, y int, dark bool) {
+	code.modules[y][x] = dark
+	code.function[y][x] = true
+}
+
+func (code *Code) drawFunctionPatterns() {
+	size := code.Size
+	for i := 0; i < size; i++ {
+		code.setFunction(6, i, i%2 == 0)
+		code.setFunction(i, 6, i%2 == 0)
+	}
+
+	// finder patterns and their separators
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					distance := max(abs(dx), abs(dy))
+					code.setFunction(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	positions := alignmentPositions[code.Version]
+	last := len(positions) - 1
+	for i, row := range positions {
+		for j, column := range positions {
+			// the corners overlap the finder patterns
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					code.setFunction(column+dx, row+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format bits, drawn with the mask
+	code.drawFormatBits(0)
+
+	if code.Version >= 7 {
+		bits := versionBits(code.Version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 != 0
+			a, b := size-11+i%3, i/3
+			code.setFunction(a, b, dark)
+			code.setFunction(b, a, dark)
+		}
+	}
+}
+
+// versionBits return the 18 version bits, from version 7
+func versionBits(version int) int {
+	remainder := version
+	for i := 0; i < 12; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+	}
+	return version<<12 | remainder
+}
+
+// formatBits return the 15 format bits of the level M and a mask
+func formatBits(mask int) int {
+	// level M is 00
+	data := mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+func (code *Code) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+	size := code.Size
+
+	for i := 0; i <= 5; i++ {
+		code.setFunction(8, i, bit(i))
+	}
+	code.setFunction(8, 7, bit(6))
+	code.setFunction(8, 8, bit(7))
+	code.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		code.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		code.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		code.setFunction(8, size-15+i, bit(i))
+	}
+	code.setFunction(8, size-8, true)
+}
+
+// drawCodewords place the codewords in the zigzag order, two columns at a time from the bottom
+// right corner, skipping the vertical timing pattern
+func (code *Code) drawCodewords(data []byte) {
+	size := code.Size
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = size - 1 - vertical
+				}
+				if code.function[y][x] == false && i < len(data)*8 {
+					code.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask invert the data modules selected by a mask, applying it twice remove it
+func (code *Code) applyMask(mask int) {
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				code.modules[y][x] = code.modules[y][x] == false
+			}
+		}
+	}
+}
+
+// penalty score the readability of the code: long runs of one color, 2x2 blocks, patterns looking
+// like a finder and an unbalanced dark ratio increase it
+func (code *Code) penalty() int {
+	size := code.Size
+	penalty := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	for _, vertical := range []bool{false, true} {
+		at := func(line int, i int) bool {
+			if vertical {
+				return code.modules[i][line]
+			}
+			return code.modules[line][i]
+		}
+		for line := 0; line < size; line++ {
+			run := 1
+			for i := 1; i <= size; i++ {
+				if i < size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			for i := 0; i+7 <= size; i++ {
+				match := true
+				for k, dark := range finderLike {
+					match = match && at(line, i+k) == dark
+				}
+				if match == false {
+					continue
+				}
+				// 4 light modules on a side, the quiet zone counts as light
+				light := func(from int, to int) bool {
+					for k := from; k < to; k++ {
+						if k >= 0 && k < size && at(line, k) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(i-4, i) || light(i+7, i+11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if code.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				module := code.modules[y][x]
+				if code.modules[y][x+1] == module && code.modules[y+1][x] == module && code.modules[y+1][x+1] == module {
+					penalty += 3
+				}
+			}
+		}
+	}
+	// 10 points per 5% away from 50% dark
+	total := size * size
+	deviation := abs(dark*20 - total*10)
+	penalty += (deviation + total - 1) / total * 10
+	return penalty
+}
+
+// PNG render the code with scale pixels per module, and the quiet zone
+func (code *Code) PNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (code.Size + 2*QUIET_ZONE) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.modules[y][x] == false {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+QUIET_ZONE)*scale+dx, (y+QUIET_ZONE)*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, img)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// SVG render the code with scale user units per module, and the quiet zone. The dark modules are a
+// single path, merged by row
+func (code *Code) SVG(scale int) []byte {
+	if scale < 1 {
+		scale = 1
+	}
+	width := code.Size + 2*QUIET_ZONE
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", width*scale, width*scale, width, width)
+	fmt.Fprintf(&buffer, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+	buffer.WriteString(`<path fill="#000000" d="`)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.modules[y][x] == false {
+				continue
+			}
+			run := 1
+			for x+run < code.Size && code.modules[y][x+run] {
+				run++
+			}
+			fmt.Fprintf(&buffer, "M%d %dh%dv1h-%dz", x+QUIET_ZONE, y+QUIET_ZONE, run, run)
+			x += run - 1
+		}
+	}
+	buffer.WriteString(`"/>` + "\n</svg>\n")
+	return buffer.Bytes()
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// the "HELLO WORLD" example of ISO/IEC 18004, version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	ecc := reedSolomonRemainder(data, reedSolomonDivisor(10))
+	if bytes.Equal(ecc, expected) == false {
+		t.Logf("Expected %v, got %v", expected, ecc)
+		t.Fail()
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	// from the format and version information tables of ISO/IEC 18004
+	formats := map[int]int{0: 0x5412, 1: 0x5125, 7: 0x4AA0}
+	for mask, expected := range formats {
+		if bits := formatBits(mask); bits != expected {
+			t.Logf("Mask %d: expected format bits %015b, got %015b", mask, expected, bits)
+			t.Fail()
+		}
+	}
+	versions := map[int]int{7: 0x07C94, 10: 0x0A4D3}
+	for version, expected := range versions {
+		if bits := versionBits(version); bits != expected {
+			t.Logf("Version %d: expected version bits %018b, got %018b", version, expected, bits)
+			t.Fail()
+		}
+	}
+}
+
+// readCodewords read the codewords back in the zigzag order, the mask removed
+func readCodewords(code *Code) []byte {
+	code.applyMask(code.Mask)
+	defer code.applyMask(code.Mask)
+
+	var bits bitBuffer
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < code.Size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vertical
+				if (right+1)&2 == 0 {
+					y = code.Size - 1 - vertical
+				}
+				if code.function[y][x] == false {
+					bits = append(bits, code.modules[y][x])
+				}
+			}
+		}
+	}
+	return bits.bytes()
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		length  int
+		version int
+	}{
+		{1, 1}, {14, 1}, {15, 2}, {26, 2}, {42, 3}, {106, 6}, {107, 7}, {180, 9}, {213, 10},
+	}
+	for _, test := range tests {
+		data := []byte(strings.Repeat("w", test.length))
+		code, err := Encode(data)
+		if err != nil {
+			t.Fatalf("[TestEncode] Error: %s", err)
+		}
+		if code.Version != test.version || code.Size != test.version*4+17 {
+			t.Logf("%d bytes: expected version %d, got %d (%d modules)", test.length, test.version, code.Version, code.Size)
+			t.Fail()
+			continue
+		}
+
+		// finder pattern centers, dark module and timing pattern
+		if code.Dark(3, 3) == false || code.Dark(code.Size-4, 3) == false || code.Dark(3, code.Size-4) == false || code.Dark(8, code.Size-8) == false {
+			t.Logf("Version %d: missing finder pattern or dark module", code.Version)
+			t.Fail()
+		}
+		for i := 8; i < code.Size-8; i++ {
+			if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+				t.Logf("Version %d: broken timing pattern at %d", code.Version, i)
+				t.Fail()
+				break
+			}
+		}
+
+		// both copies of the format bits hold the level M and the mask
+		first, second := 0, 0
+		for i := 0; i < 15; i++ {
+			var a, b bool
+			switch {
+			case i <= 5:
+				a = code.Dark(8, i)
+			case i == 6:
+				a = code.Dark(8, 7)
+			case i == 7:
+				a = code.Dark(8, 8)
+			case i == 8:
+				a = code.Dark(7, 8)
+			default:
+				a = code.Dark(14-i, 8)
+			}
+			if i < 8 {
+				b = code.Dark(code.Size-1-i, 8)
+			} else {
+				b = code.Dark(8, code.Size-15+i)
+			}
+			if a {
+				first |= 1 << uint(i)
+			}
+			if b {
+				second |= 1 << uint(i)
+			}
+		}
+		if first != formatBits(code.Mask) || second != first || (first^0x5412)>>13 != 0 {
+			t.Logf("Version %d: unexpected format bits %015b and %015b", code.Version, first, second)
+			t.Fail()
+		}
+
+		expected := codewords(data, code.Version)
+		if read := readCodewords(code); bytes.HasPrefix(read, expected) == false {
+			t.Logf("Version %d: codewords not read back", code.Version)
+			t.Fail()
+		}
+	}
+
+	_, err := Encode(make([]byte, 214))
+	if err == nil {
+		t.Logf("Expected 214 bytes too long")
+		t.Fail()
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("WIFI:T:WPA;S:home;P:12345678;;"))
+	if err != nil {
+		t.Fatalf("[TestRender] Error: %s", err)
+	}
+
+	content, err := code.PNG(4)
+	if err != nil {
+		t.Fatalf("[TestRender] Error: %s", err)
+	}
+	img, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("[TestRender] Error: %s", err)
+	}
+	width := (code.Size + 2*QUIET_ZONE) * 4
+	if img.Bounds().Dx() != width {
+		t.Logf("Expected a %d pixels wide image, got %d", width, img.Bounds().Dx())
+		t.Fail()
+	}
+	// top left pixel of the first finder pattern
+	if r, _, _, _ := img.At(QUIET_ZONE*4, QUIET_ZONE*4).RGBA(); r != 0 {
+		t.Logf("Expected a dark finder pattern")
+		t.Fail()
+	}
+
+	svg := string(code.SVG(4))
+	if strings.Contains(svg, `viewBox="0 0 37 37"`) == false || strings.Contains(svg, `d="M4 4h7v1h-7z`) == false {
+		t.Logf("Unexpected SVG: %s", svg)
+		t.Fail()
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/fixture"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+)
+
+// runRecord record the modem responses into a fixture directory
+func runRecord(args []string) {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	modemUrl := flags.String("url", "http://192.168.1.1", "Modem url")
+	output := flags.String("out", "fixtures", "Fixture directory")
+	username := flags.String("user", "admin", "Modem username")
+	password := flags.String("password", os.Getenv("MODEM_PASSWORD"), "Modem password, methods needing a login are skipped if empty (default $MODEM_PASSWORD)")
+	samples := flags.Int("samples", 1, "Number of samples recorded per method")
+	interval := flags.Duration("interval", 10*time.Second, "Interval between samples")
+	flags.Parse(args)
+
+	recorder := fixture.Recorder{
+		Modem:    modem_alcatel_mw40v.New(*modemUrl),
+		Username: *username,
+		Password: *password,
+		Samples:  *samples,
+		Interval: *interval,
+	}
+	session, err := recorder.Record()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = session.Save(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("%d method(s) recorded in %s", len(session.Methods), *output)
+}
+
+// startReplay serve a fixture directory on a local port and return its url
+func startReplay(dir string) (string, error) {
+	session, err := fixture.Load(dir)
+	if err != nil {
+		return "", err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(listener, fixture.NewReplay(session))
+
+	return "http://" + listener.Addr().String(), nil
+}
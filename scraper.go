@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+)
+
+// scrape update the gauges of a modem. Features not supported by the model are reported by
+// modem_feature_supported and skipped
+func scrape(alias string, modem device.Device, systemInfo *device.SystemInfo) error {
+	labels := prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress}
+	withLabels := func(extra prometheus.Labels) prometheus.Labels {
+		merged := prometheus.Labels{}
+		for name, value := range labels {
+			merged[name] = value
+		}
+		for name, value := range extra {
+			merged[name] = value
+		}
+		return merged
+	}
+	supported := func(feature string, err error) (bool, error) {
+		if err == device.ErrNotSupported {
+			featureSupportedGauge.With(withLabels(prometheus.Labels{"feature": feature})).Set(0)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		featureSupportedGauge.With(withLabels(prometheus.Labels{"feature": feature})).Set(1)
+		return true, nil
+	}
+
+	infoGauge.With(withLabels(prometheus.Labels{
+		"alias":            alias,
+		"driver":           modem.Driver(),
+		"vendor":           systemInfo.Vendor,
+		"model":            systemInfo.Model,
+		"hardware_version": systemInfo.HardwareVersion,
+		"software_version": systemInfo.SoftwareVersion,
+	})).Set(1)
+
+	systemStatus, err := modem.SystemStatus()
+	if err != nil {
+		return err
+	}
+	if ok, _ := supported("battery", boolError(systemStatus.Battery != nil)); ok {
+		batteryCapacityGauge.With(labels).Set(systemStatus.Battery.Capacity)
+		batteryLevelGauge.With(labels).Set(systemStatus.Battery.Level)
+	}
+	currentConnectionGauge.With(labels).Set(systemStatus.CurrentConnection)
+	totalConnectionGauge.With(labels).Set(systemStatus.TotalConnection)
+
+	connectionState, err := modem.ConnectionState()
+	if err != nil {
+		return err
+	}
+	connectionStatusGauge.With(labels).Set(connectionState.ConnectionStatus)
+	speedDownloadGauge.With(labels).Set(connectionState.SpeedDownload)
+	speedUploadGauge.With(labels).Set(connectionState.SpeedUpload)
+	downloadRateGauge.With(labels).Set(connectionState.DownloadRate)
+	uploadRateGauge.With(labels).Set(connectionState.UploadRate)
+	downloadBytesGauge.With(labels).Set(connectionState.DownloadBytes)
+	uploadBytesGauge.With(labels).Set(connectionState.UploadBytes)
+
+	signal, err := modem.Signal()
+	ok, err := supported("signal", err)
+	if err != nil {
+		return err
+	}
+	if ok {
+		signalStrengthGauge.With(withLabels(prometheus.Labels{"network_type": signal.NetworkType, "network_name": signal.NetworkName})).Set(signal.Strength)
+		setIfKnown(signalRSSIGauge.With(labels), signal.RSSI)
+		setIfKnown(signalRSRPGauge.With(labels), signal.RSRP)
+		setIfKnown(signalRSRQGauge.With(labels), signal.RSRQ)
+		setIfKnown(signalSINRGauge.With(labels), signal.SINR)
+	}
+
+	smsStorageState, err := modem.SMSStorageState()
+	ok, err = supported("sms", err)
+	if err != nil {
+		return err
+	}
+	if ok {
+		unreadSMSCountGauge.With(labels).Set(smsStorageState.UnreadSMSCount)
+		if smsStorageState.MaxCount > 0 {
+			smsStorageUsedRatioGauge.With(labels).Set(smsStorageState.UsedCount / smsStorageState.MaxCount)
+		}
+	}
+
+	var wifiSettings *device.WiFiSettings
+	err = device.ErrNotSupported
+	if configurer, ok := modem.(device.WiFiConfigurer); ok {
+		wifiSettings, err = configurer.WiFiSettings()
+	}
+	ok, err = supported("wifi_settings", err)
+	if err != nil {
+		return err
+	}
+	if ok {
+		enabled := 0.0
+		if wifiSettings.Enabled {
+			enabled = 1
+		}
+		wifiEnabledGauge.With(labels).Set(enabled)
+		for band, channel := range wifiSettings.CurrentChannels {
+			wifiChannelGauge.With(withLabels(prometheus.Labels{"band": band})).Set(float64(channel))
+		}
+	}
+
+	var portForwardings []device.PortForwarding
+	err = device.ErrNotSupported
+	if configurer, ok := modem.(device.FirewallConfigurer); ok {
+		portForwardings, err = configurer.PortForwardings()
+	}
+	ok, err = supported("port_forwarding", err)
+	if err != nil {
+		return err
+	}
+	if ok {
+		active := 0
+		for _, rule := range portForwardings {
+			if rule.Enabled {
+				active++
+			}
+		}
+		portForwardingRulesGauge.With(labels).Set(float64(active))
+	}
+
+	var connectedClients []device.ConnectedClient
+	err = device.ErrNotSupported
+	if lister, ok := modem.(device.ClientLister); ok {
+		connectedClients, err = lister.ConnectedClients()
+	}
+	ok, err = supported("clients", err)
+	if err != nil {
+		return err
+	}
+	if ok {
+		clientsCollector.Update([]string{systemInfo.IMEI, systemInfo.IMSI, systemInfo.MacAddress}, connectedClients)
+	}
+
+	log.Debugf("[%s] %s %s scraped", alias, systemInfo.Vendor, systemInfo.Model)
+	return nil
+}
+
+// boolError return device.ErrNotSupported if the feature isn't available
+func boolError(available bool) error {
+	if available {
+		return nil
+	}
+	return device.ErrNotSupported
+}
+
+// setIfKnown set a gauge unless the value is unknown (NaN)
+func setIfKnown(gauge prometheus.Gauge, value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	gauge.Set(value)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/alertmanager"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/command"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/forwarder"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/outbox"
+)
+
+// sendSMS send a message through the target modem and count the result per recipient
+func (t *target) sendSMS(ctx context.Context, to []string, text string) ([]device.SentSMS, error) {
+	modem, systemInfo, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	sender, ok := modem.(device.SMSSender)
+	if ok == false {
+		return nil, device.ErrNotSupported
+	}
+
+	sent, err := sender.SendSMS(ctx, to, text)
+	result := "success"
+	switch {
+	case err == device.ErrSendUnconfirmed || err == context.DeadlineExceeded:
+		// the modem may still send it
+		result = "timeout"
+	case err != nil:
+		result = "failure"
+	}
+	smsSentCounter.With(prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress, "result": result}).Add(float64(len(to)))
+	if err != nil {
+		return sent, err
+	}
+	log.Infof("[%s] SMS sent to %s", t.Alias, strings.Join(to, ", "))
+	archiveSent(t.Alias, sent, text)
+	return sent, nil
+}
+
+// smsTarget return the target sending the SMS, by alias, the first target if alias is empty
+func smsTarget(targets []*target, alias string) (*target, error) {
+	if strings.TrimSpace(alias) == "" {
+		return targets[0], nil
+	}
+	t := findTarget(targets, alias)
+	if t == nil {
+		return nil, fmt.Errorf("unknown SMS target: %s", alias)
+	}
+	return t, nil
+}
+
+// startAlertmanager serve the Alertmanager webhook on /alertmanager, sending the alerts through t.
+// The webhook is protected by the control API token
+func startAlertmanager(configFile string, t *target, token string) error {
+	if token == "" {
+		return fmt.Errorf("the Alertmanager webhook need CONTROL_API_TOKEN")
+	}
+	config, err := alertmanager.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	receiver := alertmanager.New(config, func(ctx context.Context, to []string, text string) error {
+		_, err := t.sendSMS(ctx, to, text)
+		return err
+	})
+	webhook, err := control.Protect(token, receiver)
+	if err != nil {
+		return err
+	}
+	err = prometheus.Register(receiver)
+	if err != nil {
+		return err
+	}
+	go receiver.Run(context.Background())
+
+	http.Handle("/alertmanager", webhook)
+	log.Infof("Alertmanager notifications sent by SMS through %s", t.Alias)
+	return nil
+}
+
+// startOutbox serve the SMS outbox API on /api/v1/sms, sending the messages through t. rateLimit is
+// like "10/1h", the default outbox limit if empty. The API is protected by the control API token
+func startOutbox(dir string, rateLimit string, t *target, token string) error {
+	if token == "" {
+		return fmt.Errorf("the SMS API need CONTROL_API_TOKEN")
+	}
+	box, err := outbox.Open(dir, func(ctx context.Context, to string, text string) (string, error) {
+		sent, err := t.sendSMS(ctx, []string{to}, text)
+		if err != nil || len(sent) == 0 {
+			return "", err
+		}
+		return sent[len(sent)-1].Reference, nil
+	})
+	if err != nil {
+		return err
+	}
+	box.Storage = func() (*device.SMSStorageState, error) {
+		modem, _, err := t.open()
+		if err != nil {
+			return nil, err
+		}
+		return modem.SMSStorageState()
+	}
+	if strings.TrimSpace(rateLimit) != "" {
+		box.RateLimit, box.RateLimitPeriod, err = parseRateLimit(rateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	api, err := control.Protect(token, box)
+	if err != nil {
+		return err
+	}
+	go box.Run(context.Background())
+
+	http.Handle(outbox.API_PATH, api)
+	http.Handle(outbox.API_PATH+"/", api)
+	log.Infof("SMS outbox in %s, %d message(s) pending, sent through %s", dir, box.Pending(), t.Alias)
+	return nil
+}
+
+// smsForwarder push the received messages to the sinks, nil if SMS_FORWARD_CONFIG is not set
+var smsForwarder *forwarder.Forwarder
+
+// startForwarder forward the SMS received by the targets to the sinks of the config file
+func startForwarder(configFile string, targets []*target) error {
+	config, err := forwarder.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	smsForwarder, err = forwarder.New(config, pollSources(targets))
+	if err != nil {
+		return err
+	}
+	err = prometheus.Register(smsForwarder)
+	if err != nil {
+		return err
+	}
+	smsForwarder.Start(context.Background())
+	log.Infof("Received SMS forwarded to %d webhook(s) and %d email(s)", len(config.Webhooks), len(config.Emails))
+	return nil
+}
+
+// startCommands run the SMS commands received by the targets, each target answering its commands
+func startCommands(configFile string, targets []*target) error {
+	config, err := command.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	channel, err := command.New(config, pollSources(targets), func(ctx context.Context, alias string, to []string, text string) error {
+		_, err := findTarget(targets, alias).sendSMS(ctx, to, text)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = prometheus.Register(channel)
+	if err != nil {
+		return err
+	}
+	go channel.Run(context.Background())
+	log.Infof("SMS commands accepted from %s", strings.Join(config.Allow, ", "))
+	return nil
+}
+
+// parseRateLimit parse a rate limit like "10/1h"
+func parseRateLimit(rateLimit string) (int, time.Duration, error) {
+	parts := strings.SplitN(rateLimit, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid rate limit %s, expected <count>/<period>", rateLimit)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, period, nil
+}
+
+// runSendSMS send a SMS from the command line, the text is the remaining arguments
+func runSendSMS(args []string) {
+	flags := flag.NewFlagSet("send-sms", flag.ExitOnError)
+	modemTarget := flags.String("target", "http://192.168.1.1", "Modem, [driver+]url")
+	to := flags.String("to", "", "Comma separated list of phone numbers")
+	timeout := flags.Duration("timeout", 60*time.Second, "Time to wait for the modem to send the message")
+	flags.Parse(args)
+
+	text := strings.Join(flags.Args(), " ")
+	if *to == "" || text == "" {
+		log.Fatal("usage: send-sms -to <number>[,<number>...] <text>")
+	}
+
+	options := device.Options{Username: os.Getenv("MODEM_USERNAME"), Password: os.Getenv("MODEM_PASSWORD")}
+	targets, err := parseTargets(*modemTarget, modem_alcatel_mw40v.DRIVER, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	sent, err := targets[0].sendSMS(ctx, strings.Split(*to, ","), text)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, message := range sent {
+		fmt.Printf("%s\t%s\t%s\t%d part(s)\treference %s\n", message.Number, message.Time.Format(time.RFC3339), message.Encoding, message.Parts, message.Reference)
+	}
+}
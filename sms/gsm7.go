@@ -0,0 +1,41 @@
+package sms
+
+// ESCAPE is the GSM-7 septet introducing a character of the extension table
+const ESCAPE = 0x1b
+
+// gsm7Alphabet is the GSM 03.38 default alphabet, indexed by septet
+var gsm7Alphabet = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// gsm7Extension is the GSM 03.38 extension table, characters sent after ESCAPE
+var gsm7Extension = map[byte]rune{
+	0x0a: '\f',
+	0x14: '^',
+	0x28: '{',
+	0x29: '}',
+	0x2f: '\\',
+	0x3c: '[',
+	0x3d: '~',
+	0x3e: ']',
+	0x40: '|',
+	0x65: '€',
+}
+
+var gsm7Index = map[rune][]byte{}
+
+func init() {
+	for septet, char := range gsm7Alphabet {
+		if septet != ESCAPE {
+			gsm7Index[char] = []byte{byte(septet)}
+		}
+	}
+	for septet, char := range gsm7Extension {
+		gsm7Index[char] = []byte{ESCAPE, septet}
+	}
+}
+
+// gsm7Septets return the septets of a character, false if it isn't in the GSM-7 alphabet
+func gsm7Septets(char rune) ([]byte, bool) {
+	septets, ok := gsm7Index[char]
+	return septets, ok
+}
@@ -0,0 +1,90 @@
+// Package sms measure SMS text: its GSM-7 or UCS-2 encoding, its length, the split of long messages
+// into parts and the parts of concatenated messages
+package sms
+
+// Encodings
+const (
+	GSM7 = "GSM-7"
+	UCS2 = "UCS-2"
+)
+
+// Characters per message, a concatenated message part lose room for the user data header
+const (
+	GSM7_SINGLE = 160
+	GSM7_PART   = 153
+	UCS2_SINGLE = 70
+	UCS2_PART   = 67
+)
+
+// Encoding return GSM7 if the text can be sent with the GSM-7 alphabet, UCS2 otherwise
+func Encoding(text string) string {
+	for _, char := range text {
+		if _, ok := gsm7Septets(char); ok == false {
+			return UCS2
+		}
+	}
+	return GSM7
+}
+
+// Length return the length of text in its encoding: septets for GSM-7 (extension characters count
+// twice), UTF-16 code units for UCS-2
+func Length(text string, encoding string) int {
+	length := 0
+	for _, char := range text {
+		length += charLength(char, encoding)
+	}
+	return length
+}
+
+// Split return the encoding of text and its parts, a single part if it fit in one message.
+// Escaped GSM-7 characters and UTF-16 surrogate pairs are never split
+func Split(text string) (string, []string) {
+	encoding := Encoding(text)
+	single, part := GSM7_SINGLE, GSM7_PART
+	if encoding == UCS2 {
+		single, part = UCS2_SINGLE, UCS2_PART
+	}
+	if Length(text, encoding) <= single {
+		return encoding, []string{text}
+	}
+
+	var parts []string
+	var current []rune
+	length := 0
+	for _, char := range text {
+		charLength := charLength(char, encoding)
+		if length+charLength > part {
+			parts = append(parts, string(current))
+			current, length = nil, 0
+		}
+		current = append(current, char)
+		length += charLength
+	}
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+	return encoding, parts
+}
+
+// Full return true if text fill a part of a concatenated message, as every part but the last does.
+// A part is a septet or a code unit short when an escaped character or a surrogate pair didn't fit
+func Full(text string) bool {
+	if Encoding(text) == GSM7 {
+		if length := Length(text, GSM7); length == GSM7_PART || length == GSM7_PART-1 {
+			return true
+		}
+	}
+	length := Length(text, UCS2)
+	return length == UCS2_PART || length == UCS2_PART-1
+}
+
+func charLength(char rune, encoding string) int {
+	if encoding == GSM7 {
+		septets, _ := gsm7Septets(char)
+		return len(septets)
+	}
+	if char > 0xffff {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,56 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLength(t *testing.T) {
+	if encoding := Encoding("5€ {ok}"); encoding != GSM7 || Length("5€ {ok}", encoding) != 10 {
+		t.Logf("Expected 10 GSM-7 septets, got: %s %d", encoding, Length("5€ {ok}", encoding))
+		t.Fail()
+	}
+	if encoding := Encoding("olá 😀"); encoding != UCS2 || Length("olá 😀", encoding) != 6 {
+		t.Logf("Expected 6 UCS-2 code units, got: %s %d", encoding, Length("olá 😀", encoding))
+		t.Fail()
+	}
+}
+
+func TestSplit(t *testing.T) {
+	encoding, parts := Split(strings.Repeat("a", 160))
+	if encoding != GSM7 || len(parts) != 1 {
+		t.Logf("Expected 1 GSM-7 part, got: %s %d", encoding, len(parts))
+		t.Fail()
+	}
+
+	// the escaped € doesn't fit in the 153 septets of the first part
+	encoding, parts = Split(strings.Repeat("a", 152) + "€" + strings.Repeat("b", 10))
+	if encoding != GSM7 || len(parts) != 2 || len(parts[0]) != 152 || strings.HasPrefix(parts[1], "€") == false {
+		t.Logf("Unexpected GSM-7 parts: %q", parts)
+		t.Fail()
+	}
+
+	encoding, parts = Split(strings.Repeat("ç", 66) + "😀" + "ççç")
+	if encoding != UCS2 || len(parts) != 2 || strings.HasPrefix(parts[1], "😀") == false {
+		t.Logf("Unexpected UCS-2 parts: %s %q", encoding, parts)
+		t.Fail()
+	}
+}
+
+func TestFull(t *testing.T) {
+	// the first part is a septet short, the escaped € didn't fit
+	_, parts := Split(strings.Repeat("a", 152) + "€" + strings.Repeat("b", 10))
+	if len(parts) != 2 || Full(parts[0]) == false || Full(parts[1]) {
+		t.Logf("Expected the first GSM-7 part full: %q", parts)
+		t.Fail()
+	}
+	_, parts = Split(strings.Repeat("ç", 150))
+	if len(parts) != 3 || Full(parts[0]) == false || Full(parts[1]) == false || Full(parts[2]) {
+		t.Logf("Expected the UCS-2 parts but the last full: %q", parts)
+		t.Fail()
+	}
+	if Full("Saldo: 5,00 EUR.") || Full(strings.Repeat("a", 160)) {
+		t.Logf("Expected single messages not full")
+		t.Fail()
+	}
+}
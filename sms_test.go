@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+)
+
+func TestSendSMSTimeout(t *testing.T) {
+	server := modemtest.NewServer()
+	defer server.Close()
+	// the context is done before the first poll of the send result
+	modem_alcatel_mw40v.SMSSendPollInterval = time.Hour
+	defer func() { modem_alcatel_mw40v.SMSSendPollInterval = time.Millisecond }()
+
+	targets, err := parseTargets("home="+server.URL, "tcl", device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatalf("[TestSendSMSTimeout] Error: %s", err)
+	}
+	_, systemInfo, err := targets[0].open()
+	if err != nil {
+		t.Fatalf("[TestSendSMSTimeout] Error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = targets[0].sendSMS(ctx, []string{"+351910000000"}, "olá")
+	if err != device.ErrSendUnconfirmed {
+		t.Logf("Expected an unconfirmed send, got: %v", err)
+		t.Fail()
+	}
+
+	var metric dto.Metric
+	labels := prometheus.Labels{"IMEI": systemInfo.IMEI, "IMSI": systemInfo.IMSI, "MacAddress": systemInfo.MacAddress, "result": "timeout"}
+	err = smsSentCounter.With(labels).Write(&metric)
+	if err != nil {
+		t.Fatalf("[TestSendSMSTimeout] Error: %s", err)
+	}
+	if metric.GetCounter().GetValue() != 1 {
+		t.Logf("Expected 1 SMS counted as timeout, got: %v", metric.GetCounter().GetValue())
+		t.Fail()
+	}
+}
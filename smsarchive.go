@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/archive"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/control"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/extract"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/housekeeping"
+)
+
+// smsArchive keep the messages sent and received, nil if SMS_ARCHIVE_FILE is not set
+var smsArchive *archive.Archive
+
+// archiveSent archive a message sent, once per number even when it was split in several messages,
+// at the time given to the modem so the record match the one read from its storage
+func archiveSent(alias string, sent []device.SentSMS, text string) {
+	if smsArchive == nil {
+		return
+	}
+	numbers := map[string]bool{}
+	var records []archive.Record
+	for _, message := range sent {
+		if numbers[message.Number] {
+			continue
+		}
+		numbers[message.Number] = true
+		records = append(records, archive.NewRecord(alias, archive.SENT, message.Number, message.Time, text))
+	}
+	_, err := smsArchive.Add(records...)
+	if err != nil {
+		log.Errorf("[Archive] %s", err)
+	}
+}
+
+// startArchive archive the messages of the targets in file, and serve the search API on
+// /api/v1/archive when the control API token is set. retention is like "8760h", empty to keep the
+// messages forever
+func startArchive(file string, retention string, targets []*target, token string) error {
+	var err error
+	smsArchive, err = archive.Open(file)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(retention) != "" {
+		smsArchive.Retention, err = time.ParseDuration(retention)
+		if err != nil {
+			return err
+		}
+	}
+	err = prometheus.Register(smsArchive)
+	if err != nil {
+		return err
+	}
+
+	go smsArchive.Run(context.Background(), pollSources(targets), archive.DEFAULT_INTERVAL)
+
+	log.Infof("SMS archived in %s", file)
+	if token == "" {
+		log.Infof("SMS archive API disabled, it need CONTROL_API_TOKEN")
+		return nil
+	}
+	api, err := control.Protect(token, smsArchive)
+	if err != nil {
+		return err
+	}
+	http.Handle(archive.API_PATH, api)
+	return nil
+}
+
+// startHousekeeping delete the old SMS of the targets following the policy of the config file,
+// archiving them first if the archive is enabled, and keeping the ones not forwarded yet if the
+// forwarder is enabled
+func startHousekeeping(configFile string, targets []*target) error {
+	config, err := housekeeping.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	var archiveFunc housekeeping.ArchiveFunc
+	if smsArchive != nil {
+		archiveFunc = func(alias string, deletions []housekeeping.Deletion) error {
+			var records []archive.Record
+			for _, deletion := range deletions {
+				direction := archive.RECEIVED
+				if deletion.Sent {
+					direction = archive.SENT
+				}
+				records = append(records, archive.NewRecord(alias, direction, deletion.Message.Number, deletion.Message.Time, deletion.Message.Text))
+			}
+			_, err := smsArchive.Add(records...)
+			return err
+		}
+	} else if config.DryRun == false {
+		log.Warn("SMS_ARCHIVE_FILE is not set, the SMS deleted by the housekeeping are not archived")
+	}
+
+	housekeeper := housekeeping.New(config, pollSources(targets), archiveFunc)
+	if smsForwarder != nil {
+		housekeeper.Forwarded = smsForwarder.Cursor.Forwarded
+	}
+	err = prometheus.Register(housekeeper)
+	if err != nil {
+		return err
+	}
+	go housekeeper.Run(context.Background())
+	log.Infof("SMS housekeeping enabled, dry run: %t", config.DryRun)
+	return nil
+}
+
+// startExtract set the operator gauges from the SMS received by the targets, and from the archive
+// if it is enabled
+func startExtract(configFile string, targets []*target) error {
+	config, err := extract.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	extractor := extract.New(config)
+	if smsArchive != nil {
+		for _, record := range smsArchive.Search(archive.Query{Direction: archive.RECEIVED}) {
+			extractor.Process(record.Modem, record.Number, record.Time, record.Text)
+		}
+	}
+	err = prometheus.Register(extractor)
+	if err != nil {
+		return err
+	}
+
+	go extractor.Run(context.Background(), pollSources(targets))
+	log.Infof("Operator values extracted from SMS with %d rule(s)", len(config.Rules))
+	return nil
+}
+
+// runArchive search the archive from the command line and write the messages as CSV or JSON
+func runArchive(args []string) {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	file := flags.String("file", os.Getenv("SMS_ARCHIVE_FILE"), "Archive file")
+	text := flags.String("q", "", "Words the messages contain")
+	number := flags.String("number", "", "Phone number, or part of it")
+	modem := flags.String("modem", "", "Modem alias")
+	direction := flags.String("direction", "", "received or sent")
+	since := flags.String("since", "", "Messages since a date or RFC 3339 time")
+	until := flags.String("until", "", "Messages before a date or RFC 3339 time")
+	limit := flags.Int("limit", 0, "Maximum number of messages, the most recent")
+	format := flags.String("format", "csv", "csv or json")
+	flags.Parse(args)
+
+	if *file == "" {
+		log.Fatal("usage: archive -file <archive file> [-q <words>] [-format csv|json]")
+	}
+	query, err := archive.ParseQuery(url.Values{
+		"q":         {*text},
+		"number":    {*number},
+		"modem":     {*modem},
+		"direction": {*direction},
+		"since":     {*since},
+		"until":     {*until},
+		"limit":     {strconv.Itoa(*limit)},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	smsArchive, err := archive.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	records := smsArchive.Search(query)
+	switch *format {
+	case "csv":
+		err = archive.WriteCSV(os.Stdout, records)
+	case "json":
+		err = archive.WriteJSON(os.Stdout, records)
+	default:
+		log.Fatalf("unknown format: %s", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/ussd"
+)
+
+// startUSSD run the USSD queries on the targets and expose the values read from the answers
+func startUSSD(configFile string, targets []*target) error {
+	config, err := ussd.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	runner := ussd.New(config, pollSources(targets))
+	err = prometheus.Register(runner)
+	if err != nil {
+		return err
+	}
+	go runner.Run(context.Background())
+	log.Infof("USSD queries run with %d query(ies)", len(config.Queries))
+	return nil
+}
@@ -0,0 +1,342 @@
+// Package ussd run USSD codes on the modems, like the balance query of a prepaid plan, and expose
+// the values read from the answers as gauges. A modem has a single USSD session, so the sessions
+// are strictly serialized, and a minimum gap between two sessions avoid flooding the network.
+//
+//	{"name": "balance", "code": "*#123#", "pattern": "Saldo: (?P<balance>[0-9.,]+)EUR",
+//	 "values": [{"group": "balance", "metric": "modem_operator_ussd_balance"}]}
+package ussd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/extract"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+// Query results
+const (
+	RESULT_OK       = "ok"
+	RESULT_FAILED   = "failed"
+	RESULT_NO_MATCH = "no_match"
+)
+
+// Defaults of the config
+const (
+	DEFAULT_INTERVAL = 6 * time.Hour
+	DEFAULT_MIN_GAP  = time.Minute
+	DEFAULT_TIMEOUT  = 30 * time.Second
+)
+
+// Metrics of the runner, the names can't be used by the values
+const (
+	QUERIES_METRIC      = "modem_ussd_queries_total"
+	LAST_SUCCESS_METRIC = "modem_ussd_last_success_timestamp_seconds"
+)
+
+// Config of the queries, read from a JSON file
+type Config struct {
+	// Interval between two runs of the queries, like "6h"
+	Interval string `json:"interval,omitempty"`
+	// MinGap is the minimum time between two sessions on a modem, like "1m"
+	MinGap string `json:"min_gap,omitempty"`
+	// Timeout of each USSD request, like "30s"
+	Timeout string  `json:"timeout,omitempty"`
+	Queries []Query `json:"queries"`
+
+	interval time.Duration
+	minGap   time.Duration
+	timeout  time.Duration
+	helps    map[string]string
+}
+
+// Query send Code, then Replies while the session is open, and read Values from the answers
+// matching Pattern. The session is cancelled if it is still open after the replies
+type Query struct {
+	Name    string          `json:"name"`
+	Code    string          `json:"code"`
+	Replies []string        `json:"replies,omitempty"`
+	Pattern string          `json:"pattern"`
+	Values  []extract.Value `json:"values"`
+
+	pattern *regexp.Regexp
+}
+
+// LoadConfig read and validate a config file
+func LoadConfig(file string) (*Config, error) {
+	var config Config
+	err := poll.LoadConfig(file, &config)
+	return &config, err
+}
+
+// Validate check the config and compile its regular expressions
+func (config *Config) Validate() error {
+	var err error
+	durations := []struct {
+		name     string
+		value    string
+		duration *time.Duration
+		fallback time.Duration
+	}{
+		{"interval", config.Interval, &config.interval, DEFAULT_INTERVAL},
+		{"min_gap", config.MinGap, &config.minGap, DEFAULT_MIN_GAP},
+		{"timeout", config.Timeout, &config.timeout, DEFAULT_TIMEOUT},
+	}
+	for _, d := range durations {
+		*d.duration, err = poll.Duration(d.name, d.value, d.fallback)
+		if err != nil {
+			return err
+		}
+	}
+	if len(config.Queries) == 0 {
+		return fmt.Errorf("no query")
+	}
+
+	config.helps = map[string]string{}
+	names := map[string]bool{}
+	for i := range config.Queries {
+		query := &config.Queries[i]
+		if query.Name == "" || names[query.Name] {
+			return fmt.Errorf("query %d: missing or duplicate name", i)
+		}
+		names[query.Name] = true
+		if query.Code == "" {
+			return fmt.Errorf("query %s: no code", query.Name)
+		}
+
+		query.pattern, err = regexp.Compile(query.Pattern)
+		if err != nil {
+			return fmt.Errorf("query %s: pattern: %s", query.Name, err)
+		}
+		groups := map[string]bool{}
+		for _, group := range query.pattern.SubexpNames() {
+			groups[group] = true
+		}
+		if len(query.Values) == 0 {
+			return fmt.Errorf("query %s: no value", query.Name)
+		}
+
+		for _, value := range query.Values {
+			err = value.Check(groups)
+			if err == nil && (value.Metric == QUERIES_METRIC || value.Metric == LAST_SUCCESS_METRIC) {
+				err = fmt.Errorf("invalid metric name %s", value.Metric)
+			}
+			if err != nil {
+				return fmt.Errorf("query %s: %s", query.Name, err)
+			}
+
+			// a metric set by several queries has one help
+			previous, ok := config.helps[value.Metric]
+			switch {
+			case value.Help != "" && previous != "" && previous != value.Help:
+				return fmt.Errorf("query %s: metric %s has another help", query.Name, value.Metric)
+			case value.Help != "" || ok == false:
+				config.helps[value.Metric] = value.Help
+			}
+		}
+	}
+	for metric, help := range config.helps {
+		if help == "" {
+			config.helps[metric] = "Value reported by the operator by USSD"
+		}
+	}
+	return nil
+}
+
+// Runner run the queries on the sources, one session at a time, it is the collector of the gauges
+type Runner struct {
+	Config  *Config
+	Sources []poll.Source
+	// Now return the current time, time.Now by default
+	Now func() time.Time
+
+	// session is held for a whole session, from the code to the last reply
+	session sync.Mutex
+	// last session end by modem
+	last map[string]time.Time
+
+	mutex sync.Mutex
+	// values by metric and modem, successes by query and modem
+	values    map[string]map[string]float64
+	successes map[string]map[string]time.Time
+	queries   *prometheus.CounterVec
+}
+
+// New return a runner, Run must be called to run the queries
+func New(config *Config, sources []poll.Source) *Runner {
+	return &Runner{
+		Config:    config,
+		Sources:   sources,
+		Now:       time.Now,
+		last:      map[string]time.Time{},
+		values:    map[string]map[string]float64{},
+		successes: map[string]map[string]time.Time{},
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: QUERIES_METRIC,
+			Help: "USSD queries run, by modem, query and result: ok, failed or no_match",
+		}, []string{"modem", "query", "result"}),
+	}
+}
+
+// Run run the queries on the sources every interval, until ctx is done
+func (runner *Runner) Run(ctx context.Context) {
+	poll.Run(ctx, "USSD", runner.Sources, runner.Config.interval, runner.queryAll)
+}
+
+// queryAll run the queries on a source, the failed queries are logged and the next ones are run,
+// unless the modem doesn't support USSD
+func (runner *Runner) queryAll(ctx context.Context, source poll.Source) error {
+	for i := range runner.Config.Queries {
+		_, err := runner.Query(ctx, source, &runner.Config.Queries[i])
+		if err == device.ErrNotSupported {
+			return err
+		} else if err != nil {
+			log.Errorf("[USSD] %s: query %s: %s", source.Name, runner.Config.Queries[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Query run a query on a source, wait for the minimum gap since the last session on the modem, and
+// return the answers
+func (runner *Runner) Query(ctx context.Context, source poll.Source, query *Query) ([]string, error) {
+	sender, err := source.USSDSender()
+	if err != nil {
+		return nil, err
+	}
+
+	runner.session.Lock()
+	defer runner.session.Unlock()
+	if wait := runner.last[source.Name].Add(runner.Config.minGap).Sub(runner.Now()); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	defer func() { runner.last[source.Name] = runner.Now() }()
+
+	answers, err := runner.exchange(ctx, sender, query)
+	if err != nil {
+		runner.queries.WithLabelValues(source.Name, query.Name, RESULT_FAILED).Inc()
+		return answers, err
+	}
+	if runner.process(source.Name, query, answers) == false {
+		runner.queries.WithLabelValues(source.Name, query.Name, RESULT_NO_MATCH).Inc()
+		return answers, fmt.Errorf("no answer matching the pattern: %q", answers)
+	}
+	runner.queries.WithLabelValues(source.Name, query.Name, RESULT_OK).Inc()
+	return answers, nil
+}
+
+// exchange send the code and the replies, and cancel the session if it is still open
+func (runner *Runner) exchange(ctx context.Context, sender device.USSDSender, query *Query) ([]string, error) {
+	var answers []string
+	request := func(send func(context.Context, string) (*device.USSDReply, error), content string) (*device.USSDReply, error) {
+		ctx, cancel := context.WithTimeout(ctx, runner.Config.timeout)
+		defer cancel()
+		reply, err := send(ctx, content)
+		if err == nil {
+			answers = append(answers, reply.Text)
+		}
+		return reply, err
+	}
+
+	reply, err := request(sender.SendUSSD, query.Code)
+	if err != nil {
+		// the session may be left open by a timeout
+		sender.CancelUSSD()
+		return nil, err
+	}
+	for _, content := range query.Replies {
+		if reply.SessionOpen == false {
+			break
+		}
+		reply, err = request(sender.ReplyUSSD, content)
+		if err != nil {
+			sender.CancelUSSD()
+			return answers, err
+		}
+	}
+	if reply.SessionOpen {
+		err = sender.CancelUSSD()
+	}
+	return answers, err
+}
+
+// process set the values from the first answer matching the pattern, the last one first
+func (runner *Runner) process(modem string, query *Query, answers []string) bool {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	for i := len(answers) - 1; i >= 0; i-- {
+		groups := extract.Match(query.pattern, answers[i])
+		if groups == nil {
+			continue
+		}
+		matched := false
+		for _, value := range query.Values {
+			parsed, err := value.Parse(groups)
+			if err != nil {
+				log.Warnf("[USSD] %s: query %s, %s: %s", modem, query.Name, value.Group, err)
+				continue
+			}
+			if runner.values[value.Metric] == nil {
+				runner.values[value.Metric] = map[string]float64{}
+			}
+			runner.values[value.Metric][modem] = parsed
+			matched = true
+		}
+		if matched {
+			if runner.successes[query.Name] == nil {
+				runner.successes[query.Name] = map[string]time.Time{}
+			}
+			runner.successes[query.Name][modem] = runner.Now()
+		}
+		return matched
+	}
+	return false
+}
+
+func (runner *Runner) desc(metric string) *prometheus.Desc {
+	if metric == LAST_SUCCESS_METRIC {
+		return prometheus.NewDesc(LAST_SUCCESS_METRIC, "Time of the last USSD query whose answer was read", []string{"modem", "query"}, nil)
+	}
+	return prometheus.NewDesc(metric, runner.Config.helps[metric], []string{"modem"}, nil)
+}
+
+// Describe implement prometheus.Collector
+func (runner *Runner) Describe(ch chan<- *prometheus.Desc) {
+	runner.queries.Describe(ch)
+	ch <- runner.desc(LAST_SUCCESS_METRIC)
+	for metric := range runner.Config.helps {
+		ch <- runner.desc(metric)
+	}
+}
+
+// Collect implement prometheus.Collector
+func (runner *Runner) Collect(ch chan<- prometheus.Metric) {
+	runner.queries.Collect(ch)
+
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	for metric, modems := range runner.values {
+		desc := runner.desc(metric)
+		for modem, value := range modems {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, modem)
+		}
+	}
+	desc := runner.desc(LAST_SUCCESS_METRIC)
+	for query, modems := range runner.successes {
+		for modem, successTime := range modems {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(successTime.Unix()), modem, query)
+		}
+	}
+}
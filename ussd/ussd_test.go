@@ -0,0 +1,154 @@
+package ussd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"nos-modem-alcatel-mw40v-prometheus-exporther/device"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/extract"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modem_alcatel_mw40v"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/modemtest"
+	"nos-modem-alcatel-mw40v-prometheus-exporther/poll"
+)
+
+func testSource(t *testing.T) (poll.Source, *modemtest.Server) {
+	server := modemtest.NewServer()
+	server.Modem.USSD["*100#"] = "1: Saldo 2: Dados"
+	server.Modem.USSD["*100#>1"] = "Saldo: 5,25EUR"
+	server.Modem.USSD["*100#>2"] = "Tem 1,5GB disponiveis"
+	modem_alcatel_mw40v.USSDPollInterval = time.Millisecond
+
+	modem, err := modem_alcatel_mw40v.Open(server.URL, device.Options{Password: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return poll.Source{Name: "home", Open: func() (device.Device, error) { return modem, nil }}, server
+}
+
+func TestQuery(t *testing.T) {
+	source, server := testSource(t)
+	defer server.Close()
+	config := &Config{MinGap: "1ms", Queries: []Query{
+		{
+			Name:    "balance",
+			Code:    "*100#",
+			Replies: []string{"1"},
+			Pattern: `Saldo: (?P<balance>[0-9.,]+) ?EUR`,
+			Values:  []extract.Value{{Group: "balance", Metric: "modem_operator_ussd_balance"}},
+		},
+		{
+			Name:    "data",
+			Code:    "*100#",
+			Pattern: `Tem (?P<data>[0-9.,]+) ?(?P<unit>[KMG]B)`,
+			Values:  []extract.Value{{Group: "data", Metric: "modem_operator_ussd_data_remaining_bytes", Kind: extract.KIND_BYTES, UnitGroup: "unit"}},
+		},
+	}}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[TestQuery] Error: %s", err)
+	}
+	runner := New(config, []poll.Source{source})
+
+	answers, err := runner.Query(context.Background(), source, &config.Queries[0])
+	if err != nil || len(answers) != 2 || answers[1] != "Saldo: 5,25EUR" {
+		t.Fatalf("Expected the menu and the balance, got: %q %v", answers, err)
+	}
+	// without reply the menu doesn't match, the session is cancelled
+	_, err = runner.Query(context.Background(), source, &config.Queries[1])
+	if err == nil || server.Modem.Calls("SetUSSDEnd") != 1 {
+		t.Fatalf("Expected no match and the session cancelled, got: %v, %d cancel", err, server.Modem.Calls("SetUSSDEnd"))
+	}
+	config.Queries[1].Replies = []string{"2"}
+	_, err = runner.Query(context.Background(), source, &config.Queries[1])
+	if err != nil {
+		t.Fatalf("[TestQuery] Error: %s", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(runner)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("[TestQuery] Error: %s", err)
+	}
+	var output bytes.Buffer
+	for _, family := range families {
+		expfmt.MetricFamilyToText(&output, family)
+	}
+	for _, expected := range []string{
+		`modem_operator_ussd_balance{modem="home"} 5.25`,
+		`modem_operator_ussd_data_remaining_bytes{modem="home"} 1.5e+09`,
+		`modem_ussd_queries_total{modem="home",query="data",result="no_match"} 1`,
+		`modem_ussd_queries_total{modem="home",query="data",result="ok"} 1`,
+		`modem_ussd_last_success_timestamp_seconds{modem="home",query="balance"}`,
+	} {
+		if strings.Contains(output.String(), expected) == false {
+			t.Logf("Expected %s in:\n%s", expected, output.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestSerialized(t *testing.T) {
+	source, server := testSource(t)
+	defer server.Close()
+	config := &Config{MinGap: "50ms", Queries: []Query{{
+		Name:    "balance",
+		Code:    "*100#",
+		Replies: []string{"1"},
+		Pattern: `Saldo: (?P<balance>[0-9.,]+)`,
+		Values:  []extract.Value{{Group: "balance", Metric: "modem_operator_ussd_balance"}},
+	}}}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("[TestSerialized] Error: %s", err)
+	}
+	runner := New(config, []poll.Source{source})
+
+	// concurrent queries don't mix their sessions, and are spaced by the minimum gap
+	start := time.Now()
+	var wg sync.WaitGroup
+	errors := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := runner.Query(context.Background(), source, &config.Queries[0])
+			errors <- err
+		}()
+	}
+	wg.Wait()
+	close(errors)
+	for err := range errors {
+		if err != nil {
+			t.Fatalf("[TestSerialized] Error: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Logf("Expected 3 sessions to take 2 gaps, took %s", elapsed)
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	value := []extract.Value{{Group: "balance", Metric: "balance"}}
+	configs := []Config{
+		{},
+		{Queries: []Query{{Name: "balance", Pattern: `(?P<balance>\d+)`, Values: value}}},
+		{Queries: []Query{{Name: "balance", Code: "*100#", Pattern: `(?P<value>\d+)`, Values: value}}},
+		{MinGap: "1 minute", Queries: []Query{{Name: "balance", Code: "*100#", Pattern: `(?P<balance>\d+)`, Values: value}}},
+		{Queries: []Query{{Name: "balance", Code: "*100#", Pattern: `(?P<balance>\d+)`, Values: []extract.Value{{Group: "balance", Metric: QUERIES_METRIC}}}}},
+	}
+	for i := range configs {
+		if configs[i].Validate() == nil {
+			t.Logf("Expected config %d invalid", i)
+			t.Fail()
+		}
+	}
+}